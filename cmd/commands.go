@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bananabacon/internal/profile"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// metricsOnly is set by runMetricsCommand, so run only serves the metrics.
+var metricsOnly bool
+
+// envFlag is a command line flag that sets an environment variable, so every
+// setting can be given either way. Flags override the environment variables,
+// which override the configuration files.
+type envFlag struct {
+	name string
+	env string
+	fallback string // default of the variable, "true" or "false" for boolean flags
+	usage string
+	boolean bool
+}
+
+// replayFlags are the flags of the replay and validate commands.
+var replayFlags = []envFlag{
+	{"input", "INPUT_FILE", "/logs/test.log", "the file to replay, or a comma-separated list of files", false},
+	{"preset", "PRESET", "", "a named log format providing the defaults of the regexes and the time format", false},
+	{"output", "OUTPUT", "stdout", "a comma-separated list of outputs the lines are written to", false},
+	{"filter", "FILTER_REGEX", ".*", "a regex selecting the lines to replay", false},
+	{"time-regex", "TIME_REGEX", defaultTimeRegex, "a regex matching the timestamps", false},
+	{"time-format", "TIME_FORMAT", defaultTimeFormat, "the Go time layout of the timestamps", false},
+	{"speed", "SPEED", "1", "the factor by which the replay is faster than the original log", false},
+	{"loop", "LOOP", "true", "replay the log again when its end is reached", true},
+	{"follow", "FOLLOW", "false", "keep replaying lines appended to the input", true},
+	{"max-lines", "MAX_LINES", "0", "the maximum number of lines per run", false},
+	{"max-duration", "MAX_DURATION", "0s", "the maximum duration of a run", false},
+	{"start-at", "START_AT", "", "the RFC 3339 time at which the replay starts", false},
+	{"checkpoint", "CHECKPOINT_FILE", "", "the file the replay position is persisted to", false},
+	{"exit-on-completion", "EXIT_ON_COMPLETION", "false", "exit when the replay has completed", true},
+}
+
+// serverFlags are the flags of the commands serving metrics.
+var serverFlags = []envFlag{
+	{"port", "METRICS_PORT", "8080", "the port the metrics are served on", false},
+	{"debug", "DEBUG", "false", "enable debug logging", true},
+	{"max-runtime", "MAX_RUNTIME", "0s", "the duration after which the process stops", false},
+}
+
+// configFlags are the flags of all commands reading the configuration files.
+var configFlags = []envFlag{
+	{"config", "CONFIG_PATH", "", "a configuration file or conf.d style directory", false},
+	{"profile", "PROFILE", "", "the profile of the configuration files to apply", false},
+}
+
+// envValues collects repeated "-env KEY=VALUE" flags.
+type envValues []string
+
+func (v *envValues) String() string {
+	return strings.Join(*v, ",")
+}
+
+func (v *envValues) Set(s string) error {
+	if key, _, ok := strings.Cut(s, "="); !ok || len(key) == 0 {
+		return fmt.Errorf("must be like KEY=VALUE")
+	}
+	*v = append(*v, s)
+	return nil
+}
+
+// newCommandFlags creates the flag set of a command with the given flags
+// bound to environment variables and a repeatable "-env KEY=VALUE" flag for
+// all other variables. Call setEnvFlags after parsing it.
+func newCommandFlags(command string, flags ...[]envFlag) *flag.FlagSet {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	for _, group := range flags {
+		for _, f := range group {
+			usage := fmt.Sprintf("%s (%s)", f.usage, f.env)
+			if f.boolean {
+				fs.Bool(f.name, f.fallback == "true", usage)
+			} else {
+				fs.String(f.name, f.fallback, usage)
+			}
+		}
+	}
+	fs.Var(&envValues{}, "env", "sets any environment variable, like KEY=VALUE, can be repeated")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: bananabacon %s [flags]\n\nFlags override the environment variables in "+
+			"parentheses, which override the configuration files.\n\n", command)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// setEnvFlags sets the environment variables of the flags given on the
+// command line, after the variables given with "-env".
+func setEnvFlags(fs *flag.FlagSet, flags ...[]envFlag) {
+	envs := map[string]string{}
+	for _, group := range flags {
+		for _, f := range group {
+			envs[f.name] = f.env
+		}
+	}
+	if v, ok := fs.Lookup("env").Value.(*envValues); ok {
+		for _, kv := range *v {
+			key, value, _ := strings.Cut(kv, "=")
+			os.Setenv(key, value)
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if env, ok := envs[f.Name]; ok {
+			os.Setenv(env, f.Value.String())
+		}
+	})
+}
+
+// runReplayCommand implements the replay command, which replays the logs and
+// serves the metrics like running without a command, configured by flags in
+// addition to the environment variables. With -dry-run, the schedule is
+// printed instead, see runDryRun, with -daemon the replay runs in the
+// background, see startDaemon. It returns the exit code.
+func runReplayCommand(args []string) int {
+	fs := newCommandFlags("replay", replayFlags, serverFlags, configFlags)
+	printSchedule := fs.Bool("dry-run", false, "print the schedule of the replay instead of waiting for the lines")
+	background := fs.Bool("daemon", false, "run the replay in the background")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	setEnvFlags(fs, replayFlags, serverFlags, configFlags)
+	loadConfig()
+	applyPreset("")
+	switch {
+	case *printSchedule:
+		return runDryRun()
+	case *background:
+		// The background process reads the flags from the environment
+		os.Args = []string{os.Args[0], "--daemon"}
+		return startDaemon()
+	}
+	run(context.Background())
+	return 0
+}
+
+// runMetricsCommand implements the metrics command, which serves the metrics
+// configured with METRIC_ environment variables without replaying a log. It
+// returns the exit code.
+func runMetricsCommand(args []string) int {
+	fs := newCommandFlags("metrics", serverFlags, configFlags)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	setEnvFlags(fs, serverFlags, configFlags)
+	loadConfig()
+	metricsOnly = true
+	run(context.Background())
+	return 0
+}
+
+// runValidate implements the validate command, which checks the
+// configuration of the replays and the metrics without starting them. It
+// prints a report of the checks of validateConfig and, if they pass, checks
+// the remaining settings like on start. It returns the exit code: 0 if the
+// configuration is valid and 1 otherwise.
+func runValidate(args []string) int {
+	fs := newCommandFlags("validate", replayFlags, serverFlags, configFlags)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	setEnvFlags(fs, replayFlags, serverFlags, configFlags)
+	loadConfig()
+	applyPreset("")
+	if n := validateConfig(os.Stdout); n > 0 {
+		fmt.Printf("Configuration is invalid: %d errors\n", n)
+		return 1
+	}
+	// The outputs are not opened, like in a dry run
+	dryRun = true
+	for _, instance := range replayInstances() {
+		newReplay(instance)
+	}
+	createMetricsEngine()
+	getPort()
+	fmt.Println("Configuration is valid")
+	return 0
+}
+
+// runGenerate implements the generate command, which writes a synthetic log
+// with the shape of a profile written by the profile command, see
+// profile.Generate. The timestamps are formatted with TIME_FORMAT. It returns
+// the exit code: 0 on success and 2 on errors.
+func runGenerate(args []string) int {
+	fs := newCommandFlags("generate", configFlags)
+	input := fs.String("input", "", "the profile to generate the log from")
+	output := fs.String("output", "", "the file the log is written to, stdout if empty")
+	startStr := fs.String("start", "", "the RFC 3339 time of the first line, the start of the profile if empty")
+	seed := fs.Uint64("seed", 0, "the seed of the random choices, a random seed if 0")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	setEnvFlags(fs, configFlags)
+	loadConfig()
+	applyPreset("")
+	if len(*input) == 0 {
+		fmt.Fprintln(os.Stderr, "generate: -input is required")
+		return 2
+	}
+	options := profile.GenerateOptions{Layout: getenv("TIME_FORMAT", defaultTimeFormat), Seed: *seed}
+	if options.Seed == 0 {
+		options.Seed = uint64(time.Now().UnixNano())
+	}
+	if len(*startStr) > 0 {
+		var err error
+		if options.Start, err = time.Parse(time.RFC3339, *startStr); err != nil {
+			fmt.Fprintf(os.Stderr, "generate: invalid start: %v\n", err)
+			return 2
+		}
+	}
+	content, err := os.ReadFile(*input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "generate: %v\n", err)
+		return 2
+	}
+	var p profile.Profile
+	if err := json.Unmarshal(content, &p); err != nil {
+		fmt.Fprintf(os.Stderr, "generate: invalid profile: %v\n", err)
+		return 2
+	}
+	out := os.Stdout
+	if len(*output) > 0 {
+		if out, err = os.Create(*output); err != nil {
+			fmt.Fprintf(os.Stderr, "generate: %v\n", err)
+			return 2
+		}
+		defer out.Close()
+	}
+	if err := profile.Generate(&p, out, options); err != nil {
+		fmt.Fprintf(os.Stderr, "generate: %v\n", err)
+		return 2
+	}
+	return 0
+}
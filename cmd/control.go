@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bananabacon/internal/anomaly"
+	logs "bananabacon/internal/logs"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// replayState is the JSON representation of the replay state returned by the
+// control endpoint.
+type replayState struct {
+	Paused bool `json:"paused"`
+	Speed float64 `json:"speed"`
+	Run int64 `json:"run"`
+	Position int64 `json:"position"`
+	LinesEmitted int64 `json:"linesEmitted"`
+}
+
+// controlHandler returns an HTTP handler to control a running replay. GET
+// requests return the current state. POST requests change it using the
+// "action" query parameter:
+//
+// - pause: pauses the replay
+// - resume: resumes a paused replay
+// - speed: sets the replay speed to the "speed" parameter, e.g. 2 for double speed
+// - skip: skips the "duration" parameter of log time, e.g. 30s
+// - incident: starts the incident named by the "name" parameter with the
+// optional "intensity" and "duration", the other parameters override the
+// parameters of the incident, see anomaly.Injector.Trigger
+func controlHandler(lr *logs.LogReplayer, inj *anomaly.Injector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			if err := applyControlAction(lr, inj, r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		speed, paused := lr.Speed()
+		stats := lr.Stats()
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(replayState{
+			Paused: paused,
+			Speed: speed,
+			Run: stats.Run,
+			Position: stats.Position,
+			LinesEmitted: stats.LinesEmitted,
+		})
+		if err != nil {
+			log.Printf("Failed to encode replay state: %v", err)
+		}
+	})
+}
+
+// applyControlAction applies the action given in the request to the replayer.
+func applyControlAction(lr *logs.LogReplayer, inj *anomaly.Injector, r *http.Request) error {
+	switch action := r.FormValue("action"); action {
+	case "pause":
+		lr.Pause()
+	case "resume":
+		lr.Resume()
+	case "speed":
+		speed, err := strconv.ParseFloat(r.FormValue("speed"), 64)
+		if err != nil {
+			return fmt.Errorf("invalid speed: %w", err)
+		}
+		return lr.SetSpeed(speed)
+	case "skip":
+		d, err := time.ParseDuration(r.FormValue("duration"))
+		if err != nil {
+			return fmt.Errorf("invalid duration: %w", err)
+		}
+		return lr.Skip(d)
+	case "incident":
+		var intensity float64
+		var d time.Duration
+		var err error
+		if s := r.FormValue("intensity"); len(s) > 0 {
+			if intensity, err = strconv.ParseFloat(s, 64); err != nil {
+				return fmt.Errorf("invalid intensity: %w", err)
+			}
+		}
+		if s := r.FormValue("duration"); len(s) > 0 {
+			if d, err = time.ParseDuration(s); err != nil {
+				return fmt.Errorf("invalid duration: %w", err)
+			}
+		}
+		params := map[string]string{}
+		for k, v := range r.Form {
+			if k != "action" && k != "name" && k != "intensity" && k != "duration" && len(v) > 0 {
+				params[k] = v[0]
+			}
+		}
+		return inj.Trigger(r.FormValue("name"), intensity, d, params, time.Now())
+	default:
+		return fmt.Errorf("unknown action: %q", action)
+	}
+	return nil
+}
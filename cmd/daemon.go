@@ -0,0 +1,54 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// daemonEnv is set in the environment of the background process started by
+// startDaemon.
+const daemonEnv = "BANANABACON_DAEMON"
+
+// pidFilePath returns the pid file given by PID_FILE.
+func pidFilePath() string {
+	return getenv("PID_FILE", filepath.Join(os.TempDir(), "bananabacon.pid"))
+}
+
+// daemonLogPath returns the log file of a background process given by
+// DAEMON_LOG_FILE.
+func daemonLogPath() string {
+	return getenv("DAEMON_LOG_FILE", filepath.Join(os.TempDir(), "bananabacon.log"))
+}
+
+// writePidFile writes the pid of the process to the pid file if the process
+// was started by startDaemon or PID_FILE is set. It returns a function that
+// removes the pid file again, unless another process has taken it over.
+func writePidFile() func() {
+	if len(os.Getenv(daemonEnv)) == 0 && len(getenv("PID_FILE", "")) == 0 {
+		return func() {}
+	}
+	path := pidFilePath()
+	pid := os.Getpid()
+	if err := os.WriteFile(path, []byte(strconv.Itoa(pid)+"\n"), 0o644); err != nil {
+		log.Printf("Failed to write pid file: %v", err)
+		return func() {}
+	}
+	return func() {
+		if p, ok := readPidFile(path); ok && p == pid {
+			os.Remove(path)
+		}
+	}
+}
+
+// readPidFile returns the pid stored in the given pid file.
+func readPidFile(path string) (int, bool) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, false
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	return pid, err == nil && pid > 0
+}
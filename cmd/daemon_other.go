@@ -0,0 +1,21 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// startDaemon is not supported on platforms without Unix sessions, Windows
+// uses the service command instead.
+func startDaemon() int {
+	fmt.Fprintln(os.Stderr, "daemon: not supported on this platform, use the service command on Windows")
+	return 2
+}
+
+// runDaemonCommand is not supported on platforms without Unix sessions.
+func runDaemonCommand(command string) int {
+	fmt.Fprintf(os.Stderr, "%s: not supported on this platform, use the service command on Windows\n", command)
+	return 2
+}
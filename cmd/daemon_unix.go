@@ -0,0 +1,96 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// daemonStopTimeout is how long "stop" waits for the background process to
+// exit.
+const daemonStopTimeout = 10 * time.Second
+
+// startDaemon starts the replay in a background process that is detached
+// from the terminal and returns the exit code of the command. The process
+// gets the arguments following "--daemon", writes its pid to PID_FILE and
+// its output to DAEMON_LOG_FILE, unless OUTPUT points elsewhere.
+func startDaemon() int {
+	pidFile := pidFilePath()
+	if pid, ok := readPidFile(pidFile); ok && processAlive(pid) {
+		fmt.Fprintf(os.Stderr, "bananabacon is already running with pid %d\n", pid)
+		return 1
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "daemon: %v\n", err)
+		return 2
+	}
+	logPath := daemonLogPath()
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "daemon: %v\n", err)
+		return 2
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, os.Args[2:]...)
+	cmd.Env = append(os.Environ(), daemonEnv+"=1", "PID_FILE="+pidFile)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	// Start a new session, so the process survives closing the terminal
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "daemon: %v\n", err)
+		return 2
+	}
+	// Write the pid file right away, so "status" works before the process
+	// has written it itself
+	pid := cmd.Process.Pid
+	if err := os.WriteFile(pidFile, []byte(fmt.Sprintf("%d\n", pid)), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "daemon: %v\n", err)
+	}
+	cmd.Process.Release()
+	fmt.Printf("Started bananabacon with pid %d, logging to %s\n", pid, logPath)
+	return 0
+}
+
+// runDaemonCommand implements the commands "stop", which terminates the
+// background process, and "status", which reports whether it is running. It
+// returns the exit code: 0 on success, 3 if the process is not running, like
+// LSB init scripts do, and 1 on errors.
+func runDaemonCommand(command string) int {
+	pidFile := pidFilePath()
+	pid, ok := readPidFile(pidFile)
+	if !ok || !processAlive(pid) {
+		fmt.Println("bananabacon is not running")
+		return 3
+	}
+	if command == "status" {
+		fmt.Printf("bananabacon is running with pid %d\n", pid)
+		return 0
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		fmt.Fprintf(os.Stderr, "stop: %v\n", err)
+		return 1
+	}
+	for deadline := time.Now().Add(daemonStopTimeout); time.Now().Before(deadline); {
+		if !processAlive(pid) {
+			fmt.Printf("Stopped bananabacon with pid %d\n", pid)
+			return 0
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	fmt.Fprintf(os.Stderr, "stop: bananabacon with pid %d did not exit within %s\n", pid, daemonStopTimeout)
+	return 1
+}
+
+// processAlive returns true if a process with the given pid exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
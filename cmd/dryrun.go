@@ -0,0 +1,91 @@
+package main
+
+import (
+	logs "bananabacon/internal/logs"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// dryRun is set by runDryRun, so newReplay does not open the outputs.
+var dryRun bool
+
+// scheduleSink writes the schedule of a dry run: one tab-separated row per
+// line with its batch, its offset from the start of the replay, its original
+// timestamp, its source and line number and the rewritten line.
+type scheduleSink struct {
+	w *bufio.Writer
+	instance string // the replay, empty for a single replay
+	start time.Time
+	batch int
+	pending bool // whether lines were written since the last batch
+}
+
+func newScheduleSink(w io.Writer, instance string, start time.Time) *scheduleSink {
+	return &scheduleSink{w: bufio.NewWriter(w), instance: instance, start: start, batch: 1}
+}
+
+func (s *scheduleSink) Write(_ context.Context, e logs.LogEvent) error {
+	s.pending = true
+	if len(s.instance) > 0 {
+		fmt.Fprintf(s.w, "%s\t", s.instance)
+	}
+	// Round away the time spent opening the inputs
+	offset := e.Time.Sub(s.start).Round(time.Millisecond)
+	_, err := fmt.Fprintf(s.w, "%d\t%s\t%s\t%s:%d\t%s\n", s.batch, offset,
+		e.OriginalTime.Format(time.RFC3339Nano), e.Source, e.LineNumber, e.Line)
+	return err
+}
+
+// Flush ends the current batch, the replay flushes the sink after each batch.
+func (s *scheduleSink) Flush() error {
+	if s.pending {
+		s.batch++
+		s.pending = false
+	}
+	return s.w.Flush()
+}
+
+func (s *scheduleSink) Close() error {
+	return s.w.Flush()
+}
+
+// runDryRun implements "--dry-run": it reads the inputs with the configuration
+// of a replay and prints the schedule the lines would be emitted in to stdout,
+// without waiting for them to be due and without writing to the outputs. This
+// validates TIME_REGEX, TIME_FORMAT and the batching before a long replay. The
+// lines are mapped to START_AT if it is set, without waiting for it. With
+// multiple replays, the schedules are printed one after another, with the
+// instance of the replay in front of each row. It returns the exit code: 0 on
+// success and 2 if a replay failed.
+func runDryRun() int {
+	dryRun = true
+	var replays []*replay
+	for _, instance := range replayInstances() {
+		replays = append(replays, newReplay(instance))
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	start := time.Now()
+	if startAt := getStartAt(); !startAt.IsZero() {
+		start = startAt
+	}
+	for _, r := range replays {
+		sink := newScheduleSink(os.Stdout, r.instance, start)
+		err := r.lr.StartSink(ctx, start, sink)
+		sink.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "dry-run: %v\n", err)
+			return 2
+		}
+		stats := r.lr.Stats()
+		fmt.Fprintf(os.Stderr, "dry-run: %d lines in %d batches, %d lines skipped\n", stats.LinesEmitted,
+			sink.batch-1, stats.LinesSkipped)
+	}
+	return 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bananabacon/internal/metrics"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runExport implements the export command, which evaluates the metrics
+// configured with METRIC_ environment variables over a virtual time range and
+// writes the samples to a CSV or Parquet file. It returns the exit code: 0 on
+// success and 2 on errors.
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	output := fs.String("output", "", "the file the samples are written to")
+	format := fs.String("format", "", `"csv" or "parquet", derived from the extension of -output if empty`)
+	startStr := fs.String("start", "", "the start of the time range in RFC 3339 format, the current time if empty")
+	duration := fs.Duration("duration", 24*time.Hour, "the length of the time range")
+	step := fs.Duration("step", 15*time.Second, "the interval between samples")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(*output) == 0 {
+		fmt.Fprintln(os.Stderr, "export: -output is required")
+		return 2
+	}
+	start := time.Now().Truncate(time.Second)
+	if len(*startStr) > 0 {
+		var err error
+		if start, err = time.Parse(time.RFC3339, *startStr); err != nil {
+			fmt.Fprintf(os.Stderr, "export: invalid start: %v\n", err)
+			return 2
+		}
+	}
+	if len(*format) == 0 {
+		*format = filepath.Ext(*output)
+		if len(*format) > 0 {
+			*format = (*format)[1:]
+		}
+	}
+	if *format != "csv" && *format != "parquet" {
+		fmt.Fprintf(os.Stderr, "export: unsupported format: %q, must be \"csv\" or \"parquet\"\n", *format)
+		return 2
+	}
+
+	out, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 2
+	}
+	defer out.Close()
+	var w metrics.SampleWriter
+	if *format == "csv" {
+		if w, err = metrics.NewCSVSampleWriter(out); err != nil {
+			fmt.Fprintf(os.Stderr, "export: %v\n", err)
+			return 2
+		}
+	} else {
+		w = metrics.NewParquetSampleWriter(out)
+	}
+	engine := createMetricsEngine()
+	if err := engine.Simulate(start, start.Add(*duration), *step, w.Write); err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 2
+	}
+	if err := w.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 2
+	}
+	if err := out.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 2
+	}
+	return 0
+}
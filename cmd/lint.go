@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bananabacon/internal/debug"
+	metrics "bananabacon/internal/metrics"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// runLintMetrics implements the lint-metrics command, which renders one
+// scrape of /metrics from the configured metrics, log metrics, stress series
+// and churn without serving it and checks the exposition with metrics.Lint.
+// Metrics whose scripts fail to evaluate are reported too, as they are missing
+// from the scrape. It returns the exit code: 0 if no problems were found, 1 if some
+// were and 2 on errors. The configuration is read like by validate.
+func runLintMetrics(args []string) int {
+	fs := newCommandFlags("lint-metrics", configFlags)
+	requireHelp := fs.Bool("require-help", false, "report metrics without a description")
+	printExposition := fs.Bool("print", false, "print the rendered exposition before the problems")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	setEnvFlags(fs, configFlags)
+	loadConfig()
+	debug.SetEnabled(getenv("DEBUG", "false") == "true")
+	builder, err := metrics.NewMetricsEngineBuilderFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "lint-metrics: %v\n", err)
+		return 2
+	}
+	engine := builder.Build()
+	server := metrics.NewMetricsServer(engine, 0)
+	server.SetResponsePadding(getResponsePadding())
+	if series := getInt("STRESS_SERIES", "0"); series > 0 {
+		server.AddCollector(metrics.NewStressCollector(series, getInt("STRESS_LABELS", "0"),
+			getInt("STRESS_LABEL_VALUE_LENGTH", "0")))
+	}
+	lms := getLogMetrics()
+	if len(lms) > 0 {
+		server.AddCollector(metrics.LogMetricsCollector(lms))
+	}
+	if churn := getChurnCollector(); churn != nil {
+		server.AddCollector(churn)
+	}
+	exposition := server.Render()
+	if *printExposition {
+		fmt.Print(exposition)
+	}
+
+	problems := metrics.Lint(exposition, *requireHelp)
+	var lines []string
+	for _, p := range problems {
+		lines = append(lines, p.String())
+	}
+	for _, m := range engine.List() {
+		name := regexp.QuoteMeta(m.Name())
+		if !regexp.MustCompile(`(?m)^` + name + `(_bucket|_sum|_count)?[ {]`).MatchString(exposition) {
+			lines = append(lines, fmt.Sprintf("%s: missing from the scrape, its script failed to evaluate "+
+				"(run with DEBUG=true for the error)", m.Name()))
+		}
+	}
+	if len(lines) == 0 {
+		fmt.Printf("%d metrics, no problems found\n", len(engine.List())+len(lms))
+		return 0
+	}
+	fmt.Println(strings.Join(lines, "\n"))
+	fmt.Printf("%d problems found\n", len(lines))
+	return 1
+}
@@ -1,21 +1,47 @@
 package main
 
 import (
+	"bananabacon/internal/anomaly"
+	"bananabacon/internal/anonymize"
+	"bananabacon/internal/clock"
+	"bananabacon/internal/config"
+	"bananabacon/internal/debug"
+	"bananabacon/internal/guard"
 	logs "bananabacon/internal/logs"
+	"bananabacon/internal/mapping"
 	metrics "bananabacon/internal/metrics"
+	"bananabacon/internal/presets"
+	"bananabacon/internal/scenario"
+	"bananabacon/internal/sinks"
+	"bananabacon/internal/suppress"
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 )
 
+const (
+	defaultTimeRegex = "(\\d{4}-\\d{2}-\\d{2} \\d{2}:\\d{2}:\\d{2}\\.\\d{3}).*"
+	defaultTimeFormat = "2006-01-02 15:04:05.000"
+)
+
 // getenv returns the value of the environment variable with the given key.
-// If the key is not set, it returns the fallback value.
+// If the key is not set, it returns the fallback value. While the settings of
+// one of multiple replays are read, the key with the suffix of its instance
+// takes precedence, see newReplay.
 func getenv(key, fallback string) string {
+    if len(envInstance) > 0 {
+        if value := os.Getenv(key + "_" + envInstance); len(value) > 0 {
+            return value
+        }
+    }
     value := os.Getenv(key)
     if len(value) == 0 {
         return fallback
@@ -26,54 +52,428 @@ func getenv(key, fallback string) string {
 // main runs the log replayer and prints the replayed log lines to stdout.
 // Additionally, it reads metrics configuration from environment variables and
 // exposes them via http.
-// It stops when it receives a SIGTERM or SIGINT signal.
+// It stops when it receives a SIGTERM or SIGINT signal. If EXIT_ON_COMPLETION
+// is "true", it also stops when the replay has completed, i.e. the whole file was
+// replayed without LOOP, and exits with EXIT_CODE.
+//
+// If CONFIG_PATH is set, configuration values are additionally read from the
+// given file or conf.d style directory. PROFILE selects a profile defined in
+// the files whose values apply on top. Environment variables take precedence
+// over values from configuration files. The metrics, filter regexes, line
+// transformations and speeds are reloaded from the files on SIGHUP and, if
+// CONFIG_WATCH is set, whenever they change, see reloader.
+//
+// If INPUT_FILE_1, INPUT_FILE_2 and so on are set, independent replays run
+// concurrently instead, each configured by the variables below with its suffix,
+// e.g. OUTPUT_2 and SPEED_2, falling back to the variables without suffix. See
+// newReplay.
 //
 // It uses the following environment variables to configure the log replayer:
 //
-// - INPUT_FILE: the file to read the log from
+// - INPUT_FILE: the file to read the log from, or a comma-separated list of
+//     files that are replayed on a single timeline. docker://<container> reads
+//     the logs of a container from the daemon at DOCKER_HOST
+// - PRESET: a named log format that provides defaults for FILTER_REGEX,
+//     TIME_REGEX and TIME_FORMAT
 // - FILTER_REGEX: a regex to filter out log lines that don't match
 // - TIME_REGEX: a regex to extract timestamps from log lines
 // - TIME_FORMAT: the format of the timestamps extracted by TIME_REGEX,
-//     as understood by the time.Parse function.
+//     as understood by the time.Parse function. TIME_REGEX and TIME_FORMAT
+//     can hold multiple alternatives separated by "||" that are tried in order.
+// - TIME_TEMPLATE: concatenates the named groups of TIME_REGEX, e.g.
+//     "{date}T{time}", to the timestamp parsed with TIME_FORMAT
+// - EXTRA_TIME_REGEX, EXTRA_TIME_FORMAT, EXTRA_TIME_TEMPLATE: "||" separated
+//     regexes, formats and templates of secondary timestamps that are shifted
+//     like the primary timestamp
+// - TIME_LOCALE: the language of month and day names in timestamps, e.g. "de",
+//     "fr", "es", "it", "nl" or "pt", defaults to English
+// - TIME_PARSE_CHECK: "warn" (the default), "stop" or "off", what to do if too
+//     many timestamps cannot be parsed with TIME_FORMAT
+// - TIME_PARSE_MAX_FAILURES: the percentage of timestamps that may fail to parse
+// - MAX_LINES: the maximum number of lines emitted per replay run
+// - MAX_DURATION: the maximum wall-clock duration of a replay run
+// - MAX_RUNTIME: the duration after which the process flushes the outputs,
+//     prints its final state and exits with 0
+// - MEMORY_LIMIT, MAX_GOROUTINES: the resident memory, e.g. "512MB", and the
+//     number of goroutines close to which the replays are slowed down by
+//     GUARD_THROTTLE and amplifications like bursts are suspended
+// - GUARD_INTERVAL: the interval in which the resource usage is checked
+// - START_AT: the RFC 3339 timestamp at which the replay starts, to start
+//     replicas on several hosts at the same moment
+// - INPUT_WAIT_TIMEOUT: how long to wait for a missing input file to appear
+// - INPUT_ENCODING: the encoding of the input files, detected by default
+// - INPUT_ROTATED: whether to replay the rotated parts of the input files first
+// - EXIT_ON_COMPLETION: whether to exit the process when the replay has completed
+// - EXIT_CODE: the exit code used when exiting after the replay has completed
+// - TEMPLATE_VARS: whether to expand placeholders like {{hostname}} in lines
+// - LINE_TRANSFORM: a JavaScript expression or function applied to every line
+// - LINE_TRANSFORM_FILE: a file containing the script for LINE_TRANSFORM
+// - LINE_MAPPING: a mapping of the fields of every line, see package mapping
+// - LINE_MAPPING_FILE: a file containing the mapping for LINE_MAPPING
+// - LINE_MAPPING_FORMAT: the format of the lines mapped (auto, json, logfmt or raw)
+// - ANONYMIZE: replaces user names, ids, hosts and addresses in security logs of
+// the given format (auditd, cef or leef, or true for the format of PRESET)
+// with pseudonyms
+// - ANONYMIZE_KEY: the key the pseudonyms are derived with (default: random)
+// - SUPPRESS_WINDOWS: recurring windows in which no lines are emitted
+// - SUPPRESS_METRICS: whether to also omit the metrics during these windows
+// - ANOMALIES: recurring or random incidents, i.e. bursts of lines, injected
+//     error lines, pauses and incident templates like memory-leak
+// - LOG_METRIC_<name>_REGEX: a regex extracting a value from the replayed
+//     lines that is observed by a summary or histogram, configured by
+//     LOG_METRIC_<name>_TYPE, _SCALE, _WINDOW, _QUANTILES, _BUCKETS, _DESCR and
+//     _LABEL, see metrics.LogMetricsFromEnv
+// - ANOMALY_ERROR_LINES: "||" separated lines injected by errors anomalies
+// - SCENARIO_FILE: a JSON file with a timeline of phases, each setting the
+//     speed, the replays that play, the scripts of metrics and the anomalies
+//     injected for its duration, see package scenario
+// - LOG_STREAM: whether to stream the replayed lines to HTTP clients on
+//     /logs/stream as Server-Sent Events or over a WebSocket
+// - LOG_STREAM_BUFFER: the number of lines buffered per streaming client
+// - EVAL_API: whether to evaluate JavaScript expressions sent to /api/eval
+//     against the metrics engine, see the "repl" command
+// - EVAL_TOKEN: the bearer token required by /api/eval, must be set if
+//     EVAL_API is enabled
+// - ANNOTATIONS_OUTPUT: a comma-separated list of outputs notable actions of
+//     the replay are written to as JSON, like OUTPUT
+// - OUTPUT: a comma-separated list of outputs the lines are written to
+// - OUTPUT_ORDERING: whether all outputs deliver a line before the next one
+//     is written (ordered) or buffer independently (independent, the default)
+// - AUDIT_FILE: a file every dropped line is recorded in with the reason
+// - DEBUG: whether to enable debug logging on start
+// - CHECKPOINT_FILE: the file the replay position is persisted to and resumed from
+// - CHECKPOINT_INTERVAL: the interval in which the replay position is persisted
+// - MAX_BYTES_PER_SECOND: the maximum number of bytes emitted per second
+// - JITTER: the maximum random deviation applied to timestamps and emission times
+// - SCHEDULER: the scheduling strategy, "timer" or "ticker"
+// - SCHEDULER_GRANULARITY: the resolution of the scheduler
+// - BATCH_WINDOW: the maximum span of log time whose lines are emitted together
+// - BATCH_MAX_ERROR: the maximum timing error of a line the batching window
+//     adapts to
+// - BATCH_MAX_LINES: the number of lines after which a batch is emitted early
+// - FOLLOW: whether to keep emitting lines appended to the input file
+// - SPEED: the factor by which the replay is faster than the original log
+// - STREAM_SPEED, STREAM_JITTER: comma-separated overrides of the speed (as a
+//     factor on top of SPEED) and jitter of individual input files, e.g.
+//     "lb.log=2,db.log=1"
+// - STREAM_FILTER_REGEX: "||" separated filter regexes of individual input
+//     files, applied in addition to FILTER_REGEX, e.g. "lb.log=GET||db.log=ERROR"
+// - SAMPLE_RATE: the share of lines between 0 and 1 that are replayed
+// - SAMPLE_RULES: "||" separated sample rates of lines matching a regex, e.g.
+//     "1=ERROR||0.5=WARN", the first matching rule applies
+// - ALIGN_WEEKS: whether to shift timestamps by whole weeks, keeping their time
+//     of day and day of week
+//
+// The command "replay" does the same, configured by command line flags that
+// override the environment variables, e.g. "replay -input app.log -speed 2",
+// see runReplayCommand. The command "metrics" only serves the metrics, see
+// runMetricsCommand. The command "validate" checks the configuration without
+// starting the replay, see runValidate. The command "generate" writes a
+// synthetic log from a profile, see runGenerate.
+//
+// The command "verify" compares the recorded output of a replay with its
+// source log instead, see runVerify. The command "repl" evaluates expressions
+// against the metrics engine of a running instance, see runRepl. The command
+// "profile" writes the statistical profile of a log, see runProfile. The command
+// "export" writes the metrics over a virtual time range to a file, see runExport.
+// The command "record" captures a live log into a file that can be replayed,
+// see runRecord.
+//
+// With "--dry-run", the schedule of the replay is printed instead of waiting
+// for the lines to be due, see runDryRun.
+//
+// With "--daemon", the replay runs in the background on Unix systems, see
+// startDaemon, and is controlled with the commands "stop" and "status". On
+// Windows, the command "service" installs and controls a Windows service
+// instead, see runServiceCommand.
+//
+// On Unix systems, SIGUSR1 dumps the current state to stderr, SIGUSR2
+// toggles debug logging and SIGHUP reloads the configuration.
 func main() {
-	file := getenv("INPUT_FILE", "/logs/test.log")
-	filterRegex := getenv("FILTER_REGEX", ".*")
-	timeRegex := getenv("TIME_REGEX", "(\\d{4}-\\d{2}-\\d{2} \\d{2}:\\d{2}:\\d{2}\\.\\d{3}).*")
-	timeFormat := getenv("TIME_FORMAT", "2006-01-02 15:04:05.000")
-	loop := getenv("LOOP", "true")
-
-	lr := logs.NewLogReplayer(file, logs.ReplayerOptions{
-		FilterRegex: filterRegex,
-		TimeRegex: timeRegex,
-		TimeFormat: timeFormat,
-		Loop: loop == "true",
-	})
+	if len(os.Args) > 1 {
+		// Commands with flags that override the configuration
+		switch os.Args[1] {
+		case "replay":
+			os.Exit(runReplayCommand(os.Args[2:]))
+		case "metrics":
+			os.Exit(runMetricsCommand(os.Args[2:]))
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "lint-metrics":
+			os.Exit(runLintMetrics(os.Args[2:]))
+		case "generate":
+			os.Exit(runGenerate(os.Args[2:]))
+		}
+	}
+	loadConfig()
+	applyPreset("")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "verify":
+			os.Exit(runVerify(os.Args[2:]))
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "profile":
+			os.Exit(runProfile(os.Args[2:]))
+		case "repl":
+			os.Exit(runRepl(os.Args[2:]))
+		case "record":
+			os.Exit(runRecord(os.Args[2:]))
+		case "--dry-run", "-dry-run":
+			os.Exit(runDryRun())
+		case "--daemon", "-daemon":
+			os.Exit(startDaemon())
+		case "stop", "status":
+			os.Exit(runDaemonCommand(os.Args[1]))
+		case "service":
+			os.Exit(runServiceCommand(os.Args[2:]))
+		}
+	}
+	if runAsService() {
+		return
+	}
+	run(context.Background())
+}
 
-	// Wrapper function for printing to stdout
-	print := func(s string) {
-        fmt.Println(s)
-    }
+// run replays the logs and serves the metrics until ctx is cancelled, a
+// termination signal is received or all replays completed.
+func run(ctx context.Context) {
+	removePidFile := writePidFile()
+	defer removePidFile()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	exitOnCompletion := getenv("EXIT_ON_COMPLETION", "false") == "true"
+	exitCode := getExitCode()
+	debug.SetEnabled(getenv("DEBUG", "false") == "true")
+	var replays []*replay
+	if !metricsOnly {
+		for _, instance := range replayInstances() {
+			replays = append(replays, newReplay(instance))
+		}
+	}
+	logMetrics := getLogMetrics()
+	lrs := make([]*logs.LogReplayer, len(replays))
+	for i, r := range replays {
+		lrs[i] = r.lr
+		r.observe(logMetrics)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	// Stop gracefully once MAX_RUNTIME has passed, so forgotten instances do
+	// not generate data forever
+	maxRuntime := getDuration("MAX_RUNTIME", "0s")
+	if maxRuntime > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeoutCause(ctx, maxRuntime, errMaxRuntime)
+		defer stop()
+	}
 
 	engine := createMetricsEngine()
 	port := getPort()
 
+	for _, r := range replays {
+		// Incidents change the shape of the metrics
+		engine.AddModifier(r.injector.Modify)
+	}
 	server := metrics.NewMetricsServer(engine, port)
+	if len(replays) > 0 {
+		setMetricsClock(engine, lrs[0])
+		server.AddCollector(replayCollector(replays))
+		server.Handle("/control/replay", controlHandler(lrs[0], replays[0].injector))
+		if stream := replays[0].stream; stream != nil {
+			server.Handle(sinks.StreamPath, stream)
+		}
+		if windows := replays[0].windows; windows != nil && getenv("SUPPRESS_METRICS", "false") == "true" {
+			server.SetSuppression(func() bool { return windows.Active(time.Now()) })
+		}
+	}
+	for _, r := range replays {
+		if len(r.instance) == 0 {
+			continue
+		}
+		server.Handle("/control/replay/"+r.instance, controlHandler(r.lr, r.injector))
+		if r.stream != nil {
+			server.Handle(sinks.StreamPath+"/"+r.instance, r.stream)
+		}
+	}
+	if getenv("EVAL_API", "false") == "true" {
+		if err := server.EnableEval(getenv("EVAL_TOKEN", "")); err != nil {
+			log.Fatalf("Failed to enable EVAL_API: %v, set EVAL_TOKEN", err)
+		}
+	}
+	server.SetServerOptions(getServerOptions())
+	certFile, keyFile := getenv("HTTP_TLS_CERT_FILE", ""), getenv("HTTP_TLS_KEY_FILE", "")
+	if h3 := getenv("HTTP3", "false") == "true"; h3 || len(certFile) > 0 || len(keyFile) > 0 {
+		if err := server.SetTLS(certFile, keyFile, h3); err != nil {
+			log.Fatalf("Invalid TLS configuration of the server: %v", err)
+		}
+	}
+	server.SetResponsePadding(getResponsePadding())
+	if err := server.SetEvalTimeout(getDuration("METRICS_EVAL_TIMEOUT", "0"),
+		getenv("METRICS_EVAL_TIMEOUT_ACTION", metrics.EvalTimeoutPartial)); err != nil {
+		log.Fatalf("Invalid metrics evaluation timeout: %v", err)
+	}
+	if expr := getenv("SCRAPE_DELAY_EXPR", ""); len(expr) > 0 {
+		server.SetScrapeDelay(metrics.NewMetric("scrape_delay", metrics.GaugeType, expr, nil, ""))
+	}
+	if len(logMetrics) > 0 {
+		server.AddCollector(metrics.LogMetricsCollector(logMetrics))
+	}
+	g := getGuard()
+	if series := getInt("STRESS_SERIES", "0"); series > 0 {
+		server.AddCollector(shedUnderPressure(g, metrics.NewStressCollector(series, getInt("STRESS_LABELS", "0"),
+			getInt("STRESS_LABEL_VALUE_LENGTH", "0"))))
+	}
+	if churn := getChurnCollector(); churn != nil {
+		server.AddCollector(shedUnderPressure(g, churn))
+	}
+	if g != nil {
+		server.SetOverload(g.Pressure)
+		g.OnChange(relievePressure(replays, getGuardThrottle()))
+	}
+	orchestrator := getScenario(replays, engine)
+	if orchestrator != nil {
+		server.AddCollector(orchestrator.Collector())
+	}
 
 
 	// Capture SIGTERM and SIGINT
 	// Cancel is called when a signal is received, we do not need it
 	ctx, _ = signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
 	
+	reloader := &reloader{replays: replays, engine: engine}
+	go handleRuntimeSignals(ctx, lrs, engine, reloader.reload)
+	if interval := getDuration("CONFIG_WATCH", "0s"); interval > 0 {
+		go reloader.watch(ctx, interval)
+	}
+	for _, r := range replays {
+		go r.injector.Run(ctx, r.lr)
+	}
+	if g != nil {
+		go g.Run(ctx, getDuration("GUARD_INTERVAL", "1s"))
+	}
+
+	// Persist the metrics state until shutdown and wait for the final write
+	stateDone := persistMetricsState(ctx, engine)
+	defer func() { <-stateDone }()
+
 	// Start serving metrics
-	go server.Run(ctx)
+	serverDone := make(chan struct{})
+	go func() {
+		server.Run(ctx)
+		close(serverDone)
+	}()
+
+	// shutdown waits until the outputs are flushed and the metrics server has
+	// stopped and prints the final state if MAX_RUNTIME has passed
+	started := false
+	shutdown := func() {
+		if !errors.Is(context.Cause(ctx), errMaxRuntime) {
+			return
+		}
+		log.Printf("Stopping after the maximum runtime of %s", maxRuntime)
+		for _, r := range replays {
+			if started {
+				<-r.closed
+			} else {
+				r.close()
+			}
+		}
+		<-serverDone
+		dumpState(os.Stderr, lrs, engine)
+	}
+
+	if len(replays) == 0 {
+		server.SetReady(true)
+		if orchestrator != nil {
+			go orchestrator.Run(ctx)
+		}
+		<-ctx.Done()
+		shutdown()
+		return
+	}
+
+	// Start replaying the logs and report readiness once all inputs are open
+	start, ok := waitForStart(ctx)
+	if !ok {
+		shutdown()
+		return
+	}
+	for _, r := range replays {
+		go r.run(ctx, start)
+	}
+	if orchestrator != nil {
+		go orchestrator.Run(ctx)
+	}
+	started = true
+	go func() {
+		for _, lr := range lrs {
+			select {
+			case <-lr.Ready():
+			case <-lr.Done():
+				return
+			}
+		}
+		server.SetReady(true)
+	}()
+
+	select {
+	case <-ctx.Done():
+		shutdown()
+	case <-allDone(lrs):
+		if !exitOnCompletion || ctx.Err() != nil {
+			<-ctx.Done()
+			shutdown()
+			return
+		}
+		// Replay completed, shut down the metrics server and exit
+		cancel()
+		<-serverDone
+		<-stateDone
+		removePidFile()
+		os.Exit(exitCode)
+	}
+}
+
+// errMaxRuntime is the cause of the cancellation of the context of run once
+// MAX_RUNTIME has passed.
+var errMaxRuntime = errors.New("maximum runtime reached")
+
+// configKeys are the environment variables set from the configuration files,
+// which are replaced when the configuration is reloaded.
+var configKeys []string
+
+// loadConfig reads the configuration files given by CONFIG_PATH with the
+// profile given by PROFILE and exposes their values as environment variables.
+func loadConfig() {
+	c, err := readConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := applyConfig(c); err != nil {
+		log.Fatalf("Failed to apply configuration: %v", err)
+	}
+}
 
-	// Start replaying the log
-	go lr.Start(ctx, time.Now(), print)
+// readConfig reads the configuration files given by CONFIG_PATH with the
+// profile given by PROFILE, nil if CONFIG_PATH is not set.
+func readConfig() (config.Config, error) {
+	path := getenv("CONFIG_PATH", "")
+	if len(path) == 0 {
+		return nil, nil
+	}
+	return config.LoadProfile(path, getenv("PROFILE", ""))
+}
 
-	<-ctx.Done()
+// applyConfig sets the environment variables of the configuration that are
+// not set yet and records them in configKeys.
+func applyConfig(c config.Config) error {
+	for k := range c {
+		if _, ok := os.LookupEnv(k); !ok {
+			configKeys = append(configKeys, k)
+		}
+	}
+	return c.Apply()
 }
 
 func createMetricsEngine() *metrics.MetricsEngine {
@@ -82,7 +482,56 @@ func createMetricsEngine() *metrics.MetricsEngine {
 		log.Fatal(err)
 	}
 	// Read metrics from env vars and expose them via http
-	return builder.Build()
+	engine := builder.Build()
+	if err := engine.SetCounterMode(getenv("METRICS_COUNTER_MODE", metrics.CounterAccumulate)); err != nil {
+		log.Fatalf("Invalid metrics counter mode: %v", err)
+	}
+	return engine
+}
+
+// persistMetricsState restores the state of the metrics engine from
+// METRICS_STATE_FILE and persists it there every METRICS_STATE_INTERVAL until
+// the context is cancelled. The returned channel is closed once the final state
+// has been written, or immediately if no state file is configured.
+func persistMetricsState(ctx context.Context, engine *metrics.MetricsEngine) <-chan struct{} {
+	done := make(chan struct{})
+	path := getenv("METRICS_STATE_FILE", "")
+	if len(path) == 0 {
+		close(done)
+		return done
+	}
+	interval := getDuration("METRICS_STATE_INTERVAL", "10s")
+	if interval <= 0 {
+		log.Fatalf("Invalid metrics state interval: %s, must be positive", interval)
+	}
+	if err := engine.LoadState(path); err != nil {
+		log.Fatalf("Failed to load metrics state: %v", err)
+	}
+	go func() {
+		engine.PersistState(ctx, path, interval)
+		close(done)
+	}()
+	return done
+}
+
+// setMetricsClock sets the clock of the metrics engine given by METRICS_CLOCK:
+// "wall" uses the real time, "replay" the virtual clock of the replay, so t
+// advances at the replay speed and the time-of-day helpers use the original
+// time of the last replayed line. Patterns then stay aligned with the replayed
+// scenario under time acceleration.
+func setMetricsClock(engine *metrics.MetricsEngine, lr *logs.LogReplayer) {
+	switch c := getenv("METRICS_CLOCK", "wall"); c {
+	case "wall":
+	case "replay":
+		engine.SetClock(clock.WithNow(lr.Clock(), func() time.Time {
+			if t := lr.Stats().LogTime; !t.IsZero() {
+				return t
+			}
+			return time.Now()
+		}))
+	default:
+		log.Fatalf("Invalid metrics clock: %s, must be wall or replay", c)
+	}
 }
 
 func getPort() int {
@@ -92,4 +541,556 @@ func getPort() int {
 		log.Fatalf("Invalid metrics port: %s, err: %s", portStr, err)
 	}
 	return port
+}
+
+func getMaxLines() int {
+	maxLinesStr := getenv("MAX_LINES", "0")
+	maxLines, err := strconv.Atoi(maxLinesStr)
+	if err != nil || maxLines < 0 {
+		log.Fatalf("Invalid max lines: %s, err: %v", maxLinesStr, err)
+	}
+	return maxLines
+}
+
+// getTimeFormats splits the regexes, formats and templates given by the
+// environment variables <prefix>_REGEX, <prefix>_FORMAT and <prefix>_TEMPLATE
+// into their "||" separated alternatives. A single regex is used for all
+// formats and vice versa. If the regex and format variables are empty, nil is
+// returned.
+func getTimeFormats(prefix, regexFallback, formatFallback string) []logs.TimestampFormat {
+	timeFormats, err := parseTimeFormats(getenv(prefix+"_REGEX", regexFallback), getenv(prefix+"_FORMAT", formatFallback),
+		getenv(prefix+"_TEMPLATE", ""))
+	if err != nil {
+		log.Fatalf("Invalid time formats in %s_REGEX, %s_FORMAT and %s_TEMPLATE: %s", prefix, prefix, prefix, err)
+	}
+	return timeFormats
+}
+
+// parseTimeFormats pairs the "||" separated regexes, formats and templates. A
+// single regex, format or template is used for all of the other lists.
+func parseTimeFormats(regexStr, formatStr, templateStr string) ([]logs.TimestampFormat, error) {
+	if len(regexStr) == 0 && len(formatStr) == 0 {
+		return nil, nil
+	}
+	regexes := strings.Split(regexStr, "||")
+	formats := strings.Split(formatStr, "||")
+	templates := strings.Split(templateStr, "||")
+	n := max(len(regexes), len(formats))
+	if (len(regexes) != n && len(regexes) != 1) || (len(formats) != n && len(formats) != 1) {
+		return nil, fmt.Errorf("got %d regexes and %d formats", len(regexes), len(formats))
+	}
+	if len(templates) != n && len(templates) != 1 {
+		return nil, fmt.Errorf("got %d templates for %d time formats", len(templates), n)
+	}
+	timeFormats := make([]logs.TimestampFormat, n)
+	for i := range timeFormats {
+		timeFormats[i] = logs.TimestampFormat{
+			Regex: regexes[min(i, len(regexes)-1)],
+			Format: formats[min(i, len(formats)-1)],
+			Template: templates[min(i, len(templates)-1)],
+		}
+	}
+	return timeFormats, nil
+}
+
+// getTimeParseCheck returns the TimeParseCheck option for TIME_PARSE_CHECK.
+func getTimeParseCheck() string {
+	check := getenv("TIME_PARSE_CHECK", logs.TimeParseWarn)
+	switch check {
+	case "off":
+		return ""
+	case logs.TimeParseStop, logs.TimeParseWarn:
+		return check
+	}
+	log.Fatalf("Invalid value for TIME_PARSE_CHECK: %s, must be stop, warn or off", check)
+	return ""
+}
+
+// getStreams returns the per-input options given by STREAM_SPEED,
+// STREAM_JITTER and STREAM_FILTER_REGEX, or nil if none are set.
+func getStreams() map[string]logs.StreamOptions {
+	var streams map[string]logs.StreamOptions
+	// forEach calls f with the input name and value of every override in the
+	// environment variable with the given key.
+	forEach := func(key, sep string, f func(name, value string) error) {
+		spec := getenv(key, "")
+		if len(spec) == 0 {
+			return
+		}
+		if streams == nil {
+			streams = map[string]logs.StreamOptions{}
+		}
+		for _, entry := range strings.Split(spec, sep) {
+			name, value, ok := strings.Cut(entry, "=")
+			if !ok {
+				log.Fatalf("Invalid value for %s: %s, must be <input>=<value>", key, entry)
+			}
+			if err := f(strings.TrimSpace(name), value); err != nil {
+				log.Fatalf("Invalid value for %s: %s, err: %v", key, entry, err)
+			}
+		}
+	}
+	forEach("STREAM_SPEED", ",", func(name, value string) error {
+		speed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		so := streams[name]
+		so.Speed = speed
+		streams[name] = so
+		return err
+	})
+	forEach("STREAM_JITTER", ",", func(name, value string) error {
+		jitter, err := time.ParseDuration(strings.TrimSpace(value))
+		so := streams[name]
+		so.Jitter = jitter
+		streams[name] = so
+		return err
+	})
+	forEach("STREAM_FILTER_REGEX", "||", func(name, value string) error {
+		so := streams[name]
+		so.FilterRegex = value
+		streams[name] = so
+		return nil
+	})
+	return streams
+}
+
+// getGuard returns a guard of the limits given by MEMORY_LIMIT and
+// MAX_GOROUTINES, or nil if neither is set.
+func getGuard() *guard.Guard {
+	var limits guard.Limits
+	if v := getenv("MEMORY_LIMIT", "0"); v != "0" {
+		var err error
+		if limits.Memory, err = sinks.ParseSize(v); err != nil {
+			log.Fatalf("Invalid value for MEMORY_LIMIT: %v", err)
+		}
+	}
+	limits.Goroutines = getInt("MAX_GOROUTINES", "0")
+	if limits.Memory == 0 && limits.Goroutines <= 0 {
+		return nil
+	}
+	if interval := getDuration("GUARD_INTERVAL", "1s"); interval <= 0 {
+		log.Fatalf("Invalid value for GUARD_INTERVAL: %s, must be positive", interval)
+	}
+	return guard.New(limits)
+}
+
+// getGuardThrottle returns the factor given by GUARD_THROTTLE the replays
+// are slowed down by under resource pressure.
+func getGuardThrottle() float64 {
+	v := getenv("GUARD_THROTTLE", "0.5")
+	throttle, err := strconv.ParseFloat(v, 64)
+	if err != nil || throttle <= 0 || throttle > 1 {
+		log.Fatalf("Invalid value for GUARD_THROTTLE: %s, must be between 0 and 1", v)
+	}
+	return throttle
+}
+
+// relievePressure returns a handler of the guard that slows the replays down
+// by throttle and suspends the bursts and injected lines of their anomalies
+// while the resource usage is close to the limits.
+func relievePressure(replays []*replay, throttle float64) func(bool, guard.Usage) {
+	return func(pressure bool, u guard.Usage) {
+		factor := 1.0
+		if pressure {
+			factor = throttle
+			log.Printf("Resource usage close to the limits (%d bytes of memory, %d goroutines), slowing down",
+				u.Memory, u.Goroutines)
+		} else {
+			log.Printf("Resource usage relieved (%d bytes of memory, %d goroutines), resuming", u.Memory, u.Goroutines)
+		}
+		for _, r := range replays {
+			r.lr.Throttle(factor)
+			r.injector.SetShedding(pressure)
+		}
+	}
+}
+
+// shedUnderPressure returns a collector that returns the values of c except
+// while g reports resource pressure. It returns c if g is nil.
+func shedUnderPressure(g *guard.Guard, c metrics.Collector) metrics.Collector {
+	if g == nil {
+		return c
+	}
+	return func() []metrics.MetricValue {
+		if g.Pressure() {
+			return nil
+		}
+		return c()
+	}
+}
+
+// getLogMetrics returns the summaries and histograms of values extracted from
+// the replayed lines configured by LOG_METRIC_ variables.
+func getLogMetrics() []*metrics.LogMetric {
+	lms, err := metrics.LogMetricsFromEnv(os.Environ())
+	if err != nil {
+		log.Fatalf("Invalid log metrics: %v", err)
+	}
+	return lms
+}
+
+// getScenario returns the orchestrator of the scenario of SCENARIO_FILE for
+// the replays and the metrics of the engine, or nil if no scenario is
+// configured.
+func getScenario(replays []*replay, engine *metrics.MetricsEngine) *scenario.Orchestrator {
+	path := getenv("SCENARIO_FILE", "")
+	if len(path) == 0 {
+		return nil
+	}
+	s, err := scenario.Load(path)
+	if err != nil {
+		log.Fatalf("Failed to load scenario: %v", err)
+	}
+	controlled := make([]scenario.Replay, len(replays))
+	for i, r := range replays {
+		controlled[i] = scenario.Replay{Name: r.instance, Replayer: r.lr, Injector: r.injector}
+	}
+	o, err := scenario.New(s, controlled, engine)
+	if err != nil {
+		log.Fatalf("Invalid scenario %s: %v", path, err)
+	}
+	return o
+}
+
+// getChurnCollector returns a collector of series churning at CHURN_RATE new
+// series per hour that live for CHURN_LIFETIME, or nil if CHURN_RATE is not set.
+func getChurnCollector() metrics.Collector {
+	v := getenv("CHURN_RATE", "0")
+	rate, err := strconv.ParseFloat(v, 64)
+	if err != nil || rate < 0 {
+		log.Fatalf("Invalid value for CHURN_RATE: %s, must be a positive number", v)
+	}
+	if rate == 0 {
+		return nil
+	}
+	churn, err := metrics.NewChurnCollector(metrics.ChurnOptions{
+		Metric: getenv("CHURN_METRIC", metrics.ChurnMetricName),
+		Label: getenv("CHURN_LABEL", "pod"),
+		Rate: rate,
+		Lifetime: getDuration("CHURN_LIFETIME", "1h"),
+	}, time.Now)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return churn
+}
+
+// getSampleRate returns the share of lines given by SAMPLE_RATE.
+func getSampleRate() float64 {
+	v := getenv("SAMPLE_RATE", "1")
+	rate, err := strconv.ParseFloat(v, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		log.Fatalf("Invalid value for SAMPLE_RATE: %s, must be between 0 and 1", v)
+	}
+	return rate
+}
+
+// getSampleRules returns the rules given by SAMPLE_RULES as "||" separated
+// "<rate>=<regex>" entries.
+func getSampleRules() []logs.SampleRule {
+	spec := getenv("SAMPLE_RULES", "")
+	if len(spec) == 0 {
+		return nil
+	}
+	var rules []logs.SampleRule
+	for _, entry := range strings.Split(spec, "||") {
+		rate, regex, ok := strings.Cut(entry, "=")
+		if !ok {
+			log.Fatalf("Invalid value for SAMPLE_RULES: %s, must be <rate>=<regex>", entry)
+		}
+		r, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		if err != nil {
+			log.Fatalf("Invalid value for SAMPLE_RULES: %s, err: %v", entry, err)
+		}
+		rules = append(rules, logs.SampleRule{Regex: regex, Rate: r})
+	}
+	return rules
+}
+
+// applyPreset sets FILTER_REGEX, TIME_REGEX and TIME_FORMAT to the values of
+// the log format preset given by PRESET, unless they are set explicitly. With a
+// suffix like "_2", the suffixed variables of a single replay are used instead.
+func applyPreset(suffix string) {
+	name := os.Getenv("PRESET" + suffix)
+	if len(name) == 0 {
+		return
+	}
+	p, err := presets.Get(name)
+	if err != nil {
+		log.Fatalf("Invalid preset: %v", err)
+	}
+	c := config.Config{
+		"FILTER_REGEX" + suffix: p.FilterRegex,
+		"TIME_REGEX" + suffix: p.TimeRegex,
+		"TIME_FORMAT" + suffix: p.TimeFormat,
+	}
+	if err := c.Apply(); err != nil {
+		log.Fatalf("Failed to apply preset: %v", err)
+	}
+}
+
+// getAnnotations returns the outputs given by ANNOTATIONS_OUTPUT the
+// annotations of the replay are written to, or nil if none are configured.
+func getAnnotations() logs.Sink {
+	spec := getenv("ANNOTATIONS_OUTPUT", "")
+	if len(spec) == 0 {
+		return nil
+	}
+	sink, err := sinks.OpenAll(spec)
+	if err != nil {
+		log.Fatalf("Invalid value for ANNOTATIONS_OUTPUT: %v", err)
+	}
+	return sink
+}
+
+// getSuppressionWindows returns the windows given by SUPPRESS_WINDOWS in which
+// no lines are emitted, or nil if none are configured.
+func getSuppressionWindows() *suppress.Windows {
+	spec := getenv("SUPPRESS_WINDOWS", "")
+	if len(spec) == 0 {
+		return nil
+	}
+	windows, err := suppress.Parse(spec)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return windows
+}
+
+// getAnomalyInjector returns the injector of the anomalies given by
+// ANOMALIES. Without anomalies, it only injects the incidents triggered via
+// the control endpoint. Injected error lines have their timestamps formatted
+// with layout.
+func getAnomalyInjector(layout string) *anomaly.Injector {
+	var anomalies []*anomaly.Anomaly
+	if spec := getenv("ANOMALIES", ""); len(spec) > 0 {
+		var err error
+		if anomalies, err = anomaly.Parse(spec); err != nil {
+			log.Fatal(err)
+		}
+	}
+	var lines []string
+	if l := getenv("ANOMALY_ERROR_LINES", ""); len(l) > 0 {
+		lines = strings.Split(l, "||")
+	}
+	return anomaly.NewInjector(anomalies, lines, layout)
+}
+
+// getTransformers returns the transformers of loadTransformers and exits if
+// they are invalid.
+func getTransformers() []logs.Transformer {
+	transformers, err := loadTransformers()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return transformers
+}
+
+// loadTransformers returns the placeholder expansion if TEMPLATE_VARS is
+// enabled, followed by the line transformation given by LINE_TRANSFORM or read
+// from LINE_TRANSFORM_FILE, the mapping given by LINE_MAPPING or read from
+// LINE_MAPPING_FILE and the anonymization given by ANONYMIZE, if any.
+// The anonymization comes last, so a transformation cannot reintroduce
+// principals.
+func loadTransformers() ([]logs.Transformer, error) {
+	var transformers []logs.Transformer
+	if getenv("TEMPLATE_VARS", "false") == "true" {
+		transformers = append(transformers, logs.NewTemplateTransformer())
+	}
+	script := getenv("LINE_TRANSFORM", "")
+	if path := getenv("LINE_TRANSFORM_FILE", ""); len(path) > 0 {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read line transform file: %w", err)
+		}
+		script = string(content)
+	}
+	if len(script) > 0 {
+		st, err := logs.NewScriptTransformer(script)
+		if err != nil {
+			return nil, err
+		}
+		transformers = append(transformers, st)
+	}
+	source := getenv("LINE_MAPPING", "")
+	if path := getenv("LINE_MAPPING_FILE", ""); len(path) > 0 {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read line mapping file: %w", err)
+		}
+		source = string(content)
+	}
+	if len(source) > 0 {
+		m, err := mapping.Compile(source, getenv("LINE_MAPPING_FORMAT", mapping.FormatAuto))
+		if err != nil {
+			return nil, err
+		}
+		transformers = append(transformers, m)
+	}
+	anonymizer, err := getAnonymizer()
+	if err != nil {
+		return nil, err
+	}
+	if anonymizer != nil {
+		transformers = append(transformers, anonymizer)
+	}
+	return transformers, nil
+}
+
+// getAnonymizer returns a transformer replacing the principals in security
+// logs of the format given by ANONYMIZE with pseudonyms derived with
+// ANONYMIZE_KEY, or nil if ANONYMIZE is not set. With ANONYMIZE=true, the
+// format of PRESET is used. Without a key, a random key is generated, so the
+// pseudonyms are only stable while the process runs.
+func getAnonymizer() (logs.Transformer, error) {
+	format := getenv("ANONYMIZE", "")
+	if len(format) == 0 || format == "false" {
+		return nil, nil
+	}
+	if format == "true" {
+		format = getenv("PRESET", "")
+	}
+	key := []byte(getenv("ANONYMIZE_KEY", ""))
+	if len(key) == 0 {
+		key = randomAnonymizationKey()
+	}
+	a, err := anonymize.New(format, key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value for ANONYMIZE: %w", err)
+	}
+	return logs.TransformerFunc(func(e logs.LogEvent) (logs.LogEvent, bool) {
+		e.Line = a.Line(e.Line)
+		return e, true
+	}), nil
+}
+
+// anonymizationKey is the random key of the pseudonyms if ANONYMIZE_KEY is not
+// set, kept across reloads of the configuration.
+var anonymizationKey []byte
+
+// randomAnonymizationKey returns anonymizationKey, generating it on first use.
+func randomAnonymizationKey() []byte {
+	if anonymizationKey == nil {
+		anonymizationKey = make([]byte, 32)
+		if _, err := rand.Read(anonymizationKey); err != nil {
+			log.Fatalf("Failed to generate anonymization key: %v", err)
+		}
+	}
+	return anonymizationKey
+}
+
+// getServerOptions returns the timeouts and limits of the HTTP server, with
+// the defaults of metrics.DefaultServerOptions.
+func getServerOptions() metrics.ServerOptions {
+	d := metrics.DefaultServerOptions
+	return metrics.ServerOptions{
+		ReadHeaderTimeout: getDuration("HTTP_READ_HEADER_TIMEOUT", d.ReadHeaderTimeout.String()),
+		ReadTimeout: getDuration("HTTP_READ_TIMEOUT", d.ReadTimeout.String()),
+		WriteTimeout: getDuration("HTTP_WRITE_TIMEOUT", d.WriteTimeout.String()),
+		IdleTimeout: getDuration("HTTP_IDLE_TIMEOUT", d.IdleTimeout.String()),
+		MaxHeaderBytes: getInt("HTTP_MAX_HEADER_BYTES", strconv.Itoa(d.MaxHeaderBytes)),
+		KeepAlive: getenv("HTTP_KEEP_ALIVE", strconv.FormatBool(d.KeepAlive)) == "true",
+		ShutdownTimeout: getDuration("HTTP_SHUTDOWN_TIMEOUT", d.ShutdownTimeout.String()),
+	}
+}
+
+func getResponsePadding() int {
+	paddingStr := getenv("METRICS_RESPONSE_PADDING", "0")
+	padding, err := strconv.Atoi(paddingStr)
+	if err != nil || padding < 0 {
+		log.Fatalf("Invalid response padding: %s, err: %v", paddingStr, err)
+	}
+	return padding
+}
+
+func getMaxBytesPerSecond() int {
+	maxBytesStr := getenv("MAX_BYTES_PER_SECOND", "0")
+	maxBytes, err := strconv.Atoi(maxBytesStr)
+	if err != nil || maxBytes < 0 {
+		log.Fatalf("Invalid max bytes per second: %s, err: %v", maxBytesStr, err)
+	}
+	return maxBytes
+}
+
+func getMaxDuration() time.Duration {
+	return getDuration("MAX_DURATION", "0s")
+}
+
+// getStartAt returns the time in START_AT, or the zero time if it is not set.
+func getStartAt() time.Time {
+	value := getenv("START_AT", "")
+	if len(value) == 0 {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		log.Fatalf("Invalid value for START_AT: %s, err: %v", value, err)
+	}
+	return t
+}
+
+// waitForStart waits until START_AT and returns the time the replay starts
+// at. The first line is mapped to START_AT itself rather than the time the wait
+// ended, so replicas that share START_AT emit identical timestamps. If START_AT
+// is not set or has passed, the replay starts now. It returns false if the
+// context was cancelled while waiting.
+func waitForStart(ctx context.Context) (time.Time, bool) {
+	now := time.Now()
+	startAt := getStartAt()
+	if startAt.IsZero() {
+		return now, true
+	}
+	if !startAt.After(now) {
+		log.Printf("START_AT %s has passed, starting the replay now", startAt.Format(time.RFC3339Nano))
+		return now, true
+	}
+	log.Printf("Waiting %s until START_AT %s", startAt.Sub(now).Round(time.Millisecond),
+		startAt.Format(time.RFC3339Nano))
+	timer := time.NewTimer(startAt.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return time.Time{}, false
+	case <-timer.C:
+		return startAt, true
+	}
+}
+
+// getDuration returns the non-negative duration in the environment variable
+// with the given key, or the fallback if it is not set.
+func getDuration(key, fallback string) time.Duration {
+	durationStr := getenv(key, fallback)
+	duration, err := time.ParseDuration(durationStr)
+	if err != nil || duration < 0 {
+		log.Fatalf("Invalid duration for %s: %s, err: %v", key, durationStr, err)
+	}
+	return duration
+}
+
+// getInt returns the non-negative integer in the environment variable with
+// the given key, or the fallback if it is not set.
+func getInt(key, fallback string) int {
+	intStr := getenv(key, fallback)
+	i, err := strconv.Atoi(intStr)
+	if err != nil || i < 0 {
+		log.Fatalf("Invalid value for %s: %s, err: %v", key, intStr, err)
+	}
+	return i
+}
+
+func getSpeed() float64 {
+	speedStr := getenv("SPEED", "1")
+	speed, err := strconv.ParseFloat(speedStr, 64)
+	if err != nil || speed <= 0 {
+		log.Fatalf("Invalid speed: %s, err: %v", speedStr, err)
+	}
+	return speed
+}
+
+func getExitCode() int {
+	exitCodeStr := getenv("EXIT_CODE", "0")
+	exitCode, err := strconv.Atoi(exitCodeStr)
+	if err != nil {
+		log.Fatalf("Invalid exit code: %s, err: %v", exitCodeStr, err)
+	}
+	return exitCode
 }
\ No newline at end of file
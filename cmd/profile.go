@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bananabacon/internal/profile"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// runProfile implements the profile command, which analyzes a log and writes
+// its statistical profile as JSON: the rate over time, the distribution of log
+// levels and the most frequent message templates. The timestamps are parsed
+// with TIME_REGEX, TIME_FORMAT and TIME_LOCALE. It returns the exit code: 0 on
+// success and 2 on errors.
+func runProfile(args []string) int {
+	fs := flag.NewFlagSet("profile", flag.ContinueOnError)
+	input := fs.String("input", getenv("INPUT_FILE", ""), "the log to profile")
+	output := fs.String("output", "", "the file the profile is written to, stdout if empty")
+	interval := fs.Duration("interval", profile.DefaultInterval, "the width of the rate buckets")
+	clusters := fs.Int("clusters", profile.DefaultMaxClusters, "the number of message templates to keep")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(*input) == 0 {
+		fmt.Fprintln(os.Stderr, "profile: -input is required")
+		return 2
+	}
+	if *interval <= 0 || *clusters <= 0 {
+		fmt.Fprintln(os.Stderr, "profile: -interval and -clusters must be positive")
+		return 2
+	}
+	in, err := os.Open(*input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "profile: %v\n", err)
+		return 2
+	}
+	defer in.Close()
+
+	p, err := profile.Extract(in, profile.Options{
+		TimeFormats: getTimeFormats("TIME", defaultTimeRegex, defaultTimeFormat),
+		Locale: getenv("TIME_LOCALE", ""),
+		Interval: *interval,
+		MaxClusters: *clusters,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "profile: %v\n", err)
+		return 2
+	}
+	out := os.Stdout
+	if len(*output) > 0 {
+		if out, err = os.Create(*output); err != nil {
+			fmt.Fprintf(os.Stderr, "profile: %v\n", err)
+			return 2
+		}
+		defer out.Close()
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(p); err != nil {
+		fmt.Fprintf(os.Stderr, "profile: %v\n", err)
+		return 2
+	}
+	return 0
+}
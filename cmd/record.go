@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bananabacon/internal/record"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// runRecord implements the record command, which captures lines from stdin, a
+// live file or the logs of a container, see record.Follow, into a replay-ready
+// log: lines without a timestamp are prefixed with the current time in
+// TIME_FORMAT, existing timestamps are found with TIME_REGEX and TIME_FORMAT
+// of the source, given by -time-regex and -time-format. It records until the input ends or SIGINT or SIGTERM is
+// received and returns the exit code: 0 on success and 2 on errors.
+func runRecord(args []string) int {
+	fs := flag.NewFlagSet("record", flag.ContinueOnError)
+	input := fs.String("input", "", "the live file to tail, docker://<container> or docker-json:<file>, stdin if empty")
+	fromStart := fs.Bool("from-start", false, "also record the existing content of -input")
+	output := fs.String("output", "", "the file the capture is appended to, stdout if empty")
+	timeRegex := fs.String("time-regex", "", "a regex matching the timestamps already in the lines, \"||\" separated")
+	timeFormat := fs.String("time-format", "", "the format of the timestamps matched by -time-regex, \"||\" separated")
+	timeTemplate := fs.String("time-template", "", "concatenates the named groups of -time-regex, \"||\" separated")
+	normalize := fs.Bool("normalize", true, "rewrite timestamps already in the lines in TIME_FORMAT")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	timeFormats, err := parseTimeFormats(*timeRegex, *timeFormat, *timeTemplate)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "record: invalid time formats: %v\n", err)
+		return 2
+	}
+	rc, err := record.NewRecorder(record.Options{
+		TimeFormats: timeFormats,
+		Locale: getenv("TIME_LOCALE", ""),
+		Layout: getenv("TIME_FORMAT", defaultTimeFormat),
+		Normalize: *normalize,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "record: %v\n", err)
+		return 2
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+	var in io.Reader = os.Stdin
+	if len(*input) > 0 {
+		if in, err = record.Follow(ctx, *input, *fromStart); err != nil {
+			fmt.Fprintf(os.Stderr, "record: %v\n", err)
+			return 2
+		}
+	}
+	out := os.Stdout
+	if len(*output) > 0 {
+		if out, err = os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err != nil {
+			fmt.Fprintf(os.Stderr, "record: %v\n", err)
+			return 2
+		}
+		defer out.Close()
+	}
+	n, err := rc.Record(in, out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "record: %v\n", err)
+		return 2
+	}
+	fmt.Fprintf(os.Stderr, "record: recorded %d lines\n", n)
+	return 0
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bananabacon/internal/debug"
+	logs "bananabacon/internal/logs"
+	metrics "bananabacon/internal/metrics"
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reloadablePrefixes are the prefixes of the environment variables whose
+// changes are applied by reloader.reload. Changes of other variables are
+// only applied on restart.
+var reloadablePrefixes = []string{"METRIC_", "FILTER_REGEX", "SPEED", "LINE_TRANSFORM", "LINE_MAPPING",
+	"TEMPLATE_VARS", "ANONYMIZE", "DEBUG"}
+
+// reloader applies changes of the configuration files to the running
+// process: the metrics, the filter regexes, the line transformations and
+// mappings and the speeds of the replays, see reload.
+type reloader struct {
+	replays []*replay
+	engine *metrics.MetricsEngine
+	mu sync.Mutex
+}
+
+// replayStages are the settings of a replay that can be reloaded.
+type replayStages struct {
+	filter string
+	transformers []logs.Transformer
+	speed string
+}
+
+// reload reads the configuration files again and applies the changed
+// settings. Metrics with unchanged names and labels continue from their last
+// values. If any of the new settings is invalid, nothing is applied and the
+// previous configuration stays in effect. Changes of settings that cannot be
+// reloaded are logged.
+func (rl *reloader) reload() error {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	c, err := readConfig()
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	before := environ()
+	previous := make([]replayStages, len(rl.replays))
+	for i, r := range rl.replays {
+		envInstance = r.instance
+		previous[i].speed = getenv("SPEED", "1")
+	}
+	envInstance = ""
+	keys := configKeys
+	for _, k := range configKeys {
+		os.Unsetenv(k)
+	}
+	configKeys = nil
+	if err := applyConfig(c); err != nil {
+		restoreEnv(before, keys)
+		return fmt.Errorf("failed to apply configuration: %w", err)
+	}
+
+	builder, err := metrics.NewMetricsEngineBuilderFromEnv()
+	if err != nil {
+		restoreEnv(before, keys)
+		return err
+	}
+	stages, err := loadReplayStages(rl.replays)
+	if err != nil {
+		restoreEnv(before, keys)
+		return err
+	}
+
+	rl.engine.SetMetrics(builder.Build().Metrics)
+	for i, r := range rl.replays {
+		if err := r.lr.Reconfigure(stages[i].filter, stages[i].transformers); err != nil {
+			// The filter regex was validated by loadReplayStages
+			log.Printf("Failed to reconfigure replay: %v", err)
+		}
+		if stages[i].speed != previous[i].speed {
+			speed, _ := strconv.ParseFloat(stages[i].speed, 64)
+			r.lr.SetSpeed(speed)
+		}
+	}
+	if debugEnabled := getenv("DEBUG", "false") == "true"; debugEnabled != (before["DEBUG"] == "true") {
+		debug.SetEnabled(debugEnabled)
+	}
+	if restart := changedKeys(before, environ()); len(restart) > 0 {
+		log.Printf("Reloaded the configuration, changes of %s are applied on restart", strings.Join(restart, ", "))
+	} else {
+		log.Printf("Reloaded the configuration")
+	}
+	return nil
+}
+
+// loadReplayStages returns the reloadable settings of the replays from the
+// environment.
+func loadReplayStages(replays []*replay) ([]replayStages, error) {
+	defer func() { envInstance = "" }()
+	stages := make([]replayStages, len(replays))
+	for i, r := range replays {
+		envInstance = r.instance
+		s := &stages[i]
+		s.filter = getenv("FILTER_REGEX", ".*")
+		if _, err := regexp.Compile(s.filter); err != nil {
+			return nil, fmt.Errorf("invalid filter regex: %w", err)
+		}
+		var err error
+		if s.transformers, err = loadTransformers(); err != nil {
+			return nil, err
+		}
+		s.speed = getenv("SPEED", "1")
+		if speed, err := strconv.ParseFloat(s.speed, 64); err != nil || speed <= 0 {
+			return nil, fmt.Errorf("invalid speed: %s", s.speed)
+		}
+	}
+	return stages, nil
+}
+
+// environ returns the environment variables by name.
+func environ() map[string]string {
+	env := map[string]string{}
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		env[k] = v
+	}
+	return env
+}
+
+// restoreEnv sets the environment to env, with keys being the variables set
+// from the configuration files.
+func restoreEnv(env map[string]string, keys []string) {
+	for k := range environ() {
+		if _, ok := env[k]; !ok {
+			os.Unsetenv(k)
+		}
+	}
+	for k, v := range env {
+		os.Setenv(k, v)
+	}
+	configKeys = keys
+}
+
+// changedKeys returns the sorted names of the variables that differ between
+// before and after and cannot be reloaded.
+func changedKeys(before, after map[string]string) []string {
+	var keys []string
+	for k := range before {
+		if v, ok := after[k]; !ok || v != before[k] {
+			keys = append(keys, k)
+		}
+	}
+	for k := range after {
+		if _, ok := before[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	keys = slices.DeleteFunc(keys, func(k string) bool {
+		return slices.ContainsFunc(reloadablePrefixes, func(p string) bool { return strings.HasPrefix(k, p) })
+	})
+	slices.Sort(keys)
+	return keys
+}
+
+// watch reloads the configuration whenever the files given by CONFIG_PATH
+// change, checking them in the given interval until the context is cancelled.
+// Files included by the configuration files are not watched.
+func (rl *reloader) watch(ctx context.Context, interval time.Duration) {
+	path := getenv("CONFIG_PATH", "")
+	if len(path) == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := configFingerprint(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if fp := configFingerprint(path); fp != last {
+			last = fp
+			if err := rl.reload(); err != nil {
+				log.Printf("Failed to reload the configuration: %v", err)
+			}
+		}
+	}
+}
+
+// configFingerprint returns a string that changes whenever a file at path,
+// or in it if it is a directory, is changed, added or removed.
+func configFingerprint(path string) string {
+	var sb strings.Builder
+	filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && !d.IsDir() {
+			fmt.Fprintf(&sb, "%s:%d:%d;", p, info.Size(), info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	return sb.String()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bananabacon/internal/metrics"
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// runRepl implements the repl command, which reads JavaScript expressions line
+// by line from stdin and evaluates them against the metrics engine of a
+// running instance with EVAL_API enabled. It returns the exit code: 0 when
+// stdin is exhausted and 2 if the instance cannot be reached.
+func runRepl(args []string) int {
+	fs := flag.NewFlagSet("repl", flag.ContinueOnError)
+	addr := fs.String("url", "http://localhost:"+getenv("METRICS_PORT", "8080"), "the address of the running instance")
+	token := fs.String("token", getenv("EVAL_TOKEN", ""), "the bearer token required by the instance")
+	metric := fs.String("metric", "", "the metric whose last value and state are passed as prev and state")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	endpoint := strings.TrimSuffix(*addr, "/") + "/api/eval"
+	if len(*metric) > 0 {
+		endpoint += "?metric=" + url.QueryEscape(*metric)
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for fmt.Print("> "); scanner.Scan(); fmt.Print("> ") {
+		expr := strings.TrimSpace(scanner.Text())
+		if len(expr) == 0 {
+			continue
+		}
+		res, err := evalRemote(endpoint, *token, expr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "\nrepl: %v\n", err)
+			return 2
+		}
+		if len(res.Error) > 0 {
+			fmt.Printf("error: %s\n", res.Error)
+			continue
+		}
+		b, _ := json.Marshal(res.Result)
+		fmt.Println(string(b))
+	}
+	fmt.Println()
+	return 0
+}
+
+// evalRemote sends expr to the eval endpoint of a running instance.
+func evalRemote(endpoint, token, expr string) (metrics.EvalResult, error) {
+	var res metrics.EvalResult
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(expr))
+	if err != nil {
+		return res, err
+	}
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return res, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnprocessableEntity {
+		return res, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+	return res, json.NewDecoder(resp.Body).Decode(&res)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bananabacon/internal/anomaly"
+	logs "bananabacon/internal/logs"
+	metrics "bananabacon/internal/metrics"
+	"bananabacon/internal/sinks"
+	"bananabacon/internal/suppress"
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envInstance is the instance whose settings getenv reads, see newReplay.
+var envInstance string
+
+// replay is one of the independent replays run by the process, together with
+// the outputs of its lines.
+type replay struct {
+	instance string // the suffix of its environment variables, empty for a single replay
+	lr *logs.LogReplayer
+	sink logs.Sink
+	annotations logs.Sink // nil if no annotations are written
+	stream *sinks.StreamSink // nil if lines are not streamed over HTTP
+	windows *suppress.Windows // nil if no suppression windows are configured
+	injector *anomaly.Injector
+	closed chan struct{} // closed once the outputs are closed
+}
+
+// replayInstances returns the instances of the replays to run: "1", "2" and so
+// on for as long as INPUT_FILE_1, INPUT_FILE_2, ... are set, or a single
+// unnamed instance if INPUT_FILE_1 is not set.
+func replayInstances() []string {
+	var instances []string
+	for n := 1; len(os.Getenv("INPUT_FILE_"+strconv.Itoa(n))) > 0; n++ {
+		instances = append(instances, strconv.Itoa(n))
+	}
+	if len(instances) == 0 {
+		return []string{""}
+	}
+	return instances
+}
+
+// newReplay creates the replay of the given instance. Its settings are read
+// from the environment variables with the suffix "_<instance>", e.g.
+// OUTPUT_2 for instance "2", falling back to the variables without suffix,
+// which thereby serve as defaults for all replays.
+func newReplay(instance string) *replay {
+	envInstance = instance
+	defer func() { envInstance = "" }()
+	if len(instance) > 0 {
+		applyPreset("_" + instance)
+	}
+
+	file := getenv("INPUT_FILE", "/logs/test.log")
+	filterRegex := getenv("FILTER_REGEX", ".*")
+	timeFormats := getTimeFormats("TIME", defaultTimeRegex, defaultTimeFormat)
+	extraTimeFormats := getTimeFormats("EXTRA_TIME", "", "")
+	timeParseCheck := getTimeParseCheck()
+	maxTimeParseFailures := getInt("TIME_PARSE_MAX_FAILURES", "10")
+	if maxTimeParseFailures > 100 {
+		log.Fatalf("Invalid value for TIME_PARSE_MAX_FAILURES: %d, must be a percentage", maxTimeParseFailures)
+	}
+	loop := getenv("LOOP", "true")
+	maxLines := getMaxLines()
+	maxDuration := getMaxDuration()
+	maxBytesPerSecond := getMaxBytesPerSecond()
+	inputWaitTimeout := getDuration("INPUT_WAIT_TIMEOUT", "0s")
+	speed := getSpeed()
+	follow := getenv("FOLLOW", "false")
+	jitter := getDuration("JITTER", "0s")
+	scheduler := getenv("SCHEDULER", logs.TimerScheduler)
+	schedulerGranularity := getDuration("SCHEDULER_GRANULARITY", "0s")
+	batchWindow := getDuration("BATCH_WINDOW", logs.DefaultBatchWindow.String())
+	maxTimingError := getDuration("BATCH_MAX_ERROR", logs.DefaultMaxTimingError.String())
+	maxBatchLines := getInt("BATCH_MAX_LINES", strconv.Itoa(logs.DefaultMaxBatchLines))
+	checkpointFile := getenv("CHECKPOINT_FILE", "")
+	checkpointInterval := getDuration("CHECKPOINT_INTERVAL", "10s")
+	transformers := getTransformers()
+	r := &replay{
+		instance: instance,
+		windows: getSuppressionWindows(),
+		closed: make(chan struct{}),
+	}
+	if !dryRun {
+		r.annotations = getAnnotations()
+	}
+	var filters []logs.Filter
+	if r.windows != nil {
+		filters = append(filters, r.windows)
+	}
+
+	lr, err := logs.NewMultiLogReplayer(strings.Split(file, ","), logs.ReplayerOptions{
+		FilterRegex: filterRegex,
+		TimeRegex: timeFormats[0].Regex,
+		TimeFormat: timeFormats[0].Format,
+		TimeTemplate: timeFormats[0].Template,
+		FallbackTimeFormats: timeFormats[1:],
+		ExtraTimeFormats: extraTimeFormats,
+		TimeLocale: getenv("TIME_LOCALE", ""),
+		TimeParseCheck: timeParseCheck,
+		MaxTimeParseFailures: float64(maxTimeParseFailures) / 100,
+		Loop: loop == "true",
+		MaxLines: maxLines,
+		MaxDuration: maxDuration,
+		MaxBytesPerSecond: maxBytesPerSecond,
+		InputWaitTimeout: inputWaitTimeout,
+		InputEncoding: getenv("INPUT_ENCODING", logs.EncodingAuto),
+		ReadRotated: getenv("INPUT_ROTATED", "false") == "true",
+		Speed: speed,
+		AlignWeeks: getenv("ALIGN_WEEKS", "false") == "true",
+		Streams: getStreams(),
+		SampleRate: getSampleRate(),
+		SampleRules: getSampleRules(),
+		Follow: follow == "true",
+		DryRun: dryRun,
+		Jitter: jitter,
+		Scheduler: scheduler,
+		SchedulerGranularity: schedulerGranularity,
+		BatchWindow: batchWindow,
+		MaxTimingError: maxTimingError,
+		MaxBatchLines: maxBatchLines,
+		CheckpointFile: checkpointFile,
+		CheckpointInterval: checkpointInterval,
+		Filters: filters,
+		Transformers: transformers,
+		AuditFile: getenv("AUDIT_FILE", ""),
+		Annotations: r.annotations,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.lr = lr
+	if dryRun {
+		// runDryRun prints the schedule instead of writing to the outputs
+		return r
+	}
+	if r.windows != nil {
+		r.windows.OnChange(func(active bool, t time.Time) {
+			if active {
+				lr.Annotate("suppression_start", "started suppressing output", nil)
+			} else {
+				lr.Annotate("suppression_end", "stopped suppressing output", nil)
+			}
+		})
+	}
+
+	// Write the replayed lines to all configured outputs
+	if r.sink, err = sinks.OpenAll(getenv("OUTPUT", "stdout")); err != nil {
+		log.Fatal(err)
+	}
+	switch ordering := getenv("OUTPUT_ORDERING", "independent"); ordering {
+	case "independent":
+	case "ordered":
+		if r.sink, err = sinks.NewOrderedSink(r.sink); err != nil {
+			log.Fatalf("Invalid value for OUTPUT_ORDERING: %v", err)
+		}
+	default:
+		log.Fatalf("Invalid value for OUTPUT_ORDERING: %s, must be independent or ordered", ordering)
+	}
+	if getenv("LOG_STREAM", "false") == "true" {
+		r.stream = sinks.NewStreamSink(getInt("LOG_STREAM_BUFFER", "1000"))
+		r.sink = sinks.MultiSink{r.sink, r.stream}
+	}
+	r.injector = getAnomalyInjector(timeFormats[0].Format)
+	r.sink = r.injector.Sink(r.sink)
+	return r
+}
+
+// observe passes the replayed lines to the log metrics.
+func (r *replay) observe(lms []*metrics.LogMetric) {
+	if len(lms) == 0 {
+		return
+	}
+	r.sink = sinks.MultiSink{r.sink, logs.SinkFunc(func(_ context.Context, e logs.LogEvent) error {
+		for _, lm := range lms {
+			lm.Observe(e.Line)
+		}
+		return nil
+	})}
+}
+
+// labels returns the labels of the self-metrics of the replay.
+func (r *replay) labels() map[string]string {
+	if len(r.instance) == 0 {
+		return nil
+	}
+	return map[string]string{"replay": r.instance}
+}
+
+// run replays the log until it completed or the context is cancelled and
+// closes the outputs.
+func (r *replay) run(ctx context.Context, start time.Time) {
+	defer r.close()
+	if err := r.lr.StartSink(ctx, start, r.sink); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// close closes the outputs of the replay, which flushes their buffered lines.
+func (r *replay) close() {
+	r.sink.Close()
+	if r.annotations != nil {
+		r.annotations.Close()
+	}
+	close(r.closed)
+}
+
+// allDone returns a channel that is closed once all replayers completed.
+func allDone(lrs []*logs.LogReplayer) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		for _, lr := range lrs {
+			<-lr.Done()
+		}
+		close(done)
+	}()
+	return done
+}
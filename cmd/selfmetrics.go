@@ -0,0 +1,75 @@
+package main
+
+import (
+	logs "bananabacon/internal/logs"
+	metrics "bananabacon/internal/metrics"
+)
+
+// replayFamily is a metric family about the log replay. value returns the value
+// of a replay's series and false if it has none yet.
+type replayFamily struct {
+	name string
+	typ int
+	description string
+	value func(stats logs.ReplayerStats) (float64, bool)
+}
+
+// replayFamilies are the metric families exposed about each replay.
+var replayFamilies = []replayFamily{
+	{"bananabacon_replay_loop", metrics.GaugeType, "Current iteration of the log replay, starting at 1",
+		func(s logs.ReplayerStats) (float64, bool) { return float64(s.Run), true }},
+	{"bananabacon_replay_log_time_seconds", metrics.GaugeType,
+		"Original timestamp of the last replayed log line in seconds since the epoch",
+		func(s logs.ReplayerStats) (float64, bool) {
+			return float64(s.LogTime.UnixNano()) / 1e9, !s.LogTime.IsZero()
+		}},
+	{"bananabacon_replay_lines_read_total", metrics.CounterType, "Lines read from the inputs",
+		func(s logs.ReplayerStats) (float64, bool) { return float64(s.LinesRead), true }},
+	{"bananabacon_replay_lines_emitted_total", metrics.CounterType, "Lines written to the outputs",
+		func(s logs.ReplayerStats) (float64, bool) { return float64(s.LinesEmitted), true }},
+	{"bananabacon_replay_lines_skipped_total", metrics.CounterType, "Lines read from the inputs but not emitted",
+		func(s logs.ReplayerStats) (float64, bool) { return float64(s.LinesSkipped), true }},
+	{"bananabacon_replay_lines_filtered_total", metrics.CounterType,
+		"Lines dropped by the filter regex, the sampling or a pipeline stage",
+		func(s logs.ReplayerStats) (float64, bool) { return float64(s.LinesFiltered), true }},
+	{"bananabacon_replay_parse_failures_total", metrics.CounterType,
+		"Lines whose timestamp matched the time regex but could not be parsed",
+		func(s logs.ReplayerStats) (float64, bool) { return float64(s.ParseFailures), true }},
+	{"bananabacon_replay_bytes_read_total", metrics.CounterType, "Bytes read from the inputs after decompression",
+		func(s logs.ReplayerStats) (float64, bool) { return float64(s.BytesRead), true }},
+	{"bananabacon_replay_schedule_drift_seconds", metrics.GaugeType,
+		"Seconds the last batch was emitted after it was due, negative if early",
+		func(s logs.ReplayerStats) (float64, bool) { return s.Drift.Seconds(), true }},
+}
+
+// replayCollector returns a metrics collector exposing statistics about the
+// replayers, e.g. the current replay loop and the virtual log time, so
+// dashboards can show where in the replayed scenario the simulator currently
+// is, and the lines read, emitted and dropped and the scheduling drift, so the
+// simulator itself can be monitored. With multiple replays, the series carry a
+// "replay" label with the instance of the replay.
+func replayCollector(replays []*replay) metrics.Collector {
+	series := make([][]*metrics.Metric, len(replayFamilies))
+	for i, f := range replayFamilies {
+		series[i] = make([]*metrics.Metric, len(replays))
+		for j, r := range replays {
+			series[i][j] = metrics.NewMetric(f.name, f.typ, "", r.labels(), f.description)
+		}
+	}
+	return func() []metrics.MetricValue {
+		stats := make([]logs.ReplayerStats, len(replays))
+		for j, r := range replays {
+			stats[j] = r.lr.Stats()
+		}
+		// Keep the series of a family together, so they share its header
+		var values []metrics.MetricValue
+		for i, f := range replayFamilies {
+			for j := range replays {
+				if v, ok := f.value(stats[j]); ok {
+					values = append(values, metrics.NewMetricValue(series[i][j], v))
+				}
+			}
+		}
+		return values
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runServiceCommand is only supported on Windows, other platforms use
+// "--daemon" instead.
+func runServiceCommand(args []string) int {
+	fmt.Fprintln(os.Stderr, "service: only supported on Windows, use --daemon instead")
+	return 2
+}
+
+// runAsService returns false, as the process never runs as Windows service.
+func runAsService() bool {
+	return false
+}
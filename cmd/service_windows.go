@@ -0,0 +1,188 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// serviceName is the name of the Windows service.
+const serviceName = "bananabacon"
+
+// runServiceCommand implements the service command, which installs and
+// controls bananabacon as a Windows service that starts with the system:
+//
+// - install: installs the service for the current executable. The service
+//   reads its configuration from the files given by CONFIG_PATH at the time
+//   of the installation.
+// - uninstall: removes the service
+// - start, stop: starts or stops the service
+// - status: reports the state of the service
+//
+// It returns the exit code: 0 on success, 1 on errors and 2 on invalid usage.
+func runServiceCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: bananabacon service install|uninstall|start|stop|status")
+		return 2
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "service: %v\n", err)
+		return 1
+	}
+	defer m.Disconnect()
+	if args[0] == "install" {
+		err = installService(m)
+	} else {
+		err = controlService(m, args[0])
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "service: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// installService installs the service for the current executable.
+func installService(m *mgr.Mgr) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Bananabacon",
+		Description: "Replays log files and serves metrics",
+		StartType: mgr.StartAutomatic,
+	})
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+	// Services do not inherit the environment, pass the configuration files
+	// in the environment of the service
+	env := []string{"DAEMON_LOG_FILE=" + daemonLogPath()}
+	if path := os.Getenv("CONFIG_PATH"); len(path) > 0 {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		env = append(env, "CONFIG_PATH="+abs)
+	} else {
+		log.Printf("CONFIG_PATH is not set, the service uses the default configuration")
+	}
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+serviceName,
+		registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	defer key.Close()
+	if err := key.SetStringsValue("Environment", env); err != nil {
+		return err
+	}
+	fmt.Printf("Installed service %s for %s\n", serviceName, exe)
+	return nil
+}
+
+// controlService applies the given command to the installed service.
+func controlService(m *mgr.Mgr, command string) error {
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", serviceName, err)
+	}
+	defer s.Close()
+	switch command {
+	case "uninstall":
+		if err := s.Delete(); err != nil {
+			return err
+		}
+		fmt.Printf("Uninstalled service %s\n", serviceName)
+	case "start":
+		return s.Start()
+	case "stop":
+		_, err := s.Control(svc.Stop)
+		return err
+	case "status":
+		status, err := s.Query()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Service %s is %s\n", serviceName, serviceState(status.State))
+	default:
+		return fmt.Errorf("unknown command: %s", command)
+	}
+	return nil
+}
+
+// serviceState returns a readable name of the given state.
+func serviceState(state svc.State) string {
+	switch state {
+	case svc.Stopped:
+		return "stopped"
+	case svc.StartPending:
+		return "starting"
+	case svc.StopPending:
+		return "stopping"
+	case svc.Running:
+		return "running"
+	case svc.Paused:
+		return "paused"
+	}
+	return fmt.Sprintf("in state %d", state)
+}
+
+// runAsService runs the replay as Windows service if the process was started
+// by the service control manager. It returns false otherwise.
+func runAsService() bool {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return false
+	}
+	if f, err := os.OpenFile(daemonLogPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644); err == nil {
+		log.SetOutput(f)
+	}
+	if err := svc.Run(serviceName, service{}); err != nil {
+		log.Fatalf("Failed to run service: %v", err)
+	}
+	return true
+}
+
+// service runs the replay under the control of the service control manager.
+type service struct{}
+
+// Execute runs the replay until the service is stopped.
+func (service) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		run(ctx)
+		close(done)
+	}()
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case <-done:
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32((10 * time.Second).Milliseconds())}
+				cancel()
+				<-done
+				return false, 0
+			}
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package main
+
+import (
+	logs "bananabacon/internal/logs"
+	metrics "bananabacon/internal/metrics"
+	"context"
+)
+
+// handleRuntimeSignals does nothing on platforms without SIGUSR1, SIGUSR2 and
+// SIGHUP.
+func handleRuntimeSignals(ctx context.Context, lrs []*logs.LogReplayer, engine *metrics.MetricsEngine,
+	reload func() error) {
+}
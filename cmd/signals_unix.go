@@ -0,0 +1,43 @@
+//go:build unix
+
+package main
+
+import (
+	"bananabacon/internal/debug"
+	logs "bananabacon/internal/logs"
+	metrics "bananabacon/internal/metrics"
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// handleRuntimeSignals listens for SIGUSR1, SIGUSR2 and SIGHUP until the
+// context is cancelled. SIGUSR1 dumps the current state of the replayers and
+// the metrics engine to stderr, SIGUSR2 toggles debug logging and SIGHUP
+// reloads the configuration.
+func handleRuntimeSignals(ctx context.Context, lrs []*logs.LogReplayer, engine *metrics.MetricsEngine,
+	reload func() error) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-sigs:
+			switch sig {
+			case syscall.SIGUSR1:
+				dumpState(os.Stderr, lrs, engine)
+			case syscall.SIGUSR2:
+				log.Printf("Debug logging enabled: %t", debug.Toggle())
+			case syscall.SIGHUP:
+				if err := reload(); err != nil {
+					log.Printf("Failed to reload the configuration: %v", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	logs "bananabacon/internal/logs"
+	metrics "bananabacon/internal/metrics"
+	"fmt"
+	"io"
+)
+
+// dumpState writes a human readable summary of the statistics of the replays and the
+// last emitted metric values to w.
+func dumpState(w io.Writer, lrs []*logs.LogReplayer, engine *metrics.MetricsEngine) {
+	fmt.Fprintln(w, "=== bananabacon state ===")
+	for i, lr := range lrs {
+		name := "replay"
+		if len(lrs) > 1 {
+			name = fmt.Sprintf("replay %d", i+1)
+		}
+		stats := lr.Stats()
+		fmt.Fprintf(w, "%s: run=%d position=%d read=%d emitted=%d skipped=%d\n",
+			name, stats.Run, stats.Position, stats.LinesRead, stats.LinesEmitted, stats.LinesSkipped)
+	}
+	fmt.Fprintln(w, "metrics:")
+	for _, m := range engine.List() {
+		fmt.Fprintf(w, "  %s (%s) = %v\n", m.Name(), metrics.MetricTypeToString(m.Type()), m.LastValue())
+	}
+}
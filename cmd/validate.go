@@ -0,0 +1,216 @@
+package main
+
+import (
+	logs "bananabacon/internal/logs"
+	metrics "bananabacon/internal/metrics"
+	"bananabacon/internal/presets"
+	"bananabacon/internal/scenario"
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// validationSampleLines is the number of lines of an input searched for a
+// line with a timestamp to check the time format against.
+const validationSampleLines = 1000
+
+// validationReport writes the results of the checks of validateConfig.
+type validationReport struct {
+	w io.Writer
+	errors int
+}
+
+// section starts a section of the report.
+func (r *validationReport) section(title string) {
+	fmt.Fprintln(r.w, title)
+}
+
+// check reports the result of checking the given setting: ok with the
+// details if err is nil, an error otherwise.
+func (r *validationReport) check(setting string, err error, details string) {
+	if err != nil {
+		r.errors++
+		fmt.Fprintf(r.w, "  error  %s: %v\n", setting, err)
+		return
+	}
+	if len(details) > 0 {
+		setting += ": " + details
+	}
+	fmt.Fprintf(r.w, "  ok     %s\n", setting)
+}
+
+// skip reports a setting that could not be checked.
+func (r *validationReport) skip(setting, reason string) {
+	fmt.Fprintf(r.w, "  skip   %s: %s\n", setting, reason)
+}
+
+// validateConfig checks the metrics and the settings of the replays that are
+// most often wrong and writes a report of all problems to w, instead of
+// stopping at the first like on start. The time formats are checked against
+// the first line of the input with a timestamp, and the scripts are compiled.
+// It returns the number of errors.
+func validateConfig(w io.Writer) int {
+	r := &validationReport{w: w}
+	r.section("Metrics")
+	builder, errs := metrics.ValidateEnv(os.Environ())
+	names := slices.Sorted(maps.Keys(builder))
+	if len(names) > 0 {
+		r.check("METRIC_*", nil, fmt.Sprintf("%d valid metrics (%s)", len(names), strings.Join(names, ", ")))
+	} else if len(errs) == 0 {
+		r.check("METRIC_*", nil, "no metrics configured")
+	}
+	for _, err := range errs {
+		r.check("METRIC_*", err, "")
+	}
+	if lms, err := metrics.LogMetricsFromEnv(os.Environ()); err != nil {
+		for _, err := range flattenErrors(err) {
+			r.check("LOG_METRIC_*", err, "")
+		}
+	} else if len(lms) > 0 {
+		r.check("LOG_METRIC_*", nil, fmt.Sprintf("%d valid log metrics", len(lms)))
+	}
+	for _, instance := range replayInstances() {
+		if len(instance) == 0 {
+			r.section("Replay")
+		} else {
+			r.section("Replay " + instance)
+		}
+		validateReplay(r, instance)
+	}
+	if path := getenv("SCENARIO_FILE", ""); len(path) > 0 {
+		r.section("Scenario")
+		validateScenario(r, path, names)
+	}
+	return r.errors
+}
+
+// validateScenario checks the scenario of SCENARIO_FILE against the replays
+// and the metrics with the given names.
+func validateScenario(r *validationReport, path string, metricNames []string) {
+	s, err := scenario.Load(path)
+	if err == nil {
+		err = s.Check(replayInstances(), metricNames)
+	}
+	if err != nil {
+		for _, err := range flattenErrors(err) {
+			r.check("SCENARIO_FILE", err, "")
+		}
+		return
+	}
+	declared := 0
+	for _, p := range s.Phases {
+		declared += len(p.Declare)
+	}
+	r.check("SCENARIO_FILE", nil, fmt.Sprintf("scenario %s with %d phases and %d declared metrics", s.Name,
+		len(s.Phases), declared))
+}
+
+// flattenErrors returns the errors joined by errors.Join, or err itself.
+func flattenErrors(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+// validateReplay checks the settings of the replay of the given instance.
+func validateReplay(r *validationReport, instance string) {
+	envInstance = instance
+	defer func() { envInstance = "" }()
+	suffix := ""
+	if len(instance) > 0 {
+		suffix = "_" + instance
+	}
+	if name := os.Getenv("PRESET" + suffix); len(name) > 0 {
+		_, err := presets.Get(name)
+		r.check("PRESET", err, name)
+		if err == nil {
+			applyPreset(suffix)
+		}
+	}
+
+	filterRegex := getenv("FILTER_REGEX", ".*")
+	_, err := regexp.Compile(filterRegex)
+	r.check("FILTER_REGEX", err, strconv.Quote(filterRegex))
+
+	timeFormats, err := parseTimeFormats(getenv("TIME_REGEX", defaultTimeRegex),
+		getenv("TIME_FORMAT", defaultTimeFormat), getenv("TIME_TEMPLATE", ""))
+	var matchers []*logs.TimestampMatcher
+	for _, f := range timeFormats {
+		var m *logs.TimestampMatcher
+		if m, err = logs.NewTimestampMatcher(f); err != nil {
+			break
+		}
+		matchers = append(matchers, m)
+	}
+	r.check("TIME_REGEX, TIME_FORMAT, TIME_TEMPLATE", err, "")
+	locale := getenv("TIME_LOCALE", "")
+	if err := logs.CheckTimeLocale(locale); err != nil {
+		r.check("TIME_LOCALE", err, "")
+	} else if len(matchers) == len(timeFormats) && len(matchers) > 0 {
+		validateTimeFormat(r, getenv("INPUT_FILE", "/logs/test.log"), timeFormats, matchers, locale)
+	}
+
+	transformers, err := loadTransformers()
+	r.check("LINE_TRANSFORM, LINE_MAPPING, ANONYMIZE", err, fmt.Sprintf("%d transformations", len(transformers)))
+
+	speed := getenv("SPEED", "1")
+	if f, err := strconv.ParseFloat(speed, 64); err != nil || f <= 0 {
+		r.check("SPEED", fmt.Errorf("invalid speed %s, must be a positive number", speed), "")
+	} else {
+		r.check("SPEED", nil, speed)
+	}
+}
+
+// validateTimeFormat checks that the timestamp of the first line of the
+// inputs matching one of the time regexes is parsed with its time format.
+func validateTimeFormat(r *validationReport, inputs string, timeFormats []logs.TimestampFormat,
+	matchers []*logs.TimestampMatcher, locale string) {
+	const setting = "TIME_FORMAT"
+	var reason string
+	for _, name := range strings.Split(inputs, ",") {
+		f, err := logs.FileSource(name).Open()
+		if err != nil {
+			reason = fmt.Sprintf("failed to open input: %v", err)
+			continue
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for n := 1; n <= validationSampleLines && scanner.Scan(); n++ {
+			for i, m := range matchers {
+				value, _, ok := m.Find(scanner.Text())
+				if !ok {
+					continue
+				}
+				format := timeFormats[i].Format
+				t, err := logs.ParseTimeIn(format, locale, value)
+				if err != nil {
+					err = fmt.Errorf("failed to parse %q in line %d of %s with %q", value, n, name, format)
+					if suggestions := logs.SuggestTimeFormats(value); len(suggestions) > 0 {
+						quoted := make([]string, len(suggestions))
+						for i, s := range suggestions {
+							quoted[i] = strconv.Quote(s)
+						}
+						err = fmt.Errorf("%w, try %s", err, strings.Join(quoted, " or "))
+					}
+				}
+				r.check(setting, err, fmt.Sprintf("parsed %q in line %d of %s as %s", value, n, name, t))
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			reason = fmt.Sprintf("failed to read input: %v", err)
+			continue
+		}
+		r.check(setting, fmt.Errorf("no timestamp found in the first %d lines of %s, check TIME_REGEX",
+			validationSampleLines, name), "")
+		return
+	}
+	r.skip(setting, reason)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bananabacon/internal/verify"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// runVerify implements the verify command, which compares the recorded output
+// of a replay with its source log and reports loss and timing drift. The
+// timestamps are parsed with TIME_REGEX, TIME_FORMAT and TIME_LOCALE. It
+// returns the exit code: 0 if no lines were lost and the drift is within the
+// tolerance, 1 if not and 2 on errors.
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	source := fs.String("source", getenv("INPUT_FILE", ""), "the replayed source log")
+	output := fs.String("output", "", "the recorded output of the replay")
+	speed := fs.Float64("speed", 1, "the replay speed the output was recorded with")
+	tolerance := fs.Duration("tolerance", 100*time.Millisecond, "the maximum accepted drift")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if len(*source) == 0 || len(*output) == 0 {
+		fmt.Fprintln(os.Stderr, "verify: -source and -output are required")
+		return 2
+	}
+	src, err := os.Open(*source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify: %v\n", err)
+		return 2
+	}
+	defer src.Close()
+	out, err := os.Open(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify: %v\n", err)
+		return 2
+	}
+	defer out.Close()
+
+	report, err := verify.Compare(src, out, verify.Options{
+		TimeFormats: getTimeFormats("TIME", defaultTimeRegex, defaultTimeFormat),
+		Locale: getenv("TIME_LOCALE", ""),
+		Speed: *speed,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "verify: %v\n", err)
+		return 2
+	}
+	fmt.Println(report)
+	if report.Lost > 0 || report.MaxDrift > *tolerance {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,470 @@
+package anomaly
+
+import (
+	"bananabacon/internal/logs"
+	"bananabacon/internal/metrics"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of anomalies.
+const (
+	// Burst multiplies the replay speed, and with it the emission rate, by
+	// its factor.
+	Burst = "burst"
+	// Errors injects error lines, factor lines per replayed line on average.
+	Errors = "errors"
+	// Pause pauses the replay.
+	Pause = "pause"
+)
+
+// DefaultErrorLine is the line injected by Errors anomalies if no lines are
+// configured.
+const DefaultErrorLine = "{{time}} ERROR Injected failure: connection reset by peer"
+
+// Anomaly is a recurring incident, either on a cron schedule or at random
+// intervals.
+type Anomaly struct {
+	// Kind is Burst, Errors, Pause or the name of an incident.
+	Kind string
+	// Factor is the speed multiplier of a Burst, the number of injected
+	// lines per replayed line of Errors or the intensity of an incident.
+	Factor float64
+	// Start is the schedule of the start of the anomaly. If nil, it starts
+	// at random with a mean interval of Every.
+	Start *metrics.CronSchedule
+	Every time.Duration
+	// Duration is how long the anomaly lasts after each start.
+	Duration time.Duration
+	// Incident is the template of an incident, nil for the other kinds.
+	Incident *Incident
+	// Params override the parameters of the template of an incident.
+	Params map[string]string
+	// Scope restricts the metrics changed by an incident, all if nil.
+	Scope metrics.Selector
+
+	active bool
+	next time.Time // start of the next random or triggered occurrence
+	once bool // triggered to occur once at next
+	start time.Time // start of the current occurrence
+	values map[string]string // parameters of the current occurrence of an incident
+}
+
+// Parse parses a semicolon-separated list of anomalies, each given as its kind,
+// an optional factor, its schedule as "at" followed by a cron expression or
+// "every" followed by the mean Go duration between random occurrences, and
+// "for" followed by its Go duration, e.g.
+// "burst 5 at 0 * * * * for 2m; errors 0.5 every 30m for 1m; pause every 2h for 5m".
+// The factor defaults to 5 for bursts and 1 for errors. The kind can also be
+// the name of one of the Incidents, optionally followed by its parameters in
+// parentheses, e.g. "memory-leak(service=api) 2 every 6h for 30m".
+func Parse(spec string) ([]*Anomaly, error) {
+	var anomalies []*Anomaly
+	for _, s := range strings.Split(spec, ";") {
+		a, err := parseAnomaly(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid anomaly %q: %w", s, err)
+		}
+		anomalies = append(anomalies, a)
+	}
+	return anomalies, nil
+}
+
+func parseAnomaly(s string) (*Anomaly, error) {
+	rest, d, ok := strings.Cut(s, " for ")
+	if !ok {
+		return nil, fmt.Errorf(`expected "<kind> [factor] at <cron> for <duration>" or "<kind> [factor] every <duration> for <duration>"`)
+	}
+	a := &Anomaly{}
+	var err error
+	if a.Duration, err = time.ParseDuration(strings.TrimSpace(d)); err != nil || a.Duration <= 0 {
+		return nil, fmt.Errorf("invalid duration %s", d)
+	}
+	head, schedule, ok := strings.Cut(rest, " at ")
+	if ok {
+		if a.Start, err = metrics.ParseCron(schedule); err != nil {
+			return nil, err
+		}
+	} else if head, schedule, ok = strings.Cut(rest, " every "); ok {
+		if a.Every, err = time.ParseDuration(strings.TrimSpace(schedule)); err != nil || a.Every <= 0 {
+			return nil, fmt.Errorf("invalid interval %s", schedule)
+		}
+	} else {
+		return nil, fmt.Errorf(`expected "at <cron>" or "every <duration>"`)
+	}
+	if err := a.parseKind(head); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ParseOccurrence parses a single occurrence of an anomaly, given like the
+// anomalies of Parse without a schedule and with an optional duration, e.g.
+// "errors 2", "burst 3 for 5m" or "memory-leak(service=api) 2". The duration
+// is zero if not given. The occurrence is started by Injector.Start.
+func ParseOccurrence(spec string) (*Anomaly, error) {
+	a := &Anomaly{}
+	head, d, ok := strings.Cut(spec, " for ")
+	if ok {
+		var err error
+		if a.Duration, err = time.ParseDuration(strings.TrimSpace(d)); err != nil || a.Duration <= 0 {
+			return nil, fmt.Errorf("invalid anomaly %q: invalid duration %s", spec, d)
+		}
+	}
+	if err := a.parseKind(head); err != nil {
+		return nil, fmt.Errorf("invalid anomaly %q: %w", spec, err)
+	}
+	return a, nil
+}
+
+// parseKind parses the kind of the anomaly, the parameters of an incident and
+// the optional factor.
+func (a *Anomaly) parseKind(head string) error {
+	var params map[string]string
+	var err error
+	if open := strings.Index(head, "("); open >= 0 {
+		end := strings.LastIndex(head, ")")
+		if end < open {
+			return fmt.Errorf("missing ) after the parameters")
+		}
+		if params, err = parseParams(head[open+1 : end]); err != nil {
+			return err
+		}
+		head = head[:open] + " " + head[end+1:]
+	}
+	fields := strings.Fields(head)
+	if len(fields) == 0 || len(fields) > 2 {
+		return fmt.Errorf("expected a kind and an optional factor")
+	}
+	a.Kind = fields[0]
+	if params != nil && Incidents[a.Kind] == nil {
+		return fmt.Errorf("%s does not take parameters", a.Kind)
+	}
+	switch a.Kind {
+	case Burst:
+		a.Factor = 5
+	case Errors:
+		a.Factor = 1
+	case Pause:
+		if len(fields) > 1 {
+			return fmt.Errorf("pause does not take a factor")
+		}
+	default:
+		if Incidents[a.Kind] == nil {
+			return fmt.Errorf("unknown kind %q, must be %q, %q, %q or an incident: %s", a.Kind, Burst, Errors,
+				Pause, strings.Join(incidentNames(), ", "))
+		}
+		inc, err := newIncident(a.Kind, params)
+		if err != nil {
+			return err
+		}
+		a.Factor, a.Incident, a.Params, a.Scope = inc.Factor, inc.Incident, inc.Params, inc.Scope
+	}
+	if len(fields) > 1 {
+		if a.Factor, err = strconv.ParseFloat(fields[1], 64); err != nil || a.Factor <= 0 {
+			return fmt.Errorf("invalid factor %s, must be a positive number", fields[1])
+		}
+	}
+	return nil
+}
+
+// activeAt returns whether the anomaly is active at t.
+func (a *Anomaly) activeAt(t time.Time, rnd *rand.Rand) bool {
+	_, active := a.activeSince(t, rnd)
+	return active
+}
+
+// activeSince returns the start of the occurrence of the anomaly active at t
+// and whether there is one.
+func (a *Anomaly) activeSince(t time.Time, rnd *rand.Rand) (time.Time, bool) {
+	switch {
+	case a.once:
+		return a.next, !t.Before(a.next) && t.Before(a.next.Add(a.Duration))
+	case a.Start != nil:
+		// Look for a start within the duration before t
+		for m := t.Truncate(time.Minute); t.Sub(m) < a.Duration; m = m.Add(-time.Minute) {
+			if a.Start.Matches(m) {
+				return m, true
+			}
+		}
+		return time.Time{}, false
+	}
+	if a.next.IsZero() {
+		a.next = t.Add(time.Duration(rnd.ExpFloat64() * float64(a.Every)))
+	}
+	for !t.Before(a.next.Add(a.Duration)) {
+		a.next = a.next.Add(a.Duration + time.Duration(rnd.ExpFloat64()*float64(a.Every)))
+	}
+	return a.next, !t.Before(a.next)
+}
+
+// Replayer is the part of logs.LogReplayer controlled by an Injector.
+type Replayer interface {
+	Pause()
+	Resume()
+	SetSpeed(speed float64) error
+	Speed() (float64, bool)
+	Annotate(kind, message string, fields map[string]any)
+}
+
+// Injector starts and ends anomalies on their schedule. Bursts and pauses
+// control the replayer, errors and the lines of incidents are injected by
+// the sink returned by Sink and the metrics are changed by Modify.
+type Injector struct {
+	anomalies []*Anomaly
+	lines []string
+	layout string
+	rnd *rand.Rand
+	mu sync.Mutex
+	factor float64 // product of the factors of the active bursts
+	baseSpeed float64 // speed of the replay before the bursts started
+	paused bool
+	shedding bool // bursts and injected lines are suspended, see SetShedding
+	sources []lineSource // of the injected lines of the active anomalies
+	errorRatio float64 // sum of the ratios of the sources
+	credit float64 // injected lines owed to the sink
+	effects []activeEffect // of the active incidents
+	counters map[*metrics.Metric]*counterState
+}
+
+// lineSource provides injected lines, ratio lines per replayed line.
+type lineSource struct {
+	ratio float64
+	lines []string
+	params map[string]string // substituted for their placeholders
+}
+
+// NewInjector creates an Injector for the anomalies. The injected error lines
+// are picked at random from lines, where "{{time}}" is replaced by the time of
+// the replayed line they follow, formatted with layout.
+func NewInjector(anomalies []*Anomaly, lines []string, layout string) *Injector {
+	if len(lines) == 0 {
+		lines = []string{DefaultErrorLine}
+	}
+	return &Injector{
+		anomalies: anomalies,
+		lines: lines,
+		layout: layout,
+		rnd: rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		factor: 1,
+		counters: map[*metrics.Metric]*counterState{},
+	}
+}
+
+// Run updates the anomalies every second until the context is cancelled.
+func (inj *Injector) Run(ctx context.Context, r Replayer) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	inj.update(time.Now(), r)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			inj.update(now, r)
+		}
+	}
+}
+
+// update starts and ends the anomalies at the given time.
+func (inj *Injector) update(now time.Time, r Replayer) {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	factor, ratio, paused := 1.0, 0.0, false
+	inj.sources, inj.effects = inj.sources[:0], inj.effects[:0]
+	anomalies := inj.anomalies[:0]
+	for _, a := range inj.anomalies {
+		start, active := a.activeSince(now, inj.rnd)
+		if active != a.active {
+			a.active, a.start = active, start
+			fields := map[string]any{"anomaly": a.Kind, "duration": a.Duration.String()}
+			if a.Kind != Pause {
+				fields["factor"] = a.Factor
+			}
+			if a.Incident != nil && active {
+				a.values = a.pickParams(inj.rnd)
+			}
+			for k, v := range a.values {
+				fields[k] = v
+			}
+			if active {
+				r.Annotate("anomaly_start", fmt.Sprintf("started %s anomaly", a.Kind), fields)
+			} else {
+				r.Annotate("anomaly_end", fmt.Sprintf("ended %s anomaly", a.Kind), fields)
+			}
+		}
+		if a.once && !active && !now.Before(a.next) {
+			// Triggered incidents are removed once they ended
+			continue
+		}
+		anomalies = append(anomalies, a)
+		if !active {
+			continue
+		}
+		switch a.Kind {
+		case Burst:
+			factor *= a.Factor
+		case Errors:
+			inj.sources = append(inj.sources, lineSource{ratio: a.Factor, lines: inj.lines})
+			ratio += a.Factor
+		case Pause:
+			paused = true
+		default:
+			progress := a.progress(now)
+			rate := a.Incident.Rate * a.Factor
+			if a.Incident.Ramp {
+				rate *= progress
+			}
+			inj.sources = append(inj.sources, lineSource{ratio: rate, lines: a.Incident.Lines, params: a.values})
+			ratio += rate
+			for _, e := range a.Incident.Effects {
+				inj.effects = append(inj.effects, activeEffect{metrics: e.Metrics, scope: a.Scope,
+					multiplier: a.multiplier(e, progress)})
+			}
+		}
+	}
+	clear(inj.anomalies[len(anomalies):])
+	inj.anomalies = anomalies
+	if inj.shedding {
+		factor, ratio, inj.sources = 1, 0, inj.sources[:0]
+	}
+	if factor != inj.factor {
+		if inj.factor == 1 {
+			inj.baseSpeed, _ = r.Speed()
+		}
+		inj.factor = factor
+		r.SetSpeed(inj.baseSpeed * factor)
+	}
+	if paused != inj.paused {
+		inj.paused = paused
+		if paused {
+			r.Pause()
+		} else {
+			r.Resume()
+		}
+	}
+	inj.errorRatio = ratio
+	if ratio == 0 {
+		inj.credit = 0
+	}
+}
+
+// SetShedding suspends or resumes the bursts and the injected lines of the
+// anomalies, which multiply the output of the replay, from their next update
+// on. The anomalies still start and end, and incidents still change the
+// metrics.
+func (inj *Injector) SetShedding(shedding bool) {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	inj.shedding = shedding
+}
+
+// SetSpeed sets the speed of the replay r the injector runs on, keeping the
+// active bursts: the speed is multiplied by their factors until they end and
+// restored afterwards.
+func (inj *Injector) SetSpeed(r Replayer, speed float64) error {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	if inj.factor == 1 {
+		return r.SetSpeed(speed)
+	}
+	if err := r.SetSpeed(speed * inj.factor); err != nil {
+		return err
+	}
+	inj.baseSpeed = speed
+	return nil
+}
+
+// Start starts an occurrence of the anomaly at now, e.g. one parsed by
+// ParseOccurrence, which lasts for its duration or the duration of the
+// template of an incident if zero. The anomaly is copied, so it can be started
+// again or by other injectors, and removed once it ended.
+func (inj *Injector) Start(a *Anomaly, now time.Time) error {
+	o := *a
+	if o.Duration <= 0 {
+		if o.Incident == nil {
+			return fmt.Errorf("missing duration of %s anomaly", o.Kind)
+		}
+		o.Duration = o.Incident.Duration
+	}
+	o.active, o.once, o.next = false, true, now
+	inj.mu.Lock()
+	inj.anomalies = append(inj.anomalies, &o)
+	inj.mu.Unlock()
+	return nil
+}
+
+// Seed seeds the random choices of the injector, i.e. the random occurrences
+// of the anomalies, the parameters of incidents and the injected lines, so
+// runs with the same seed inject the same anomalies.
+func (inj *Injector) Seed(seed uint64) {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	inj.rnd = rand.New(rand.NewPCG(seed, seed))
+}
+
+// Sink returns a sink that writes to s and, while errors anomalies are active,
+// injects error lines after the replayed lines.
+func (inj *Injector) Sink(s logs.Sink) logs.Sink {
+	return &injectingSink{Sink: s, inj: inj}
+}
+
+// injected returns the error lines to inject after the event.
+func (inj *Injector) injected(e logs.LogEvent) []string {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	if inj.errorRatio == 0 {
+		return nil
+	}
+	inj.credit += inj.errorRatio
+	var lines []string
+	for ; inj.credit >= 1; inj.credit-- {
+		// Pick a source weighted by its ratio
+		source := inj.sources[len(inj.sources)-1]
+		if len(inj.sources) > 1 {
+			n := inj.rnd.Float64() * inj.errorRatio
+			for _, s := range inj.sources {
+				if n < s.ratio {
+					source = s
+					break
+				}
+				n -= s.ratio
+			}
+		}
+		line := strings.ReplaceAll(source.lines[inj.rnd.IntN(len(source.lines))], "{{time}}", e.Time.Format(inj.layout))
+		for k, v := range source.params {
+			line = strings.ReplaceAll(line, "{{"+k+"}}", v)
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+type injectingSink struct {
+	logs.Sink
+	inj *Injector
+}
+
+func (s *injectingSink) Write(ctx context.Context, e logs.LogEvent) error {
+	if err := s.Sink.Write(ctx, e); err != nil {
+		return err
+	}
+	for _, line := range s.inj.injected(e) {
+		err := s.Sink.Write(ctx, logs.LogEvent{
+			OriginalTime: e.OriginalTime,
+			Time: e.Time,
+			RawLine: line,
+			Line: line,
+			Source: "anomaly",
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
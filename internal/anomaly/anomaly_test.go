@@ -0,0 +1,226 @@
+package anomaly
+
+import (
+	"bananabacon/internal/logs"
+	"bananabacon/internal/metrics"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeReplayer struct {
+	speed       float64
+	paused      bool
+	annotations []string
+}
+
+func (r *fakeReplayer) Pause()  { r.paused = true }
+func (r *fakeReplayer) Resume() { r.paused = false }
+func (r *fakeReplayer) SetSpeed(speed float64) error {
+	r.speed = speed
+	return nil
+}
+func (r *fakeReplayer) Speed() (float64, bool) { return r.speed, r.paused }
+func (r *fakeReplayer) Annotate(kind, message string, fields map[string]any) {
+	r.annotations = append(r.annotations, kind+" "+fields["anomaly"].(string))
+}
+
+func TestInjector(t *testing.T) {
+	anomalies, err := Parse("burst 4 at 0 * * * * for 10m; errors 0.5 at 5 * * * * for 10m; pause at 30 * * * * for 1m")
+	if err != nil {
+		t.Fatalf("Failed to parse anomalies: %s", err)
+	}
+	var lines []string
+	inj := NewInjector(anomalies, []string{"{{time}} ERROR boom"}, "15:04")
+	sink := inj.Sink(logs.SinkFunc(func(_ context.Context, e logs.LogEvent) error {
+		lines = append(lines, e.Line)
+		return nil
+	}))
+	r := &fakeReplayer{speed: 2}
+	hour := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	inj.update(hour, r)
+	if r.speed != 8 {
+		t.Errorf("Expected speed 8 during the burst, got %v", r.speed)
+	}
+	inj.update(hour.Add(5*time.Minute), r)
+	for i := 0; i < 4; i++ {
+		sink.Write(context.Background(), logs.LogEvent{Time: hour.Add(5 * time.Minute), Line: "line"})
+	}
+	expected := []string{"line", "line", "12:05 ERROR boom", "line", "line", "12:05 ERROR boom"}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected lines %v, got %v", expected, lines)
+	}
+	for i := range expected {
+		if lines[i] != expected[i] {
+			t.Errorf("Expected line %d to be %q, got %q", i, expected[i], lines[i])
+		}
+	}
+	inj.update(hour.Add(10*time.Minute), r)
+	if r.speed != 2 {
+		t.Errorf("Expected speed 2 after the burst, got %v", r.speed)
+	}
+	inj.update(hour.Add(30*time.Minute), r)
+	if !r.paused {
+		t.Errorf("Expected the replay to be paused")
+	}
+	inj.update(hour.Add(31*time.Minute), r)
+	if r.paused {
+		t.Errorf("Expected the replay to be resumed")
+	}
+	if len(r.annotations) != 6 || r.annotations[0] != "anomaly_start burst" || r.annotations[5] != "anomaly_end pause" {
+		t.Errorf("Unexpected annotations: %v", r.annotations)
+	}
+
+	for _, invalid := range []string{"burst at 0 * * * *", "spike every 1h for 1m", "pause 2 every 1h for 1m", "errors 0 every 1h for 1m", "burst sometimes for 1m"} {
+		if _, err := Parse(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestAnomaly_Random(t *testing.T) {
+	anomalies, err := Parse("pause every 10m for 1m")
+	if err != nil {
+		t.Fatalf("Failed to parse anomalies: %s", err)
+	}
+	inj := NewInjector(anomalies, nil, "")
+	start := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	active := 0
+	for s := 0; s < 24*3600; s++ {
+		if anomalies[0].activeAt(start.Add(time.Duration(s)*time.Second), inj.rnd) {
+			active++
+		}
+	}
+	// On average active 1 of 11 minutes
+	if active < 3600 || active > 12000 {
+		t.Errorf("Expected about 7850s of activity per day, got %ds", active)
+	}
+}
+
+func TestIncident(t *testing.T) {
+	anomalies, err := Parse(`connection-pool-exhaustion(pool=orders, scope="{job=\"api\"}") 2 at 0 * * * * for 10m`)
+	if err != nil {
+		t.Fatalf("Failed to parse anomalies: %s", err)
+	}
+	var lines []string
+	inj := NewInjector(anomalies, nil, "15:04")
+	sink := inj.Sink(logs.SinkFunc(func(_ context.Context, e logs.LogEvent) error {
+		lines = append(lines, e.Line)
+		return nil
+	}))
+	r := &fakeReplayer{speed: 1}
+	hour := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	pool := metrics.NewMetric("db_pool_connections", metrics.GaugeType, "10", map[string]string{"job": "api"}, "")
+	other := metrics.NewMetric("db_pool_connections", metrics.GaugeType, "10", map[string]string{"job": "web"}, "")
+	errors := metrics.NewMetric("http_errors_total", metrics.CounterType, "t", map[string]string{"job": "api"}, "")
+
+	if v := inj.Modify(errors, 100); v != 100 {
+		t.Errorf("Expected the counter to be unchanged before the incident, got %v", v)
+	}
+	inj.update(hour.Add(5*time.Minute), r)
+	for i := 0; i < 10; i++ {
+		sink.Write(context.Background(), logs.LogEvent{Time: hour.Add(5 * time.Minute), Line: "line"})
+	}
+	// 0.3 lines at intensity 2 are 0.6 lines per replayed line, up to rounding
+	if injected := len(lines) - 10; injected < 5 || injected > 6 {
+		t.Fatalf("Expected 6 injected lines, got %v", lines)
+	}
+	for _, line := range lines {
+		if line != "line" && !strings.HasPrefix(line, "12:05 ") || strings.Contains(line, "{{") {
+			t.Errorf("Unexpected line %q", line)
+		}
+		if line != "line" && !strings.Contains(line, "[orders]") {
+			t.Errorf("Expected the pool parameter in %q", line)
+		}
+	}
+	// Gauges are multiplied by 1 + (3 - 1) * 2, counters increase 1 + (10 - 1) * 2 times as fast
+	if v := inj.Modify(pool, 10); v != 50 {
+		t.Errorf("Expected 50 connections during the incident, got %v", v)
+	}
+	if v := inj.Modify(other, 10); v != 10 {
+		t.Errorf("Expected metrics outside the scope to be unchanged, got %v", v)
+	}
+	if v := inj.Modify(errors, 110); v != 290 {
+		t.Errorf("Expected the counter to increase 19 times as fast, got %v", v)
+	}
+	inj.update(hour.Add(10*time.Minute), r)
+	if v := inj.Modify(errors, 120); v != 300 {
+		t.Errorf("Expected the counter to keep its increase after the incident, got %v", v)
+	}
+	if len(r.annotations) != 2 || r.annotations[0] != "anomaly_start connection-pool-exhaustion" {
+		t.Errorf("Unexpected annotations: %v", r.annotations)
+	}
+
+	if err := inj.Trigger("memory-leak", 1, time.Minute, map[string]string{"service": "billing"}, hour); err != nil {
+		t.Fatalf("Failed to trigger incident: %s", err)
+	}
+	heap := metrics.NewMetric("process_heap_bytes", metrics.GaugeType, "1", nil, "")
+	inj.update(hour.Add(30*time.Second), r)
+	if v := inj.Modify(heap, 100); v != 250 {
+		t.Errorf("Expected the heap to have grown halfway to 4 times its size, got %v", v)
+	}
+	inj.update(hour.Add(time.Minute), r)
+	if v := inj.Modify(heap, 100); v != 100 || len(inj.anomalies) != 1 {
+		t.Errorf("Expected the triggered incident to have ended, got %v", v)
+	}
+
+	for _, invalid := range []string{"memory-leak(color=red) every 1h for 1m", "burst(x=1) every 1h for 1m",
+		"disk-filling(mount=/ every 1h for 1m", `memory-leak(scope="{") every 1h for 1m`} {
+		if _, err := Parse(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+	if err := inj.Trigger("meltdown", 1, 0, nil, hour); err == nil {
+		t.Error("Expected an error for an unknown incident")
+	}
+}
+
+func TestInjector_Start(t *testing.T) {
+	burst, err := ParseOccurrence("burst 3 for 10m")
+	if err != nil {
+		t.Fatalf("Failed to parse anomaly: %s", err)
+	}
+	inj := NewInjector(nil, nil, "")
+	r := &fakeReplayer{speed: 2}
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	if err := inj.Start(burst, now); err != nil {
+		t.Fatalf("Failed to start anomaly: %s", err)
+	}
+	inj.update(now, r)
+	if r.speed != 6 {
+		t.Errorf("Expected speed 6 during the burst, got %v", r.speed)
+	}
+	inj.SetSpeed(r, 4)
+	if r.speed != 12 {
+		t.Errorf("Expected speed 12 after changing the speed during the burst, got %v", r.speed)
+	}
+	inj.update(now.Add(10*time.Minute), r)
+	if r.speed != 4 {
+		t.Errorf("Expected speed 4 after the burst, got %v", r.speed)
+	}
+	if len(inj.anomalies) != 0 {
+		t.Errorf("Expected the anomaly to be removed after it ended, got %d anomalies", len(inj.anomalies))
+	}
+
+	errors, err := ParseOccurrence("errors")
+	if err != nil {
+		t.Fatalf("Failed to parse anomaly: %s", err)
+	}
+	if err := inj.Start(errors, now); err == nil {
+		t.Error("Expected an error for an anomaly without duration")
+	}
+	leak, err := ParseOccurrence("memory-leak(service=api) 2")
+	if err != nil {
+		t.Fatalf("Failed to parse anomaly: %s", err)
+	}
+	if err := inj.Start(leak, now); err != nil || inj.anomalies[0].Duration != Incidents["memory-leak"].Duration {
+		t.Errorf("Expected the incident to last for the duration of its template, got %v", err)
+	}
+	for _, invalid := range []string{"burst 3 every 1h", "spike", "burst for 0s"} {
+		if _, err := ParseOccurrence(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package anomaly
+
+import (
+	"bananabacon/internal/metrics"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Incident is a template of a realistic incident: the lines it injects into
+// the replay and how it changes the shape of the metrics. Incidents are
+// anomalies of the kind of their name, with the factor as their intensity.
+type Incident struct {
+	Name string
+	Description string
+	// Duration is the duration of an incident triggered without one.
+	Duration time.Duration
+	// Params are the parameters of the incident with their defaults. They
+	// are substituted for placeholders like "{{service}}" in Lines. Values
+	// with alternatives separated by "|" are picked from at random on each
+	// start of the incident.
+	Params map[string]string
+	// Lines are the injected lines, with "{{time}}" replaced by the time of
+	// the replayed line they follow.
+	Lines []string
+	// Rate is the number of injected lines per replayed line at intensity 1.
+	Rate float64
+	// Ramp is set if the rate grows linearly from 0 to Rate over the
+	// duration of the incident instead of applying at once.
+	Ramp bool
+	Effects []Effect
+}
+
+// Effect changes the metrics with matching names while an incident is active.
+type Effect struct {
+	Metrics *regexp.Regexp
+	// Factor multiplies the values of gauges and untyped metrics and the
+	// increase of counters at intensity 1. At intensity i, the multiplier is
+	// 1 + (Factor - 1) * i.
+	Factor float64
+	// Ramp is set if the multiplier grows linearly from 1 over the duration
+	// of the incident instead of applying at once.
+	Ramp bool
+}
+
+// Incidents are the built-in incident templates by name.
+var Incidents = map[string]*Incident{
+	"memory-leak": {
+		Name: "memory-leak",
+		Description: "the heap of a service grows until it runs out of memory, with GC pauses slowing it down",
+		Duration: 30 * time.Minute,
+		Params: map[string]string{"service": "api|worker|billing"},
+		Lines: []string{
+			"{{time}} WARN [{{service}}] GC overhead limit approaching: heap usage at 92%",
+			"{{time}} WARN [{{service}}] Full GC took 2431ms, reclaimed 1% of the heap",
+			"{{time}} ERROR [{{service}}] java.lang.OutOfMemoryError: Java heap space",
+		},
+		Rate: 0.1,
+		Ramp: true,
+		Effects: []Effect{
+			{regexp.MustCompile(`(?i)memory|heap|rss`), 4, true},
+			{regexp.MustCompile(`(?i)gc|garbage`), 5, true},
+			{regexp.MustCompile(`(?i)latency|duration`), 2, true},
+		},
+	},
+	"connection-pool-exhaustion": {
+		Name: "connection-pool-exhaustion",
+		Description: "all connections of a pool are in use, so requests wait for a connection and time out",
+		Duration: 10 * time.Minute,
+		Params: map[string]string{"pool": "primary-db|orders-db|redis", "size": "50|100|200"},
+		Lines: []string{
+			"{{time}} ERROR [{{pool}}] Timeout waiting for idle connection after 30000ms (active={{size}}, idle=0)",
+			"{{time}} ERROR [{{pool}}] Connection pool exhausted: maximum pool size {{size}} reached",
+			"{{time}} WARN [{{pool}}] Acquiring a connection took 12453ms",
+		},
+		Rate: 0.3,
+		Effects: []Effect{
+			{regexp.MustCompile(`(?i)connection|pool`), 3, false},
+			{regexp.MustCompile(`(?i)latency|duration|wait`), 8, true},
+			{regexp.MustCompile(`(?i)error|fail`), 10, false},
+		},
+	},
+	"cascading-timeouts": {
+		Name: "cascading-timeouts",
+		Description: "a slow dependency makes its callers time out, opening circuit breakers up the call chain",
+		Duration: 15 * time.Minute,
+		Params: map[string]string{"service": "payments|inventory|auth", "caller": "checkout|gateway|frontend"},
+		Lines: []string{
+			"{{time}} ERROR [{{caller}}] Request to {{service}} timed out after 30000ms",
+			"{{time}} WARN [{{caller}}] Retrying request to {{service}} (attempt 3 of 3)",
+			"{{time}} ERROR [{{caller}}] Circuit breaker for {{service}} opened after 50 consecutive failures",
+			"{{time}} ERROR [gateway] 504 Gateway Timeout: {{caller}} did not respond in time",
+		},
+		Rate: 0.5,
+		Ramp: true,
+		Effects: []Effect{
+			{regexp.MustCompile(`(?i)latency|duration`), 10, true},
+			{regexp.MustCompile(`(?i)error|fail|timeout`), 20, true},
+			{regexp.MustCompile(`(?i)request|throughput`), 0.5, true},
+		},
+	},
+	"disk-filling": {
+		Name: "disk-filling",
+		Description: "a file system fills up until writes fail",
+		Duration: time.Hour,
+		Params: map[string]string{"mount": "/var/lib/data|/var/log|/", "device": "sda1|nvme0n1p1"},
+		Lines: []string{
+			"{{time}} WARN File system {{mount}} on /dev/{{device}} is 95% full",
+			"{{time}} ERROR Write to {{mount}} failed: No space left on device",
+			"{{time}} ERROR Failed to rotate log file in {{mount}}: ENOSPC",
+		},
+		Rate: 0.05,
+		Ramp: true,
+		Effects: []Effect{
+			{regexp.MustCompile(`(?i)(disk|filesystem|fs).*(used|usage)`), 3, true},
+			{regexp.MustCompile(`(?i)(disk|filesystem|fs).*(free|avail)`), 0.05, true},
+		},
+	},
+}
+
+// incidentNames returns the names of the built-in incidents, sorted.
+func incidentNames() []string {
+	names := make([]string, 0, len(Incidents))
+	for name := range Incidents {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// newIncident creates an anomaly of the named incident with the given
+// parameters, which override the defaults of the template. The parameter
+// "scope" is a series selector restricting the affected metrics.
+func newIncident(name string, params map[string]string) (*Anomaly, error) {
+	inc, ok := Incidents[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown incident %q, must be one of %s", name, strings.Join(incidentNames(), ", "))
+	}
+	a := &Anomaly{Kind: name, Factor: 1, Incident: inc, Params: map[string]string{}}
+	for k, v := range params {
+		if k == "scope" {
+			var err error
+			if a.Scope, err = metrics.ParseSelector(v); err != nil {
+				return nil, fmt.Errorf("invalid scope: %w", err)
+			}
+			continue
+		}
+		if _, ok := inc.Params[k]; !ok {
+			known := []string{"scope"}
+			for p := range inc.Params {
+				known = append(known, p)
+			}
+			slices.Sort(known)
+			return nil, fmt.Errorf("unknown parameter %q of %s, must be one of %s", k, name, strings.Join(known, ", "))
+		}
+		a.Params[k] = v
+	}
+	return a, nil
+}
+
+// parseParams parses comma-separated key=value parameters. Values can be
+// quoted to contain commas, e.g. pool=orders,scope="{job=\"api\"}".
+func parseParams(s string) (map[string]string, error) {
+	params := map[string]string{}
+	for rest := strings.TrimSpace(s); len(rest) > 0; {
+		key, value, ok := strings.Cut(rest, "=")
+		key = strings.TrimSpace(key)
+		if !ok || len(key) == 0 {
+			return nil, fmt.Errorf("invalid parameter %q, expected key=value", rest)
+		}
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, `"`) {
+			quoted, err := strconv.QuotedPrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value of %s: %w", key, err)
+			}
+			rest = strings.TrimSpace(value[len(quoted):])
+			if value, err = strconv.Unquote(quoted); err != nil {
+				return nil, fmt.Errorf("invalid value of %s: %w", key, err)
+			}
+			if len(rest) > 0 && !strings.HasPrefix(rest, ",") {
+				return nil, fmt.Errorf("expected a comma after the value of %s", key)
+			}
+		} else {
+			value, rest, _ = strings.Cut(value, ",")
+			value = strings.TrimSpace(value)
+		}
+		params[key] = value
+		rest = strings.TrimSpace(strings.TrimPrefix(rest, ","))
+	}
+	return params, nil
+}
+
+// pickParams returns the parameters of a new occurrence of an incident, with
+// one of the alternatives of each value picked at random.
+func (a *Anomaly) pickParams(rnd *rand.Rand) map[string]string {
+	values := map[string]string{}
+	for k, v := range a.Incident.Params {
+		if p, ok := a.Params[k]; ok {
+			v = p
+		}
+		alternatives := strings.Split(v, "|")
+		values[k] = alternatives[rnd.IntN(len(alternatives))]
+	}
+	return values
+}
+
+// progress returns the share of the duration of the current occurrence that
+// has passed at t, between 0 and 1.
+func (a *Anomaly) progress(t time.Time) float64 {
+	return min(1, max(0, float64(t.Sub(a.start))/float64(a.Duration)))
+}
+
+// multiplier returns the multiplier of an effect of the anomaly at the given
+// progress.
+func (a *Anomaly) multiplier(e Effect, progress float64) float64 {
+	f := (e.Factor - 1) * a.Factor
+	if e.Ramp {
+		f *= progress
+	}
+	return max(0, 1+f)
+}
+
+// activeEffect is an effect of an active incident.
+type activeEffect struct {
+	metrics *regexp.Regexp
+	scope metrics.Selector
+	multiplier float64
+}
+
+// counterState is the state of a counter changed by incidents.
+type counterState struct {
+	last float64 // last value before the change
+	offset float64 // sum of the changes of the increases
+}
+
+// Modify returns the value of a metric changed by the effects of the active
+// incidents. The increases of counters are changed instead of their values,
+// so they stay monotonic and keep the increases of past incidents. It is a
+// metrics.Modifier.
+func (inj *Injector) Modify(m *metrics.Metric, value float64) float64 {
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+	multiplier := 1.0
+	for _, e := range inj.effects {
+		if e.metrics.MatchString(m.Name()) && (e.scope == nil || e.scope.Matches(m.Name(), m.Labels())) {
+			multiplier *= e.multiplier
+		}
+	}
+	if m.Type() != metrics.CounterType {
+		return value * multiplier
+	}
+	c, ok := inj.counters[m]
+	if !ok {
+		c = &counterState{last: value}
+		inj.counters[m] = c
+	}
+	if value < c.last {
+		// The counter was reset
+		c.offset = 0
+	} else {
+		c.offset += (value - c.last) * (multiplier - 1)
+	}
+	c.last = value
+	return max(0, value+c.offset)
+}
+
+// Trigger starts an incident at now, which lasts for the given duration or
+// the duration of its template if zero. The intensity defaults to 1 if zero.
+// params override the defaults of the template, see newIncident.
+func (inj *Injector) Trigger(name string, intensity float64, duration time.Duration, params map[string]string,
+	now time.Time) error {
+	a, err := newIncident(name, params)
+	if err != nil {
+		return err
+	}
+	if intensity < 0 {
+		return fmt.Errorf("invalid intensity %v, must be positive", intensity)
+	}
+	if intensity > 0 {
+		a.Factor = intensity
+	}
+	a.Duration = duration
+	return inj.Start(a, now)
+}
@@ -0,0 +1,276 @@
+package anonymize
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Auditd anonymizes Linux audit logs, e.g.
+	// type=USER_LOGIN msg=audit(1364481363.243:24287): pid=1 uid=0 auid=1000 acct="alice" addr=10.0.0.5
+	Auditd = "auditd"
+	// CEF anonymizes the extension of ArcSight Common Event Format records, e.g.
+	// CEF:0|Vendor|Product|1.0|100|Login|5|src=10.0.0.5 suser=alice
+	CEF = "cef"
+	// LEEF anonymizes the attributes of IBM QRadar Log Event Extended Format
+	// records, e.g. LEEF:1.0|Vendor|Product|1.0|Login|src=10.0.0.5<tab>usrName=alice
+	LEEF = "leef"
+)
+
+// kind is the kind of principal a field holds, which determines the shape of
+// its pseudonym.
+type kind int
+
+const (
+	userKind kind = iota
+	idKind
+	hostKind
+	addrKind
+	macKind
+)
+
+// fields are the fields holding principals for each format.
+var fields = map[string]map[string]kind{
+	Auditd: {
+		"auid": idKind, "uid": idKind, "gid": idKind, "euid": idKind, "suid": idKind, "fsuid": idKind,
+		"egid": idKind, "sgid": idKind, "fsgid": idKind, "ouid": idKind, "ogid": idKind, "id": idKind,
+		// Interpreted names appended by ausearch and the enriched log format
+		"AUID": userKind, "UID": userKind, "GID": userKind, "EUID": userKind, "SUID": userKind,
+		"FSUID": userKind, "EGID": userKind, "SGID": userKind, "FSGID": userKind, "OUID": userKind,
+		"OGID": userKind, "ID": userKind, "acct": userKind, "ACCT": userKind,
+		"node": hostKind, "hostname": hostKind, "addr": addrKind, "laddr": addrKind, "raddr": addrKind,
+	},
+	CEF: {
+		"suser": userKind, "duser": userKind, "suid": userKind, "duid": userKind,
+		"sntdom": hostKind, "dntdom": hostKind, "shost": hostKind, "dhost": hostKind, "dvchost": hostKind,
+		"src": addrKind, "dst": addrKind, "dvc": addrKind, "sourceTranslatedAddress": addrKind,
+		"destinationTranslatedAddress": addrKind, "smac": macKind, "dmac": macKind, "dvcmac": macKind,
+	},
+	LEEF: {
+		"usrName": userKind, "accountName": userKind, "identSrc": addrKind, "identHostName": hostKind,
+		"identNetBios": hostKind, "src": addrKind, "dst": addrKind, "srcPreNAT": addrKind, "dstPreNAT": addrKind,
+		"srcPostNAT": addrKind, "dstPostNAT": addrKind, "srcMAC": macKind, "dstMAC": macKind, "identMAC": macKind,
+	},
+}
+
+// Formats returns the names of the supported formats in alphabetical order.
+func Formats() []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Anonymizer replaces the principals in the fields of security log records,
+// i.e. user names and ids, host names and addresses, with pseudonyms. The
+// structure of the records is preserved: the same principal always gets the
+// same pseudonym of the same shape, so detection rules correlating events of a
+// user or host still fire. Unset values like "?" and "unset", system ids below
+// 1000 and the user root are kept, as rules commonly match on them.
+type Anonymizer struct {
+	format string
+	fields map[string]kind
+	key []byte
+}
+
+// New creates an Anonymizer for the given format. The pseudonyms are derived
+// from the principals with the key, so they are stable for a key but cannot be
+// reversed without it.
+func New(format string, key []byte) (*Anonymizer, error) {
+	f, ok := fields[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown anonymization format %q, must be one of %s", format,
+			strings.Join(Formats(), ", "))
+	}
+	return &Anonymizer{format: format, fields: f, key: key}, nil
+}
+
+// Line returns the line with the principals replaced. Lines that are not
+// records of the format are returned unchanged.
+func (a *Anonymizer) Line(line string) string {
+	switch a.format {
+	case CEF:
+		return a.cef(line)
+	case LEEF:
+		return a.leef(line)
+	default:
+		return a.auditd(line)
+	}
+}
+
+// auditdField matches the fields of audit records, including those nested in
+// the msg='...' of user space records. Values are double quoted strings,
+// hex-encoded strings or bare words.
+var auditdField = regexp.MustCompile(`\b([A-Za-z_]+)=("[^"]*"|[^\s'"\x1d]+)`)
+
+// auditd anonymizes an audit record.
+func (a *Anonymizer) auditd(line string) string {
+	return auditdField.ReplaceAllStringFunc(line, func(field string) string {
+		key, value, _ := strings.Cut(field, "=")
+		k, ok := a.fields[key]
+		if !ok {
+			return field
+		}
+		if unquoted, ok := strings.CutPrefix(value, `"`); ok {
+			return key + `="` + a.pseudonym(k, strings.TrimSuffix(unquoted, `"`)) + `"`
+		}
+		// Names with special characters are hex-encoded, the pseudonyms never
+		// need to be
+		if k == userKind {
+			if decoded, err := hex.DecodeString(value); err == nil && len(value) > 0 {
+				return key + `="` + a.pseudonym(k, string(decoded)) + `"`
+			}
+		}
+		return key + "=" + a.pseudonym(k, value)
+	})
+}
+
+// cefExtensionKey matches the keys of a CEF extension. Values may contain
+// spaces, so a value ends where the next key starts.
+var cefExtensionKey = regexp.MustCompile(`(?:^|\s)([A-Za-z0-9_.]+)=`)
+
+// cef anonymizes the extension of a CEF record, which follows the seventh
+// unescaped "|" after "CEF:".
+func (a *Anonymizer) cef(line string) string {
+	start := strings.Index(line, "CEF:")
+	if start < 0 {
+		return line
+	}
+	ext := start
+	for pipes := 0; pipes < 7; ext++ {
+		if ext >= len(line) {
+			return line
+		}
+		switch line[ext] {
+		case '\\':
+			ext++
+		case '|':
+			pipes++
+		}
+	}
+	extension := line[ext:]
+	keys := cefExtensionKey.FindAllStringSubmatchIndex(extension, -1)
+	var sb strings.Builder
+	sb.WriteString(line[:ext])
+	last := 0
+	for i, m := range keys {
+		end := len(extension)
+		if i+1 < len(keys) {
+			end = keys[i+1][0]
+		}
+		k, ok := a.fields[extension[m[2]:m[3]]]
+		if !ok {
+			continue
+		}
+		sb.WriteString(extension[last:m[1]])
+		sb.WriteString(a.pseudonym(k, extension[m[1]:end]))
+		last = end
+	}
+	sb.WriteString(extension[last:])
+	return sb.String()
+}
+
+// leef anonymizes the attributes of a LEEF record. LEEF 1.0 records have five
+// header fields and tab-separated attributes, LEEF 2.0 records may have a sixth
+// header field with the delimiter of the attributes.
+func (a *Anonymizer) leef(line string) string {
+	start := strings.Index(line, "LEEF:")
+	if start < 0 {
+		return line
+	}
+	header := strings.SplitN(line[start:], "|", 7)
+	if len(header) < 6 {
+		return line
+	}
+	delimiter := "\t"
+	attributes := strings.Join(header[5:], "|")
+	if strings.HasPrefix(header[0], "LEEF:2") && len(header) == 7 && leefDelimiter(header[5]) != "" {
+		delimiter = leefDelimiter(header[5])
+		attributes = header[6]
+	}
+	prefix := line[:len(line)-len(attributes)]
+	parts := strings.Split(attributes, delimiter)
+	for i, part := range parts {
+		key, value, ok := strings.Cut(part, "=")
+		if k, anonymize := a.fields[key]; ok && anonymize {
+			parts[i] = key + "=" + a.pseudonym(k, value)
+		}
+	}
+	return prefix + strings.Join(parts, delimiter)
+}
+
+// leefDelimiter returns the attribute delimiter given in the header of a LEEF
+// 2.0 record, either as a single character or in hex like "x09" or "0x09". It
+// returns "" if the field is no delimiter.
+func leefDelimiter(field string) string {
+	if len(field) == 1 {
+		return field
+	}
+	digits, ok := strings.CutPrefix(strings.TrimPrefix(field, "0"), "x")
+	if !ok {
+		digits, ok = strings.CutPrefix(strings.TrimPrefix(field, "0"), "X")
+	}
+	if !ok {
+		return ""
+	}
+	b, err := hex.DecodeString(digits)
+	if err != nil || len(b) != 1 {
+		return ""
+	}
+	return string(b)
+}
+
+// pseudonym returns the pseudonym of a principal of the given kind.
+func (a *Anonymizer) pseudonym(k kind, value string) string {
+	switch value {
+	case "", "?", "-", "(none)", "unset", "root", "4294967295", "-1":
+		return value
+	}
+	sum := a.hash(k, value)
+	switch k {
+	case idKind:
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || id < 1000 {
+			return value
+		}
+		return strconv.FormatUint(1000+binary.BigEndian.Uint64(sum)%59000, 10)
+	case hostKind:
+		return "host-" + hex.EncodeToString(sum[:4])
+	case addrKind:
+		ip := net.ParseIP(value)
+		if ip == nil || ip.IsLoopback() || ip.IsUnspecified() {
+			return value
+		}
+		if ip.To4() != nil {
+			// Map to the private range 10.0.0.0/8
+			return net.IPv4(10, sum[0], sum[1], sum[2]).String()
+		}
+		// Map to the unique local range fd00::/8
+		return append(net.IP{0xfd}, sum[:15]...).String()
+	case macKind:
+		if _, err := net.ParseMAC(value); err != nil {
+			return value
+		}
+		// Locally administered unicast address
+		return net.HardwareAddr(append([]byte{0x02}, sum[:5]...)).String()
+	default:
+		return "user-" + hex.EncodeToString(sum[:4])
+	}
+}
+
+// hash returns the keyed hash of a principal of the given kind.
+func (a *Anonymizer) hash(k kind, value string) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	fmt.Fprintf(mac, "%d:%s", k, value)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,85 @@
+package anonymize
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAnonymizer_Auditd(t *testing.T) {
+	a, err := New(Auditd, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Failed to create anonymizer: %s", err)
+	}
+	line := `type=USER_LOGIN msg=audit(1696945536.123:24287): pid=1234 uid=0 auid=1000 ses=3 ` +
+		`msg='op=login acct="alice" exe="/usr/sbin/sshd" hostname=ws-alice addr=203.0.113.7 terminal=sshd res=success'` +
+		"\x1dUID=\"root\" AUID=\"alice\""
+	out := a.Line(line)
+	for _, leaked := range []string{"alice", "ws-alice", "203.0.113.7", "auid=1000 "} {
+		if strings.Contains(out, leaked) {
+			t.Errorf("Expected %q to be anonymized, got %s", leaked, out)
+		}
+	}
+	for _, kept := range []string{"type=USER_LOGIN msg=audit(1696945536.123:24287): pid=1234 uid=0 ", "ses=3",
+		`exe="/usr/sbin/sshd"`, "terminal=sshd res=success'", "\x1dUID=\"root\""} {
+		if !strings.Contains(out, kept) {
+			t.Errorf("Expected %q to be kept, got %s", kept, out)
+		}
+	}
+	// The same principal gets the same pseudonym in every field and line
+	acct := a.pseudonym(userKind, "alice")
+	if !strings.Contains(out, `acct="`+acct+`"`) || !strings.Contains(out, `AUID="`+acct+`"`) {
+		t.Errorf("Expected acct and AUID to be %s, got %s", acct, out)
+	}
+	// Names with special characters are hex-encoded
+	if hexed := a.Line("acct=616C696365"); hexed != `acct="`+acct+`"` {
+		t.Errorf("Expected hex-encoded name to be replaced by %s, got %s", acct, hexed)
+	}
+	if other, _ := New(Auditd, []byte("other")); other.pseudonym(userKind, "alice") == acct {
+		t.Error("Expected pseudonyms to depend on the key")
+	}
+}
+
+func TestAnonymizer_CEF(t *testing.T) {
+	a, err := New(CEF, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Failed to create anonymizer: %s", err)
+	}
+	line := `Oct 10 13:55:36 fw CEF:0|Vendor|Product \| Suite|1.0|100|Failed login|5|rt=1696945536123 ` +
+		`src=203.0.113.7 suser=Alice Smith shost=ws-alice.corp.example smac=00:11:22:33:44:55 msg=a\=b`
+	out := a.Line(line)
+	expected := `Oct 10 13:55:36 fw CEF:0|Vendor|Product \| Suite|1.0|100|Failed login|5|rt=1696945536123 ` +
+		"src=" + a.pseudonym(addrKind, "203.0.113.7") + " suser=" + a.pseudonym(userKind, "Alice Smith") +
+		" shost=" + a.pseudonym(hostKind, "ws-alice.corp.example") + " smac=" + a.pseudonym(macKind, "00:11:22:33:44:55") +
+		` msg=a\=b`
+	if out != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, out)
+	}
+	if ip := a.pseudonym(addrKind, "203.0.113.7"); !strings.HasPrefix(ip, "10.") {
+		t.Errorf("Expected an address in 10.0.0.0/8, got %s", ip)
+	}
+}
+
+func TestAnonymizer_LEEF(t *testing.T) {
+	a, err := New(LEEF, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Failed to create anonymizer: %s", err)
+	}
+	user := a.pseudonym(userKind, "alice")
+	tests := map[string]string{
+		"LEEF:1.0|Vendor|Product|1.0|Login|devTime=Oct 10 2000 13:55:36\tusrName=alice\tproto=TCP":
+			"LEEF:1.0|Vendor|Product|1.0|Login|devTime=Oct 10 2000 13:55:36\tusrName=" + user + "\tproto=TCP",
+		"LEEF:2.0|Vendor|Product|1.0|Login|^|usrName=alice^proto=TCP":
+			"LEEF:2.0|Vendor|Product|1.0|Login|^|usrName=" + user + "^proto=TCP",
+		"LEEF:2.0|Vendor|Product|1.0|Login|x7C|usrName=alice|proto=TCP":
+			"LEEF:2.0|Vendor|Product|1.0|Login|x7C|usrName=" + user + "|proto=TCP",
+		"no record": "no record",
+	}
+	for line, expected := range tests {
+		if out := a.Line(line); out != expected {
+			t.Errorf("Expected %q, got %q", expected, out)
+		}
+	}
+	if _, err := New("xml", nil); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
@@ -0,0 +1,165 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a source of time. The log replay and the metrics engine share a
+// clock, so both agree on how much time passed when the replay is accelerated,
+// paused or skips ahead.
+type Clock interface {
+	// Now returns the current time of the clock.
+	Now() time.Time
+	// Elapsed returns the time elapsed since the clock started.
+	Elapsed() time.Duration
+}
+
+// wall is the real time.
+type wall struct {
+	start time.Time
+}
+
+// Wall returns a clock with the real time as current time, which started now.
+func Wall() Clock {
+	return wall{start: time.Now()}
+}
+
+func (c wall) Now() time.Time {
+	return time.Now()
+}
+
+func (c wall) Elapsed() time.Duration {
+	return time.Since(c.start)
+}
+
+// Virtual is a clock whose time runs at an adjustable speed relative to the
+// real time. It can be paused and moved ahead. It is safe for concurrent use.
+type Virtual struct {
+	mu sync.Mutex
+	origin time.Time // virtual time at elapsed zero
+	anchor time.Time // real time at which base was elapsed
+	base time.Duration
+	speed float64
+	paused bool
+}
+
+// NewVirtual creates a Virtual clock that starts now at the virtual time
+// origin and runs at the given speed.
+func NewVirtual(origin time.Time, speed float64) *Virtual {
+	return &Virtual{origin: origin, anchor: time.Now(), speed: speed}
+}
+
+// Now returns the origin of the clock plus the elapsed virtual time.
+func (c *Virtual) Now() time.Time {
+	return c.origin.Add(c.Elapsed())
+}
+
+// Elapsed returns the virtual time elapsed since the clock started.
+func (c *Virtual) Elapsed() time.Duration {
+	return c.ElapsedAt(time.Now())
+}
+
+// ElapsedAt returns the virtual time elapsed at the given real time, assuming
+// the speed does not change until then.
+func (c *Virtual) ElapsedAt(t time.Time) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.elapsedAt(t)
+}
+
+// elapsedAt implements ElapsedAt. The caller must hold the lock.
+func (c *Virtual) elapsedAt(t time.Time) time.Duration {
+	if c.paused {
+		return c.base
+	}
+	return c.base + time.Duration(float64(t.Sub(c.anchor))*c.speed)
+}
+
+// rebase moves the anchor to now. The caller must hold the lock.
+func (c *Virtual) rebase() {
+	now := time.Now()
+	c.base = c.elapsedAt(now)
+	c.anchor = now
+}
+
+// Until returns the real duration until the given elapsed virtual time is
+// reached and whether the clock is running. If the clock is paused, the
+// duration is meaningless.
+func (c *Virtual) Until(v time.Duration) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.paused {
+		return 0, false
+	}
+	return time.Duration(float64(v-c.elapsedAt(time.Now())) / c.speed), true
+}
+
+// RealTime returns the real time at which the given elapsed virtual time is
+// reached at the current speed.
+func (c *Virtual) RealTime(v time.Duration) time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	from := c.anchor
+	if c.paused {
+		from = time.Now()
+	}
+	return from.Add(time.Duration(float64(v-c.base) / c.speed))
+}
+
+// SetSpeed changes the speed of the clock.
+func (c *Virtual) SetSpeed(speed float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rebase()
+	c.speed = speed
+}
+
+// SetPaused pauses or resumes the clock.
+func (c *Virtual) SetPaused(paused bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rebase()
+	c.paused = paused
+}
+
+// Advance moves the clock ahead by d and returns the new elapsed time.
+func (c *Virtual) Advance(d time.Duration) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rebase()
+	c.base += d
+	return c.base
+}
+
+// Set sets the elapsed virtual time to v as of now.
+func (c *Virtual) Set(v time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.anchor = time.Now()
+	c.base = v
+}
+
+// State returns the current speed and pause state.
+func (c *Virtual) State() (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.speed, c.paused
+}
+
+// withNow is a clock with the elapsed time of another clock and its own
+// current time.
+type withNow struct {
+	Clock
+	now func() time.Time
+}
+
+// WithNow returns a clock with the elapsed time of c and the current time
+// returned by now, e.g. the time of the last replayed line.
+func WithNow(c Clock, now func() time.Time) Clock {
+	return withNow{Clock: c, now: now}
+}
+
+func (c withNow) Now() time.Time {
+	return c.now()
+}
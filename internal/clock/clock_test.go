@@ -0,0 +1,38 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtual(t *testing.T) {
+	origin := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := NewVirtual(origin, 3600)
+	time.Sleep(10 * time.Millisecond)
+	// 10ms at 3600x are 36s
+	if e := c.Elapsed(); e < 36*time.Second || e > time.Minute {
+		t.Errorf("Expected about 36s to have elapsed, got %s", e)
+	}
+
+	c.SetPaused(true)
+	paused := c.Elapsed()
+	time.Sleep(10 * time.Millisecond)
+	if e := c.Elapsed(); e != paused {
+		t.Errorf("Expected the paused clock to stay at %s, got %s", paused, e)
+	}
+	if !c.Now().Equal(origin.Add(paused)) {
+		t.Errorf("Expected the current time to be %s, got %s", origin.Add(paused), c.Now())
+	}
+
+	if e := c.Advance(time.Hour); e != paused+time.Hour {
+		t.Errorf("Expected the clock to advance to %s, got %s", paused+time.Hour, e)
+	}
+	c.SetSpeed(1)
+	c.SetPaused(false)
+	if d, running := c.Until(paused + time.Hour + time.Second); !running || d > time.Second || d < 900*time.Millisecond {
+		t.Errorf("Expected 1s until the given time at speed 1, got %s", d)
+	}
+	if speed, paused := c.State(); speed != 1 || paused {
+		t.Errorf("Expected the clock to run at speed 1, got %v and paused %v", speed, paused)
+	}
+}
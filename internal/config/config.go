@@ -0,0 +1,228 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// IncludeDirective starts a line that includes other configuration files.
+	// The rest of the line is a path or glob pattern, relative paths are
+	// resolved against the directory of the including file.
+	IncludeDirective = "include:"
+	// ExtendsDirective makes the current profile inherit the values of the
+	// profile named by the rest of the line.
+	ExtendsDirective = "extends:"
+)
+
+// Config holds configuration values keyed by the name of the environment
+// variable they configure, e.g. INPUT_FILE or METRIC_my_metric_EXPR.
+type Config map[string]string
+
+// profile is a named set of configuration values.
+type profile struct {
+	values Config
+	extends string // name of the profile the values are inherited from
+}
+
+// loader reads configuration files.
+type loader struct {
+	global Config // values outside of profiles
+	profiles map[string]*profile
+	visiting map[string]bool // files currently being loaded, to detect include cycles
+}
+
+// Load reads the configuration from the given path. If the path is a
+// directory, all files in it ending with ".conf" or ".env" are loaded in
+// lexical order (conf.d style), with later files overriding values of earlier
+// ones. Each file contains lines of the form KEY=VALUE. Empty lines and lines
+// starting with # are ignored, lines starting with "include:" load the given
+// files at that position. Values of profiles are ignored, see LoadProfile.
+func Load(path string) (Config, error) {
+	return LoadProfile(path, "")
+}
+
+// LoadProfile reads the configuration from the given path like Load and
+// applies the values of the given profile on top. A line "[name]" starts the
+// profile with the given name, which lasts until the next profile or the end
+// of the file. Within a profile, a line "extends: other" inherits the values
+// of another profile, which the profile's own values override. Included files
+// start outside of profiles. An empty name only loads the values outside of
+// profiles.
+func LoadProfile(path, name string) (Config, error) {
+	l := &loader{global: Config{}, profiles: map[string]*profile{}, visiting: map[string]bool{}}
+	if err := l.load(path); err != nil {
+		return nil, err
+	}
+	c := l.global
+	if len(name) == 0 {
+		return c, nil
+	}
+	if err := l.apply(c, name, map[string]bool{}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// apply sets the values of the named profile and the profiles it extends in
+// c. seen contains the profiles already applied and is used to detect
+// inheritance cycles.
+func (l *loader) apply(c Config, name string, seen map[string]bool) error {
+	p, ok := l.profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+	if seen[name] {
+		return fmt.Errorf("inheritance cycle detected at profile %q", name)
+	}
+	seen[name] = true
+	if len(p.extends) > 0 {
+		if err := l.apply(c, p.extends, seen); err != nil {
+			return err
+		}
+	}
+	for k, v := range p.values {
+		c[k] = v
+	}
+	return nil
+}
+
+// load reads the file or directory at path.
+func (l *loader) load(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return l.loadFile(path)
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if !e.IsDir() && (ext == ".conf" || ext == ".env") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := l.loadFile(filepath.Join(path, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadFile reads a single configuration file, following includes.
+func (l *loader) loadFile(path string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	if l.visiting[abs] {
+		return fmt.Errorf("include cycle detected at %s", path)
+	}
+	l.visiting[abs] = true
+	defer delete(l.visiting, abs)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	c := l.global
+	var current *profile // profile of the current section, nil outside of profiles
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if len(name) == 0 {
+				return fmt.Errorf("%s:%d: missing profile name", path, lineNumber)
+			}
+			if current = l.profiles[name]; current == nil {
+				current = &profile{values: Config{}}
+				l.profiles[name] = current
+			}
+			c = current.values
+			continue
+		}
+		if strings.HasPrefix(line, ExtendsDirective) {
+			if current == nil {
+				return fmt.Errorf("%s:%d: %s outside of a profile", path, lineNumber, ExtendsDirective)
+			}
+			current.extends = strings.TrimSpace(line[len(ExtendsDirective):])
+			continue
+		}
+		if strings.HasPrefix(line, IncludeDirective) {
+			if err := l.include(filepath.Dir(path), strings.TrimSpace(line[len(IncludeDirective):])); err != nil {
+				return fmt.Errorf("%s:%d: %w", path, lineNumber, err)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || len(key) == 0 {
+			return fmt.Errorf("%s:%d: expected KEY=VALUE, got %q", path, lineNumber, line)
+		}
+		c[key] = unquote(strings.TrimSpace(value))
+	}
+	return scanner.Err()
+}
+
+// include loads all files matching the given pattern. Relative patterns are
+// resolved against dir.
+func (l *loader) include(dir, pattern string) error {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(dir, pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("include %s matches no files", pattern)
+	}
+	for _, m := range matches {
+		if err := l.load(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unquote removes matching single or double quotes around a value.
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// Apply sets an environment variable for each configuration value. Variables
+// that are already set in the environment are left untouched, so the
+// environment always takes precedence over configuration files.
+func (c Config) Apply() error {
+	for k, v := range c {
+		if _, ok := os.LookupEnv(k); ok {
+			continue
+		}
+		if err := os.Setenv(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
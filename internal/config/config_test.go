@@ -0,0 +1,95 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"10-base.conf":     "# Base configuration\nINPUT_FILE=/logs/app.log\nLOOP=false\ninclude: shared/*.inc\n",
+		"20-team.env":      "LOOP = \"true\"\nMETRIC_requests_EXPR=t * 2\n",
+		"ignored.txt":      "INPUT_FILE=/wrong.log\n",
+		"shared/speed.inc": "SPEED='2'\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	expected := Config{
+		"INPUT_FILE":           "/logs/app.log",
+		"LOOP":                 "true",
+		"SPEED":                "2",
+		"METRIC_requests_EXPR": "t * 2",
+	}
+	if len(c) != len(expected) {
+		t.Errorf("Expected %d values, got %d: %v", len(expected), len(c), c)
+	}
+	for k, v := range expected {
+		if c[k] != v {
+			t.Errorf("Expected %s=%q, got %q", k, v, c[k])
+		}
+	}
+}
+
+func TestLoad_IncludeCycle(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.conf"), []byte("include: b.conf\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b.conf"), []byte("include: a.conf\n"), 0o644)
+
+	if _, err := Load(filepath.Join(dir, "a.conf")); err == nil {
+		t.Error("Expected error for include cycle")
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bananabacon.conf")
+	content := "INPUT_FILE=/logs/app.log\nSPEED=1\n\n" +
+		"[base]\nLOOP=true\n\n" +
+		"[staging]\nextends: base\nOUTPUT=stdout\nSPEED=2\n\n" +
+		"[workshop-A]\nextends: staging\nSPEED=4\n\n" +
+		"[loop-a]\nextends: loop-b\n[loop-b]\nextends: loop-a\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadProfile(path, "workshop-A")
+	if err != nil {
+		t.Fatalf("Failed to load profile: %v", err)
+	}
+	expected := Config{
+		"INPUT_FILE": "/logs/app.log",
+		"LOOP":       "true",
+		"OUTPUT":     "stdout",
+		"SPEED":      "4",
+	}
+	if len(c) != len(expected) {
+		t.Errorf("Expected %d values, got %d: %v", len(expected), len(c), c)
+	}
+	for k, v := range expected {
+		if c[k] != v {
+			t.Errorf("Expected %s=%q, got %q", k, v, c[k])
+		}
+	}
+
+	if c, err := Load(path); err != nil || len(c) != 2 || c["SPEED"] != "1" {
+		t.Errorf("Expected only the values outside of profiles, got %v, err: %v", c, err)
+	}
+	for _, name := range []string{"unknown", "loop-a"} {
+		if _, err := LoadProfile(path, name); err == nil {
+			t.Errorf("Expected error for profile %s", name)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package debug
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+var enabled atomic.Bool
+
+// Enabled returns true if debug logging is enabled.
+func Enabled() bool {
+	return enabled.Load()
+}
+
+// SetEnabled enables or disables debug logging.
+func SetEnabled(e bool) {
+	enabled.Store(e)
+}
+
+// Toggle switches debug logging on or off and returns the new state.
+func Toggle() bool {
+	for {
+		old := enabled.Load()
+		if enabled.CompareAndSwap(old, !old) {
+			return !old
+		}
+	}
+}
+
+// Printf logs the given message using the standard logger if debug logging
+// is enabled. Arguments are handled in the manner of fmt.Printf.
+func Printf(format string, v ...any) {
+	if enabled.Load() {
+		log.Printf("[debug] "+format, v...)
+	}
+}
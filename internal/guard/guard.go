@@ -0,0 +1,132 @@
+// Package guard keeps the process within self-imposed resource limits, so it
+// does not destabilize a shared node it runs on.
+package guard
+
+import (
+	"context"
+	"runtime"
+	rdebug "runtime/debug"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+const (
+	// PressureRatio is the share of a limit at which the guard reports
+	// pressure.
+	PressureRatio = 0.9
+	// ReliefRatio is the share of a limit below which the guard reports that
+	// the pressure is relieved. It is lower than PressureRatio, so the
+	// reactions do not flap.
+	ReliefRatio = 0.75
+)
+
+// Limits are the resource limits of the process. Zero means no limit.
+type Limits struct {
+	// Memory is the maximum resident memory in bytes.
+	Memory int64
+	// Goroutines is the maximum number of goroutines.
+	Goroutines int
+}
+
+// Usage is the resource usage of the process.
+type Usage struct {
+	Memory int64
+	Goroutines int
+}
+
+// Guard checks the usage of the process against its limits and notifies its
+// handlers when the usage comes close to a limit and when it is relieved.
+type Guard struct {
+	limits Limits
+	mu sync.Mutex
+	pressure bool
+	handlers []func(pressure bool, u Usage)
+}
+
+// New creates a guard for the given limits. With a memory limit, the garbage
+// collector also collects more eagerly when the heap approaches the limit,
+// see runtime/debug.SetMemoryLimit.
+func New(limits Limits) *Guard {
+	if limits.Memory > 0 {
+		rdebug.SetMemoryLimit(int64(float64(limits.Memory) * PressureRatio))
+	}
+	return &Guard{limits: limits}
+}
+
+// OnChange registers a handler that is called with true when the usage
+// reaches PressureRatio of a limit and with false once it is below
+// ReliefRatio of all limits again.
+func (g *Guard) OnChange(handler func(pressure bool, u Usage)) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.handlers = append(g.handlers, handler)
+}
+
+// Pressure returns true while the usage is close to a limit.
+func (g *Guard) Pressure() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.pressure
+}
+
+// Run checks the usage in the given interval until the context is cancelled.
+func (g *Guard) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			g.check(CurrentUsage())
+		}
+	}
+}
+
+// check updates the pressure state for the usage and notifies the handlers
+// of a change.
+func (g *Guard) check(u Usage) {
+	g.mu.Lock()
+	pressure := g.pressure
+	if pressure {
+		pressure = !g.below(u, ReliefRatio)
+	} else {
+		pressure = !g.below(u, PressureRatio)
+	}
+	changed := pressure != g.pressure
+	g.pressure = pressure
+	handlers := g.handlers
+	g.mu.Unlock()
+	if changed {
+		for _, h := range handlers {
+			h(pressure, u)
+		}
+	}
+}
+
+// below returns true if the usage is below the given share of all limits.
+func (g *Guard) below(u Usage, ratio float64) bool {
+	if g.limits.Memory > 0 && float64(u.Memory) >= float64(g.limits.Memory)*ratio {
+		return false
+	}
+	if g.limits.Goroutines > 0 && float64(u.Goroutines) >= float64(g.limits.Goroutines)*ratio {
+		return false
+	}
+	return true
+}
+
+// CurrentUsage returns the current usage of the process. The memory is the
+// resident set size where the operating system reports it, and the memory
+// obtained from the operating system by the Go runtime otherwise.
+func CurrentUsage() Usage {
+	u := Usage{Goroutines: runtime.NumGoroutine()}
+	if rss, ok := residentMemory(); ok {
+		u.Memory = rss
+		return u
+	}
+	samples := []metrics.Sample{{Name: "/memory/classes/total:bytes"}, {Name: "/memory/classes/heap/released:bytes"}}
+	metrics.Read(samples)
+	u.Memory = int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+	return u
+}
@@ -0,0 +1,36 @@
+package guard
+
+import (
+	"testing"
+)
+
+func TestGuard(t *testing.T) {
+	g := &Guard{limits: Limits{Memory: 1000, Goroutines: 100}}
+	var changes []bool
+	g.OnChange(func(pressure bool, u Usage) {
+		changes = append(changes, pressure)
+	})
+	for _, u := range []Usage{
+		{Memory: 800, Goroutines: 10},
+		{Memory: 950, Goroutines: 10}, // pressure
+		{Memory: 800, Goroutines: 10}, // still above the relief ratio
+		{Memory: 700, Goroutines: 10}, // relieved
+		{Memory: 100, Goroutines: 95}, // pressure
+		{Memory: 100, Goroutines: 50}, // relieved
+	} {
+		g.check(u)
+	}
+	expected := []bool{true, false, true, false}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected changes %v, got %v", expected, changes)
+	}
+	for i := range expected {
+		if changes[i] != expected[i] {
+			t.Fatalf("Expected changes %v, got %v", expected, changes)
+		}
+	}
+
+	if u := CurrentUsage(); u.Memory <= 0 || u.Goroutines <= 0 {
+		t.Errorf("Expected a positive usage, got %+v", u)
+	}
+}
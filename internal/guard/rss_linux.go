@@ -0,0 +1,27 @@
+//go:build linux
+
+package guard
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// residentMemory returns the resident set size of the process from
+// /proc/self/statm.
+func residentMemory() (int64, bool) {
+	content, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) < 2 {
+		return 0, false
+	}
+	pages, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return pages * int64(os.Getpagesize()), true
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package guard
+
+// residentMemory is not supported on this platform, see CurrentUsage.
+func residentMemory() (int64, bool) {
+	return 0, false
+}
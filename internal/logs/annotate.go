@@ -0,0 +1,101 @@
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Kinds of the annotations written by the LogReplayer.
+const (
+	// AnnotationStart means a replay run started.
+	AnnotationStart = "start"
+	// AnnotationLoop means a replay run started over because Loop is set.
+	AnnotationLoop = "loop"
+	// AnnotationEnd means a replay run ended.
+	AnnotationEnd = "end"
+	// AnnotationPause means the replay was paused.
+	AnnotationPause = "pause"
+	// AnnotationResume means a paused replay was resumed.
+	AnnotationResume = "resume"
+	// AnnotationSpeed means the replay speed was changed.
+	AnnotationSpeed = "speed"
+	// AnnotationSkip means the replay skipped ahead in the log.
+	AnnotationSkip = "skip"
+	// AnnotationRotate means an input file was rotated or truncated and is
+	// read from its start again.
+	AnnotationRotate = "rotate"
+	// AnnotationReconfigure means the filter regex and transformers were
+	// replaced.
+	AnnotationReconfigure = "reconfigure"
+	// AnnotationThrottle means the replay was slowed down on top of its
+	// speed, or the throttle was removed.
+	AnnotationThrottle = "throttle"
+)
+
+// Annotation is a notable action of the replay, e.g. a skip or a change of
+// speed, written to ReplayerOptions.Annotations as JSON.
+type Annotation struct {
+	// Time is the wall-clock time of the action.
+	Time time.Time `json:"time"`
+	// Kind is the kind of the action, e.g. AnnotationSkip.
+	Kind string `json:"kind"`
+	// Run is the replay run the action happened in.
+	Run int64 `json:"run"`
+	// LogTime is the original time of the last emitted line, if any.
+	LogTime *time.Time `json:"log_time,omitempty"`
+	// Message describes the action.
+	Message string `json:"message"`
+	// Fields are details of the action, e.g. the new speed.
+	Fields map[string]any `json:"fields,omitempty"`
+}
+
+// Annotate writes an annotation of the given kind to the annotations sink, if
+// one is configured. Besides the annotations of the LogReplayer itself,
+// components around the replay can record their actions, e.g. the start of
+// a suppression window. It is safe to call Annotate concurrently with the
+// replay. Errors of the sink are logged, they do not stop the replay.
+func (lr *LogReplayer) Annotate(kind, message string, fields map[string]any) {
+	if lr.options.Annotations == nil {
+		return
+	}
+	a := Annotation{
+		Time: time.Now(),
+		Kind: kind,
+		Run: lr.counters.run.Load(),
+		Message: message,
+		Fields: fields,
+	}
+	if lt := lr.counters.logTime.Load(); lt != 0 {
+		t := time.Unix(0, lt)
+		a.LogTime = &t
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		log.Printf("Failed to encode annotation: %v", err)
+		return
+	}
+	e := LogEvent{Time: a.Time, Line: string(b), RawLine: string(b), Source: "annotations"}
+	if a.LogTime != nil {
+		e.OriginalTime = *a.LogTime
+	}
+	lr.annotationsMu.Lock()
+	defer lr.annotationsMu.Unlock()
+	err = lr.options.Annotations.Write(context.Background(), e)
+	if err == nil {
+		err = lr.options.Annotations.Flush()
+	}
+	if err != nil {
+		log.Printf("Failed to write annotation: %v", err)
+	}
+}
+
+// annotatef is Annotate with a formatted message.
+func (lr *LogReplayer) annotatef(kind string, fields map[string]any, format string, args ...any) {
+	if lr.options.Annotations == nil {
+		return
+	}
+	lr.Annotate(kind, fmt.Sprintf(format, args...), fields)
+}
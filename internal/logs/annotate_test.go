@@ -0,0 +1,52 @@
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLogReplayer_Annotations(t *testing.T) {
+	var annotations []Annotation
+	sink := SinkFunc(func(_ context.Context, e LogEvent) error {
+		var a Annotation
+		if err := json.Unmarshal([]byte(e.Line), &a); err != nil {
+			t.Errorf("Failed to decode annotation %q: %s", e.Line, err)
+		}
+		annotations = append(annotations, a)
+		return nil
+	})
+	source := stringSource{name: "test", content: "2024-01-01 00:00:00.000 a\n2024-01-01 00:00:01.000 b\n"}
+	replayer, err := NewPipelineReplayer([]Source{source}, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		Annotations: sink,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+	if err := replayer.SetSpeed(1000); err != nil {
+		t.Fatalf("Failed to set speed: %s", err)
+	}
+	if err := replayer.Start(context.Background(), time.Now(), func(string) {}); err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+
+	var kinds []string
+	for _, a := range annotations {
+		kinds = append(kinds, a.Kind)
+	}
+	expected := []string{AnnotationSpeed, AnnotationStart, AnnotationEnd}
+	if !slices.Equal(kinds, expected) {
+		t.Fatalf("Expected annotations %v, got %v", expected, kinds)
+	}
+	if speed := annotations[0].Fields["speed"]; speed != 1000.0 {
+		t.Errorf("Expected the new speed in the annotation, got %v", speed)
+	}
+	if end := annotations[2]; end.Run != 1 || end.LogTime == nil || end.LogTime.Second() != 1 {
+		t.Errorf("Expected the end of run 1 after the last line, got %+v", end)
+	}
+}
@@ -0,0 +1,34 @@
+package logs
+
+import "unsafe"
+
+// arenaChunkSize is the size of the chunks of a stringArena. Lines of more
+// than a quarter of it are allocated on their own.
+const arenaChunkSize = 64 << 10
+
+// stringArena copies the lines of a replay into large chunks, so lines cost
+// one allocation per chunk instead of one each. The bytes of a string are
+// never written again once it was handed out, strings of an arena are
+// therefore as immutable as any other. Retaining a single line retains its
+// whole chunk though, so sinks keeping lines beyond Write keep a clone, see
+// LogEvent.Clone. A nil arena allocates every string. An arena must
+// only be used by one goroutine.
+type stringArena struct {
+	chunk []byte
+}
+
+// string returns b as a string in the arena.
+func (a *stringArena) string(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	if a == nil || len(b) > arenaChunkSize/4 {
+		return string(b)
+	}
+	if cap(a.chunk)-len(a.chunk) < len(b) {
+		a.chunk = make([]byte, 0, arenaChunkSize)
+	}
+	start := len(a.chunk)
+	a.chunk = append(a.chunk, b...)
+	return unsafe.String(&a.chunk[start], len(b))
+}
@@ -0,0 +1,109 @@
+package logs
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Reasons recorded in the audit file for dropped lines.
+const (
+	// AuditFilterRegex means the line did not match FilterRegex.
+	AuditFilterRegex = "filter_regex"
+	// AuditNoTimestamp means no time regex matched the line and no earlier
+	// line had a timestamp it could inherit.
+	AuditNoTimestamp = "no_timestamp"
+	// AuditTimeParseFailed means the timestamp of the line could not be parsed
+	// and no earlier line had a timestamp it could inherit.
+	AuditTimeParseFailed = "time_parse_failed"
+	// AuditBeforeStart means the timestamp of the line is before the first
+	// line of the log.
+	AuditBeforeStart = "before_start"
+	// AuditCheckpoint means the line was emitted before the checkpoint the
+	// replay resumed from.
+	AuditCheckpoint = "checkpoint"
+	// AuditSampled means the line was dropped by SampleRate or SampleRules.
+	AuditSampled = "sampled"
+	// AuditSkipped means the line was skipped over using Skip.
+	AuditSkipped = "skipped"
+	// AuditFiltered means the line was dropped by one of the Filters or
+	// Transformers, e.g. during a suppression window.
+	AuditFiltered = "filtered"
+)
+
+// auditRecord is a line of the audit file.
+type auditRecord struct {
+	Run int64 `json:"run"`
+	Source string `json:"source"`
+	LineNumber int `json:"line_number"`
+	Reason string `json:"reason"`
+	Line string `json:"line"`
+	Error string `json:"error,omitempty"`
+}
+
+// auditLog writes the dropped lines of a replay to a file as JSON lines.
+type auditLog struct {
+	mu sync.Mutex
+	file *os.File
+	w *bufio.Writer
+	enc *json.Encoder
+}
+
+// openAuditLog creates the audit file, truncating an existing one.
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	return &auditLog{file: f, w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// record writes a dropped line to the audit file. Write errors are ignored,
+// the audit must not stop the replay.
+func (a *auditLog) record(r auditRecord) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.enc.Encode(r)
+}
+
+// flush writes the buffered records to the file.
+func (a *auditLog) flush() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.w.Flush()
+}
+
+// close flushes and closes the audit file.
+func (a *auditLog) close() error {
+	err := a.flush()
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// skip counts a dropped line and records it in the audit file, if enabled.
+// cause is an optional error explaining the reason.
+func (lr *LogReplayer) skip(reason, source string, lineNumber int, line string, cause error) {
+	lr.counters.linesSkipped.Add(1)
+	switch reason {
+	case AuditFilterRegex, AuditSampled, AuditFiltered:
+		lr.counters.linesFiltered.Add(1)
+	}
+	if lr.audit == nil {
+		return
+	}
+	r := auditRecord{
+		Run: lr.counters.run.Load(),
+		Source: source,
+		LineNumber: lineNumber,
+		Reason: reason,
+		Line: line,
+	}
+	if cause != nil {
+		r.Error = cause.Error()
+	}
+	lr.audit.record(r)
+}
@@ -0,0 +1,61 @@
+package logs
+
+import (
+	"sync"
+	"time"
+)
+
+// batchWindow adapts the batching window of a replay to a maximum timing error
+// of the emitted lines in wall-clock time. The lines of a batch are emitted
+// once its first line is due, so a batch spanning w of log time emits its last
+// line up to w/speed early, and writing the lines makes them late by the time
+// the sink takes. The window is chosen so both stay within the target:
+// (target - lag) * speed of log time, where lag is the lateness measured for
+// the last batches, but at most the configured BatchWindow. Sparse logs are
+// therefore batched like before, while dense logs get smaller windows the more
+// lines the sink has to keep up with.
+type batchWindow struct {
+	max time.Duration // BatchWindow in log time
+	target time.Duration // MaxTimingError in wall-clock time, 0 to not adapt
+	mu sync.Mutex
+	lag time.Duration // lateness of the lines of the last batches
+}
+
+// newBatchWindow creates a batchWindow of at most max log time that keeps the
+// timing error within target. A zero target always returns max.
+func newBatchWindow(max, target time.Duration) *batchWindow {
+	return &batchWindow{max: max, target: target}
+}
+
+// adaptive returns whether the window adapts to the timing error.
+func (w *batchWindow) adaptive() bool {
+	return w.target > 0
+}
+
+// window returns the span of log time of the next batch at the given speed.
+func (w *batchWindow) window(speed float64) time.Duration {
+	if !w.adaptive() {
+		return w.max
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	budget := w.target - w.lag
+	if budget <= 0 {
+		return 0
+	}
+	return min(w.max, time.Duration(float64(budget)*speed))
+}
+
+// observe records the maximum lateness of the lines of an emitted batch. The
+// lag follows increases right away and decays slowly, so a single fast batch
+// does not widen the window while the sink is slow.
+func (w *batchWindow) observe(late time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	late = max(0, late)
+	if late > w.lag {
+		w.lag = late
+	} else {
+		w.lag -= (w.lag - late) / 4
+	}
+}
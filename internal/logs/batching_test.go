@@ -0,0 +1,42 @@
+package logs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBatchWindow(t *testing.T) {
+	static := newBatchWindow(5*time.Second, 0)
+	static.observe(time.Second)
+	if w := static.window(10); w != 5*time.Second {
+		t.Errorf("Expected a static window of 5s, got %s", w)
+	}
+
+	target := 20 * time.Millisecond
+	w := newBatchWindow(5*time.Second, target)
+	for _, test := range []struct {
+		late     time.Duration
+		speed    float64
+		expected time.Duration
+	}{
+		// Without lag, the whole target is spent on batching
+		{0, 10, 200 * time.Millisecond},
+		// The lag is deducted from the target right away
+		{5 * time.Millisecond, 10, 150 * time.Millisecond},
+		{8 * time.Millisecond, 1, 12 * time.Millisecond},
+		// Lines later than the target are emitted one timestamp at a time
+		{30 * time.Millisecond, 10, 0},
+		// The lag decays by a quarter of the difference per batch, 22.5ms
+		// still exceed the target
+		{0, 10, 0},
+		{0, 10, (target - 30*time.Millisecond*9/16) * 10},
+		// The window never exceeds the maximum
+		{-time.Second, 1e6, 5 * time.Second},
+	} {
+		w.observe(test.late)
+		if window := w.window(test.speed); window != test.expected {
+			t.Errorf("Expected a window of %s after %s late at speed %v, got %s", test.expected, test.late,
+				test.speed, window)
+		}
+	}
+}
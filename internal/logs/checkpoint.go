@@ -0,0 +1,130 @@
+package logs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Checkpoint describes the position of a replay, so that it can be resumed
+// after a restart.
+type Checkpoint struct {
+	// Run is the replay run the checkpoint was taken in.
+	Run int64 `json:"run"`
+	// Lines maps each input file to the number of its last emitted line.
+	Lines map[string]int64 `json:"lines"`
+	// Offset is the virtual time of the last emitted line, relative to the
+	// first line of the input file.
+	Offset time.Duration `json:"offset"`
+}
+
+// checkpoint returns the current position of the replay.
+func (lr *LogReplayer) checkpoint() Checkpoint {
+	lr.positionsMu.Lock()
+	lines := make(map[string]int64, len(lr.positions))
+	for source, line := range lr.positions {
+		lines[source] = line
+	}
+	lr.positionsMu.Unlock()
+	return Checkpoint{
+		Run: lr.counters.run.Load(),
+		Lines: lines,
+		Offset: time.Duration(lr.counters.lastOffset.Load()),
+	}
+}
+
+// setPosition records the last emitted line of an input.
+func (lr *LogReplayer) setPosition(source string, line int) {
+	lr.positionsMu.Lock()
+	defer lr.positionsMu.Unlock()
+	lr.positions[source] = int64(line)
+}
+
+// resetPositions resets the recorded positions at the start of a run, either
+// to the positions of the checkpoint the run resumes from or to the start of
+// the inputs.
+func (lr *LogReplayer) resetPositions(resume *Checkpoint) {
+	lr.positionsMu.Lock()
+	defer lr.positionsMu.Unlock()
+	lr.positions = map[string]int64{}
+	lr.counters.lastOffset.Store(0)
+	if resume != nil {
+		for source, line := range resume.Lines {
+			lr.positions[source] = line
+		}
+		lr.counters.lastOffset.Store(int64(resume.Offset))
+	}
+}
+
+// loadCheckpoint reads the checkpoint file configured in the options. It
+// returns nil if no checkpoint file is configured or it does not exist, or if
+// the checkpoint belongs to different input files.
+func (lr *LogReplayer) loadCheckpoint() (*Checkpoint, error) {
+	if len(lr.options.CheckpointFile) == 0 {
+		return nil, nil
+	}
+	content, err := os.ReadFile(lr.options.CheckpointFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(content, &cp); err != nil {
+		return nil, err
+	}
+	for source := range cp.Lines {
+		if !slices.Contains(lr.inputFiles, source) {
+			log.Printf("Ignoring checkpoint for %s, replaying %s", source, strings.Join(lr.inputFiles, ", "))
+			return nil, nil
+		}
+	}
+	return &cp, nil
+}
+
+// writeCheckpoint writes the current position of the replay to the checkpoint
+// file. The file is replaced atomically, so a crash while writing never leaves
+// a corrupt checkpoint behind.
+func (lr *LogReplayer) writeCheckpoint() error {
+	content, err := json.Marshal(lr.checkpoint())
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(lr.options.CheckpointFile), ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), lr.options.CheckpointFile)
+}
+
+// writeCheckpoints writes a checkpoint every CheckpointInterval until the
+// context is cancelled.
+func (lr *LogReplayer) writeCheckpoints(ctx context.Context) {
+	ticker := time.NewTicker(lr.options.CheckpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := lr.writeCheckpoint(); err != nil {
+				log.Printf("Failed to write checkpoint: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package logs
+
+import (
+	"bananabacon/internal/clock"
+	"sync"
+	"time"
+)
+
+// replayClock maps the virtual time of a replay run, i.e. the offset from the
+// first line of the log, to wall-clock time. It supports pausing, skipping ahead
+// and changing the replay speed while the replay is running. Every change is
+// signalled on the changed channel, so a waiting replay loop can reschedule.
+// The virtual time of all runs is kept by a clock.Virtual, which is shared with
+// the metrics engine, see LogReplayer.Clock.
+type replayClock struct {
+	virtual *clock.Virtual
+	mu sync.Mutex
+	start time.Duration // virtual time at which the current run started
+	skippedUntil time.Duration // lines before this virtual time of the run are dropped
+	speed float64 // speed set with setSpeed
+	throttle float64 // factor on top of speed, see setThrottle
+	changed chan struct{}
+}
+
+// newReplayClock creates a new clock running at the given speed.
+func newReplayClock(speed float64) *replayClock {
+	return &replayClock{
+		virtual: clock.NewVirtual(time.Now(), speed),
+		speed: speed,
+		throttle: 1,
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// reset restarts the virtual time of the run at zero at the given wall-clock
+// time, keeping speed and pause state.
+func (c *replayClock) reset(at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.start = c.virtual.ElapsedAt(at)
+	c.skippedUntil = 0
+}
+
+// next starts the next run at the end of the current run, which lasted length
+// in virtual time. The runs of a looped replay thus follow each other on the
+// absolute timeline of the clock, and the time spent between the runs, e.g.
+// reopening the inputs and emitting the last batch, does not add up over many
+// runs. If the current run was skipped beyond its end, the next run starts now.
+func (c *replayClock) next(length time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.skippedUntil > length {
+		c.start = c.virtual.Elapsed()
+	} else {
+		c.start += length
+	}
+	c.skippedUntil = 0
+}
+
+// runStart returns the virtual time at which the current run started.
+func (c *replayClock) runStart() time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.start
+}
+
+// notify signals a change to a waiting replay loop without blocking.
+func (c *replayClock) notify() {
+	select {
+	case c.changed <- struct{}{}:
+	default:
+	}
+}
+
+// until returns the wall-clock duration until the given virtual time is
+// reached and whether the clock is running. If the clock is paused, the
+// duration is meaningless.
+func (c *replayClock) until(v time.Duration) (time.Duration, bool) {
+	return c.virtual.Until(c.runStart() + v)
+}
+
+// wallTime returns the wall-clock time at which the given virtual time is
+// reached at the current speed.
+func (c *replayClock) wallTime(v time.Duration) time.Time {
+	return c.virtual.RealTime(c.runStart() + v)
+}
+
+// skipped returns true if lines at the given virtual time were skipped.
+func (c *replayClock) skipped(v time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return v < c.skippedUntil
+}
+
+// setSpeed changes the speed of the clock.
+func (c *replayClock) setSpeed(speed float64) {
+	c.mu.Lock()
+	c.speed = speed
+	c.virtual.SetSpeed(speed * c.throttle)
+	c.mu.Unlock()
+	c.notify()
+}
+
+// setThrottle slows the clock down by the given factor on top of its speed.
+func (c *replayClock) setThrottle(throttle float64) {
+	c.mu.Lock()
+	c.throttle = throttle
+	c.virtual.SetSpeed(c.speed * throttle)
+	c.mu.Unlock()
+	c.notify()
+}
+
+// setPaused pauses or resumes the clock.
+func (c *replayClock) setPaused(paused bool) {
+	c.virtual.SetPaused(paused)
+	c.notify()
+}
+
+// advance moves the virtual time forward by d without dropping any lines.
+func (c *replayClock) advance(d time.Duration) {
+	c.virtual.Advance(d)
+	c.notify()
+}
+
+// skip advances the virtual time by d. Lines within the skipped window are
+// dropped.
+func (c *replayClock) skip(d time.Duration) {
+	c.mu.Lock()
+	c.skippedUntil = c.virtual.Advance(d) - c.start
+	c.mu.Unlock()
+	c.notify()
+}
+
+// skipTo moves the virtual time to v, which is reached now. Lines before v are
+// dropped.
+func (c *replayClock) skipTo(v time.Duration) {
+	c.mu.Lock()
+	c.virtual.Set(c.start + v)
+	c.skippedUntil = v
+	c.mu.Unlock()
+	c.notify()
+}
+
+// state returns the current speed, without the throttle, and pause state.
+func (c *replayClock) state() (float64, bool) {
+	_, paused := c.virtual.State()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.speed, paused
+}
@@ -0,0 +1,303 @@
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	// DockerPrefix starts the input names of the logs of a container read
+	// through the Docker Engine API, e.g. "docker://api".
+	DockerPrefix = "docker://"
+	// DockerJSONPrefix starts the input names of log files in the json-file
+	// format of Docker, e.g.
+	// "docker-json:/var/lib/docker/containers/<id>/<id>-json.log".
+	DockerJSONPrefix = "docker-json:"
+	// DefaultDockerHost is the address of the Docker daemon if DOCKER_HOST is
+	// not set.
+	DefaultDockerHost = "unix:///var/run/docker.sock"
+)
+
+// IsDockerInput returns true if the input name refers to the logs of a
+// container, i.e. it starts with DockerPrefix or DockerJSONPrefix.
+func IsDockerInput(name string) bool {
+	return strings.HasPrefix(name, DockerPrefix) || strings.HasPrefix(name, DockerJSONPrefix)
+}
+
+// dockerInput is a parsed Docker input name. The name can be followed by
+// "?timestamps=true" to prefix every line with the time Docker received it,
+// in RFC 3339 format with nanoseconds like "docker logs -t" does, for logs
+// without timestamps of their own.
+type dockerInput struct {
+	target string // container or path
+	timestamps bool
+}
+
+// ParseDockerInput parses an input name without its prefix into the
+// container or path and whether lines are prefixed with the time Docker
+// received them, see IsDockerInput.
+func ParseDockerInput(name string) (target string, timestamps bool, err error) {
+	in, err := parseDockerInput(name)
+	return in.target, in.timestamps, err
+}
+
+// parseDockerInput parses an input name without its prefix.
+func parseDockerInput(name string) (dockerInput, error) {
+	target, query, _ := strings.Cut(name, "?")
+	in := dockerInput{target: target}
+	if len(target) == 0 {
+		return in, errors.New("missing container")
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return in, fmt.Errorf("invalid options of %s: %w", name, err)
+	}
+	for k, v := range params {
+		if k != "timestamps" {
+			return in, fmt.Errorf("unknown option %q of %s, must be timestamps", k, name)
+		}
+		in.timestamps = v[len(v)-1] == "true"
+	}
+	return in, nil
+}
+
+// openDockerInput reads the logs of a container up to now, see IsDockerInput.
+// Lines of stdout and stderr are interleaved in the order they were written.
+// It returns an error wrapping fs.ErrNotExist if the container or the log
+// file does not exist.
+func openDockerInput(name string) (io.ReadSeekCloser, error) {
+	var r io.ReadCloser
+	if path, ok := strings.CutPrefix(name, DockerJSONPrefix); ok {
+		in, err := parseDockerInput(path)
+		if err != nil {
+			return nil, err
+		}
+		f, err := os.Open(in.target)
+		if err != nil {
+			return nil, err
+		}
+		r = struct {
+			io.Reader
+			io.Closer
+		}{DockerJSONReader(f, in.timestamps), f}
+	} else {
+		in, err := parseDockerInput(strings.TrimPrefix(name, DockerPrefix))
+		if err != nil {
+			return nil, err
+		}
+		if r, err = containerLogs(context.Background(), in, false, true); err != nil {
+			return nil, err
+		}
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the logs of %s: %w", name, err)
+	}
+	return memoryInput{bytes.NewReader(content)}, nil
+}
+
+// FollowContainer returns a reader of the lines a container writes from now
+// on, read through the Docker Engine API like "docker logs -f". container is
+// the name or id of the container, optionally followed by "?timestamps=true",
+// see IsDockerInput. If fromStart is set, the existing logs are read first.
+// The reader reaches its end when the container stops or the context is
+// cancelled.
+func FollowContainer(ctx context.Context, container string, fromStart bool) (io.ReadCloser, error) {
+	in, err := parseDockerInput(container)
+	if err != nil {
+		return nil, err
+	}
+	return containerLogs(ctx, in, true, fromStart)
+}
+
+// DockerJSONReader returns a reader of the lines logged in the json-file
+// format read from r, e.g. {"log":"line\n","stream":"stdout","time":"..."}.
+// Lines split into several entries by Docker are joined. If timestamps is
+// set, every line is prefixed with its time followed by a space. Entries that
+// are not valid JSON are passed through unchanged.
+func DockerJSONReader(r io.Reader, timestamps bool) io.Reader {
+	pr, pw := io.Pipe()
+	go func() {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, 1<<20)
+		lineStart := true
+		for scanner.Scan() {
+			var entry dockerJSONEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				entry.Log = scanner.Text() + "\n"
+			}
+			line := entry.Log
+			if timestamps && lineStart && len(entry.Time) > 0 {
+				line = entry.Time + " " + line
+			}
+			lineStart = strings.HasSuffix(entry.Log, "\n")
+			if _, err := io.WriteString(pw, line); err != nil {
+				return
+			}
+		}
+		pw.CloseWithError(scanner.Err())
+	}()
+	return pr
+}
+
+// dockerJSONEntry is an entry of a log file in the json-file format.
+type dockerJSONEntry struct {
+	Log string `json:"log"`
+	Stream string `json:"stream"`
+	Time string `json:"time"`
+}
+
+// memoryInput is an input read into memory, whose Close does nothing.
+type memoryInput struct {
+	io.ReadSeeker
+}
+
+func (memoryInput) Close() error {
+	return nil
+}
+
+// dockerClient sends requests to the Docker Engine API.
+type dockerClient struct {
+	client *http.Client
+	base string
+}
+
+// newDockerClient returns a client of the daemon given by DOCKER_HOST, like
+// the Docker CLI uses: a unix socket like "unix:///var/run/docker.sock" or a
+// TCP address like "tcp://127.0.0.1:2375".
+func newDockerClient() (*dockerClient, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if len(host) == 0 {
+		host = DefaultDockerHost
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKER_HOST %s: %w", host, err)
+	}
+	switch u.Scheme {
+	case "unix":
+		var dialer net.Dialer
+		transport := &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", u.Path)
+		}}
+		return &dockerClient{client: &http.Client{Transport: transport}, base: "http://docker"}, nil
+	case "tcp", "http":
+		return &dockerClient{client: &http.Client{}, base: "http://" + u.Host}, nil
+	case "https":
+		return &dockerClient{client: &http.Client{}, base: "https://" + u.Host}, nil
+	}
+	return nil, fmt.Errorf("invalid DOCKER_HOST %s, must start with unix://, tcp://, http:// or https://", host)
+}
+
+// get sends a GET request to the API and returns the body of a successful
+// response. A 404 response is returned as an error wrapping fs.ErrNotExist.
+func (c *dockerClient) get(ctx context.Context, path string, query url.Values) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the Docker daemon: %w", err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp.Body, nil
+	}
+	defer resp.Body.Close()
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(body, &apiErr) != nil || len(apiErr.Message) == 0 {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	err = fmt.Errorf("docker: %s (status %d)", apiErr.Message, resp.StatusCode)
+	if resp.StatusCode == http.StatusNotFound {
+		err = fmt.Errorf("%w: %w", err, fs.ErrNotExist)
+	}
+	return nil, err
+}
+
+// containerLogs returns the logs of a container. If follow is set, the reader
+// continues with the lines written later. If all is not set, only these are
+// read.
+func containerLogs(ctx context.Context, in dockerInput, follow, all bool) (io.ReadCloser, error) {
+	c, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	path := "/containers/" + url.PathEscape(in.target)
+	body, err := c.get(ctx, path+"/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	var info struct {
+		Config struct {
+			Tty bool
+		}
+	}
+	err = json.NewDecoder(body).Decode(&info)
+	body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect container %s: %w", in.target, err)
+	}
+
+	query := url.Values{"stdout": {"true"}, "stderr": {"true"}, "timestamps": {fmt.Sprint(in.timestamps)},
+		"follow": {fmt.Sprint(follow)}}
+	if !all {
+		query.Set("tail", "0")
+	}
+	if body, err = c.get(ctx, path+"/logs", query); err != nil {
+		return nil, err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		defer body.Close()
+		var err error
+		if info.Config.Tty {
+			// The output of containers with a terminal is not multiplexed
+			_, err = io.Copy(pw, body)
+		} else {
+			err = demultiplex(pw, body)
+		}
+		if ctx.Err() != nil {
+			// The logs were followed until the context was cancelled
+			err = nil
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// demultiplex copies the payload of the frames of stdout and stderr
+// multiplexed by the Docker Engine API from r to w. Every frame starts with
+// an 8 byte header: the stream, three zero bytes and the big-endian length of
+// the payload.
+func demultiplex(w io.Writer, r io.Reader) error {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package logs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDockerJSONReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app-json.log")
+	content := `{"log":"2024-01-01 10:00:00.000 first\n","stream":"stdout","time":"2024-01-01T10:00:00.1Z"}
+{"log":"2024-01-01 10:00:01.000 split ","stream":"stderr","time":"2024-01-01T10:00:01.1Z"}
+{"log":"line\n","stream":"stderr","time":"2024-01-01T10:00:01.2Z"}
+not json
+`
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write log: %s", err)
+	}
+
+	for _, test := range []struct {
+		input    string
+		expected string
+	}{
+		{DockerJSONPrefix + path, "2024-01-01 10:00:00.000 first\n2024-01-01 10:00:01.000 split line\nnot json\n"},
+		{DockerJSONPrefix + path + "?timestamps=true", "2024-01-01T10:00:00.1Z 2024-01-01 10:00:00.000 first\n" +
+			"2024-01-01T10:00:01.1Z 2024-01-01 10:00:01.000 split line\nnot json\n"},
+	} {
+		r, err := FileSource(test.input).Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %s", test.input, err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s", test.input, err)
+		}
+		if string(b) != test.expected {
+			t.Errorf("Expected %q for %s, got %q", test.expected, test.input, b)
+		}
+	}
+
+	if _, err := FileSource(DockerJSONPrefix + path + ".missing").Open(); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a missing file to not exist, got %v", err)
+	}
+}
+
+func TestDockerInput(t *testing.T) {
+	frame := func(stream byte, payload string) []byte {
+		header := make([]byte, 8, 8+len(payload))
+		header[0] = stream
+		binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+		return append(header, payload...)
+	}
+	var query string
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/containers/api/json":
+			w.Write([]byte(`{"Id":"abc","Config":{"Tty":false}}`))
+		case "/containers/tty/json":
+			w.Write([]byte(`{"Id":"def","Config":{"Tty":true}}`))
+		case "/containers/api/logs":
+			query = r.URL.RawQuery
+			w.Write(frame(1, "2024-01-01 10:00:00.000 out\n"))
+			w.Write(frame(2, "2024-01-01 10:00:01.000 err"))
+			w.Write(frame(2, "or\n"))
+		case "/containers/tty/logs":
+			w.Write([]byte("2024-01-01 10:00:00.000 raw\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"No such container: missing"}`))
+		}
+	}))
+	defer daemon.Close()
+	t.Setenv("DOCKER_HOST", "tcp://"+strings.TrimPrefix(daemon.URL, "http://"))
+
+	for _, test := range []struct {
+		input    string
+		expected string
+		query    string
+	}{
+		{"docker://api", "2024-01-01 10:00:00.000 out\n2024-01-01 10:00:01.000 error\n",
+			"follow=false&stderr=true&stdout=true&timestamps=false"},
+		{"docker://api?timestamps=true", "2024-01-01 10:00:00.000 out\n2024-01-01 10:00:01.000 error\n",
+			"follow=false&stderr=true&stdout=true&timestamps=true"},
+		{"docker://tty", "2024-01-01 10:00:00.000 raw\n", ""},
+	} {
+		query = ""
+		r, err := FileSource(test.input).Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %s", test.input, err)
+		}
+		b, _ := io.ReadAll(r)
+		if string(b) != test.expected {
+			t.Errorf("Expected %q for %s, got %q", test.expected, test.input, b)
+		}
+		if query != test.query {
+			t.Errorf("Expected query %q for %s, got %q", test.query, test.input, query)
+		}
+	}
+
+	_, err := FileSource("docker://missing").Open()
+	if !errors.Is(err, fs.ErrNotExist) || !strings.Contains(err.Error(), "No such container: missing") {
+		t.Errorf("Expected a missing container to not exist, got %v", err)
+	}
+	if _, err := FileSource("docker://api?tail=10").Open(); err == nil {
+		t.Error("Expected an unknown option to fail")
+	}
+}
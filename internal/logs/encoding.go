@@ -0,0 +1,194 @@
+package logs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Input encodings, see ReplayerOptions.InputEncoding.
+const (
+	// EncodingAuto detects the encoding of an input from its byte order mark
+	// or its first bytes, see detectEncoding.
+	EncodingAuto = "auto"
+	EncodingUTF8 = "utf-8"
+	EncodingUTF16LE = "utf-16le"
+	EncodingUTF16BE = "utf-16be"
+	// EncodingLatin1 is ISO-8859-1.
+	EncodingLatin1 = "latin1"
+	// EncodingWindows1252 is the Western European code page of Windows, a
+	// superset of the printable characters of ISO-8859-1.
+	EncodingWindows1252 = "windows-1252"
+)
+
+// sniffSize is the number of bytes at the start of an input the encoding is
+// detected from.
+const sniffSize = 4096
+
+// checkEncoding returns an error if encoding is not one of the supported
+// input encodings.
+func checkEncoding(encoding string) error {
+	switch encoding {
+	case "", EncodingAuto, EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE, EncodingLatin1, EncodingWindows1252:
+		return nil
+	}
+	return fmt.Errorf("invalid input encoding: %s, must be %s, %s, %s, %s, %s or %s", encoding, EncodingAuto,
+		EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE, EncodingLatin1, EncodingWindows1252)
+}
+
+// decodeInput returns a reader decoding r from the given encoding to UTF-8,
+// skipping a byte order mark, and the encoding used. If encoding is empty or
+// EncodingAuto, it is detected from the start of r.
+func decodeInput(r io.Reader, encoding string) (io.Reader, string) {
+	br := bufio.NewReaderSize(r, sniffSize)
+	start, _ := br.Peek(sniffSize)
+	bom := 0
+	if encoding == "" || encoding == EncodingAuto {
+		encoding, bom = detectEncoding(start)
+	} else {
+		_, bom = detectEncoding(start)
+		if bom > 0 && !bytes.HasPrefix(start, byteOrderMarks[encoding]) {
+			// The byte order mark of another encoding is not skipped
+			bom = 0
+		}
+	}
+	br.Discard(bom)
+	switch encoding {
+	case EncodingUTF16LE:
+		return &decodingReader{r: br, decode: decodeUTF16(binary.LittleEndian)}, encoding
+	case EncodingUTF16BE:
+		return &decodingReader{r: br, decode: decodeUTF16(binary.BigEndian)}, encoding
+	case EncodingLatin1:
+		return &decodingReader{r: br, decode: decodeSingleByte(nil)}, encoding
+	case EncodingWindows1252:
+		return &decodingReader{r: br, decode: decodeSingleByte(&windows1252)}, encoding
+	}
+	return br, EncodingUTF8
+}
+
+// byteOrderMarks are the byte order marks of the encodings that have one.
+var byteOrderMarks = map[string][]byte{
+	EncodingUTF8: {0xEF, 0xBB, 0xBF},
+	EncodingUTF16LE: {0xFF, 0xFE},
+	EncodingUTF16BE: {0xFE, 0xFF},
+}
+
+// detectEncoding returns the encoding of an input starting with start and
+// the length of its byte order mark. Without a byte order mark, inputs with
+// null bytes at mostly every other position, as in ASCII text encoded in
+// UTF-16, are UTF-16, inputs that are not valid UTF-8 are Latin-1 and all
+// others UTF-8.
+func detectEncoding(start []byte) (string, int) {
+	for _, encoding := range []string{EncodingUTF8, EncodingUTF16LE, EncodingUTF16BE} {
+		if bom := byteOrderMarks[encoding]; bytes.HasPrefix(start, bom) {
+			return encoding, len(bom)
+		}
+	}
+	var even, odd int // null bytes at even and odd positions
+	for i, b := range start {
+		if b == 0 {
+			if i%2 == 0 {
+				even++
+			} else {
+				odd++
+			}
+		}
+	}
+	switch half := len(start) / 2; {
+	case half > 0 && odd > half/2 && even*8 < odd:
+		return EncodingUTF16LE, 0
+	case half > 0 && even > half/2 && odd*8 < even:
+		return EncodingUTF16BE, 0
+	}
+	// The start may end in the middle of a character
+	for cut := 0; cut < utf8.UTFMax && cut < len(start); cut++ {
+		if utf8.Valid(start[:len(start)-cut]) {
+			return EncodingUTF8, 0
+		}
+	}
+	if len(start) == 0 {
+		return EncodingUTF8, 0
+	}
+	return EncodingLatin1, 0
+}
+
+// decodingReader decodes the bytes read from r to UTF-8. Like reading a file,
+// it can be read again after it returned io.EOF, e.g. to follow an input.
+type decodingReader struct {
+	r io.Reader
+	// decode appends the characters of src to dst and returns the number of
+	// bytes decoded. Incomplete characters at the end of src are left for
+	// the next call.
+	decode func(dst, src []byte) ([]byte, int)
+	in []byte // bytes read but not decoded yet
+	out []byte // decoded bytes not returned yet
+	buf [sniffSize]byte
+}
+
+func (d *decodingReader) Read(p []byte) (int, error) {
+	for len(d.out) == 0 {
+		n, err := d.r.Read(d.buf[:])
+		d.in = append(d.in, d.buf[:n]...)
+		var decoded int
+		d.out, decoded = d.decode(d.out[:0], d.in)
+		d.in = d.in[:copy(d.in, d.in[decoded:])]
+		if len(d.out) == 0 && err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.out)
+	d.out = d.out[n:]
+	return n, nil
+}
+
+// decodeUTF16 returns a decode function for UTF-16 in the given byte order.
+// Unpaired surrogates are decoded as utf8.RuneError.
+func decodeUTF16(order binary.ByteOrder) func(dst, src []byte) ([]byte, int) {
+	return func(dst, src []byte) ([]byte, int) {
+		i := 0
+		for ; i+1 < len(src); i += 2 {
+			r := rune(order.Uint16(src[i:]))
+			if utf16.IsSurrogate(r) {
+				if i+3 >= len(src) {
+					// Wait for the rest of the pair
+					break
+				}
+				if r2 := rune(order.Uint16(src[i+2:])); r < 0xDC00 && r2 >= 0xDC00 && r2 <= 0xDFFF {
+					r = utf16.DecodeRune(r, r2)
+					i += 2
+				} else {
+					r = utf8.RuneError
+				}
+			}
+			dst = utf8.AppendRune(dst, r)
+		}
+		return dst, i
+	}
+}
+
+// decodeSingleByte returns a decode function for an encoding with a character
+// per byte. high maps the bytes 0x80 to 0x9F, nil maps them to the Unicode
+// code points of the same value like Latin-1.
+func decodeSingleByte(high *[32]rune) func(dst, src []byte) ([]byte, int) {
+	return func(dst, src []byte) ([]byte, int) {
+		for _, b := range src {
+			r := rune(b)
+			if high != nil && b >= 0x80 && b < 0xA0 {
+				r = high[b-0x80]
+			}
+			dst = utf8.AppendRune(dst, r)
+		}
+		return dst, len(src)
+	}
+}
+
+// windows1252 maps the bytes 0x80 to 0x9F of Windows-1252, which differ from
+// Latin-1. Undefined bytes are mapped like in Latin-1.
+var windows1252 = [32]rune{
+	'€', 0x81, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0x8D, 'Ž', 0x8F,
+	0x90, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0x9D, 'ž', 'Ÿ',
+}
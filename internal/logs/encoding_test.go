@@ -0,0 +1,78 @@
+package logs
+
+import (
+	"context"
+	"encoding/binary"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// encodeUTF16 encodes s as UTF-16 with a byte order mark.
+func encodeUTF16(s string, order binary.AppendByteOrder) string {
+	b := order.AppendUint16(nil, 0xFEFF)
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = order.AppendUint16(b, u)
+	}
+	return string(b)
+}
+
+func TestLogReplayer_InputEncoding(t *testing.T) {
+	text := "2023-01-01 00:00:00.000 Benutzer Jürgen angemeldet 😀\r\n" +
+		"2023-01-01 00:00:01.000 Größe überschritten\r\n"
+	expected := []string{
+		"2023-01-01 00:00:00.000 Benutzer Jürgen angemeldet 😀",
+		"2023-01-01 00:00:01.000 Größe überschritten",
+	}
+	latin1 := "2023-01-01 00:00:00.000 Benutzer J\xfcrgen angemeldet\r\n" +
+		"2023-01-01 00:00:01.000 Gr\xf6\xdfe \xfcberschritten\r\n"
+	for _, test := range []struct {
+		name     string
+		content  string
+		encoding string
+		expected []string
+	}{
+		{"utf-8 with BOM", "\xef\xbb\xbf" + text, "", expected},
+		{"utf-16le", encodeUTF16(text, binary.LittleEndian), "", expected},
+		{"utf-16be", encodeUTF16(text, binary.BigEndian), "", expected},
+		{"utf-16le without BOM", encodeUTF16(text, binary.LittleEndian)[2:], "", expected},
+		{"latin1", latin1, "", []string{expected[0][:len(expected[0])-5], expected[1]}},
+		{"windows-1252", "2023-01-01 00:00:00.000 \x93quoted\x94 \x80\n", EncodingWindows1252,
+			[]string{"2023-01-01 00:00:00.000 “quoted” €"}},
+	} {
+		replayer, err := NewPipelineReplayer([]Source{stringSource{name: "app.log", content: test.content}},
+			ReplayerOptions{
+				FilterRegex:   ".*",
+				TimeRegex:     `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+				TimeFormat:    "2006-01-02 15:04:05.000",
+				Speed:         1000,
+				InputEncoding: test.encoding,
+			})
+		if err != nil {
+			t.Fatalf("Failed to create replayer: %s", err)
+		}
+		var lines []string
+		err = replayer.StartEvents(context.Background(), time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+			func(_ context.Context, e LogEvent) {
+				lines = append(lines, e.RawLine)
+			})
+		if err != nil {
+			t.Fatalf("Replay failed: %s", err)
+		}
+		if !slices.Equal(lines, test.expected) {
+			t.Errorf("Expected %s input to be replayed as %q, got %q", test.name, test.expected, lines)
+		}
+	}
+
+	_, err := NewLogReplayer("app.log", ReplayerOptions{
+		FilterRegex:   ".*",
+		TimeRegex:     `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:    "2006-01-02 15:04:05.000",
+		InputEncoding: "ebcdic",
+	})
+	if err == nil || !strings.Contains(err.Error(), "encoding") {
+		t.Errorf("Expected error for unknown encoding, got %v", err)
+	}
+}
@@ -0,0 +1,40 @@
+package logs
+
+import (
+	"strings"
+	"time"
+)
+
+// LogEvent describes a single replayed log line together with the metadata
+// gathered while parsing it. The lines of an event share their memory with
+// other lines of the replay, sinks keeping events beyond Write, e.g. in a
+// queue or until the next flush, should keep a Clone instead.
+type LogEvent struct {
+	// OriginalTime is the timestamp of the line in the input file. For lines
+	// without a timestamp of their own, it is the timestamp of the batch the
+	// line belongs to.
+	OriginalTime time.Time
+	// Time is the timestamp the line was mapped to in the replay.
+	Time time.Time
+	// RawLine is the line as read from the input file.
+	RawLine string
+	// Line is the line with its timestamp rewritten. It equals RawLine if the
+	// line has no timestamp of its own.
+	Line string
+	// Source is the input file the line was read from.
+	Source string
+	// LineNumber is the 1-based number of the line in the input file.
+	LineNumber int
+}
+
+// Clone returns a copy of the event whose lines do not share memory with the
+// replay.
+func (e LogEvent) Clone() LogEvent {
+	e.RawLine = strings.Clone(e.RawLine)
+	if e.Line == e.RawLine {
+		e.Line = e.RawLine
+	} else {
+		e.Line = strings.Clone(e.Line)
+	}
+	return e
+}
@@ -0,0 +1,202 @@
+package logs
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// FollowPollInterval is the interval in which a followed input file is
+	// checked for new lines.
+	FollowPollInterval = 250 * time.Millisecond
+)
+
+// follow reads lines appended to the input file after the replay reached its
+// end and emits them immediately with the current time as timestamp. It polls
+// the file until the context is cancelled or the line limit is reached. If the
+// input file is rotated or truncated while it is followed, the new content is
+// read from its start, see followRotation.
+// count is the number of lines emitted in the current run so far and
+// lineNumber the number of the last line read from the file.
+func (lr *LogReplayer) follow(ctx context.Context, file io.Reader, count, lineNumber int,
+	sink Sink) error {
+	decoded, _ := decodeInput(file, lr.encodings[0])
+	reader := bufio.NewReader(decoded)
+	var reopened io.Closer // input opened after a rotation, the original input is closed by start
+	defer func() {
+		if reopened != nil {
+			reopened.Close()
+		}
+	}()
+	partial := ""
+	sampledOut := false // whether the last line with a timestamp was dropped by sampling
+	ticker := time.NewTicker(FollowPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if ctx.Err() != nil || (lr.options.MaxLines > 0 && count >= lr.options.MaxLines) {
+			return nil
+		}
+		chunk, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		lr.counters.bytesRead.Add(int64(len(chunk)))
+		if !strings.HasSuffix(chunk, "\n") {
+			// Incomplete line, wait for the rest of it to be written
+			partial += chunk
+			if next := lr.followRotation(file); next != nil {
+				if next != file {
+					if reopened != nil {
+						reopened.Close()
+					}
+					reopened, file = next, next
+				}
+				decoded, _ = decodeInput(file, lr.encodings[0])
+				reader.Reset(decoded)
+				partial = ""
+				lineNumber = 0
+				continue
+			}
+			if lr.audit != nil {
+				lr.audit.flush()
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+			continue
+		}
+		raw := strings.TrimRight(partial+chunk, "\r\n")
+		partial = ""
+		lineNumber++
+		lr.counters.position.Add(1)
+		lr.counters.linesRead.Add(1)
+		if frx := lr.streams[0].frx; !lr.filter().MatchString(raw) || (frx != nil && !frx.MatchString(raw)) {
+			lr.skip(AuditFilterRegex, lr.inputFiles[0], lineNumber, raw, nil)
+			continue
+		}
+
+		now := time.Now()
+		e := LogEvent{
+			OriginalTime: now,
+			Time: now,
+			RawLine: raw,
+			Line: raw,
+			Source: lr.inputFiles[0],
+			LineNumber: lineNumber,
+		}
+		ts, err := lr.extractTimestamp(raw)
+		if err == nil {
+			e.OriginalTime = ts.time
+			if lr.sampler != nil {
+				sampledOut = !lr.sampler.keep(raw)
+			}
+		} else {
+			if err != errNoTimestamp {
+				lr.counters.parseFailures.Add(1)
+			}
+			ts.start = -1
+		}
+		if sampledOut {
+			lr.skip(AuditSampled, e.Source, lineNumber, raw, nil)
+			continue
+		}
+		e.Line = lr.rewriteLine(raw, ts, e.OriginalTime, now)
+		e, ok := lr.applyStages(e)
+		if !ok {
+			lr.skip(AuditFiltered, e.Source, e.LineNumber, e.RawLine, nil)
+			continue
+		}
+		if !lr.waitForBandwidth(ctx, e) {
+			return nil
+		}
+		if sink.Write(ctx, e) != nil || sink.Flush() != nil {
+			return nil
+		}
+		count++
+		lr.counters.linesEmitted.Add(1)
+		lr.counters.logTime.Store(e.OriginalTime.UnixNano())
+		lr.setPosition(e.Source, lineNumber)
+	}
+}
+
+// followRotation checks whether the followed input file was rotated or
+// truncated once its end was reached. If the path of the input refers to
+// another file now, e.g. because logrotate renamed the file and the application
+// created a new one, the new file is opened and returned. If the file is
+// shorter than the offset read so far, e.g. because it was truncated in place
+// by copytruncate, it is rewound and returned. Otherwise, followRotation
+// returns nil.
+func (lr *LogReplayer) followRotation(file io.Reader) io.ReadSeekCloser {
+	if rotated(lr.sources[0], file) {
+		next, err := lr.sources[0].Open()
+		if err != nil {
+			log.Printf("Failed to reopen rotated input file %s: %v", lr.inputFiles[0], err)
+			return nil
+		}
+		log.Printf("Input file %s was rotated, following the new file", lr.inputFiles[0])
+		lr.annotatef(AnnotationRotate, map[string]any{"reason": "rotated"},
+			"input file %s was rotated, following the new file", lr.inputFiles[0])
+		return next
+	}
+	if f, ok := file.(io.ReadSeekCloser); ok && truncated(f) {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			log.Printf("Failed to rewind truncated input file %s: %v", lr.inputFiles[0], err)
+			return nil
+		}
+		log.Printf("Input file %s was truncated, following it from the start", lr.inputFiles[0])
+		lr.annotatef(AnnotationRotate, map[string]any{"reason": "truncated"},
+			"input file %s was truncated, following it from the start", lr.inputFiles[0])
+		return f
+	}
+	return nil
+}
+
+// statFile is implemented by files that can report their size and identity,
+// e.g. *os.File.
+type statFile interface {
+	Stat() (fs.FileInfo, error)
+}
+
+// rotated returns true if the path of a file source refers to another file
+// than the opened file. If the path does not exist, e.g. because the new file
+// has not been created yet, the opened file is still read.
+func rotated(src Source, file io.Reader) bool {
+	path, ok := src.(FileSource)
+	sf, isFile := file.(statFile)
+	if !ok || !isFile {
+		return false
+	}
+	opened, err := sf.Stat()
+	if err != nil {
+		return false
+	}
+	current, err := os.Stat(string(path))
+	if err != nil {
+		return false
+	}
+	return !os.SameFile(opened, current)
+}
+
+// truncated returns true if the file is shorter than its read offset.
+func truncated(file io.ReadSeeker) bool {
+	sf, ok := file.(statFile)
+	if !ok {
+		return false
+	}
+	info, err := sf.Stat()
+	if err != nil {
+		return false
+	}
+	offset, err := file.Seek(0, io.SeekCurrent)
+	return err == nil && info.Size() < offset
+}
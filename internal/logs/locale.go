@@ -0,0 +1,192 @@
+package logs
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// timeLocale holds the month and day names of a language, in the order of
+// time.Month and time.Weekday.
+type timeLocale struct {
+	months, shortMonths [12]string
+	days, shortDays [7]string
+	// names maps the lower-case localized names to their index, see lookup
+	names map[string]localeName
+}
+
+// localeName is a localized month or day name.
+type localeName struct {
+	month bool
+	short bool
+	index int
+}
+
+// timeLocales are the supported locales by their ISO 639-1 code.
+var timeLocales = map[string]*timeLocale{
+	"de": newTimeLocale(
+		[12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		[12]string{"Jan", "Feb", "Mär", "Apr", "Mai", "Jun", "Jul", "Aug", "Sep", "Okt", "Nov", "Dez"},
+		[7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		[7]string{"So", "Mo", "Di", "Mi", "Do", "Fr", "Sa"}),
+	"fr": newTimeLocale(
+		[12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		[12]string{"janv", "févr", "mars", "avr", "mai", "juin", "juil", "août", "sept", "oct", "nov", "déc"},
+		[7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		[7]string{"dim", "lun", "mar", "mer", "jeu", "ven", "sam"}),
+	"es": newTimeLocale(
+		[12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		[12]string{"ene", "feb", "mar", "abr", "may", "jun", "jul", "ago", "sep", "oct", "nov", "dic"},
+		[7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		[7]string{"dom", "lun", "mar", "mié", "jue", "vie", "sáb"}),
+	"it": newTimeLocale(
+		[12]string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		[12]string{"gen", "feb", "mar", "apr", "mag", "giu", "lug", "ago", "set", "ott", "nov", "dic"},
+		[7]string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"},
+		[7]string{"dom", "lun", "mar", "mer", "gio", "ven", "sab"}),
+	"nl": newTimeLocale(
+		[12]string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+		[12]string{"jan", "feb", "mrt", "apr", "mei", "jun", "jul", "aug", "sep", "okt", "nov", "dec"},
+		[7]string{"zondag", "maandag", "dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag"},
+		[7]string{"zo", "ma", "di", "wo", "do", "vr", "za"}),
+	"pt": newTimeLocale(
+		[12]string{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+		[12]string{"jan", "fev", "mar", "abr", "mai", "jun", "jul", "ago", "set", "out", "nov", "dez"},
+		[7]string{"domingo", "segunda-feira", "terça-feira", "quarta-feira", "quinta-feira", "sexta-feira", "sábado"},
+		[7]string{"dom", "seg", "ter", "qua", "qui", "sex", "sáb"}),
+}
+
+// newTimeLocale creates a locale with the given names.
+func newTimeLocale(months, shortMonths [12]string, days, shortDays [7]string) *timeLocale {
+	l := &timeLocale{months: months, shortMonths: shortMonths, days: days, shortDays: shortDays,
+		names: map[string]localeName{}}
+	// Days first, so month names take precedence if a name is both
+	for i := range days {
+		l.names[strings.ToLower(shortDays[i])] = localeName{short: true, index: i}
+		l.names[strings.ToLower(days[i])] = localeName{index: i}
+	}
+	for i := range months {
+		l.names[strings.ToLower(shortMonths[i])] = localeName{month: true, short: true, index: i}
+		l.names[strings.ToLower(months[i])] = localeName{month: true, index: i}
+	}
+	return l
+}
+
+// TimeLocales returns the codes of the supported locales.
+func TimeLocales() []string {
+	return slices.Sorted(maps.Keys(timeLocales))
+}
+
+// lookupTimeLocale returns the locale with the given code. An empty code or
+// "en" returns nil, i.e. English names.
+func lookupTimeLocale(code string) (*timeLocale, error) {
+	if len(code) == 0 || code == "en" {
+		return nil, nil
+	}
+	l, ok := timeLocales[code]
+	if !ok {
+		return nil, fmt.Errorf("unsupported time locale: %s, must be one of en, %s", code,
+			strings.Join(TimeLocales(), ", "))
+	}
+	return l, nil
+}
+
+// CheckTimeLocale returns an error if the locale with the given code is not
+// supported.
+func CheckTimeLocale(code string) error {
+	_, err := lookupTimeLocale(code)
+	return err
+}
+
+// ParseTimeIn is like ParseTime, but parses month and day names in the given
+// locale.
+func ParseTimeIn(format, locale, value string) (time.Time, error) {
+	l, err := lookupTimeLocale(locale)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if l != nil && lookupTimeParser(format) == nil {
+		value = l.toEnglish(value, format)
+	}
+	return ParseTime(format, value)
+}
+
+// replaceNames replaces the words in s, i.e. runs of letters and hyphens,
+// using replace.
+func replaceNames(s string, replace func(word string) string) string {
+	var b strings.Builder
+	start := -1
+	for i, r := range s {
+		if unicode.IsLetter(r) || (r == '-' && start >= 0) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			b.WriteString(replace(s[start:i]))
+			start = -1
+		}
+		b.WriteRune(r)
+	}
+	if start >= 0 {
+		b.WriteString(replace(s[start:]))
+	}
+	return b.String()
+}
+
+// toEnglish replaces the localized month and day names in a timestamp with
+// the English names expected by the layout.
+func (l *timeLocale) toEnglish(value, layout string) string {
+	months := strings.Contains(layout, "Jan")
+	days := strings.Contains(layout, "Mon")
+	return replaceNames(value, func(word string) string {
+		n, ok := l.names[strings.ToLower(word)]
+		if !ok || (n.month && !months) || (!n.month && !days) {
+			return word
+		}
+		if n.month {
+			m := time.Month(n.index + 1)
+			if n.short {
+				return m.String()[:3]
+			}
+			return m.String()
+		}
+		d := time.Weekday(n.index)
+		if n.short {
+			return d.String()[:3]
+		}
+		return d.String()
+	})
+}
+
+// fromEnglish replaces the English month and day names in a timestamp
+// formatted with layout by the localized names.
+func (l *timeLocale) fromEnglish(value, layout string) string {
+	fullMonths := strings.Contains(layout, "January")
+	fullDays := strings.Contains(layout, "Monday")
+	return replaceNames(value, func(word string) string {
+		for i := range l.months {
+			name := time.Month(i + 1).String()
+			if word == name || word == name[:3] {
+				if fullMonths {
+					return l.months[i]
+				}
+				return l.shortMonths[i]
+			}
+		}
+		for i := range l.days {
+			name := time.Weekday(i).String()
+			if word == name || word == name[:3] {
+				if fullDays {
+					return l.days[i]
+				}
+				return l.shortDays[i]
+			}
+		}
+		return word
+	})
+}
@@ -0,0 +1,60 @@
+package logs
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTimeIn(t *testing.T) {
+	for _, test := range []struct {
+		locale, format, value string
+		expected              time.Time
+	}{
+		{"de", "02. January 2006 15:04:05", "05. März 2024 13:04:05", time.Date(2024, 3, 5, 13, 4, 5, 0, time.UTC)},
+		{"de", "Mon, 02 Jan 2006", "di, 05 mär 2024", time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"fr", "Monday 2 January 2006", "mardi 5 mars 2024", time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+		{"pt", "Monday, 2 January 2006", "segunda-feira, 4 março 2024", time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},
+		// "mar" is Tuesday and March in Spanish, the month wins
+		{"es", "2 Jan 2006", "5 mar 2024", time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC)},
+	} {
+		parsed, err := ParseTimeIn(test.format, test.locale, test.value)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %s", test.value, err)
+			continue
+		}
+		if !parsed.Equal(test.expected) {
+			t.Errorf("Expected %q to be parsed as %s, got %s", test.value, test.expected, parsed)
+		}
+	}
+	if _, err := ParseTimeIn(time.RFC3339, "xx", "2024-03-05T13:04:05Z"); err == nil {
+		t.Errorf("Expected an error for an unsupported locale")
+	}
+}
+
+func TestLogReplayer_TimeLocale(t *testing.T) {
+	source := stringSource{name: "de", content: "05. März 2024 13:04:05 a\n05. März 2024 13:04:06 b\n"}
+	replayer, err := NewPipelineReplayer([]Source{source}, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `^(\d{2}\. \S+ \d{4} \d{2}:\d{2}:\d{2})`,
+		TimeFormat:  "02. January 2006 15:04:05",
+		TimeLocale:  "de",
+		Speed:       100,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	var lines []string
+	start := time.Date(2025, 10, 1, 8, 0, 0, 0, time.UTC)
+	err = replayer.StartEvents(context.Background(), start, func(_ context.Context, e LogEvent) {
+		lines = append(lines, e.Line)
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "01. Oktober 2025 08:00:00") {
+		t.Errorf("Expected the timestamps to be rewritten with German month names, got %q", lines)
+	}
+}
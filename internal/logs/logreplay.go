@@ -1,221 +1,1099 @@
 package logs
 
 import (
-	"bufio"
+	"bananabacon/internal/clock"
+	"bananabacon/internal/debug"
+	"bananabacon/internal/ratelimit"
+	"bananabacon/internal/samples"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"math/rand/v2"
 	"os"
 	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
+const (
+	// InputRetryInitialBackoff is the initial delay between attempts to open
+	// a missing input file.
+	InputRetryInitialBackoff = 250 * time.Millisecond
+	// InputRetryMaxBackoff is the maximum delay between attempts to open a
+	// missing input file.
+	InputRetryMaxBackoff = 5 * time.Second
+	// DefaultBatchWindow is the batching window used by the command, which
+	// trades sub-second timing fidelity for fewer wake-ups.
+	DefaultBatchWindow = 500 * time.Millisecond
+	// DefaultMaxBatchLines is the static cap on the number of lines per batch
+	// used by the command.
+	DefaultMaxBatchLines = 100
+	// DefaultMaxTimingError is the maximum timing error of a line the
+	// batching window of the command adapts to.
+	DefaultMaxTimingError = 50 * time.Millisecond
+)
+
+// TimestampFormat is a regex to find a timestamp in a log line together with
+// the format to parse it.
+type TimestampFormat struct {
+	// Regex must have a subgroup for the timestamp, or named groups for its
+	// parts, e.g. `(?P<date>\S+) \w+ (?P<time>\S+)`.
+	Regex string
+	// Format is the Go time format of the timestamp, or the name of a parser
+	// registered with RegisterTimeParser.
+	Format string
+	// Template concatenates the named groups of Regex to the timestamp that is
+	// parsed with Format, e.g. "{date}T{time}". The groups must be separated
+	// by text that does not occur in their values. Empty joins the named
+	// groups with spaces in the order of the regex.
+	Template string
+}
+
 type ReplayerOptions struct {
 	FilterRegex string
 	TimeRegex string
 	TimeFormat string
+	// TimeTemplate concatenates the named groups of TimeRegex, see
+	// TimestampFormat.Template.
+	TimeTemplate string
+	// FallbackTimeFormats are tried in order for lines that TimeRegex does not
+	// match or whose timestamp cannot be parsed with TimeFormat. Rewritten
+	// timestamps keep the format they were parsed with.
+	FallbackTimeFormats []TimestampFormat
+	// ExtraTimeFormats find secondary timestamps in a line, e.g. the start
+	// time of a request. Every occurrence is shifted by the same delta as the
+	// primary timestamp of the line, keeping the line internally consistent.
+	ExtraTimeFormats []TimestampFormat
+	// TimeLocale is the language of month and day names in timestamps, e.g.
+	// "de" for German, see TimeLocales. Empty means English.
+	TimeLocale string
+	// TimeParseCheck is what happens if the timestamps of more than
+	// MaxTimeParseFailures of the lines matching a time regex cannot be
+	// parsed: TimeParseStop returns a TimeFormatError, TimeParseWarn logs it.
+	// Empty disables the check.
+	TimeParseCheck string
+	// MaxTimeParseFailures is the share of timestamps, between 0 and 1, that
+	// may fail to parse before TimeParseCheck applies.
+	MaxTimeParseFailures float64
 	Loop bool
+	// MaxLines stops a replay run after the given number of lines has been
+	// emitted. Zero means no limit.
+	MaxLines int
+	// MaxDuration stops a replay run after the given wall-clock duration.
+	// Zero means no limit.
+	MaxDuration time.Duration
+	// InputWaitTimeout is how long to wait for a missing input file to appear
+	// before giving up. Zero means the input file must exist on start.
+	InputWaitTimeout time.Duration
+	// InputEncoding is the encoding of the inputs, one of the Encoding
+	// constants. The lines are decoded to UTF-8 before they are replayed.
+	InputEncoding string
+	// ReadRotated reads each input file of NewLogReplayer and
+	// NewMultiLogReplayer together with its rotated parts, e.g. app.log.1.gz,
+	// as one stream, see RotatedSource.
+	ReadRotated bool
+	// CheckpointFile is the file the replay position is persisted to. If the
+	// file exists on start, the replay resumes from the stored position.
+	// Empty means no checkpoints are written.
+	CheckpointFile string
+	// CheckpointInterval is the interval in which checkpoints are written.
+	CheckpointInterval time.Duration
+	// MaxBytesPerSecond caps the number of bytes emitted per second across
+	// all lines. Zero means no limit.
+	MaxBytesPerSecond int
+	// Jitter is the maximum random deviation applied to the rewritten
+	// timestamps of lines and to the time batches are emitted at, in both
+	// directions. Zero means no jitter.
+	Jitter time.Duration
+	// Scheduler is the strategy used to wait for batches, either
+	// TimerScheduler or TickerScheduler. Empty means TimerScheduler.
+	Scheduler string
+	// SchedulerGranularity is the resolution of the scheduler. Wait times of
+	// the timer scheduler are rounded up to multiples of it, the ticker
+	// scheduler ticks at this interval.
+	SchedulerGranularity time.Duration
+	// Follow keeps watching the input file after its end was reached and
+	// emits appended lines immediately. Loop has no effect if Follow is set.
+	Follow bool
+	// BatchWindow is the maximum span of log time whose lines are emitted
+	// together in one batch. Zero schedules every line individually, only
+	// lines with identical timestamps share a batch.
+	BatchWindow time.Duration
+	// MaxTimingError adapts the batching window to keep the timing error of
+	// the emitted lines, i.e. how early or late they are written compared to
+	// their timestamps, within the given wall-clock duration. The window
+	// shrinks with the replay speed and the measured lateness of the lines,
+	// so dense logs are emitted in small batches, and grows up to BatchWindow
+	// again for sparse logs. Zero means batches always span BatchWindow.
+	MaxTimingError time.Duration
+	// MaxBatchLines is a static cap on the number of lines of a batch, which
+	// is emitted early once it holds that many lines. Zero means no cap.
+	MaxBatchLines int
+	// DryRun emits every batch as soon as it is read instead of waiting until
+	// it is due, with the times the lines would be emitted at, to inspect the
+	// schedule of a replay. The sink is flushed after every batch. Loop,
+	// Follow, CheckpointFile and MaxBytesPerSecond have no effect in a dry run.
+	DryRun bool
+	// AlignWeeks shifts the timestamps of the log by whole weeks instead of
+	// mapping the first line to the start time, so lines keep their time of
+	// day and day of week and weekly seasonality lines up with the calendar.
+	// A run starts at the line logged at the same time of week as its start
+	// time, earlier lines are skipped.
+	AlignWeeks bool
+	// Speed is the factor by which the replay is faster than the original log.
+	// Zero means the original speed.
+	Speed float64
+	// Streams overrides the speed, jitter and filter of individual sources,
+	// keyed by their name.
+	Streams map[string]StreamOptions
+	// SampleRate is the share of lines, between 0 and 1, that are replayed,
+	// the others are dropped at random. The remaining lines keep their
+	// timing. Lines without a timestamp of their own, e.g. stack traces,
+	// share the fate of the line they continue. Zero means all lines.
+	SampleRate float64
+	// SampleRules override SampleRate for the lines matching their regex, the
+	// first matching rule applies.
+	SampleRules []SampleRule
+	// Filters select the events that are emitted, in addition to FilterRegex.
+	Filters []Filter
+	// Transformers modify the events before they are emitted, in order.
+	Transformers []Transformer
+	// Annotations receives an Annotation as JSON line whenever the replay
+	// performs a notable action, e.g. starts over, skips ahead or changes its
+	// speed, so test harnesses can correlate their observations with the
+	// replay. The sink is not closed when the replay ends.
+	Annotations Sink
+	// AuditFile is a file every dropped line is recorded in as JSON, together
+	// with the reason it was dropped. Empty means no audit file is written.
+	AuditFile string
 }
 
 type LogReplayer struct {
 	options ReplayerOptions
-	inputFile string
+	sources []Source
+	inputFiles []string // names of the sources
+	streams []stream // options of the sources, by index
+	frx *regexp.Regexp
+	stagesMu sync.RWMutex // guards frx and options.Transformers, see Reconfigure
+	timeFormats []timeFormat
+	extraTimeFormats []timeFormat
+	done chan struct{}
+	doneOnce sync.Once
+	ready chan struct{}
+	counters replayerCounters
+	clock *replayClock
+	bandwidth *ratelimit.TokenBucket
+	scheduler scheduler
+	batching *batchWindow
+	positions map[string]int64 // last emitted line per input
+	positionsMu sync.Mutex
+	timeCheck timeCheck
+	sampler *sampler // nil if all lines are replayed
+	audit *auditLog // nil if no audit file is written
+	arena *stringArena // holds the lines read and rewritten while processing the inputs
+	encodings []string // encodings of the inputs, detected in the first run
+	annotationsMu sync.Mutex // serializes writing annotations
 }
 
 // NewLogReplayer creates a new LogReplayer object with the given input file and
-// options. The options struct can be initialized with the following default
-// values:
+// options. The input file can also reference one of the bundled sample logs,
+// e.g. "builtin:webserver". The options struct can be initialized with the
+// following default values:
 //
 // - FilterRegex: ".*" (match all lines)
 // - TimeRegex: "(\\d{4}-\\d{2}-\\d{2} \\d{2}:\\d{2}:\\d{2}\\.\\d{3}).*" (match lines with
 //   timestamps in the format 2006-01-02 15:04:05.000)
 // - TimeFormat: "2006-01-02 15:04:05.000" (the format of the timestamps extracted
 //   by TimeRegex)
+// - TimeTemplate: "" (named groups of TimeRegex are joined with spaces)
+// - FallbackTimeFormats: nil (lines without a timestamp matching TimeRegex
+//   use the timestamp of the previous line)
+// - ExtraTimeFormats: nil (only the primary timestamp is rewritten)
+// - TimeLocale: "" (English month and day names)
+// - TimeParseCheck: "" (timestamps that cannot be parsed are not reported)
+// - MaxTimeParseFailures: 0 (any failed timestamp is reported if TimeParseCheck is set)
+// - MaxLines: 0 (no limit on the number of lines emitted per run)
+// - MaxDuration: 0 (no limit on the duration of a run)
+// - InputWaitTimeout: 0 (fail immediately if the input file does not exist)
+// - Speed: 0 (replay at the original speed)
+// - AlignWeeks: false (map the first line to the start time)
+// - Streams: nil (all sources use the same speed, jitter and filter)
+// - SampleRate: 0 (replay all lines)
+// - SampleRules: nil (SampleRate applies to all lines)
+// - BatchWindow: 0 (schedule every line individually)
+// - MaxTimingError: 0 (batches always span BatchWindow)
+// - MaxBatchLines: 0 (no limit on the number of lines per batch)
+// - Follow: false (stop or loop at the end of the input file)
+// - DryRun: false (wait until the lines are due)
+// - InputEncoding: "" (detect the encoding of each input, see EncodingAuto)
+// - ReadRotated: false (rotated parts of the input files are not read)
+// - CheckpointFile: "" (do not persist the replay position)
+// - MaxBytesPerSecond: 0 (no bandwidth limit)
+// - Jitter: 0 (no random deviation from the original timing)
+// - Scheduler: "" (use a timer per batch)
+// - SchedulerGranularity: 0 (no coalescing of timers, 10ms for the ticker)
+// - AuditFile: "" (dropped lines are only counted)
+// - Annotations: nil (actions of the replay are not recorded)
 //
 // The returned LogReplayer object can be used to replay the log lines in the
-// input file using the Start method.
-func NewLogReplayer(inputFile string, options ReplayerOptions) *LogReplayer {
+// input file using the Start method. An error is returned if the options are
+// invalid, e.g. if one of the regular expressions does not compile.
+func NewLogReplayer(inputFile string, options ReplayerOptions) (*LogReplayer, error) {
+	return NewMultiLogReplayer([]string{inputFile}, options)
+}
+
+// NewMultiLogReplayer creates a new LogReplayer that replays multiple input
+// files on a single timeline. The lines of all files are merged by their
+// timestamps, so lines of different files logged at the same time are also
+// emitted at the same time. The options are the same as for NewLogReplayer
+// and apply to all input files. Follow is only supported for a single input.
+func NewMultiLogReplayer(inputFiles []string, options ReplayerOptions) (*LogReplayer, error) {
+	sources := make([]Source, len(inputFiles))
+	for i, name := range inputFiles {
+		if options.ReadRotated {
+			sources[i] = RotatedSource(name)
+		} else {
+			sources[i] = FileSource(name)
+		}
+	}
+	return NewPipelineReplayer(sources, options)
+}
+
+// NewPipelineReplayer creates a new LogReplayer that replays the given
+// sources on a single timeline, like NewMultiLogReplayer does for files. Custom
+// filter and transformation stages can be added with the Filters and
+// Transformers options, a custom Sink can be passed to StartSink.
+func NewPipelineReplayer(sources []Source, options ReplayerOptions) (*LogReplayer, error) {
+	if len(sources) == 0 {
+		return nil, errors.New("no input file given")
+	}
+	if options.Follow && len(sources) > 1 {
+		return nil, errors.New("follow is only supported for a single input file")
+	}
+	if options.Follow && IsDockerInput(sources[0].Name()) {
+		return nil, errors.New("follow is not supported for the logs of containers, record them instead")
+	}
+	frx, err := regexp.Compile(options.FilterRegex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter regex: %s, err: %w", options.FilterRegex, err)
+	}
+	formats := append([]TimestampFormat{{Regex: options.TimeRegex, Format: options.TimeFormat,
+		Template: options.TimeTemplate}}, options.FallbackTimeFormats...)
+	locale, err := lookupTimeLocale(options.TimeLocale)
+	if err != nil {
+		return nil, err
+	}
+	timeFormats, err := compileTimeFormats(formats, locale)
+	if err != nil {
+		return nil, err
+	}
+	extraTimeFormats, err := compileTimeFormats(options.ExtraTimeFormats, locale)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEncoding(options.InputEncoding); err != nil {
+		return nil, err
+	}
+	if len(options.CheckpointFile) > 0 && options.CheckpointInterval <= 0 {
+		return nil, errors.New("checkpoint interval must be positive")
+	}
+	if options.MaxLines < 0 || options.MaxDuration < 0 || options.InputWaitTimeout < 0 || options.Jitter < 0 ||
+		options.BatchWindow < 0 || options.MaxTimingError < 0 || options.MaxBatchLines < 0 {
+		return nil, errors.New("limits and timeouts must not be negative")
+	}
+	if options.MaxBytesPerSecond < 0 {
+		return nil, fmt.Errorf("invalid bandwidth limit: %d, must not be negative", options.MaxBytesPerSecond)
+	}
+	switch options.TimeParseCheck {
+	case "", TimeParseStop, TimeParseWarn:
+	default:
+		return nil, fmt.Errorf("invalid time parse check: %s, must be %s or %s", options.TimeParseCheck,
+			TimeParseStop, TimeParseWarn)
+	}
+	if options.MaxTimeParseFailures < 0 || options.MaxTimeParseFailures > 1 {
+		return nil, fmt.Errorf("invalid share of time parse failures: %v, must be between 0 and 1",
+			options.MaxTimeParseFailures)
+	}
+	if options.Speed < 0 {
+		return nil, fmt.Errorf("invalid speed: %v, must be positive", options.Speed)
+	}
+	speed := options.Speed
+	if speed == 0 {
+		speed = 1
+	}
+	sched, err := newScheduler(options.Scheduler, options.SchedulerGranularity)
+	if err != nil {
+		return nil, err
+	}
+	if options.DryRun {
+		// A dry run ends with the input and leaves no traces
+		sched = dryRunScheduler{}
+		options.Loop, options.Follow = false, false
+		options.CheckpointFile, options.MaxBytesPerSecond = "", 0
+	}
+	var bandwidth *ratelimit.TokenBucket
+	if options.MaxBytesPerSecond > 0 {
+		// Allow bursts of one second worth of bytes
+		bandwidth = ratelimit.NewTokenBucket(options.MaxBytesPerSecond, options.MaxBytesPerSecond)
+	}
+	inputFiles := make([]string, len(sources))
+	for i, src := range sources {
+		inputFiles[i] = src.Name()
+	}
+	streams, err := compileStreams(inputFiles, options)
+	if err != nil {
+		return nil, err
+	}
+	sampler, err := newSampler(options.SampleRate, options.SampleRules)
+	if err != nil {
+		return nil, err
+	}
 	return &LogReplayer{
-		inputFile: inputFile,
+		sources: sources,
+		inputFiles: inputFiles,
+		streams: streams,
+		sampler: sampler,
+		bandwidth: bandwidth,
+		scheduler: sched,
+		batching: newBatchWindow(options.BatchWindow, options.MaxTimingError),
+		arena: &stringArena{},
 		options: options,
+		frx: frx,
+		timeFormats: timeFormats,
+		extraTimeFormats: extraTimeFormats,
+		done: make(chan struct{}),
+		ready: make(chan struct{}),
+		clock: newReplayClock(speed),
+		positions: map[string]int64{},
+	}, nil
+}
+
+// Ready returns a channel that is closed once the input file has been opened
+// and the replay has started.
+func (lr *LogReplayer) Ready() <-chan struct{} {
+	return lr.ready
+}
+
+// Stats returns statistics about the replay. It is safe to call Stats while
+// the replay is running.
+func (lr *LogReplayer) Stats() ReplayerStats {
+	return lr.counters.snapshot()
+}
+
+// Pause pauses the replay until Resume is called.
+func (lr *LogReplayer) Pause() {
+	lr.clock.setPaused(true)
+	lr.annotatef(AnnotationPause, nil, "paused the replay")
+}
+
+// Resume continues a paused replay.
+func (lr *LogReplayer) Resume() {
+	lr.clock.setPaused(false)
+	lr.annotatef(AnnotationResume, nil, "resumed the replay")
+}
+
+// SetSpeed changes the factor by which the replay is faster than the original
+// log, e.g. 2 replays the log at double speed.
+func (lr *LogReplayer) SetSpeed(speed float64) error {
+	if speed <= 0 {
+		return fmt.Errorf("invalid speed: %v, must be positive", speed)
+	}
+	lr.clock.setSpeed(speed)
+	lr.annotatef(AnnotationSpeed, map[string]any{"speed": speed}, "changed the speed to %v", speed)
+	return nil
+}
+
+// Throttle slows the replay down by the given factor between 0 and 1 on top
+// of its speed, e.g. to relieve memory pressure, and 1 removes the throttle.
+// Unlike SetSpeed, the throttle is kept when the speed changes and Speed
+// reports the speed without it.
+func (lr *LogReplayer) Throttle(factor float64) error {
+	if factor <= 0 || factor > 1 {
+		return fmt.Errorf("invalid throttle: %v, must be between 0 and 1", factor)
 	}
+	lr.clock.setThrottle(factor)
+	lr.annotatef(AnnotationThrottle, map[string]any{"factor": factor}, "throttled the replay to %v of its speed", factor)
+	return nil
+}
+
+// Reconfigure replaces the filter regex and the transformers while the
+// replay runs, e.g. after the configuration was reloaded. Lines already
+// scheduled are transformed with the new transformers.
+func (lr *LogReplayer) Reconfigure(filterRegex string, transformers []Transformer) error {
+	frx, err := regexp.Compile(filterRegex)
+	if err != nil {
+		return fmt.Errorf("invalid filter regex: %w", err)
+	}
+	lr.stagesMu.Lock()
+	lr.frx, lr.options.Transformers = frx, transformers
+	lr.stagesMu.Unlock()
+	lr.annotatef(AnnotationReconfigure, map[string]any{"filter": filterRegex}, "replaced the filter and transformers")
+	return nil
+}
+
+// filter returns the filter regex, which Reconfigure may replace.
+func (lr *LogReplayer) filter() *regexp.Regexp {
+	lr.stagesMu.RLock()
+	defer lr.stagesMu.RUnlock()
+	return lr.frx
+}
+
+// Skip jumps ahead in the current replay run by the given duration of log
+// time. Lines within the skipped window are not emitted.
+func (lr *LogReplayer) Skip(d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("invalid skip duration: %s, must not be negative", d)
+	}
+	lr.clock.skip(d)
+	lr.annotatef(AnnotationSkip, map[string]any{"duration": d.String()}, "skipped %s of log time", d)
+	return nil
+}
+
+// Speed returns the current replay speed and whether the replay is paused.
+func (lr *LogReplayer) Speed() (float64, bool) {
+	return lr.clock.state()
+}
+
+// Clock returns the virtual clock of the replay, e.g. for the metrics engine.
+// Its elapsed time advances at the replay speed over all runs, stops while the
+// replay is paused and jumps ahead when the replay skips ahead. Its current
+// time starts at the time the LogReplayer was created.
+func (lr *LogReplayer) Clock() clock.Clock {
+	return lr.clock.virtual
+}
+
+// Done returns a channel that is closed when the replay has completed, i.e.
+// when Start returns because the end of the input was reached without looping
+// or because the context was cancelled.
+func (lr *LogReplayer) Done() <-chan struct{} {
+	return lr.done
 }
 
 // Start replays the log lines in the input file according to the options given
+// to NewLogReplayer. It is a shorthand for StartEvents with a callback that
+// only receives the rewritten line.
+func (lr *LogReplayer) Start(ctx context.Context, mst time.Time, callback func(string)) error {
+	return lr.StartEvents(ctx, mst, func(_ context.Context, e LogEvent) {
+		callback(e.Line)
+	})
+}
+
+// StartEvents replays the log lines in the input file according to the options given
 // to NewLogReplayer. It will stop when the context is cancelled or when the
-// end of the file is reached. The callback function is called on each log line
-// that matches the filter regex and has a valid timestamp.
+// end of the file is reached. If MaxLines or MaxDuration are set, a run also
+// ends when one of the limits is hit; with Loop enabled the replay then starts
+// over. The callback function is called with a LogEvent for each log line that
+// matches the filter regex and has a valid timestamp. It also receives the
+// context of the replay, so it can abort long running work on shutdown; once
+// the context is cancelled, no further lines are passed to it. When StartEvents returns,
+// the channel returned by Done is closed.
 // mts defines the time the first log line is mapped to.
 // This is usually time.Now, but can be different for testing.
-func (lr *LogReplayer) Start(ctx context.Context, mst time.Time, callback func(string)) {
-	frx, err := regexp.Compile(lr.options.FilterRegex)
-	if err != nil {
-		log.Fatalf("Invalid filter regex: %s, err: %s", lr.options.FilterRegex, err)
+// StartEvents returns an error if the input file cannot be opened or read. It
+// returns nil when the replay completed or the context was cancelled.
+// If a CheckpointFile is configured, the replay resumes from the checkpoint
+// and persists its position regularly. The checkpoint is removed once the
+// replay completed.
+func (lr *LogReplayer) StartEvents(ctx context.Context, mst time.Time, callback func(context.Context, LogEvent)) error {
+	return lr.StartSink(ctx, mst, SinkFunc(func(ctx context.Context, e LogEvent) error {
+		callback(ctx, e)
+		return nil
+	}))
+}
+
+// StartSink replays the log lines like StartEvents and writes the events to the
+// given sink. The sink is flushed after each batch and when the replay ends,
+// but not closed. If the sink returns an error, the replay is stopped and the
+// error is returned.
+func (lr *LogReplayer) StartSink(ctx context.Context, mst time.Time, sink Sink) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s := &stoppingSink{sink: sink, cancel: cancel}
+	if err := lr.start(ctx, mst, s); err != nil {
+		return err
 	}
-	trx, err := regexp.Compile(lr.options.TimeRegex)
-	if err != nil {
-		log.Fatalf("Invalid time regex: %s, err: %s", lr.options.TimeRegex, err)
+	if s.err != nil {
+		return fmt.Errorf("sink failed: %w", s.err)
 	}
+	return nil
+}
 
-	file, err := os.Open(lr.inputFile)
+// start implements StartSink.
+func (lr *LogReplayer) start(ctx context.Context, mst time.Time, sink Sink) error {
+	defer lr.doneOnce.Do(func() { close(lr.done) })
+	// Flush before Done is closed, so no lines are lost if the process exits
+	defer sink.Flush()
+
+	waitStart := time.Now()
+	files := make([]io.ReadSeekCloser, 0, len(lr.sources))
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, src := range lr.sources {
+		file, err := lr.waitForInput(ctx, src)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		files = append(files, file)
+	}
+	defer lr.scheduler.stop()
+	if len(lr.options.AuditFile) > 0 {
+		audit, err := openAuditLog(lr.options.AuditFile)
+		if err != nil {
+			return err
+		}
+		lr.audit = audit
+		defer audit.close()
+	}
+	close(lr.ready)
+	// Shift the mapped start time by the time spent waiting for the input
+	mst = mst.Add(time.Since(waitStart))
+
+	resume, err := lr.loadCheckpoint()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	if resume != nil {
+		log.Printf("Resuming replay of %s at %s", strings.Join(lr.inputFiles, ", "), resume.Offset)
+		lr.counters.run.Store(resume.Run - 1)
+	}
+	if len(lr.options.CheckpointFile) > 0 {
+		cpCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go lr.writeCheckpoints(cpCtx)
 	}
-	defer file.Close()
 
-	start := time.Now()
+	// The runs are scheduled on a single timeline starting now, which maps to
+	// mst, see replayClock.next
+	rst := time.Now()
+	lr.clock.reset(rst)
+	lr.encodings = make([]string, len(files))
+	for i := range lr.encodings {
+		lr.encodings[i] = lr.options.InputEncoding
+	}
 	again := true
 	for again {
-		file.Seek(0, 0)
-		lr.processFile(ctx, file, mst, frx, trx, callback)
-		again = lr.options.Loop && ctx.Err() == nil
-		mst = mst.Add(time.Since(start))
+		readers := make([]io.Reader, len(files))
+		for i, f := range files {
+			// Read rotated inputs from the new file in the next run
+			if rotated(lr.sources[i], f) {
+				if next, err := lr.sources[i].Open(); err == nil {
+					log.Printf("Input file %s was rotated, replaying the new file", lr.inputFiles[i])
+					lr.annotatef(AnnotationRotate, map[string]any{"reason": "rotated"},
+						"input file %s was rotated, replaying the new file", lr.inputFiles[i])
+					f.Close()
+					f, files[i] = next, next
+				} else {
+					log.Printf("Failed to reopen rotated input file %s: %v", lr.inputFiles[i], err)
+				}
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			readers[i] = f
+		}
+		run := lr.counters.run.Add(1)
+		debug.Printf("Starting replay run %d of %s", run, strings.Join(lr.inputFiles, ", "))
+		switch {
+		case resume != nil:
+			lr.annotatef(AnnotationStart, map[string]any{"offset": resume.Offset.String()},
+				"resumed replay run %d at %s", run, resume.Offset)
+		case run > 1:
+			lr.annotatef(AnnotationLoop, nil, "started replay run %d", run)
+		default:
+			lr.annotatef(AnnotationStart, nil, "started replay run %d", run)
+		}
+		length, err := lr.processInputs(ctx, readers, mst, rst, resume, sink)
+		if err != nil {
+			return err
+		}
+		lr.clock.next(length)
+		lr.annotatef(AnnotationEnd, map[string]any{"lines_emitted": lr.counters.linesEmitted.Load()},
+			"ended replay run %d", run)
+		resume = nil
+		again = lr.options.Loop && !lr.options.Follow && ctx.Err() == nil
 	}
+	return lr.finishCheckpoints(ctx)
 }
 
-// processFile reads a file line by line, applies a filter regex to each line and
-// extracts a timestamp from each line that matches the filter regex. It then
-// schedules a timer that will emit the lines at a time that ensures that the
-// overall rate of the log replay is consistent with the timestamps in the
-// log. This means that if the log has a gap of 10 seconds between two log
-// lines, the timer will wait 10 seconds before emitting the second line.
-// mts defines the time the first log line is mapped to.
-// This is usually time.Now, but can be different for testing.
+// finishCheckpoints writes a final checkpoint if the replay was cancelled, or
+// removes the checkpoint file if the replay completed.
+func (lr *LogReplayer) finishCheckpoints(ctx context.Context) error {
+	if len(lr.options.CheckpointFile) == 0 {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return lr.writeCheckpoint()
+	}
+	if err := os.Remove(lr.options.CheckpointFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// waitForInput opens the given source. If the source does not exist, it
+// retries with exponential backoff until the source appears, InputWaitTimeout has
+// passed or the context is cancelled.
+func (lr *LogReplayer) waitForInput(ctx context.Context, src Source) (io.ReadSeekCloser, error) {
+	deadline := time.Now().Add(lr.options.InputWaitTimeout)
+	backoff := InputRetryInitialBackoff
+	for {
+		file, err := src.Open()
+		if err == nil || !errors.Is(err, fs.ErrNotExist) || time.Now().After(deadline) {
+			return file, err
+		}
+		log.Printf("Waiting for input file %s to appear, retrying in %s", src.Name(), backoff)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, InputRetryMaxBackoff)
+	}
+}
+
+// openInput opens the given input file. Input files starting with
+// samples.Prefix are read from the bundled sample logs, the logs of Docker
+// containers up to now, see IsDockerInput, files ending in ".gz" are
+// decompressed.
+func openInput(name string) (io.ReadSeekCloser, error) {
+	if samples.IsBuiltin(name) {
+		return samples.Open(name)
+	}
+	if IsDockerInput(name) {
+		return openDockerInput(name)
+	}
+	if strings.HasSuffix(name, ".gz") {
+		return openParts([]string{name})
+	}
+	return os.Open(name)
+}
+
+// pendingLine is a log line that has been read and is waiting to be emitted.
+type pendingLine struct {
+	event LogEvent
+	at time.Time // time of the line on the replay timeline, see stream.scale
+	offset time.Duration // virtual time of the line, relative to the first line
+	ts timestamp // timestamp in the line, start is -1 if it has none
+	stream *stream // options of the source of the line
+}
+
+// timeFormat is a compiled TimestampFormat.
+type timeFormat struct {
+	matcher *TimestampMatcher
+	layout string
+	parser *TimeParser // registered parser named layout, nil for time layouts
+	locale *timeLocale // locale of month and day names, nil for English
+	layouts map[int]string // layouts matching the timestamps, by their length, see layoutFor
+}
+
+// parse parses a timestamp matched by the regex of the format.
+func (f *timeFormat) parse(value string) (time.Time, error) {
+	if f.parser != nil {
+		return f.parser.Parse(value)
+	}
+	if f.locale != nil {
+		value = f.locale.toEnglish(value, f.layout)
+	}
+	return time.Parse(f.layout, value)
+}
+
+// appendFormat appends t formatted in the format to b, using the given layout
+// of the format, see layoutFor.
+func (f *timeFormat) appendFormat(b []byte, t time.Time, layout string) []byte {
+	if f.parser != nil {
+		return append(b, f.parser.Format(t)...)
+	}
+	if f.locale != nil {
+		return append(b, f.locale.fromEnglish(t.Format(layout), layout)...)
+	}
+	return t.AppendFormat(b, layout)
+}
+
+// timestamp is a timestamp found in a log line.
+type timestamp struct {
+	time time.Time
+	start, end int // position of the timestamp in the line
+	spans []int // positions of the named groups making up the timestamp, nil for a single group
+	format *timeFormat // format the timestamp was parsed with
+	layout string // layout matching the precision and zone style of the timestamp
+	loc *time.Location // zone of the timestamp, nil if its layout has none
+}
+
+// moveTo returns the timestamp with its time replaced by t, in the zone of the
+// original timestamp.
+func (ts timestamp) moveTo(t time.Time) timestamp {
+	if ts.loc != nil {
+		t = t.In(ts.loc)
+	}
+	ts.time = t
+	return ts
+}
+
+// processInputs reads the inputs line by line, applies a filter regex to each line
+// and extracts a timestamp from each line that matches the filter regex. The
+// lines of all inputs are merged into a single timeline ordered by their
+// timestamps. It then waits until the lines are due and emits them at a time
+// that ensures that the overall rate of the log replay is consistent with the
+// timestamps in the log. This means that if the log has a gap of 10 seconds
+// between two log lines, it will wait 10 seconds before emitting the second line.
+// The lines are due at absolute deadlines on the timeline of the replay
+// clock, where the run starts at the end of the previous run, so delays do not
+// accumulate over the runs of a long replay. mst defines the time the start of
+// the timeline at the real time rst is mapped to. This is usually time.Now, but
+// can be different for testing.
+// If resume is not nil, the lines up to the checkpoint are dropped and the
+// replay continues at the time of the checkpoint.
 // The method returns when the context is cancelled or when the end of the
-// file is reached.
-func (lr *LogReplayer) processFile(ctx context.Context, file *os.File, mst time.Time, frx, trx *regexp.Regexp,
-	callback func(string)) {
-	scanner := bufio.NewScanner(file)
-	rst := time.Now() // Real start time, i.e. when we started processing the file
+// inputs is reached. It returns the virtual length of the run, i.e. the offset
+// of its last line, and an error if an input could not be read.
+func (lr *LogReplayer) processInputs(ctx context.Context, files []io.Reader, mst, rst time.Time,
+	resume *Checkpoint, sink Sink) (time.Duration, error) {
+	if lr.options.MaxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, lr.options.MaxDuration)
+		defer cancel()
+	}
+
+	lr.resetPositions(resume)
+	if resume != nil {
+		lr.clock.advance(resume.Offset)
+	}
+	lr.counters.position.Store(0)
+
+	readers := make([]*lineReader, len(files))
+	for i, f := range files {
+		readers[i] = newLineReader(lr, lr.inputFiles[i], i, f)
+	}
+	lines, err := newReaderHeap(readers)
+	if err != nil {
+		return 0, err
+	}
+
 	var lst time.Time // log start time (when the first line was logged)
 	var ctime time.Time // time of the first line of the current batch
+	var window time.Duration // span of log time of the current batch
+	var end time.Duration // offset of the last line, i.e. the length of the run
 
-	// Channel for synchronization, used to wait for the timer to fire
-	notify := make(chan struct{})
-	defer close(notify)
+	buffer := []pendingLine{}
+	count := 0 // number of lines buffered or emitted in this run
 
-	buffer := []string{}
-
-	// TODO: optionally, resize scanner's capacity for lines over 64K
-	for scanner.Scan() {
-		line := scanner.Text()
-		
-		// Check if the line matches the filter regex
-		if !frx.MatchString(line) {
-			continue
+	for {
+		if ctx.Err() != nil {
+			return end, nil
+		}
+		// Stop reading once the line limit is reached
+		if lr.options.MaxLines > 0 && count >= lr.options.MaxLines {
+			break
+		}
+		l, ok, err := lines.pop()
+		if err != nil {
+			return end, err
 		}
-
-		// Find the timestamp
-		t, line, ok := lr.extractAndReplaceTimestamp(line, mst, lst, trx)
 		if !ok {
-			// If timestamp could not be extracted, use first time of current batch.
-			// If current batch is empty, ignore.
-			if ctime.IsZero() {
-				continue
-			}
-			t = ctime
+			break
 		}
+		t := l.at
 
 		// Check we have a logging start time and if yes, if this is before it
 		if !lst.IsZero() && t.Before(lst) {
+			lr.skip(AuditBeforeStart, l.event.Source, l.event.LineNumber, l.event.RawLine, nil)
 			continue
 		}
 
 		// If we have no ctime, we have an empty buffer
 		if ctime.IsZero() {
 			ctime = t
+			window = lr.batchWindow()
 		}
 		if lst.IsZero() {
 			lst = ctime
+			// Start at the line logged at the time of week of the run
+			if lr.options.AlignWeeks && resume == nil {
+				lr.clock.skipTo(weekOffset(lst, mst.Add(lr.clock.wallTime(0).Sub(rst))))
+			}
 		}
+		end = t.Sub(lst)
 
-		// If the difference between first line in buffer and new line is 
-		if (t.Sub(ctime) > time.Duration(500) * time.Millisecond) {
-			timer, _ := lr.handleBufferedLines(buffer, notify, ctime, lst, rst, callback)
-			lr.wait(ctx, notify, timer)
-			// Reset buffer and current time
-			buffer = []string{}
-			ctime = time.Time{}
+		// If the difference between first line in buffer and new line is
+		// larger than the batching window or the batch is full, emit the
+		// buffered lines first
+		if t.Sub(ctime) > window || (lr.options.MaxBatchLines > 0 && len(buffer) >= lr.options.MaxBatchLines) {
+			if !lr.emitWhenDue(ctx, buffer, ctime.Sub(lst), mst, rst, sink) {
+				return end, nil
+			}
+			// Reset buffer and start a new batch with the current line. The
+			// emitted lines have been copied to the sink, so the backing
+			// array can be reused.
+			buffer = buffer[:0]
+			ctime = t
+			window = lr.batchWindow()
+		}
+		// Drop lines that were emitted before the checkpoint
+		if resume != nil && int64(l.event.LineNumber) <= resume.Lines[l.event.Source] {
+			lr.skip(AuditCheckpoint, l.event.Source, l.event.LineNumber, l.event.RawLine, nil)
+			continue
 		}
-		buffer = append(buffer, line)
+		l.offset = end
+		buffer = append(buffer, l)
+		count++
 	}
 	// Last lines, flush buffer
-	if len(buffer) > 0 {
-		timer, _ := lr.handleBufferedLines(buffer, notify, ctime, lst, rst, callback)
-		lr.wait(ctx, notify, timer)
+	if len(buffer) > 0 && ctx.Err() == nil {
+		lr.emitWhenDue(ctx, buffer, ctime.Sub(lst), mst, rst, sink)
+	}
+	// Check the timestamps of inputs too short for the check while reading
+	if err := lr.checkTimestamps(true); err != nil {
+		return end, err
 	}
 
-	// Handle errors during scanning of file
-	if err := scanner.Err(); err != nil {
-		log.Fatal(err)
+	// Wait for new lines if the end of the file was reached
+	if lr.options.Follow && ctx.Err() == nil {
+		return end, lr.follow(ctx, files[0], count, readers[0].lineNumber, sink)
 	}
+	return end, nil
+}
+
+// batchWindow returns the span of log time of the next batch, see
+// ReplayerOptions.MaxTimingError.
+func (lr *LogReplayer) batchWindow() time.Duration {
+	speed, _ := lr.clock.virtual.State()
+	return lr.batching.window(speed)
 }
 
-// wait pauses the execution until either the context is done or a notification
-// is received on the provided channel. It is used to synchronize the log replay
-// with the timing of the log entries, allowing for graceful cancellation using
-// the context. When the context is cancelled, the passed timer is stopped.
-func (lr *LogReplayer) wait(ctx context.Context, notify chan struct{}, timer *time.Timer) {
-	select {
-	case <-ctx.Done():
-		timer.Stop()
-		return
-	case <- notify:
+// weekOffset returns the offset from the log start time lst to the first time
+// at the same time of week as the mapped start time mst. The wall-clock time of
+// lst is interpreted in the location of mst, which the rewritten timestamps are
+// formatted in.
+func weekOffset(lst, mst time.Time) time.Duration {
+	const week = 7 * 24 * time.Hour
+	wall := time.Date(lst.Year(), lst.Month(), lst.Day(), lst.Hour(), lst.Minute(), lst.Second(), lst.Nanosecond(),
+		mst.Location())
+	// Truncate to seconds, so the time spent opening the inputs does not skip
+	// a line logged exactly at the time of week
+	offset := mst.Sub(wall).Truncate(time.Second) % week
+	if offset < 0 {
+		offset += week
 	}
+	return offset
 }
 
-// emitLines iterates over a slice of log lines and invokes the provided callback
-// function on each line. It is used to output or process each log line individually
-// after it has been buffered and is ready to be emitted.
-func (lr *LogReplayer) emitLines(lines []string, callback func(string)) {
-	for _, l := range lines {
-		callback(l)
+// emitWhenDue waits until the replay clock reaches the virtual time offset of
+// a batch and then emits its lines. While waiting, it reacts to changes of the
+// clock, e.g. pausing or a change of the replay speed. It returns false if the
+// context was cancelled before the lines were emitted.
+func (lr *LogReplayer) emitWhenDue(ctx context.Context, lines []pendingLine, offset time.Duration,
+	mst, rst time.Time, sink Sink) bool {
+	due := offset + jitter(lr.options.Jitter)
+	if !lr.scheduler.wait(ctx, lr.clock, due) {
+		return false
+	}
+	// Batches skipped over were due in the past, they do not drift
+	if !lr.options.DryRun && !lr.clock.skipped(offset) {
+		lr.counters.drift.Store(int64(time.Since(lr.clock.wallTime(due))))
 	}
+	return lr.emitLines(ctx, lines, mst, rst, sink)
 }
 
-// extractAndReplaceTimestamp extracts a timestamp from a log line using a given regular expression.
-// It then replaces the extracted timestamp with a new, current, timestamp that ensures that the
-// overall rate of the log replay is consistent with the timestamps in the log.
-// It returns the extracted timestamp as a time.Time object, the modified log line,
-// and a boolean indicating whether the extraction was successful.
-// If the timestamp cannot be extracted or parsed, it returns a zero time, empty string, and false.
-func (lr *LogReplayer) extractAndReplaceTimestamp(l string, mst, lst time.Time, trx *regexp.Regexp) (time.Time, string, bool) {
-	matches := trx.FindStringSubmatchIndex(l)
-	if matches == nil || len(matches) < 4 {
-		return time.Time{}, "",false
+// emitLines iterates over a slice of buffered log lines, rewrites their
+// timestamps and writes each line to the sink, which is flushed at the end of
+// the batch. Lines that were skipped over using Skip are dropped. The context
+// is checked between lines, so a cancelled context stops the emission mid-batch.
+// It returns false if the context was cancelled or the sink failed.
+func (lr *LogReplayer) emitLines(ctx context.Context, lines []pendingLine, mst, rst time.Time,
+	sink Sink) bool {
+	// The lateness of the lines adapts the batching window, a dry run is not
+	// timed
+	measure := lr.batching.adaptive() && !lr.options.DryRun
+	var late time.Duration
+	for _, l := range lines {
+		if ctx.Err() != nil {
+			return false
+		}
+		if lr.clock.skipped(l.offset) {
+			lr.skip(AuditSkipped, l.event.Source, l.event.LineNumber, l.event.RawLine, nil)
+			continue
+		}
+		e := l.event
+		// Map the line to the wall-clock time it is due at
+		e.Time = mst.Add(lr.clock.wallTime(l.offset).Sub(rst)).Add(jitter(l.stream.jitter))
+		e.Line = lr.rewriteLine(e.RawLine, l.ts, e.OriginalTime, e.Time)
+		e, ok := lr.applyStages(e)
+		if !ok {
+			lr.skip(AuditFiltered, e.Source, e.LineNumber, e.RawLine, nil)
+			lr.setPosition(e.Source, e.LineNumber)
+			continue
+		}
+		if !lr.waitForBandwidth(ctx, e) {
+			return false
+		}
+		if err := sink.Write(ctx, e); err != nil {
+			return false
+		}
+		if measure {
+			late = max(late, time.Since(lr.clock.wallTime(l.offset)))
+		}
+		lr.counters.linesEmitted.Add(1)
+		lr.counters.logTime.Store(e.OriginalTime.UnixNano())
+		lr.counters.lastOffset.Store(int64(l.offset))
+		lr.setPosition(e.Source, e.LineNumber)
 	}
-	tstr := l[matches[2]:matches[3]]
+	if lr.audit != nil {
+		lr.audit.flush()
+	}
+	if err := sink.Flush(); err != nil {
+		return false
+	}
+	if measure {
+		lr.batching.observe(late)
+	}
+	return true
+}
 
-	ts, err := time.Parse(lr.options.TimeFormat, tstr)
-	if err != nil {
-		return time.Time{}, "", false
+// jitter returns a random duration within ±d, or 0 if d is not positive.
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
 	}
+	return time.Duration(rand.Int64N(2*int64(d)+1)) - d
+}
 
-	var nts time.Time
-	if lst.IsZero() {
-		nts = mst
-	} else {
-		// Replace timestamp with current timestamp
-		nts = mst.Add(ts.Sub(lst))
+// waitForBandwidth blocks until the event may be emitted without exceeding
+// MaxBytesPerSecond. It returns false if the context was cancelled while waiting.
+func (lr *LogReplayer) waitForBandwidth(ctx context.Context, e LogEvent) bool {
+	if lr.bandwidth == nil {
+		return true
 	}
+	// Account for the line break written after each line
+	return lr.bandwidth.Wait(ctx, len(e.Line)+1) == nil
+}
 
-	return ts, (l[:matches[2]] + nts.Format(lr.options.TimeFormat) + l[matches[3]:]), true
+// lineBuffers pools the buffers used to rewrite timestamps, so rewriting a
+// line only copies the result into the arena.
+var lineBuffers = sync.Pool{
+	New: func() any {
+		b := make([]byte, 0, 1024)
+		return &b
+	},
 }
 
-// handleBufferedLines schedules a timer that will emit the given lines
-// at a time that ensures that the overall rate of the log replay is
-// consistent with the timestamps in the log. It returns a channel that
-// will send a single value when the timer fires. The channel is closed afterwards.
-func (lr *LogReplayer) handleBufferedLines(lines []string, notify chan struct{}, t, lst, rst time.Time,
-	callback func(string)) (*time.Timer, error) {
-	diff := t.Sub(lst)
-	ndiff := time.Since(rst)
-	dur := diff - ndiff
-	if dur < 0 {
-		dur = time.Duration(0)
+// rewriteLine maps the timestamps in the line from original to t. The primary
+// timestamp ts is replaced with t unless its start is -1, the timestamps found
+// by the extra time formats are shifted by the same delta. The timestamps keep
+// their precision and zone, see layoutFor.
+func (lr *LogReplayer) rewriteLine(line string, ts timestamp, original, t time.Time) string {
+	if len(lr.extraTimeFormats) == 0 {
+		if ts.start < 0 {
+			return line
+		}
+		return rewriteTimestamps(lr.arena, line, []timestamp{ts.moveTo(t)})
+	}
+	var stamps []timestamp
+	if ts.start >= 0 {
+		stamps = append(stamps, ts.moveTo(t))
+	}
+	delta := t.Sub(original)
+	for i := range lr.extraTimeFormats {
+		f := &lr.extraTimeFormats[i]
+		values, spans := f.matcher.FindAll(line)
+		for j, value := range values {
+			et, err := f.parse(value)
+			if err != nil {
+				continue
+			}
+			stamps = append(stamps, f.timestamp(value, spans[j], et).moveTo(et.Add(delta)))
+		}
+	}
+	if len(stamps) == 0 {
+		return line
 	}
-	timer := time.AfterFunc(dur, func() {
-		lr.emitLines(lines, callback)
-		notify <- struct{}{}
+	slices.SortFunc(stamps, func(a, b timestamp) int {
+		return a.start - b.start
 	})
-	return timer, nil
-}
\ No newline at end of file
+	return rewriteTimestamps(lr.arena, line, stamps)
+}
+
+// rewriteTimestamps replaces the given timestamps in the line, which must be
+// ordered by their position, with their time formatted in their format.
+// Timestamps overlapping a previous one are ignored. The rewritten line is
+// copied into the arena.
+func rewriteTimestamps(a *stringArena, line string, stamps []timestamp) string {
+	bp := lineBuffers.Get().(*[]byte)
+	b := (*bp)[:0]
+	pos := 0
+	for _, ts := range stamps {
+		if ts.start < pos {
+			continue
+		}
+		b = append(b, line[pos:ts.start]...)
+		if ts.spans == nil {
+			b = ts.format.appendFormat(b, ts.time, ts.layout)
+		} else {
+			b = ts.appendGroups(b, line)
+		}
+		pos = ts.end
+	}
+	b = append(b, line[pos:]...)
+	rewritten := a.string(b)
+	*bp = b
+	lineBuffers.Put(bp)
+	return rewritten
+}
+
+// compileTimeFormats compiles the regexes of the given formats. Month and day
+// names are parsed and formatted in the given locale.
+func compileTimeFormats(formats []TimestampFormat, locale *timeLocale) ([]timeFormat, error) {
+	timeFormats := make([]timeFormat, len(formats))
+	for i, f := range formats {
+		matcher, err := NewTimestampMatcher(f)
+		if err != nil {
+			return nil, err
+		}
+		timeFormats[i] = timeFormat{matcher: matcher, layout: f.Format, parser: lookupTimeParser(f.Format), locale: locale}
+	}
+	return timeFormats, nil
+}
+
+// errNoTimestamp is returned by extractTimestamp if no time regex matches.
+var errNoTimestamp = errors.New("no timestamp found")
+
+// extractTimestamp extracts a timestamp from a log line using the time regex of
+// the LogReplayer. If it does not match or the timestamp cannot be parsed, the
+// fallback formats are tried in order. If no format matches, it returns
+// errNoTimestamp, or the error of the first timestamp that could not be parsed.
+func (lr *LogReplayer) extractTimestamp(l string) (timestamp, error) {
+	failed := -1 // format of the first timestamp that could not be parsed
+	var value string
+	var parseErr error
+	for i := range lr.timeFormats {
+		f := &lr.timeFormats[i]
+		v, spans, ok := f.matcher.Find(l)
+		if !ok {
+			continue
+		}
+		t, err := f.parse(v)
+		if err != nil {
+			if failed < 0 {
+				failed, value, parseErr = i, v, err
+			}
+			continue
+		}
+		lr.timeCheck.record(true, "", "")
+		return f.timestamp(v, spans, t), nil
+	}
+	if failed >= 0 {
+		lr.timeCheck.record(false, lr.timeFormats[failed].layout, value)
+		return timestamp{}, parseErr
+	}
+	return timestamp{}, errNoTimestamp
+}
@@ -2,11 +2,19 @@ package logs
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 func TestLogReplayer_Start(t *testing.T) {
@@ -34,7 +42,10 @@ func TestLogReplayer_Start(t *testing.T) {
 	}
 
 	// Create a LogReplayer instance
-	replayer := NewLogReplayer(tempFile.Name(), options)
+	replayer, err := NewLogReplayer(tempFile.Name(), options)
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
 
 	// Define the context and the callback function
 	ctx, cancel := context.WithCancel(context.Background())
@@ -47,7 +58,9 @@ func TestLogReplayer_Start(t *testing.T) {
 
 	// Start the LogReplayer
 	startTime := time.Now().In(time.UTC)
-	replayer.Start(ctx, startTime, callback)
+	if err := replayer.Start(ctx, startTime, callback); err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
 
 	// Validate the results
 	expectedLines := []string{
@@ -76,4 +89,1065 @@ func TestLogReplayer_Start(t *testing.T) {
 			t.Errorf("Expected line %d to contain 'Log line %d', got %q", i+1, i+1, line)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestLogReplayer_MaxLines(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "test-log-*.log")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	logLines := `2023-01-01 00:00:01.000 Log line 1
+2023-01-01 00:00:01.100 Log line 2
+2023-01-01 00:00:01.200 Log line 3`
+	if _, err := tempFile.WriteString(logLines); err != nil {
+		t.Fatalf("Failed to write to temporary file: %s", err)
+	}
+	tempFile.Close()
+
+	replayer, err := NewLogReplayer(tempFile.Name(), ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}).*`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		MaxLines:    2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	var processedLines []string
+	err = replayer.Start(context.Background(), time.Now(), func(line string) {
+		processedLines = append(processedLines, line)
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+
+	if len(processedLines) != 2 {
+		t.Fatalf("Expected 2 processed lines, got %d", len(processedLines))
+	}
+}
+
+func TestLogReplayer_Errors(t *testing.T) {
+	if _, err := NewLogReplayer("test.log", ReplayerOptions{FilterRegex: "(", TimeRegex: "(.*)"}); err == nil {
+		t.Error("Expected error for invalid filter regex")
+	}
+	if _, err := NewLogReplayer("test.log", ReplayerOptions{FilterRegex: ".*", TimeRegex: ".*"}); err == nil {
+		t.Error("Expected error for time regex without subgroup")
+	}
+
+	replayer, err := NewLogReplayer("/does/not/exist.log", ReplayerOptions{FilterRegex: ".*", TimeRegex: "(.*)"})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+	if err := replayer.Start(context.Background(), time.Now(), func(string) {}); err == nil {
+		t.Error("Expected error for missing input file")
+	}
+}
+
+func TestLogReplayer_StartEvents(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "test-log-*.log")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	logLines := `2023-01-01 00:00:01.000 Log line 1
+	continuation of line 1
+2023-01-01 00:00:01.100 Log line 2`
+	if _, err := tempFile.WriteString(logLines); err != nil {
+		t.Fatalf("Failed to write to temporary file: %s", err)
+	}
+	tempFile.Close()
+
+	replayer, err := NewLogReplayer(tempFile.Name(), ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}).*`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	var events []LogEvent
+	startTime := time.Now().In(time.UTC)
+	err = replayer.StartEvents(context.Background(), startTime, func(_ context.Context, e LogEvent) {
+		events = append(events, e)
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	if events[1].Line != "\tcontinuation of line 1" || events[1].RawLine != events[1].Line {
+		t.Errorf("Expected continuation line to be emitted unchanged, got %q", events[1].Line)
+	}
+	if events[2].LineNumber != 3 || events[2].Source != tempFile.Name() {
+		t.Errorf("Expected line 3 of %s, got line %d of %s", tempFile.Name(), events[2].LineNumber, events[2].Source)
+	}
+	if events[2].RawLine != "2023-01-01 00:00:01.100 Log line 2" {
+		t.Errorf("Expected raw line to be unchanged, got %q", events[2].RawLine)
+	}
+	if d := events[2].Time.Sub(events[0].Time); d != 100*time.Millisecond {
+		t.Errorf("Expected rewritten timestamps 100ms apart, got %s", d)
+	}
+}
+
+func TestLogReplayer_Speed(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "test-log-*.log")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	logLines := `2023-01-01 00:00:01.000 Log line 1
+2023-01-01 00:00:03.000 Log line 2
+2023-01-01 00:00:05.000 Log line 3`
+	if _, err := tempFile.WriteString(logLines); err != nil {
+		t.Fatalf("Failed to write to temporary file: %s", err)
+	}
+	tempFile.Close()
+
+	replayer, err := NewLogReplayer(tempFile.Name(), ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}).*`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		Speed:       4,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	var events []LogEvent
+	start := time.Now()
+	err = replayer.StartEvents(context.Background(), start, func(_ context.Context, e LogEvent) {
+		events = append(events, e)
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+
+	// 4 seconds of log time at 4x speed take one second
+	if d := time.Since(start); d < 900*time.Millisecond || d > 1500*time.Millisecond {
+		t.Errorf("Expected replay to take about 1s, took %s", d)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	if d := events[2].Time.Sub(events[0].Time); d != time.Second {
+		t.Errorf("Expected rewritten timestamps 1s apart, got %s", d)
+	}
+}
+
+func TestLogReplayer_ResumeFromCheckpoint(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "test-log-*.log")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	logLines := `2023-01-01 00:00:01.000 Log line 1
+2023-01-01 00:00:01.100 Log line 2
+2023-01-01 00:00:01.200 Log line 3`
+	if _, err := tempFile.WriteString(logLines); err != nil {
+		t.Fatalf("Failed to write to temporary file: %s", err)
+	}
+	tempFile.Close()
+
+	checkpointFile := tempFile.Name() + ".checkpoint"
+	defer os.Remove(checkpointFile)
+	checkpoint := `{"run": 1, "lines": {"` + tempFile.Name() + `": 2}, "offset": 100000000}`
+	if err := os.WriteFile(checkpointFile, []byte(checkpoint), 0o644); err != nil {
+		t.Fatalf("Failed to write checkpoint: %s", err)
+	}
+
+	replayer, err := NewLogReplayer(tempFile.Name(), ReplayerOptions{
+		FilterRegex:        ".*",
+		TimeRegex:          `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}).*`,
+		TimeFormat:         "2006-01-02 15:04:05.000",
+		CheckpointFile:     checkpointFile,
+		CheckpointInterval: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	var events []LogEvent
+	err = replayer.StartEvents(context.Background(), time.Now(), func(_ context.Context, e LogEvent) {
+		events = append(events, e)
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+
+	if len(events) != 1 || events[0].LineNumber != 3 {
+		t.Fatalf("Expected replay to resume at line 3, got %v", events)
+	}
+	if _, err := os.Stat(checkpointFile); !os.IsNotExist(err) {
+		t.Errorf("Expected checkpoint to be removed after completion")
+	}
+}
+
+func TestLogReplayer_CancelMidBatch(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "test-log-*.log")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	// All lines share a timestamp, so they are emitted as a single batch
+	var sb strings.Builder
+	for i := 0; i < 100; i++ {
+		sb.WriteString("2023-01-01 00:00:01.000 Log line " + strconv.Itoa(i+1) + "\n")
+	}
+	if _, err := tempFile.WriteString(sb.String()); err != nil {
+		t.Fatalf("Failed to write to temporary file: %s", err)
+	}
+	tempFile.Close()
+
+	replayer, err := NewLogReplayer(tempFile.Name(), ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}).*`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	count := 0
+	err = replayer.StartEvents(ctx, time.Now(), func(_ context.Context, e LogEvent) {
+		count++
+		if count == 10 {
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+	if count != 10 {
+		t.Errorf("Expected emission to stop after 10 lines, got %d", count)
+	}
+}
+
+func TestLogReplayer_TickerScheduler(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "test-log-*.log")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %s", err)
+	}
+	defer os.Remove(tempFile.Name())
+
+	logLines := `2023-01-01 00:00:01.000 Log line 1
+2023-01-01 00:00:01.600 Log line 2
+2023-01-01 00:00:02.200 Log line 3`
+	if _, err := tempFile.WriteString(logLines); err != nil {
+		t.Fatalf("Failed to write to temporary file: %s", err)
+	}
+	tempFile.Close()
+
+	replayer, err := NewLogReplayer(tempFile.Name(), ReplayerOptions{
+		FilterRegex:          ".*",
+		TimeRegex:            `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}).*`,
+		TimeFormat:           "2006-01-02 15:04:05.000",
+		Scheduler:            TickerScheduler,
+		SchedulerGranularity: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	var emitted []time.Duration
+	start := time.Now()
+	err = replayer.StartEvents(context.Background(), start, func(_ context.Context, e LogEvent) {
+		emitted = append(emitted, time.Since(start))
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+
+	if len(emitted) != 3 {
+		t.Fatalf("Expected 3 lines, got %d", len(emitted))
+	}
+	for i, d := range emitted {
+		expected := time.Duration(i) * 600 * time.Millisecond
+		if (d - expected).Abs() > 100*time.Millisecond {
+			t.Errorf("Expected line %d after %s, got %s", i+1, expected, d)
+		}
+	}
+}
+
+func TestTickerScheduler_Interleaved(t *testing.T) {
+	// Waits for the batches of two inputs, registered in an order unrelated
+	// to their due times
+	offsets := []time.Duration{100, 20, 80, 40, 60, 120}
+	clock := newReplayClock(1)
+	clock.reset(time.Now())
+	sched := &tickerScheduler{granularity: 5 * time.Millisecond}
+	defer sched.stop()
+
+	var mu sync.Mutex
+	var released []time.Duration
+	var wg sync.WaitGroup
+	for _, offset := range offsets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if sched.wait(context.Background(), clock, offset*time.Millisecond) {
+				mu.Lock()
+				released = append(released, offset)
+				mu.Unlock()
+			}
+		}()
+	}
+	// A cancelled wait is removed from the heap
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan bool)
+	go func() {
+		cancelled <- sched.wait(ctx, clock, 70*time.Millisecond)
+	}()
+	cancel()
+	if <-cancelled {
+		t.Error("Expected a cancelled wait to return false")
+	}
+	wg.Wait()
+
+	expected := slices.Sorted(slices.Values(offsets))
+	if !slices.Equal(released, expected) {
+		t.Errorf("Expected the waits to be released in the order %v, got %v", expected, released)
+	}
+	if sched.waits.Len() != 0 {
+		t.Errorf("Expected no pending waits, got %d", sched.waits.Len())
+	}
+
+	// Two interleaved inputs replayed with the ticker scheduler
+	replayer, err := NewPipelineReplayer([]Source{
+		stringSource{name: "a", content: "2023-01-01 00:00:00.000 a1\n2023-01-01 00:00:00.100 a2\n"},
+		stringSource{name: "b", content: "2023-01-01 00:00:00.050 b1\n2023-01-01 00:00:00.150 b2\n"},
+	}, ReplayerOptions{
+		FilterRegex:          ".*",
+		TimeRegex:            `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:           "2006-01-02 15:04:05.000",
+		Scheduler:            TickerScheduler,
+		SchedulerGranularity: 5 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+	var lines []string
+	err = replayer.StartEvents(context.Background(), time.Now(), func(_ context.Context, e LogEvent) {
+		lines = append(lines, e.Line[24:])
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+	if expected := []string{"a1", "b1", "a2", "b2"}; !slices.Equal(lines, expected) {
+		t.Errorf("Expected lines %v, got %v", expected, lines)
+	}
+}
+
+func TestLogReplayer_MultipleInputs(t *testing.T) {
+	dir := t.TempDir()
+	first := dir + "/first.log"
+	second := dir + "/second.log"
+	os.WriteFile(first, []byte("2023-01-01 00:00:01.000 first 1\n2023-01-01 00:00:01.200 first 2\n"), 0o644)
+	os.WriteFile(second, []byte("2023-01-01 00:00:01.100 second 1\n2023-01-01 00:00:01.300 second 2\n"), 0o644)
+
+	replayer, err := NewMultiLogReplayer([]string{first, second}, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}).*`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	var sources []string
+	err = replayer.StartEvents(context.Background(), time.Now(), func(_ context.Context, e LogEvent) {
+		sources = append(sources, e.Source)
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+
+	expected := []string{first, second, first, second}
+	if strings.Join(sources, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected lines from %v, got %v", expected, sources)
+	}
+}
+
+// stringSource is a Source that reads a string.
+type stringSource struct {
+	name string
+	content string
+}
+
+func (s stringSource) Name() string {
+	return s.name
+}
+
+func (s stringSource) Open() (io.ReadSeekCloser, error) {
+	return nopCloser{strings.NewReader(s.content)}, nil
+}
+
+type nopCloser struct {
+	io.ReadSeeker
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func TestLogReplayer_Pipeline(t *testing.T) {
+	source := stringSource{
+		name: "memory",
+		content: "2023-01-01 00:00:01.000 keep 1\n2023-01-01 00:00:01.010 drop\n2023-01-01 00:00:01.020 keep 2\n2023-01-01 00:00:01.030 keep 3\n",
+	}
+	replayer, err := NewPipelineReplayer([]Source{source}, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}).*`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		Filters: []Filter{FilterFunc(func(e LogEvent) bool {
+			return !strings.Contains(e.Line, "drop")
+		})},
+		Transformers: []Transformer{TransformerFunc(func(e LogEvent) (LogEvent, bool) {
+			e.Line += " host=test"
+			return e, true
+		})},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	var lines []string
+	sinkErr := errors.New("sink full")
+	err = replayer.StartSink(context.Background(), time.Now(), SinkFunc(func(_ context.Context, e LogEvent) error {
+		if e.Source != "memory" {
+			t.Errorf("Expected source memory, got %s", e.Source)
+		}
+		lines = append(lines, e.Line)
+		if len(lines) == 2 {
+			return sinkErr
+		}
+		return nil
+	}))
+	if !errors.Is(err, sinkErr) {
+		t.Fatalf("Expected sink error, got %v", err)
+	}
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "keep 1 host=test") || !strings.HasSuffix(lines[1], "keep 2 host=test") {
+		t.Errorf("Expected two transformed lines, got %q", lines)
+	}
+	if stats := replayer.Stats(); stats.LinesSkipped != 1 {
+		t.Errorf("Expected 1 skipped line, got %d", stats.LinesSkipped)
+	}
+}
+
+func TestLogReplayer_Reconfigure(t *testing.T) {
+	source := stringSource{
+		name:    "memory",
+		content: "2023-01-01 00:00:01.000 keep 1\n2023-01-01 00:00:01.010 drop\n2023-01-01 00:00:01.020 keep 2\n",
+	}
+	replayer, err := NewPipelineReplayer([]Source{source}, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}).*`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+	if err := replayer.Reconfigure("(", nil); err == nil {
+		t.Error("Expected an error for an invalid filter regex")
+	}
+	if err := replayer.Reconfigure("keep", nil); err != nil {
+		t.Fatalf("Failed to reconfigure replayer: %s", err)
+	}
+
+	var lines []string
+	err = replayer.StartSink(context.Background(), time.Now(), SinkFunc(func(_ context.Context, e LogEvent) error {
+		lines = append(lines, e.Line)
+		if len(lines) == 1 {
+			return replayer.Reconfigure("keep", []Transformer{TransformerFunc(func(e LogEvent) (LogEvent, bool) {
+				e.Line += " reloaded"
+				return e, true
+			})})
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], "keep 1") || !strings.HasSuffix(lines[1], "keep 2 reloaded") {
+		t.Errorf("Expected the new filter and transformers to apply, got %q", lines)
+	}
+}
+
+func TestLogReplayer_FallbackTimeFormats(t *testing.T) {
+	source := stringSource{
+		name: "mixed",
+		content: "2023-01-01 00:00:01.000 app line\n" +
+			`127.0.0.1 - - [01/Jan/2023:00:00:01 +0000] "GET / HTTP/1.1" 200` + "\n" +
+			"not a timestamp\n",
+	}
+	replayer, err := NewPipelineReplayer([]Source{source}, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}).*`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		FallbackTimeFormats: []TimestampFormat{
+			{Regex: `\[([^\]]+)\]`, Format: "02/Jan/2006:15:04:05 -0700"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	var events []LogEvent
+	startTime := time.Date(2024, 2, 3, 4, 5, 6, 0, time.UTC)
+	err = replayer.StartEvents(context.Background(), startTime, func(_ context.Context, e LogEvent) {
+		events = append(events, e)
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d", len(events))
+	}
+	if events[0].Line != "2024-02-03 04:05:06.000 app line" {
+		t.Errorf("Expected app line to be rewritten, got %q", events[0].Line)
+	}
+	if !strings.HasPrefix(events[1].Line, "127.0.0.1 - - [03/Feb/2024:04:05:06 +0000]") {
+		t.Errorf("Expected access log line to be rewritten in its own format, got %q", events[1].Line)
+	}
+	if events[2].Line != "not a timestamp" {
+		t.Errorf("Expected line without timestamp to be unchanged, got %q", events[2].Line)
+	}
+}
+
+// benchmarkSource returns a source with n lines that are logged 1ms apart.
+func benchmarkSource(n int) Source {
+	var b strings.Builder
+	t := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		b.WriteString(t.Add(time.Duration(i) * time.Millisecond).Format("2006-01-02 15:04:05.000"))
+		b.WriteString(" INFO request handled path=/api/items status=200\n")
+	}
+	return stringSource{name: "benchmark", content: b.String()}
+}
+
+func benchmarkReplay(b testing.TB, source Source) {
+	replayer, err := NewPipelineReplayer([]Source{source}, ReplayerOptions{
+		FilterRegex: "INFO",
+		TimeRegex:   `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		Speed:       1e9,
+	})
+	if err != nil {
+		b.Fatalf("Failed to create replayer: %s", err)
+	}
+	bytes := 0
+	err = replayer.StartEvents(context.Background(), time.Now(), func(_ context.Context, e LogEvent) {
+		bytes += len(e.Line)
+	})
+	if err != nil {
+		b.Fatalf("Replay failed: %s", err)
+	}
+}
+
+// raceEnabled is set if the tests run with the race detector, see race_test.go.
+var raceEnabled bool
+
+func TestLogReplayer_Allocs(t *testing.T) {
+	// The race detector instruments memory accesses and allocates for its own
+	// bookkeeping, so AllocsPerRun counts allocations the replay does not make
+	if raceEnabled {
+		t.Skip("Allocations are not representative with the race detector")
+	}
+	const lines = 20000
+	source := benchmarkSource(lines)
+	allocs := testing.AllocsPerRun(3, func() {
+		benchmarkReplay(t, source)
+	}) / lines
+
+	// Matching the time regex allocates the positions of its groups in the
+	// regexp package, which offers no way to reuse them. Everything else must
+	// not allocate per line: lines are copied into the chunks of the arena
+	// and events are passed by value.
+	matcher, err := NewTimestampMatcher(TimestampFormat{
+		Regex:  `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		Format: "2006-01-02 15:04:05.000",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create matcher: %s", err)
+	}
+	line := "2023-01-01 00:00:00.000 INFO request handled path=/api/items status=200"
+	matchAllocs := testing.AllocsPerRun(100, func() {
+		matcher.Find(line)
+	})
+	// Setting up the replay and the chunks of the arena are shared by all lines
+	if allocs-matchAllocs > 0.05 {
+		t.Errorf("Expected no allocations per line besides the %.0f of the time regex, got %.2f", matchAllocs,
+			allocs)
+	}
+}
+
+func TestLogEvent_Clone(t *testing.T) {
+	arena := &stringArena{}
+	raw := arena.string([]byte("2023-01-01 00:00:00.000 a"))
+	e := LogEvent{RawLine: raw, Line: arena.string([]byte("2024-01-01 00:00:00.000 a"))}
+	c := e.Clone()
+	if c != e {
+		t.Errorf("Expected the clone to equal the event, got %+v", c)
+	}
+	if unsafe.StringData(c.RawLine) == unsafe.StringData(e.RawLine) ||
+		unsafe.StringData(c.Line) == unsafe.StringData(e.Line) {
+		t.Error("Expected the lines of the clone to not share memory with the arena")
+	}
+}
+
+// BenchmarkLogReplayer_Lines replays b.N lines, so the reported allocations
+// are per line.
+func BenchmarkLogReplayer_Lines(b *testing.B) {
+	source := benchmarkSource(b.N)
+	b.ReportAllocs()
+	b.ResetTimer()
+	benchmarkReplay(b, source)
+}
+
+// BenchmarkLogReplayer_1M replays 1M lines per iteration.
+func BenchmarkLogReplayer_1M(b *testing.B) {
+	source := benchmarkSource(1_000_000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		benchmarkReplay(b, source)
+	}
+}
+
+func TestLogReplayer_ExtraTimeFormats(t *testing.T) {
+	source := stringSource{
+		name: "extra",
+		content: "2023-01-01 00:00:10.000 done started=00:00:05 queued=00:00:01\n\tcontinued started=00:00:07\n",
+	}
+	replayer, err := NewPipelineReplayer([]Source{source}, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}).*`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		ExtraTimeFormats: []TimestampFormat{
+			{Regex: `(?:started|queued)=(\d{2}:\d{2}:\d{2})`, Format: "15:04:05"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	var lines []string
+	startTime := time.Date(2024, 2, 3, 12, 0, 0, 0, time.UTC)
+	err = replayer.Start(context.Background(), startTime, func(l string) {
+		lines = append(lines, l)
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+
+	expected := []string{
+		"2024-02-03 12:00:00.000 done started=11:59:55 queued=11:59:51",
+		"\tcontinued started=11:59:57",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected lines %q, got %q", expected, lines)
+	}
+}
+
+func TestLogReplayer_AuditFile(t *testing.T) {
+	source := stringSource{
+		name: "app.log",
+		content: "preamble without timestamp\n" +
+			"2023-01-01 00:00:02.000 INFO started\n" +
+			"2023-01-01 00:00:01.000 INFO late\n" +
+			"2023-01-01 00:00:03.000 DEBUG noise\n",
+	}
+	auditFile := filepath.Join(t.TempDir(), "audit.jsonl")
+	replayer, err := NewPipelineReplayer([]Source{source}, ReplayerOptions{
+		FilterRegex: "INFO|preamble",
+		TimeRegex:   `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		AuditFile:   auditFile,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+	if err := replayer.StartEvents(context.Background(), time.Now(), func(context.Context, LogEvent) {}); err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+
+	content, err := os.ReadFile(auditFile)
+	if err != nil {
+		t.Fatalf("Failed to read audit file: %s", err)
+	}
+	var reasons []string
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var r auditRecord
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatalf("Failed to parse audit record %q: %s", line, err)
+		}
+		reasons = append(reasons, fmt.Sprintf("%d:%s", r.LineNumber, r.Reason))
+	}
+	expected := []string{"1:no_timestamp", "4:filter_regex", "3:before_start"}
+	if !slices.Equal(reasons, expected) {
+		t.Errorf("Expected audit records %v, got %v", expected, reasons)
+	}
+	if skipped := replayer.Stats().LinesSkipped; skipped != 3 {
+		t.Errorf("Expected 3 skipped lines, got %d", skipped)
+	}
+}
+
+func TestLogReplayer_Stats(t *testing.T) {
+	source := stringSource{
+		name: "app.log",
+		content: "2023-13-01 00:00:00.000 INFO invalid month\n" +
+			"2023-01-01 00:00:01.000 INFO started\n" +
+			"2023-01-01 00:00:02.000 DEBUG noise\n" +
+			"2023-01-01 00:00:03.000 INFO done\n",
+	}
+	replayer, err := NewPipelineReplayer([]Source{source}, ReplayerOptions{
+		FilterRegex: "INFO",
+		TimeRegex:   `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		Speed:       100,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+	if err := replayer.StartEvents(context.Background(), time.Now(), func(context.Context, LogEvent) {}); err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+
+	stats := replayer.Stats()
+	if stats.LinesRead != 4 || stats.LinesEmitted != 2 || stats.LinesFiltered != 1 || stats.ParseFailures != 1 {
+		t.Errorf("Expected 4 lines read, 2 emitted, 1 filtered and 1 parse failure, got %+v", stats)
+	}
+	if stats.BytesRead != int64(len(source.content)) {
+		t.Errorf("Expected %d bytes read, got %d", len(source.content), stats.BytesRead)
+	}
+	if stats.Drift < -time.Millisecond || stats.Drift > time.Second {
+		t.Errorf("Expected a small scheduling drift, got %s", stats.Drift)
+	}
+}
+
+// flushCountingSink counts the lines written and the flushes, i.e. the
+// emitted batches.
+type flushCountingSink struct {
+	lines, flushes int
+}
+
+func (s *flushCountingSink) Write(context.Context, LogEvent) error {
+	s.lines++
+	return nil
+}
+
+func (s *flushCountingSink) Flush() error {
+	s.flushes++
+	return nil
+}
+
+func (s *flushCountingSink) Close() error {
+	return nil
+}
+
+func TestLogReplayer_MaxBatchLines(t *testing.T) {
+	// 1000 lines logged 1ms apart fit into a single 5s window
+	options := ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		Speed:       1e6,
+		BatchWindow: 5 * time.Second,
+	}
+	for _, test := range []struct {
+		maxBatchLines int
+		batches       int
+	}{
+		{0, 1},
+		{100, 10},
+		{300, 4},
+	} {
+		options.MaxBatchLines = test.maxBatchLines
+		replayer, err := NewPipelineReplayer([]Source{benchmarkSource(1000)}, options)
+		if err != nil {
+			t.Fatalf("Failed to create replayer: %s", err)
+		}
+		sink := &flushCountingSink{}
+		if err := replayer.StartSink(context.Background(), time.Now(), sink); err != nil {
+			t.Fatalf("Replay failed: %s", err)
+		}
+		// The replay flushes once more when it ends
+		if sink.lines != 1000 || sink.flushes-1 != test.batches {
+			t.Errorf("Expected 1000 lines in %d batches with at most %d lines, got %d lines in %d batches",
+				test.batches, test.maxBatchLines, sink.lines, sink.flushes-1)
+		}
+	}
+}
+
+func TestLogReplayer_AlignWeeks(t *testing.T) {
+	// 2024-01-01 and 2025-06-02 are both Mondays
+	source := stringSource{name: "weekly", content: "2024-01-01 00:00:00.000 night\n" +
+		"2024-01-01 12:00:00.000 noon\n2024-01-01 12:00:01.000 after noon\n"}
+	replayer, err := NewPipelineReplayer([]Source{source}, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		Speed:       1000,
+		AlignWeeks:  true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	var lines []string
+	start := time.Date(2025, 6, 2, 12, 0, 0, 0, time.UTC)
+	err = replayer.Start(context.Background(), start, func(line string) {
+		lines = append(lines, line)
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "2025-06-02 12:00:00") {
+		t.Errorf("Expected the replay to start at noon on Monday, got %q", lines)
+	}
+	if s := replayer.Stats(); s.LinesSkipped != 1 {
+		t.Errorf("Expected the line before noon to be skipped, got %d skipped lines", s.LinesSkipped)
+	}
+}
+
+func TestLogReplayer_Streams(t *testing.T) {
+	db := stringSource{name: "db", content: "2024-01-01 00:00:00.000 db 1\n" +
+		"2024-01-01 00:00:02.000 db 2\n2024-01-01 00:00:04.000 db 3\n"}
+	lb := stringSource{name: "lb", content: "2024-01-01 00:00:00.000 lb 1\n" +
+		"2024-01-01 00:00:01.000 lb health\n2024-01-01 00:00:02.000 lb 2\n2024-01-01 00:00:04.000 lb 3\n"}
+	replayer, err := NewPipelineReplayer([]Source{db, lb}, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		Speed:       100,
+		Streams: map[string]StreamOptions{
+			"lb": {Speed: 2, FilterRegex: "lb \\d"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	var lines []string
+	err = replayer.StartEvents(context.Background(), time.Now(), func(_ context.Context, e LogEvent) {
+		lines = append(lines, e.RawLine[24:])
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+	// The lines of lb are logged at 0s, 1s and 2s on the replay timeline
+	expected := []string{"db 1", "lb 1", "lb 2", "db 2", "lb 3", "db 3"}
+	if !slices.Equal(lines, expected) {
+		t.Errorf("Expected lines %q, got %q", expected, lines)
+	}
+
+	_, err = NewPipelineReplayer([]Source{db}, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		Streams:     map[string]StreamOptions{"lb": {Speed: 2}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "not an input") {
+		t.Error("Expected error for options of an unknown stream")
+	}
+}
+
+func TestLogReplayer_Sampling(t *testing.T) {
+	var sb strings.Builder
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 2000; i++ {
+		level := "INFO"
+		if i%10 == 0 {
+			level = "ERROR"
+		}
+		fmt.Fprintf(&sb, "%s %s %d\n\tat continuation %d\n", start.Add(time.Duration(i)*time.Millisecond).Format("2006-01-02 15:04:05.000"), level, i, i)
+	}
+	replayer, err := NewPipelineReplayer([]Source{stringSource{name: "app", content: sb.String()}}, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		Speed:       1000,
+		SampleRate:  0.1,
+		SampleRules: []SampleRule{{Regex: "ERROR", Rate: 1}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	var lines []string
+	err = replayer.StartEvents(context.Background(), time.Now(), func(_ context.Context, e LogEvent) {
+		lines = append(lines, e.RawLine)
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+	errors, infos := 0, 0
+	for i, l := range lines {
+		if strings.HasPrefix(l, "\t") {
+			continue
+		}
+		if i+1 >= len(lines) || !strings.HasPrefix(lines[i+1], "\tat continuation") {
+			t.Fatalf("Expected line %q to be followed by its continuation", l)
+		}
+		if strings.Contains(l, "ERROR") {
+			errors++
+		} else {
+			infos++
+		}
+	}
+	// All 200 errors and about 10% of the 1800 other lines
+	if errors != 200 || infos < 100 || infos > 260 {
+		t.Errorf("Expected 200 errors and about 180 other lines, got %d and %d", errors, infos)
+	}
+	if stats := replayer.Stats(); int(stats.LinesSkipped) != 4000-len(lines) {
+		t.Errorf("Expected %d skipped lines, got %d", 4000-len(lines), stats.LinesSkipped)
+	}
+
+	_, err = NewLogReplayer("test.log", ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		SampleRate:  1.5,
+	})
+	if err == nil || !strings.Contains(err.Error(), "sample rate") {
+		t.Error("Expected error for an invalid sample rate")
+	}
+}
+
+func TestLogReplayer_DryRun(t *testing.T) {
+	// Lines an hour apart would take two hours to replay
+	content := "2024-01-01 00:00:00.000 first\n2024-01-01 00:00:00.000 second\n" +
+		"2024-01-01 01:00:00.000 third\n2024-01-01 02:00:00.000 fourth\n"
+	replayer, err := NewPipelineReplayer([]Source{stringSource{name: "app", content: content}}, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		Speed:       2,
+		Loop:        true,
+		DryRun:      true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+	mst := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	var offsets []time.Duration
+	var lines []string
+	err = replayer.StartEvents(context.Background(), mst, func(_ context.Context, e LogEvent) {
+		offsets = append(offsets, e.Time.Sub(mst).Round(time.Second))
+		lines = append(lines, e.Line)
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+	expected := []time.Duration{0, 0, 30 * time.Minute, time.Hour}
+	if !slices.Equal(offsets, expected) {
+		t.Errorf("Expected offsets %v, got %v", expected, offsets)
+	}
+	if len(lines) != 4 || lines[2] != "2024-06-01 12:30:00.000 third" {
+		t.Errorf("Expected the rewritten lines of a single run, got %q", lines)
+	}
+}
+
+func TestLogReplayer_LoopWithoutDrift(t *testing.T) {
+	content := "2024-01-01 00:00:00.000 first\n2024-01-01 00:00:00.100 second\n"
+	replayer, err := NewPipelineReplayer([]Source{stringSource{name: "app", content: content}}, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		BatchWindow: time.Millisecond,
+		Loop:        true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var times []time.Time
+	start := time.Now()
+	err = replayer.StartEvents(ctx, start, func(_ context.Context, e LogEvent) {
+		times = append(times, e.Time)
+		// A slow sink delays the emission, but must not delay the next runs
+		time.Sleep(30 * time.Millisecond)
+		if len(times) == 6 {
+			cancel()
+		}
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+
+	// The runs follow each other without a gap, the last line of a run is
+	// mapped to the same time as the first line of the next run
+	expected := []time.Duration{0, 100 * time.Millisecond, 100 * time.Millisecond, 200 * time.Millisecond,
+		200 * time.Millisecond, 300 * time.Millisecond}
+	for i, ts := range times {
+		if d := ts.Sub(times[0]); d != expected[i] {
+			t.Errorf("Expected line %d at %s, got %s", i, expected[i], d)
+		}
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("Expected replay to take about 330ms, took %s", d)
+	}
+}
+
+func TestLogReplayer_FollowRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, []byte("2024-01-01 00:00:00.000 first\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write input file: %s", err)
+	}
+	replayer, err := NewLogReplayer(path, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex:   `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+		TimeFormat:  "2006-01-02 15:04:05.000",
+		Follow:      true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+	lines := make(chan string, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go replayer.Start(ctx, time.Now(), func(line string) {
+		lines <- line[24:]
+	})
+	expect := func(expected string) {
+		t.Helper()
+		select {
+		case line := <-lines:
+			if line != expected {
+				t.Fatalf("Expected line %q, got %q", expected, line)
+			}
+		case <-time.After(10 * FollowPollInterval):
+			t.Fatalf("Expected line %q, got none", expected)
+		}
+	}
+	expect("first")
+
+	// Rotate the file by renaming it, like logrotate does
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("Failed to rotate input file: %s", err)
+	}
+	if err := os.WriteFile(path, []byte("2024-01-01 00:00:01.000 rotated\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write input file: %s", err)
+	}
+	expect("rotated")
+
+	// Truncate the file in place, like copytruncate does
+	if err := os.WriteFile(path, []byte("2024-01-01 00:00:02.000 new\n"), 0o644); err != nil {
+		t.Fatalf("Failed to truncate input file: %s", err)
+	}
+	expect("new")
+}
@@ -0,0 +1,187 @@
+package logs
+
+import (
+	"bufio"
+	"container/heap"
+	"io"
+	"time"
+)
+
+// lineReader reads the lines of a single input, applies the filter regex and
+// determines the timestamp of each line. Lines without a timestamp of their own
+// get the timestamp of the previous line, lines before the first timestamp are
+// dropped.
+type lineReader struct {
+	lr *LogReplayer
+	source string
+	index int // position of the input in the list of inputs, used to break ties
+	stream *stream
+	first time.Time // timestamp of the first line, the origin of the stream's timeline
+	scanner *bufio.Scanner
+	lineNumber int
+	last time.Time // timestamp of the last line with a timestamp
+	sampledOut bool // whether the last line with a timestamp was dropped by sampling
+	next pendingLine // the next line, valid after advance returned true
+	failure error // set if reading stopped for another reason than the input
+}
+
+// newLineReader creates a lineReader reading from r, which is decoded from
+// the encoding of the input.
+func newLineReader(lr *LogReplayer, source string, index int, r io.Reader) *lineReader {
+	r, lr.encodings[index] = decodeInput(r, lr.encodings[index])
+	// TODO: optionally, resize scanner's capacity for lines over 64K
+	return &lineReader{
+		lr: lr,
+		source: source,
+		index: index,
+		stream: &lr.streams[index],
+		scanner: bufio.NewScanner(countingReader{r: r, n: &lr.counters.bytesRead}),
+	}
+}
+
+// advance reads the next line that passes the filter into next. It returns
+// false at the end of the input or if reading failed, see err.
+func (r *lineReader) advance() bool {
+	for r.scanner.Scan() {
+		r.lineNumber++
+		r.lr.counters.position.Add(1)
+		r.lr.counters.linesRead.Add(1)
+
+		// Check if the line matches the filter regex. Matching the bytes of
+		// the scanner avoids allocating a string for lines that are dropped.
+		if !r.lr.filter().Match(r.scanner.Bytes()) {
+			var raw string
+			if r.lr.audit != nil {
+				raw = r.scanner.Text()
+			}
+			r.lr.skip(AuditFilterRegex, r.source, r.lineNumber, raw, nil)
+			continue
+		}
+		raw := r.lr.arena.string(r.scanner.Bytes())
+		if r.stream.frx != nil && !r.stream.frx.MatchString(raw) {
+			r.lr.skip(AuditFilterRegex, r.source, r.lineNumber, raw, nil)
+			continue
+		}
+
+		// Find the timestamp
+		ts, err := r.lr.extractTimestamp(raw)
+		if err != nil && err != errNoTimestamp {
+			r.lr.counters.parseFailures.Add(1)
+		}
+		if r.failure = r.lr.checkTimestamps(false); r.failure != nil {
+			return false
+		}
+		if err != nil {
+			// If timestamp could not be extracted, use the one of the previous line.
+			// If there is none yet, ignore.
+			if r.last.IsZero() {
+				if err == errNoTimestamp {
+					r.lr.skip(AuditNoTimestamp, r.source, r.lineNumber, raw, nil)
+				} else {
+					r.lr.skip(AuditTimeParseFailed, r.source, r.lineNumber, raw, err)
+				}
+				continue
+			}
+			ts = timestamp{time: r.last, start: -1, end: -1}
+		} else if r.lr.sampler != nil {
+			r.sampledOut = !r.lr.sampler.keep(raw)
+		}
+		r.last = ts.time
+		if r.first.IsZero() {
+			r.first = ts.time
+		}
+		if r.sampledOut {
+			r.lr.skip(AuditSampled, r.source, r.lineNumber, raw, nil)
+			continue
+		}
+		r.next = pendingLine{
+			event: LogEvent{
+				OriginalTime: ts.time,
+				RawLine: raw,
+				Line: raw,
+				Source: r.source,
+				LineNumber: r.lineNumber,
+			},
+			at: r.stream.scale(r.first, ts.time),
+			ts: ts,
+			stream: r.stream,
+		}
+		return true
+	}
+	return false
+}
+
+// err returns the error that stopped the reader, if any.
+func (r *lineReader) err() error {
+	if r.failure != nil {
+		return r.failure
+	}
+	return r.scanner.Err()
+}
+
+// readerHeap is a min-heap of line readers ordered by the time of their next
+// line on the replay timeline. It merges the lines of multiple inputs into a single timeline.
+type readerHeap []*lineReader
+
+func (h readerHeap) Len() int {
+	return len(h)
+}
+
+func (h readerHeap) Less(i, j int) bool {
+	ti, tj := h[i].next.at, h[j].next.at
+	if ti.Equal(tj) {
+		return h[i].index < h[j].index
+	}
+	return ti.Before(tj)
+}
+
+func (h readerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *readerHeap) Push(x any) {
+	*h = append(*h, x.(*lineReader))
+}
+
+func (h *readerHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// newReaderHeap creates a heap of the given readers, reading the first line of
+// each. Readers without any line are left out. It returns an error if reading
+// one of the inputs failed.
+func newReaderHeap(readers []*lineReader) (*readerHeap, error) {
+	h := &readerHeap{}
+	for _, r := range readers {
+		if r.advance() {
+			*h = append(*h, r)
+		} else if err := r.err(); err != nil {
+			return nil, err
+		}
+	}
+	heap.Init(h)
+	return h, nil
+}
+
+// pop returns the next line in the merged timeline and advances the reader it
+// came from. It returns false if all readers are exhausted, and an error if
+// reading an input failed.
+func (h *readerHeap) pop() (pendingLine, bool, error) {
+	if h.Len() == 0 {
+		return pendingLine{}, false, nil
+	}
+	r := (*h)[0]
+	l := r.next
+	if r.advance() {
+		heap.Fix(h, 0)
+	} else {
+		if err := r.err(); err != nil {
+			return pendingLine{}, false, err
+		}
+		heap.Pop(h)
+	}
+	return l, true, nil
+}
@@ -0,0 +1,147 @@
+package logs
+
+import (
+	"context"
+	"io"
+)
+
+// The replay is organized as a pipeline of stages: Sources provide the raw
+// lines, the filter regex and Filters select the lines to replay, the
+// LogReplayer schedules them on the replay timeline, Transformers modify the
+// resulting events and a Sink receives them. All stages except the scheduler
+// can be replaced to assemble custom pipelines, e.g. with an enrichment stage,
+// while reusing the timing engine of the LogReplayer.
+
+// Source is an input of a replay.
+type Source interface {
+	// Name identifies the source. It is used as LogEvent.Source and to store
+	// the position of the source in checkpoints.
+	Name() string
+	// Open opens the source for reading. It is called once per replay, the
+	// returned reader is rewound at the start of each replay run. Open should
+	// return an error wrapping fs.ErrNotExist if the source is not available
+	// yet, so the replay waits for it if InputWaitTimeout is set.
+	Open() (io.ReadSeekCloser, error)
+}
+
+// Filter selects the events that are replayed. Filters are applied after the
+// timestamp of an event has been rewritten.
+type Filter interface {
+	// Keep returns whether the event is emitted.
+	Keep(e LogEvent) bool
+}
+
+// Transformer modifies events before they are emitted.
+type Transformer interface {
+	// Transform returns the modified event. If it returns false, the event is
+	// dropped.
+	Transform(e LogEvent) (LogEvent, bool)
+}
+
+// Sink receives the emitted events.
+type Sink interface {
+	// Write is called for each event when it is due. An error stops the
+	// replay.
+	Write(ctx context.Context, e LogEvent) error
+	// Flush writes out buffered events. It is called after each batch of
+	// events that are due at the same time.
+	Flush() error
+	// Close flushes and releases the resources of the sink.
+	Close() error
+}
+
+// FilterFunc adapts a function to the Filter interface.
+type FilterFunc func(e LogEvent) bool
+
+// Keep calls f(e).
+func (f FilterFunc) Keep(e LogEvent) bool {
+	return f(e)
+}
+
+// TransformerFunc adapts a function to the Transformer interface.
+type TransformerFunc func(e LogEvent) (LogEvent, bool)
+
+// Transform calls f(e).
+func (f TransformerFunc) Transform(e LogEvent) (LogEvent, bool) {
+	return f(e)
+}
+
+// SinkFunc adapts a function to the Sink interface. It does not buffer, so
+// Flush and Close do nothing.
+type SinkFunc func(ctx context.Context, e LogEvent) error
+
+// Write calls f(ctx, e).
+func (f SinkFunc) Write(ctx context.Context, e LogEvent) error {
+	return f(ctx, e)
+}
+
+// Flush does nothing.
+func (f SinkFunc) Flush() error {
+	return nil
+}
+
+// Close does nothing.
+func (f SinkFunc) Close() error {
+	return nil
+}
+
+// stoppingSink wraps the sink of a replay. It records the first error of the
+// sink and cancels the replay.
+type stoppingSink struct {
+	sink Sink
+	cancel context.CancelFunc
+	err error
+}
+
+func (s *stoppingSink) fail(err error) error {
+	if err != nil && s.err == nil {
+		s.err = err
+		s.cancel()
+	}
+	return err
+}
+
+func (s *stoppingSink) Write(ctx context.Context, e LogEvent) error {
+	return s.fail(s.sink.Write(ctx, e))
+}
+
+func (s *stoppingSink) Flush() error {
+	return s.fail(s.sink.Flush())
+}
+
+func (s *stoppingSink) Close() error {
+	return s.fail(s.sink.Close())
+}
+
+// FileSource is a Source that reads a file. Names starting with
+// samples.Prefix are read from the bundled sample logs.
+type FileSource string
+
+// Name returns the file name.
+func (s FileSource) Name() string {
+	return string(s)
+}
+
+// Open opens the file.
+func (s FileSource) Open() (io.ReadSeekCloser, error) {
+	return openInput(string(s))
+}
+
+// applyStages applies the filters and transformers of the options to the
+// event. It returns false if the event is filtered out or dropped.
+func (lr *LogReplayer) applyStages(e LogEvent) (LogEvent, bool) {
+	for _, f := range lr.options.Filters {
+		if !f.Keep(e) {
+			return e, false
+		}
+	}
+	lr.stagesMu.RLock()
+	defer lr.stagesMu.RUnlock()
+	for _, t := range lr.options.Transformers {
+		var ok bool
+		if e, ok = t.Transform(e); !ok {
+			return e, false
+		}
+	}
+	return e, true
+}
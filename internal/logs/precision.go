@@ -0,0 +1,141 @@
+package logs
+
+import (
+	"slices"
+	"strings"
+	"time"
+)
+
+// zoneLayouts are the numeric zone elements of Go time layouts, longest first,
+// so the longest element of a layout is found first.
+var zoneLayouts = []string{"Z07:00:00", "-07:00:00", "Z070000", "-070000", "Z07:00", "-07:00", "Z0700", "-0700",
+	"Z07", "-07"}
+
+// timestamp returns the timestamp value found at the given spans of a line,
+// see TimestampMatcher.Find, which was parsed as t.
+func (f *timeFormat) timestamp(value string, spans []int, t time.Time) timestamp {
+	ts := timestamp{time: t, start: spans[0], end: spans[1], format: f, layout: f.layoutFor(value, t)}
+	if len(spans) > 2 {
+		ts.spans = spans
+		for i := 2; i < len(spans); i += 2 {
+			ts.start, ts.end = min(ts.start, spans[i]), max(ts.end, spans[i+1])
+		}
+	}
+	if len(zoneLayout(ts.layout)) > 0 {
+		ts.loc = t.Location()
+	}
+	return ts
+}
+
+// appendGroups appends the part of line covered by the named groups of the
+// timestamp to b, with the values of the groups replaced with the parts of the
+// formatted time. The text between the groups is kept. If the formatted time
+// does not fit the template, the groups are kept unchanged.
+func (ts timestamp) appendGroups(b []byte, line string) []byte {
+	values, ok := ts.format.matcher.split(string(ts.format.appendFormat(nil, ts.time, ts.layout)))
+	if !ok {
+		return append(b, line[ts.start:ts.end]...)
+	}
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return ts.spans[2*a] - ts.spans[2*b]
+	})
+	pos := ts.start
+	for _, i := range order {
+		start, end := ts.spans[2*i], ts.spans[2*i+1]
+		if start < pos {
+			// Groups referenced twice are replaced once
+			continue
+		}
+		b = append(b, line[pos:start]...)
+		b = append(b, values[i]...)
+		pos = end
+	}
+	return append(b, line[pos:ts.end]...)
+}
+
+// layoutFor returns the layout that formats t like the timestamp value it was
+// parsed from. Go parses timestamps more leniently than it formats them, e.g.
+// the layout "2006-01-02T15:04:05Z07:00" also parses
+// "2024-01-02T10:00:00.123456789+00:00", but formats it as
+// "2024-01-02T10:00:00Z". To keep strict parsers of the replayed lines working,
+// fractional seconds missing from the layout are added and its zone element is
+// replaced with the one used by value. The layouts are cached by the length of
+// the timestamps, which reflects their precision and zone style. Layouts of
+// registered parsers and localized layouts are returned as they are.
+func (f *timeFormat) layoutFor(value string, t time.Time) string {
+	if f.parser != nil || f.locale != nil {
+		return f.layout
+	}
+	if layout, ok := f.layouts[len(value)]; ok {
+		return layout
+	}
+	layout := matchLayout(f.layout, value, t)
+	if f.layouts == nil {
+		f.layouts = map[int]string{}
+	}
+	f.layouts[len(value)] = layout
+	return layout
+}
+
+// matchLayout returns the variant of layout that formats t as value, or layout
+// itself if there is none.
+func matchLayout(layout, value string, t time.Time) string {
+	if t.Format(layout) == value {
+		return layout
+	}
+	for _, zoned := range zoneVariants(layout) {
+		for _, variant := range fractionVariants(zoned) {
+			if t.Format(variant) == value {
+				return variant
+			}
+		}
+	}
+	return layout
+}
+
+// zoneLayout returns the numeric zone element of layout, or an empty string if
+// it has none.
+func zoneLayout(layout string) string {
+	for _, zone := range zoneLayouts {
+		if strings.Contains(layout, zone) {
+			return zone
+		}
+	}
+	return ""
+}
+
+// zoneVariants returns layout with its numeric zone element replaced with each
+// of the numeric zone elements, or only layout if it has none.
+func zoneVariants(layout string) []string {
+	zone := zoneLayout(layout)
+	if len(zone) == 0 {
+		return []string{layout}
+	}
+	variants := make([]string, 0, len(zoneLayouts))
+	for _, z := range zoneLayouts {
+		variants = append(variants, strings.Replace(layout, zone, z, 1))
+	}
+	return variants
+}
+
+// fractionVariants returns layout with fractional seconds of one to nine digits
+// added after its seconds, separated by a dot or a comma. If layout has no seconds or already has fractional seconds,
+// only layout is returned.
+func fractionVariants(layout string) []string {
+	i := strings.Index(layout, "05")
+	if i < 0 || strings.HasPrefix(layout[i+2:], ".0") || strings.HasPrefix(layout[i+2:], ".9") ||
+		strings.HasPrefix(layout[i+2:], ",0") || strings.HasPrefix(layout[i+2:], ",9") {
+		return []string{layout}
+	}
+	variants := []string{layout}
+	for _, sep := range []string{".", ","} {
+		for digits := 1; digits <= 9; digits++ {
+			variants = append(variants, layout[:i+2]+sep+strings.Repeat("0", digits)+layout[i+2:])
+		}
+	}
+	return variants
+}
@@ -0,0 +1,79 @@
+package logs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimeFormat_LayoutFor(t *testing.T) {
+	mapped := time.Date(2024, 6, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	for _, test := range []struct {
+		layout   string
+		value    string
+		expected string
+	}{
+		{time.RFC3339, "2024-01-01T00:00:00Z", "2024-06-01T10:00:00Z"},
+		{time.RFC3339, "2024-01-01T00:00:00.123456789Z", "2024-06-01T10:00:00.000000000Z"},
+		{time.RFC3339, "2024-01-01T00:00:00.120+00:00", "2024-06-01T10:00:00.000+00:00"},
+		{time.RFC3339, "2024-01-01T01:00:00.5+01:00", "2024-06-01T11:00:00.0+01:00"},
+		{"2006-01-02T15:04:05Z0700", "2024-01-01T01:00:00,25+0100", "2024-06-01T11:00:00,00+0100"},
+		{"2006-01-02 15:04:05", "2024-01-01 00:00:00.000001", "2024-06-01 12:00:00.000000"},
+		{"2006-01-02T15:04:05.999999999Z07:00", "2024-01-01T00:00:00.5Z", "2024-06-01T10:00:00Z"},
+	} {
+		formats, err := compileTimeFormats([]TimestampFormat{{Regex: "(.*)", Format: test.layout}}, nil)
+		if err != nil {
+			t.Fatalf("Failed to compile time format: %s", err)
+		}
+		f := &formats[0]
+		parsed, err := f.parse(test.value)
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %s", test.value, err)
+		}
+		ts := f.timestamp(test.value, []int{0, len(test.value)}, parsed)
+		if rewritten := rewriteTimestamps(nil, test.value, []timestamp{ts.moveTo(mapped)}); rewritten != test.expected {
+			t.Errorf("Expected %q to be rewritten with %q as %q, got %q", test.value, test.layout, test.expected,
+				rewritten)
+		}
+	}
+}
+
+func TestTimeFormat_NamedGroups(t *testing.T) {
+	formats, err := compileTimeFormats([]TimestampFormat{{
+		Regex:    `^(?P<date>\S+) \| \S+ \| (?P<time>\S+)`,
+		Format:   "2006-01-02T15:04:05.000",
+		Template: "{date}T{time}",
+	}}, nil)
+	if err != nil {
+		t.Fatalf("Failed to compile time format: %s", err)
+	}
+	f := &formats[0]
+	line := "2024-01-15 | web-1 | 10:00:00.123 | GET /index.html"
+	value, spans, ok := f.matcher.Find(line)
+	if !ok || value != "2024-01-15T10:00:00.123" {
+		t.Fatalf("Expected timestamp 2024-01-15T10:00:00.123, got %q", value)
+	}
+	parsed, err := f.parse(value)
+	if err != nil {
+		t.Fatalf("Failed to parse %q: %s", value, err)
+	}
+	mapped := time.Date(2024, 6, 1, 23, 59, 58, 5e6, time.Local)
+	ts := f.timestamp(value, spans, parsed)
+	expected := "2024-06-01 | web-1 | 23:59:58.005 | GET /index.html"
+	if rewritten := rewriteTimestamps(nil, line, []timestamp{ts.moveTo(mapped)}); rewritten != expected {
+		t.Errorf("Expected %q, got %q", expected, rewritten)
+	}
+	if _, rest, _, _ := f.matcher.Cut(line); rest != " | web-1 |  | GET /index.html" {
+		t.Errorf("Expected the groups to be cut, got %q", rest)
+	}
+
+	for _, test := range []TimestampFormat{
+		{Regex: `(?P<date>\S+) (?P<time>\S+)`, Template: "{date}{time}"},
+		{Regex: `(?P<date>\S+) (?P<time>\S+)`, Template: "{date} {clock}"},
+		{Regex: `(?P<date>\S+) (?P<time>\S+)`, Template: "{date"},
+		{Regex: `(\S+) (\S+)`, Template: "{date} {time}"},
+	} {
+		if _, err := NewTimestampMatcher(test); err == nil {
+			t.Errorf("Expected error for template %q of regex %q", test.Template, test.Regex)
+		}
+	}
+}
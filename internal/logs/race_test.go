@@ -0,0 +1,8 @@
+//go:build race
+
+package logs
+
+func init() {
+	// The race detector slows down the replay and allocates on its own
+	raceEnabled = true
+}
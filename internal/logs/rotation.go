@@ -0,0 +1,205 @@
+package logs
+
+import (
+	"bananabacon/internal/samples"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// rotatedSuffix matches the suffixes logrotate appends to rotated parts of a
+// log file: a number like ".1" or a date like "-20240301", optionally followed
+// by ".gz" if the part is compressed.
+var rotatedSuffix = regexp.MustCompile(`^(?:\.(\d+)|-(\d{8,10}))(\.gz)?$`)
+
+// RotatedSource is a Source reading a log file together with its rotated
+// parts as one continuous stream, e.g. app.log.2.gz, app.log.1.gz and
+// app.log in this order. Numbered parts are read from the highest number, as
+// logrotate gives the oldest part the highest number, and dated parts from
+// the earliest date. Compressed parts are decompressed while reading.
+type RotatedSource string
+
+// Name returns the name of the log file.
+func (s RotatedSource) Name() string {
+	return string(s)
+}
+
+// Open opens the parts of the log file. It returns an error wrapping
+// fs.ErrNotExist if neither the file nor a rotated part exists.
+func (s RotatedSource) Open() (io.ReadSeekCloser, error) {
+	if samples.IsBuiltin(string(s)) || IsDockerInput(string(s)) {
+		return openInput(string(s))
+	}
+	parts, err := rotatedParts(string(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("no rotated parts of %s: %w", s, fs.ErrNotExist)
+	}
+	return openParts(parts)
+}
+
+// rotatedParts returns the paths of the rotated parts of a log file and the
+// file itself, in the order they were written.
+func rotatedParts(path string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	type part struct {
+		path string
+		number int // -1 for dated parts
+		date string
+	}
+	var parts []part
+	base := filepath.Base(path)
+	for _, entry := range entries {
+		suffix, ok := strings.CutPrefix(entry.Name(), base)
+		if !ok || entry.IsDir() {
+			continue
+		}
+		m := rotatedSuffix.FindStringSubmatch(suffix)
+		if m == nil {
+			continue
+		}
+		p := part{path: filepath.Join(filepath.Dir(path), entry.Name()), number: -1, date: m[2]}
+		if len(m[1]) > 0 {
+			p.number, _ = strconv.Atoi(m[1])
+		}
+		parts = append(parts, p)
+	}
+	// Dated parts first, as logrotate does not mix them with numbered ones
+	slices.SortFunc(parts, func(a, b part) int {
+		switch {
+		case a.number < 0 && b.number < 0:
+			return strings.Compare(a.date, b.date)
+		case a.number < 0:
+			return -1
+		case b.number < 0:
+			return 1
+		}
+		return b.number - a.number
+	})
+	paths := make([]string, 0, len(parts)+1)
+	for _, p := range parts {
+		paths = append(paths, p.path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// openParts opens files that are read one after the other as a single
+// stream. Files ending in ".gz" are decompressed.
+func openParts(paths []string) (*partsReader, error) {
+	r := &partsReader{paths: paths}
+	if err := r.open(0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// partsReader reads the files of a rotation set one after the other. A line
+// break is inserted after a file that does not end with one, so the last line
+// of a part is not joined with the first line of the next. It can only be
+// rewound to its start.
+type partsReader struct {
+	paths []string
+	index int // index of the current part
+	current io.Reader
+	closers []io.Closer // of the current part
+	last byte // last byte read from the current part
+	offset int64 // bytes read in total
+}
+
+// open opens the part at the given index.
+func (r *partsReader) open(index int) error {
+	r.closeCurrent()
+	r.index, r.last = index, '\n'
+	if index >= len(r.paths) {
+		r.current = nil
+		return nil
+	}
+	f, err := os.Open(r.paths[index])
+	if err != nil {
+		return err
+	}
+	r.current, r.closers = f, []io.Closer{f}
+	if strings.HasSuffix(r.paths[index], ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("failed to decompress %s: %w", r.paths[index], err)
+		}
+		r.current, r.closers = gz, []io.Closer{gz, f}
+	}
+	return nil
+}
+
+func (r *partsReader) Read(p []byte) (int, error) {
+	for r.current != nil {
+		n, err := r.current.Read(p)
+		if n > 0 {
+			r.last = p[n-1]
+			r.offset += int64(n)
+			return n, nil
+		}
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, io.EOF) {
+			return 0, fmt.Errorf("failed to read %s: %w", r.paths[r.index], err)
+		}
+		if r.index == len(r.paths)-1 {
+			// Keep the last part open, so lines appended to it can be followed
+			return 0, io.EOF
+		}
+		lineBreak := r.last != '\n'
+		if err := r.open(r.index + 1); err != nil {
+			return 0, err
+		}
+		if lineBreak && len(p) > 0 {
+			p[0] = '\n'
+			r.offset++
+			return 1, nil
+		}
+	}
+	return 0, io.EOF
+}
+
+// Seek rewinds the reader to the start of the first part. Other positions are
+// not supported, except for querying the current offset.
+func (r *partsReader) Seek(offset int64, whence int) (int64, error) {
+	switch {
+	case offset == 0 && whence == io.SeekStart:
+		r.offset = 0
+		return 0, r.open(0)
+	case offset == 0 && whence == io.SeekCurrent:
+		return r.offset, nil
+	}
+	return r.offset, errors.New("rotated log files can only be rewound to their start")
+}
+
+// closeCurrent closes the current part.
+func (r *partsReader) closeCurrent() {
+	for _, c := range r.closers {
+		c.Close()
+	}
+	r.closers = nil
+}
+
+func (r *partsReader) Close() error {
+	r.closeCurrent()
+	r.current = nil
+	return nil
+}
@@ -0,0 +1,67 @@
+package logs
+
+import (
+	"compress/gzip"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLogReplayer_ReadRotated(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("Failed to create %s: %s", name, err)
+		}
+		defer f.Close()
+		if filepath.Ext(name) == ".gz" {
+			gz := gzip.NewWriter(f)
+			defer gz.Close()
+			gz.Write([]byte(content))
+			return
+		}
+		f.Write([]byte(content))
+	}
+	write("app.log.10.gz", "2023-01-01 00:00:00.000 first\n")
+	write("app.log.2.gz", "2023-01-01 00:00:01.000 second\n")
+	// Not compressed yet by delaycompress, and without a final line break
+	write("app.log.1", "2023-01-01 00:00:02.000 third")
+	write("app.log", "2023-01-01 00:00:03.000 fourth\n")
+	write("app.log.bak", "2023-01-01 00:00:04.000 unrelated\n")
+
+	for _, test := range []struct {
+		input    string
+		rotated  bool
+		expected []string
+	}{
+		{"app.log", true, []string{"first", "second", "third", "fourth"}},
+		{"app.log", false, []string{"fourth"}},
+		{"app.log.2.gz", false, []string{"second"}},
+	} {
+		replayer, err := NewLogReplayer(filepath.Join(dir, test.input), ReplayerOptions{
+			FilterRegex: ".*",
+			TimeRegex:   `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}) `,
+			TimeFormat:  "2006-01-02 15:04:05.000",
+			Speed:       1000,
+			ReadRotated: test.rotated,
+		})
+		if err != nil {
+			t.Fatalf("Failed to create replayer: %s", err)
+		}
+		var lines []string
+		err = replayer.StartEvents(context.Background(), time.Now(), func(_ context.Context, e LogEvent) {
+			lines = append(lines, e.RawLine[24:])
+		})
+		if err != nil {
+			t.Fatalf("Replay failed: %s", err)
+		}
+		if !slices.Equal(lines, test.expected) {
+			t.Errorf("Expected %s (rotated: %t) to be replayed as %q, got %q", test.input, test.rotated,
+				test.expected, lines)
+		}
+	}
+}
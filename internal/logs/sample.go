@@ -0,0 +1,67 @@
+package logs
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+)
+
+// SampleRule replays only a share of the lines matching its regex, overriding
+// ReplayerOptions.SampleRate for them.
+type SampleRule struct {
+	// Regex selects the lines the rule applies to.
+	Regex string
+	// Rate is the share of the matching lines, between 0 and 1, that are
+	// replayed.
+	Rate float64
+}
+
+// sampler decides which lines are replayed.
+type sampler struct {
+	rate float64
+	rules []sampleRule
+}
+
+type sampleRule struct {
+	rx *regexp.Regexp
+	rate float64
+}
+
+// newSampler compiles the sample rate and rules. It returns nil if all lines
+// are replayed.
+func newSampler(rate float64, rules []SampleRule) (*sampler, error) {
+	if rate < 0 || rate > 1 {
+		return nil, fmt.Errorf("invalid sample rate: %v, must be between 0 and 1", rate)
+	}
+	if rate == 0 {
+		rate = 1
+	}
+	s := &sampler{rate: rate}
+	for _, r := range rules {
+		if r.Rate < 0 || r.Rate > 1 {
+			return nil, fmt.Errorf("invalid sample rate for %s: %v, must be between 0 and 1", r.Regex, r.Rate)
+		}
+		rx, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sample regex: %s, err: %w", r.Regex, err)
+		}
+		s.rules = append(s.rules, sampleRule{rx: rx, rate: r.Rate})
+	}
+	if rate == 1 && len(s.rules) == 0 {
+		return nil, nil
+	}
+	return s, nil
+}
+
+// keep returns whether the line is replayed. The first matching rule
+// determines the rate, SampleRate applies if none matches.
+func (s *sampler) keep(line string) bool {
+	rate := s.rate
+	for _, r := range s.rules {
+		if r.rx.MatchString(line) {
+			rate = r.rate
+			break
+		}
+	}
+	return rate >= 1 || rand.Float64() < rate
+}
@@ -0,0 +1,223 @@
+package logs
+
+import (
+	"bananabacon/internal/debug"
+	"container/heap"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// TimerScheduler waits for each batch using its own timer. It is the most
+	// precise strategy.
+	TimerScheduler = "timer"
+	// TickerScheduler uses a single ticker for the whole replay and checks on
+	// each tick whether the next batch is due. It avoids creating a timer per
+	// batch at the cost of a precision of one tick.
+	TickerScheduler = "ticker"
+
+	// DefaultTickerGranularity is the tick interval used by the ticker
+	// scheduler if no granularity is configured.
+	DefaultTickerGranularity = 10 * time.Millisecond
+)
+
+// scheduler waits until the replay clock reaches the virtual time of the next
+// batch.
+type scheduler interface {
+	// wait blocks until the clock reaches the given virtual time or the
+	// context is cancelled. It returns false if the context was cancelled.
+	wait(ctx context.Context, clock *replayClock, offset time.Duration) bool
+	// stop releases the resources of the scheduler.
+	stop()
+}
+
+// newScheduler creates the scheduler with the given strategy. The granularity
+// is the resolution of the scheduler: wait times of the timer scheduler are
+// rounded up to multiples of it, so close batches share a wake-up, and the
+// ticker scheduler ticks at this interval.
+func newScheduler(strategy string, granularity time.Duration) (scheduler, error) {
+	if granularity < 0 {
+		return nil, fmt.Errorf("invalid scheduler granularity: %s, must not be negative", granularity)
+	}
+	switch strategy {
+	case "", TimerScheduler:
+		return &timerScheduler{granularity: granularity}, nil
+	case TickerScheduler:
+		if granularity == 0 {
+			granularity = DefaultTickerGranularity
+		}
+		return &tickerScheduler{granularity: granularity}, nil
+	default:
+		return nil, fmt.Errorf("unknown scheduler: %q, must be %q or %q", strategy, TimerScheduler, TickerScheduler)
+	}
+}
+
+// timerScheduler creates a timer for every wait.
+type timerScheduler struct {
+	granularity time.Duration
+}
+
+func (ts *timerScheduler) wait(ctx context.Context, clock *replayClock, offset time.Duration) bool {
+	for {
+		dur, running := clock.until(offset)
+		if running && dur <= 0 {
+			return true
+		}
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if running {
+			// Coalesce wake-ups by rounding up to the granularity
+			if ts.granularity > 0 && dur%ts.granularity != 0 {
+				dur = dur.Truncate(ts.granularity) + ts.granularity
+			}
+			debug.Printf("Waiting %s for next batch", dur)
+			timer = time.NewTimer(dur)
+			timeout = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			stopTimer(timer)
+			return false
+		case <-clock.changed:
+			stopTimer(timer)
+		case <-timeout:
+			return true
+		}
+	}
+}
+
+func (ts *timerScheduler) stop() {
+}
+
+// stopTimer stops the given timer if it is not nil.
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// tickerScheduler shares a single ticker between all waits. A loop started
+// on the first wait checks on each tick and on each change of the clock which
+// waits are due. The pending waits are kept in a heap ordered by their due
+// time, so a tick only looks at the waits it releases and the next one.
+type tickerScheduler struct {
+	granularity time.Duration
+	once sync.Once
+	quit chan struct{}
+	mu sync.Mutex
+	waits waitHeap
+}
+
+func (ts *tickerScheduler) wait(ctx context.Context, clock *replayClock, offset time.Duration) bool {
+	ts.once.Do(func() {
+		ts.quit = make(chan struct{})
+		go ts.run(clock)
+	})
+	if dur, running := clock.until(offset); running && dur <= 0 {
+		return true
+	}
+	w := &pendingWait{offset: offset, due: make(chan struct{})}
+	ts.mu.Lock()
+	heap.Push(&ts.waits, w)
+	ts.mu.Unlock()
+	select {
+	case <-w.due:
+		return true
+	case <-ctx.Done():
+	case <-ts.quit:
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if w.index >= 0 {
+		heap.Remove(&ts.waits, w.index)
+	}
+	return false
+}
+
+// run releases the due waits on each tick until the scheduler is stopped.
+func (ts *tickerScheduler) run(clock *replayClock) {
+	ticker := time.NewTicker(ts.granularity)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ts.quit:
+			return
+		case <-clock.changed:
+		case <-ticker.C:
+		}
+		ts.release(clock)
+	}
+}
+
+// release releases the waits the clock has reached, in the order of their due
+// time.
+func (ts *tickerScheduler) release(clock *replayClock) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for ts.waits.Len() > 0 {
+		if dur, running := clock.until(ts.waits[0].offset); !running || dur > 0 {
+			return
+		}
+		close(heap.Pop(&ts.waits).(*pendingWait).due)
+	}
+}
+
+func (ts *tickerScheduler) stop() {
+	ts.once.Do(func() {})
+	if ts.quit != nil {
+		close(ts.quit)
+	}
+}
+
+// pendingWait is a wait of the ticker scheduler for the batch at the given
+// virtual time. due is closed when it is reached.
+type pendingWait struct {
+	offset time.Duration
+	due chan struct{}
+	index int // position in the heap, -1 once released or removed
+}
+
+// waitHeap is a min-heap of pending waits ordered by their virtual time.
+type waitHeap []*pendingWait
+
+func (h waitHeap) Len() int {
+	return len(h)
+}
+
+func (h waitHeap) Less(i, j int) bool {
+	return h[i].offset < h[j].offset
+}
+
+func (h waitHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *waitHeap) Push(x any) {
+	w := x.(*pendingWait)
+	w.index = len(*h)
+	*h = append(*h, w)
+}
+
+func (h *waitHeap) Pop() any {
+	old := *h
+	w := old[len(old)-1]
+	w.index = -1
+	*h = old[:len(old)-1]
+	return w
+}
+
+// dryRunScheduler does not wait at all, so a dry run emits the batches as fast
+// as they are read. As the clock is not advanced, the lines are still mapped
+// to the times they are due at.
+type dryRunScheduler struct{}
+
+func (dryRunScheduler) wait(ctx context.Context, clock *replayClock, offset time.Duration) bool {
+	return ctx.Err() == nil
+}
+
+func (dryRunScheduler) stop() {
+}
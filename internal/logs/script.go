@@ -0,0 +1,71 @@
+package logs
+
+import (
+	"bananabacon/internal/debug"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dop251/goja"
+)
+
+const (
+	// ScriptTransformFuncTemplate wraps a transformation expression in the
+	// function called for each line.
+	ScriptTransformFuncTemplate = "function transform(line, time, originalTime, source) { return %s }"
+)
+
+// ScriptTransformer is a Transformer that applies a JavaScript function to
+// each line. The function is called as transform(line, time, originalTime,
+// source), where time is the rewritten and originalTime the parsed timestamp
+// of the line as Date objects. It returns the new line, or null or undefined
+// to drop the line.
+type ScriptTransformer struct {
+	vm *goja.Runtime
+	fn goja.Callable
+	mu sync.Mutex
+}
+
+// NewScriptTransformer compiles the given script. The script is either an
+// expression, e.g. `line.replace(/\d+\.\d+\.\d+\.\d+/g, "x.x.x.x")`, or a
+// definition of the function transform. An error is returned if the script
+// cannot be compiled.
+func NewScriptTransformer(script string) (*ScriptTransformer, error) {
+	if !strings.HasPrefix(strings.TrimSpace(script), "function") {
+		script = fmt.Sprintf(ScriptTransformFuncTemplate, script)
+	}
+	vm := goja.New()
+	if _, err := vm.RunString(script); err != nil {
+		return nil, fmt.Errorf("invalid transform script: %w", err)
+	}
+	fn, ok := goja.AssertFunction(vm.Get("transform"))
+	if !ok {
+		return nil, fmt.Errorf("invalid transform script: transform is not a function")
+	}
+	return &ScriptTransformer{vm: vm, fn: fn}, nil
+}
+
+// Transform calls the script with the event. If the script fails, the event
+// is emitted unchanged.
+func (st *ScriptTransformer) Transform(e LogEvent) (LogEvent, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	t, err := st.vm.New(st.vm.Get("Date"), st.vm.ToValue(e.Time.UnixMilli()))
+	if err != nil {
+		return e, true
+	}
+	ot, err := st.vm.New(st.vm.Get("Date"), st.vm.ToValue(e.OriginalTime.UnixMilli()))
+	if err != nil {
+		return e, true
+	}
+	res, err := st.fn(goja.Undefined(), st.vm.ToValue(e.Line), t, ot, st.vm.ToValue(e.Source))
+	if err != nil {
+		debug.Printf("Failed to transform line %d of %s: %v", e.LineNumber, e.Source, err)
+		return e, true
+	}
+	if goja.IsNull(res) || goja.IsUndefined(res) {
+		return e, false
+	}
+	e.Line = res.String()
+	return e, true
+}
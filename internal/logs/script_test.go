@@ -0,0 +1,39 @@
+package logs
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScriptTransformer(t *testing.T) {
+	st, err := NewScriptTransformer(`line.indexOf("DEBUG") >= 0 ? null : line.replace(/user=\w+/, "user=***") + " year=" + originalTime.getUTCFullYear()`)
+	if err != nil {
+		t.Fatalf("Failed to create transformer: %s", err)
+	}
+	e := LogEvent{
+		OriginalTime: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Time: time.Now(),
+		Line: "INFO login user=alice",
+	}
+	res, ok := st.Transform(e)
+	if !ok || res.Line != "INFO login user=*** year=2023" {
+		t.Errorf("Expected masked line, got %q (kept: %v)", res.Line, ok)
+	}
+	e.Line = "DEBUG details"
+	if _, ok := st.Transform(e); ok {
+		t.Error("Expected line to be dropped")
+	}
+
+	st, err = NewScriptTransformer(`function transform(line, time, originalTime, source) { return source + ": " + line }`)
+	if err != nil {
+		t.Fatalf("Failed to create transformer: %s", err)
+	}
+	e.Source = "app.log"
+	if res, _ := st.Transform(e); res.Line != "app.log: DEBUG details" {
+		t.Errorf("Expected prefixed line, got %q", res.Line)
+	}
+
+	if _, err := NewScriptTransformer("line +"); err == nil {
+		t.Error("Expected error for invalid script")
+	}
+}
@@ -0,0 +1,86 @@
+package logs
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// ReplayerStats contains statistics about a running replay.
+type ReplayerStats struct {
+	// Run is the number of the current replay run, starting at 1.
+	Run int64
+	// Position is the number of lines read from the inputs in the current run.
+	Position int64
+	// LinesRead is the number of lines read from the input over all runs.
+	LinesRead int64
+	// LinesEmitted is the number of lines written to the sink over all runs.
+	LinesEmitted int64
+	// LinesSkipped is the number of lines that were dropped by the filter
+	// or because no timestamp could be assigned, over all runs.
+	LinesSkipped int64
+	// LinesFiltered is the number of skipped lines that were dropped by the
+	// filter regex, the sampling or a pipeline stage, over all runs.
+	LinesFiltered int64
+	// ParseFailures is the number of lines whose timestamp matched the time
+	// regex but could not be parsed, over all runs.
+	ParseFailures int64
+	// BytesRead is the number of bytes read from the inputs over all runs,
+	// after decompression.
+	BytesRead int64
+	// Drift is how late the last batch was emitted compared to the time it was
+	// due at in real time, e.g. because the sink was slow. It is negative if
+	// the batch was emitted early.
+	Drift time.Duration
+	// LogTime is the original timestamp of the line emitted last, i.e. the
+	// virtual time of the replay. It is zero if no line was emitted yet.
+	LogTime time.Time
+}
+
+// replayerCounters holds the counters behind ReplayerStats. They are updated
+// from the replay goroutine and the emitting timers, so they are atomic.
+type replayerCounters struct {
+	run atomic.Int64
+	position atomic.Int64
+	linesRead atomic.Int64
+	linesEmitted atomic.Int64
+	linesSkipped atomic.Int64
+	linesFiltered atomic.Int64
+	parseFailures atomic.Int64
+	bytesRead atomic.Int64
+	drift atomic.Int64
+	logTime atomic.Int64 // UnixNano of the original timestamp emitted last
+	lastOffset atomic.Int64 // virtual time of the line emitted last
+}
+
+// snapshot returns the current values of the counters.
+func (rc *replayerCounters) snapshot() ReplayerStats {
+	var logTime time.Time
+	if lt := rc.logTime.Load(); lt != 0 {
+		logTime = time.Unix(0, lt)
+	}
+	return ReplayerStats{
+		Run: rc.run.Load(),
+		Position: rc.position.Load(),
+		LinesRead: rc.linesRead.Load(),
+		LinesEmitted: rc.linesEmitted.Load(),
+		LinesSkipped: rc.linesSkipped.Load(),
+		LinesFiltered: rc.linesFiltered.Load(),
+		ParseFailures: rc.parseFailures.Load(),
+		BytesRead: rc.bytesRead.Load(),
+		Drift: time.Duration(rc.drift.Load()),
+		LogTime: logTime,
+	}
+}
+
+// countingReader counts the bytes read from an input.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (cr countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n.Add(int64(n))
+	return n, err
+}
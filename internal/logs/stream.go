@@ -0,0 +1,74 @@
+package logs
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// StreamOptions overrides the options of a replay for one of its sources, e.g.
+// to replay the log of a load balancer faster than the log of a database it
+// is merged with.
+type StreamOptions struct {
+	// Speed is the factor by which the source is replayed faster than the
+	// other sources, on top of ReplayerOptions.Speed. The lines of the source
+	// are spread over a timeline that starts at its first line and runs Speed
+	// times faster than the log time. Zero means 1.
+	Speed float64
+	// Jitter replaces ReplayerOptions.Jitter for the rewritten timestamps of
+	// the lines of the source. Zero means ReplayerOptions.Jitter.
+	Jitter time.Duration
+	// FilterRegex selects the lines of the source that are replayed, in
+	// addition to ReplayerOptions.FilterRegex. Empty means all lines.
+	FilterRegex string
+}
+
+// stream holds the compiled StreamOptions of a source.
+type stream struct {
+	speed float64
+	jitter time.Duration
+	frx *regexp.Regexp // nil if the source has no filter of its own
+}
+
+// compileStreams compiles the stream options of the given sources. It returns
+// an error if the options reference an unknown source or are invalid.
+func compileStreams(sources []string, options ReplayerOptions) ([]stream, error) {
+	streams := make([]stream, len(sources))
+	known := map[string]int{}
+	for i, name := range sources {
+		known[name] = i
+		streams[i] = stream{speed: 1, jitter: options.Jitter}
+	}
+	for name, so := range options.Streams {
+		i, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("invalid stream options: %s is not an input", name)
+		}
+		if so.Speed < 0 || so.Jitter < 0 {
+			return nil, fmt.Errorf("invalid stream options for %s: speed and jitter must not be negative", name)
+		}
+		if so.Speed > 0 {
+			streams[i].speed = so.Speed
+		}
+		if so.Jitter > 0 {
+			streams[i].jitter = so.Jitter
+		}
+		if len(so.FilterRegex) > 0 {
+			frx, err := regexp.Compile(so.FilterRegex)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter regex for %s: %s, err: %w", name, so.FilterRegex, err)
+			}
+			streams[i].frx = frx
+		}
+	}
+	return streams, nil
+}
+
+// scale maps the log time t of a line of the stream to its time on the replay
+// timeline, given the time of the first line of the stream.
+func (s *stream) scale(first, t time.Time) time.Time {
+	if s.speed == 1 {
+		return t
+	}
+	return first.Add(time.Duration(float64(t.Sub(first)) / s.speed))
+}
@@ -0,0 +1,91 @@
+package logs
+
+import (
+	"crypto/rand"
+	"fmt"
+	mrand "math/rand/v2"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// placeholderRegex matches placeholders like {{hostname}} or {{rand 1 100}}.
+var placeholderRegex = regexp.MustCompile(`\{\{\s*(\w+)((?:\s+(?:"[^"]*"|[^\s"}]+))*)\s*\}\}`)
+
+// argRegex matches the arguments of a placeholder.
+var argRegex = regexp.MustCompile(`"[^"]*"|[^\s"]+`)
+
+// TemplateTransformer is a Transformer that expands placeholders in lines, so
+// one log can be replayed as if it came from many distinct instances. The
+// following placeholders are supported:
+//
+// - {{hostname}}: the host name of the machine
+// - {{pod}}: the value of POD_NAME, or the host name if it is not set
+// - {{uuid}}: a random UUID, different for every occurrence
+// - {{rand a b}}: a random integer between a and b, inclusive
+// - {{env "FOO"}}: the value of the environment variable FOO
+//
+// Unknown or invalid placeholders are left unchanged.
+type TemplateTransformer struct {
+	hostname string
+	pod string
+}
+
+// NewTemplateTransformer creates a TemplateTransformer for the current host.
+func NewTemplateTransformer() *TemplateTransformer {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	pod := os.Getenv("POD_NAME")
+	if len(pod) == 0 {
+		pod = hostname
+	}
+	return &TemplateTransformer{hostname: hostname, pod: pod}
+}
+
+// Transform expands the placeholders in the line of the event.
+func (tt *TemplateTransformer) Transform(e LogEvent) (LogEvent, bool) {
+	if !strings.Contains(e.Line, "{{") {
+		return e, true
+	}
+	e.Line = placeholderRegex.ReplaceAllStringFunc(e.Line, tt.expand)
+	return e, true
+}
+
+// expand returns the value of a single placeholder.
+func (tt *TemplateTransformer) expand(placeholder string) string {
+	m := placeholderRegex.FindStringSubmatch(placeholder)
+	args := argRegex.FindAllString(m[2], -1)
+	for i, a := range args {
+		args[i] = strings.Trim(a, `"`)
+	}
+	switch {
+	case m[1] == "hostname" && len(args) == 0:
+		return tt.hostname
+	case m[1] == "pod" && len(args) == 0:
+		return tt.pod
+	case m[1] == "uuid" && len(args) == 0:
+		return newUUID()
+	case m[1] == "rand" && len(args) == 2:
+		lo, err1 := strconv.Atoi(args[0])
+		hi, err2 := strconv.Atoi(args[1])
+		if err1 != nil || err2 != nil || hi < lo {
+			return placeholder
+		}
+		return strconv.Itoa(lo + mrand.IntN(hi-lo+1))
+	case m[1] == "env" && len(args) == 1:
+		return os.Getenv(args[0])
+	}
+	return placeholder
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
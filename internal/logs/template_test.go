@@ -0,0 +1,29 @@
+package logs
+
+import (
+	"os"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+func TestTemplateTransformer(t *testing.T) {
+	t.Setenv("POD_NAME", "web-1")
+	t.Setenv("REGION", "eu-west")
+	tt := NewTemplateTransformer()
+	hostname, _ := os.Hostname()
+
+	e, ok := tt.Transform(LogEvent{Line: `host={{hostname}} pod={{ pod }} region={{env "REGION"}} {{unknown}}`})
+	if !ok || e.Line != "host="+hostname+" pod=web-1 region=eu-west {{unknown}}" {
+		t.Errorf("Expected placeholders to be expanded, got %q", e.Line)
+	}
+
+	e, _ = tt.Transform(LogEvent{Line: "id={{uuid}} n={{rand 5 7}} bad={{rand 7 5}}"})
+	m := regexp.MustCompile(`^id=([0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}) n=(\d+) bad=\{\{rand 7 5\}\}$`).FindStringSubmatch(e.Line)
+	if m == nil {
+		t.Fatalf("Expected uuid and random number, got %q", e.Line)
+	}
+	if n, _ := strconv.Atoi(m[2]); n < 5 || n > 7 {
+		t.Errorf("Expected random number between 5 and 7, got %d", n)
+	}
+}
@@ -0,0 +1,197 @@
+package logs
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// TimeParseStop stops the replay if too many timestamps cannot be parsed.
+	TimeParseStop = "stop"
+	// TimeParseWarn logs a warning if too many timestamps cannot be parsed.
+	TimeParseWarn = "warn"
+	// timeCheckMinLines is the number of lines matching a time regex after
+	// which the parse failures are checked. Shorter inputs are checked at the
+	// end of the first run.
+	timeCheckMinLines = 100
+)
+
+// commonTimeFormats are the layouts suggested for timestamps that cannot be
+// parsed with the configured format.
+var commonTimeFormats = []string{
+	"2006-01-02 15:04:05.000",
+	"2006-01-02 15:04:05,000",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05.000Z07:00",
+	"2006-01-02T15:04:05.000000Z07:00",
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02T15:04:05.000",
+	"2006-01-02T15:04:05",
+	"2006/01/02 15:04:05",
+	"02/Jan/2006:15:04:05 -0700",
+	"02.01.2006 15:04:05",
+	"01/02/2006 15:04:05",
+	"Jan _2 15:04:05",
+	"Mon Jan _2 15:04:05 2006",
+	"Mon Jan 02 15:04:05.000000 2006",
+	"0102 15:04:05.000000",
+	time.RFC1123,
+	time.RFC1123Z,
+}
+
+// TimeFormatError is returned by the Start methods if the timestamps of too
+// many lines matching a time regex cannot be parsed, which usually means that
+// the time format does not match the log.
+type TimeFormatError struct {
+	// Failed is the number of timestamps that could not be parsed.
+	Failed int
+	// Matched is the number of lines matching a time regex.
+	Matched int
+	// Format is the format the first failed timestamp was parsed with.
+	Format string
+	// Sample is the first timestamp that could not be parsed.
+	Sample string
+	// Suggestions are common formats that parse or resemble Sample.
+	Suggestions []string
+}
+
+func (e *TimeFormatError) Error() string {
+	msg := fmt.Sprintf("%d of %d timestamps could not be parsed with time format %q, e.g. %q",
+		e.Failed, e.Matched, e.Format, e.Sample)
+	if len(e.Suggestions) > 0 {
+		quoted := make([]string, len(e.Suggestions))
+		for i, s := range e.Suggestions {
+			quoted[i] = strconv.Quote(s)
+		}
+		msg += ", try " + strings.Join(quoted, " or ")
+	}
+	return msg
+}
+
+// timeCheck counts the lines whose timestamp matched a time regex but could not
+// be parsed. It is only used from the goroutine reading the inputs.
+type timeCheck struct {
+	matched int
+	failed int
+	format string
+	sample string
+	done bool
+}
+
+// record counts a line matching a time regex. If parsing failed, layout and
+// value are the format and timestamp of the first regex that matched.
+func (c *timeCheck) record(ok bool, layout, value string) {
+	c.matched++
+	if !ok {
+		c.failed++
+		if len(c.sample) == 0 {
+			c.format, c.sample = layout, value
+		}
+	}
+}
+
+// checkTimestamps checks the share of timestamps that could not be parsed once
+// enough lines have been read, or at the end of the input if final is set. It
+// returns a TimeFormatError if the share exceeds MaxTimeParseFailures and
+// TimeParseCheck is TimeParseStop. The check is only done once per replay.
+func (lr *LogReplayer) checkTimestamps(final bool) error {
+	c := &lr.timeCheck
+	if len(lr.options.TimeParseCheck) == 0 || c.done || c.matched == 0 || (!final && c.matched < timeCheckMinLines) {
+		return nil
+	}
+	c.done = true
+	if float64(c.failed) <= lr.options.MaxTimeParseFailures*float64(c.matched) {
+		return nil
+	}
+	err := &TimeFormatError{
+		Failed: c.failed,
+		Matched: c.matched,
+		Format: c.format,
+		Sample: c.sample,
+		Suggestions: SuggestTimeFormats(c.sample),
+	}
+	if lr.options.TimeParseCheck == TimeParseWarn {
+		log.Printf("WARNING: %s. Lines without a parsed timestamp get the timestamp of the previous line.", err)
+		return nil
+	}
+	return err
+}
+
+// SuggestTimeFormats returns common time formats for the given timestamp. If
+// some of them parse it, these are returned. Otherwise, up to three formats
+// whose layout resembles the timestamp most closely are returned.
+func SuggestTimeFormats(value string) []string {
+	var parsing []string
+	for _, layout := range commonTimeFormats {
+		if _, err := time.Parse(layout, value); err == nil {
+			parsing = append(parsing, layout)
+		}
+	}
+	if len(parsing) > 0 {
+		return parsing
+	}
+
+	type candidate struct {
+		layout string
+		distance int
+	}
+	shape := timestampShape(value)
+	ref := time.Date(2006, 1, 2, 15, 4, 5, 123456789, time.UTC)
+	var candidates []candidate
+	for _, layout := range commonTimeFormats {
+		d := editDistance(shape, timestampShape(ref.Format(layout)))
+		// Formats that differ in more than half of the characters are no
+		// helpful suggestion
+		if 2*d <= len(shape) {
+			candidates = append(candidates, candidate{layout, d})
+		}
+	}
+	slices.SortStableFunc(candidates, func(a, b candidate) int {
+		return a.distance - b.distance
+	})
+	var suggestions []string
+	for _, c := range candidates[:min(len(candidates), 3)] {
+		suggestions = append(suggestions, c.layout)
+	}
+	return suggestions
+}
+
+// timestampShape replaces all digits of s with '0' and all letters with 'a',
+// so timestamps can be compared by their structure.
+func timestampShape(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= '0' && r <= '9':
+			return '0'
+		case r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+			return 'a'
+		}
+		return r
+	}, s)
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
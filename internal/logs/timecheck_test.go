@@ -0,0 +1,69 @@
+package logs
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLogReplayer_TimeParseCheck(t *testing.T) {
+	source := stringSource{
+		name: "iso",
+		content: "2023-01-01T00:00:01Z first\n2023-01-01T00:00:02Z second\n",
+	}
+	options := ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex: `^(\S+)`,
+		TimeFormat: "2006-01-02 15:04:05.000",
+		TimeParseCheck: TimeParseStop,
+		MaxTimeParseFailures: 0.1,
+	}
+	replayer, err := NewPipelineReplayer([]Source{source}, options)
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+	err = replayer.StartEvents(context.Background(), time.Now(), func(context.Context, LogEvent) {})
+	var tfErr *TimeFormatError
+	if !errors.As(err, &tfErr) {
+		t.Fatalf("Expected a TimeFormatError, got %v", err)
+	}
+	if tfErr.Failed != 2 || tfErr.Matched != 2 || tfErr.Sample != "2023-01-01T00:00:01Z" {
+		t.Errorf("Unexpected error %+v", tfErr)
+	}
+	if !slices.Contains(tfErr.Suggestions, time.RFC3339) {
+		t.Errorf("Expected %q to be suggested, got %q", time.RFC3339, tfErr.Suggestions)
+	}
+
+	// Warnings do not stop the replay
+	options.TimeParseCheck = TimeParseWarn
+	replayer, err = NewPipelineReplayer([]Source{source}, options)
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+	if err := replayer.StartEvents(context.Background(), time.Now(), func(context.Context, LogEvent) {}); err != nil {
+		t.Errorf("Expected only a warning, got %s", err)
+	}
+}
+
+func TestSuggestTimeFormats(t *testing.T) {
+	tests := []struct {
+		value string
+		expected string
+	}{
+		{"05/Jan/2024:13:04:05 +0100", "02/Jan/2006:15:04:05 -0700"},
+		{"2024-01-05 13:04:05", "2006-01-02 15:04:05"},
+		// Not parseable by any format, but closest to the slash separated one
+		{"2024/01/05 13:04:05.123", "2006/01/02 15:04:05"},
+	}
+	for _, test := range tests {
+		suggestions := SuggestTimeFormats(test.value)
+		if len(suggestions) == 0 || suggestions[0] != test.expected {
+			t.Errorf("Expected %q to be suggested first for %q, got %q", test.expected, test.value, suggestions)
+		}
+	}
+	if suggestions := SuggestTimeFormats("request-42"); len(suggestions) > 0 {
+		t.Errorf("Expected no suggestions, got %q", suggestions)
+	}
+}
@@ -0,0 +1,198 @@
+package logs
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// TimestampMatcher finds the timestamps of a TimestampFormat in lines. If the
+// regex of the format has named groups, e.g. for a date and a time split by
+// other tokens, the timestamp is made up of their values concatenated with the
+// Template of the format. Otherwise it is the value of the first subgroup.
+type TimestampMatcher struct {
+	rx *regexp.Regexp
+	parts []templatePart // nil if the regex has no named groups
+}
+
+// templatePart is literal text or a reference to a named group of a time
+// template.
+type templatePart struct {
+	text string
+	group int // index of the subgroup, -1 for literal text
+}
+
+// NewTimestampMatcher compiles the regex and the template of the given format.
+func NewTimestampMatcher(f TimestampFormat) (*TimestampMatcher, error) {
+	rx, err := regexp.Compile(f.Regex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time regex: %s, err: %w", f.Regex, err)
+	}
+	if rx.NumSubexp() < 1 {
+		return nil, fmt.Errorf("invalid time regex: %s, must have a subgroup for the timestamp", f.Regex)
+	}
+	m := &TimestampMatcher{rx: rx}
+	var names []string
+	for _, name := range rx.SubexpNames() {
+		if len(name) > 0 {
+			names = append(names, "{"+name+"}")
+		}
+	}
+	if len(names) == 0 {
+		if len(f.Template) > 0 {
+			return nil, fmt.Errorf("invalid time template: %s, time regex %s has no named groups", f.Template, f.Regex)
+		}
+		return m, nil
+	}
+	template := f.Template
+	if len(template) == 0 {
+		template = strings.Join(names, " ")
+	}
+	if m.parts, err = parseTimeTemplate(rx, template); err != nil {
+		return nil, fmt.Errorf("invalid time template: %s, %w", template, err)
+	}
+	return m, nil
+}
+
+// parseTimeTemplate splits template into literal text and references to the
+// named groups of rx like "{date}". Groups must be separated by text, so a
+// formatted timestamp can be split into the values of the groups again.
+func parseTimeTemplate(rx *regexp.Regexp, template string) ([]templatePart, error) {
+	var parts []templatePart
+	for len(template) > 0 {
+		start := strings.Index(template, "{")
+		if start < 0 {
+			parts = append(parts, templatePart{text: template, group: -1})
+			break
+		}
+		if start > 0 {
+			parts = append(parts, templatePart{text: template[:start], group: -1})
+		}
+		end := strings.Index(template[start:], "}")
+		if end < 0 {
+			return nil, fmt.Errorf("unclosed group reference")
+		}
+		name := template[start+1 : start+end]
+		group := rx.SubexpIndex(name)
+		if group < 0 {
+			return nil, fmt.Errorf("time regex has no group named %q", name)
+		}
+		if len(parts) > 0 && parts[len(parts)-1].group >= 0 {
+			return nil, fmt.Errorf("groups %s must be separated by text", name)
+		}
+		parts = append(parts, templatePart{group: group})
+		template = template[start+end+1:]
+	}
+	if !slices.ContainsFunc(parts, func(p templatePart) bool { return p.group >= 0 }) {
+		return nil, fmt.Errorf("template references no group")
+	}
+	return parts, nil
+}
+
+// Find returns the first timestamp in line and the positions of the matched
+// groups as start and end pairs, in the order of the template. ok is false if
+// the regex does not match.
+func (m *TimestampMatcher) Find(line string) (value string, spans []int, ok bool) {
+	return m.value(line, m.rx.FindStringSubmatchIndex(line))
+}
+
+// Cut returns the first timestamp in line and the line with the matched
+// groups removed, e.g. to compare lines regardless of their timestamps. at is
+// the position in rest where the first group was. ok is false if the regex
+// does not match.
+func (m *TimestampMatcher) Cut(line string) (value, rest string, at int, ok bool) {
+	value, spans, ok := m.Find(line)
+	if !ok {
+		return "", line, 0, false
+	}
+	order := make([]int, 0, len(spans)/2)
+	for i := 0; i < len(spans); i += 2 {
+		order = append(order, i)
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return spans[a] - spans[b]
+	})
+	var sb strings.Builder
+	pos := 0
+	for _, i := range order {
+		if spans[i] < pos {
+			continue
+		}
+		sb.WriteString(line[pos:spans[i]])
+		pos = spans[i+1]
+	}
+	sb.WriteString(line[pos:])
+	return value, sb.String(), spans[order[0]], true
+}
+
+// FindAll returns all timestamps in line and the positions of their groups,
+// see Find.
+func (m *TimestampMatcher) FindAll(line string) (values []string, spans [][]int) {
+	for _, match := range m.rx.FindAllStringSubmatchIndex(line, -1) {
+		if value, s, ok := m.value(line, match); ok {
+			values = append(values, value)
+			spans = append(spans, s)
+		}
+	}
+	return values, spans
+}
+
+// value returns the timestamp of the given submatch indices of the regex in
+// line, see Find.
+func (m *TimestampMatcher) value(line string, match []int) (string, []int, bool) {
+	if len(match) < 4 {
+		return "", nil, false
+	}
+	if m.parts == nil {
+		if match[2] < 0 {
+			return "", nil, false
+		}
+		return line[match[2]:match[3]], match[2:4], true
+	}
+	var sb strings.Builder
+	var spans []int
+	for _, p := range m.parts {
+		if p.group < 0 {
+			sb.WriteString(p.text)
+			continue
+		}
+		start, end := match[2*p.group], match[2*p.group+1]
+		if start < 0 {
+			return "", nil, false
+		}
+		sb.WriteString(line[start:end])
+		spans = append(spans, start, end)
+	}
+	return sb.String(), spans, true
+}
+
+// split splits a timestamp formatted like the values returned by Find into
+// the values of its groups, in the order of the template. ok is false if the
+// timestamp does not fit the template.
+func (m *TimestampMatcher) split(value string) ([]string, bool) {
+	if m.parts == nil {
+		return []string{value}, true
+	}
+	var values []string
+	for i, p := range m.parts {
+		if p.group < 0 {
+			rest, ok := strings.CutPrefix(value, p.text)
+			if !ok {
+				return nil, false
+			}
+			value = rest
+			continue
+		}
+		end := len(value)
+		if i+1 < len(m.parts) {
+			end = strings.Index(value, m.parts[i+1].text)
+			if end < 0 {
+				return nil, false
+			}
+		}
+		values = append(values, value[:end])
+		value = value[end:]
+	}
+	return values, len(value) == 0
+}
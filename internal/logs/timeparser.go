@@ -0,0 +1,93 @@
+package logs
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TimeParser parses and formats timestamps that time.Parse cannot handle,
+// e.g. seconds since boot or localized month names. Registered parsers are
+// used by time formats whose Format is the name of the parser.
+type TimeParser struct {
+	// Parse parses a timestamp extracted by the time regex.
+	Parse func(value string) (time.Time, error)
+	// Format formats a time to replace the timestamp in the line. It should
+	// produce a timestamp Parse can read.
+	Format func(t time.Time) string
+}
+
+var (
+	timeParsersMu sync.RWMutex
+	timeParsers = map[string]*TimeParser{
+		"unix": {Parse: parseUnix(time.Second), Format: formatUnix(time.Second)},
+		"unix_ms": {Parse: parseUnix(time.Millisecond), Format: formatUnix(time.Millisecond)},
+	}
+)
+
+// RegisterTimeParser registers a parser under the given name, replacing a
+// parser registered before. Time formats whose Format is name use the parser
+// instead of time.Parse and Time.Format. Parsers must be registered before
+// the LogReplayer using them is created. The parsers "unix" and "unix_ms" for
+// epoch timestamps in seconds and milliseconds are registered by default.
+func RegisterTimeParser(name string, p TimeParser) error {
+	if len(name) == 0 {
+		return errors.New("name of the time parser must not be empty")
+	}
+	if p.Parse == nil || p.Format == nil {
+		return errors.New("time parser must have a Parse and a Format function")
+	}
+	timeParsersMu.Lock()
+	defer timeParsersMu.Unlock()
+	timeParsers[name] = &p
+	return nil
+}
+
+// lookupTimeParser returns the parser registered under the given name, or nil
+// if the name is no registered parser but a time layout.
+func lookupTimeParser(name string) *TimeParser {
+	timeParsersMu.RLock()
+	defer timeParsersMu.RUnlock()
+	return timeParsers[name]
+}
+
+// ParseTime parses value with the parser registered as format, or with
+// time.Parse if format is a time layout.
+func ParseTime(format, value string) (time.Time, error) {
+	if p := lookupTimeParser(format); p != nil {
+		return p.Parse(value)
+	}
+	return time.Parse(format, value)
+}
+
+// parseUnix returns a function parsing epoch timestamps in the given unit,
+// with optional fractional digits.
+func parseUnix(unit time.Duration) func(string) (time.Time, error) {
+	return func(value string) (time.Time, error) {
+		// Parse the integer part separately to keep the full precision
+		whole, frac, _ := strings.Cut(value, ".")
+		n, err := strconv.ParseInt(whole, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		t := time.Unix(0, n*int64(unit))
+		if len(frac) > 0 {
+			f, err := strconv.ParseFloat("0."+frac, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			t = t.Add(time.Duration(f * float64(unit)))
+		}
+		return t, nil
+	}
+}
+
+// formatUnix returns a function formatting times as epoch timestamps in the
+// given unit.
+func formatUnix(unit time.Duration) func(time.Time) string {
+	return func(t time.Time) string {
+		return strconv.FormatInt(t.UnixNano()/int64(unit), 10)
+	}
+}
@@ -0,0 +1,70 @@
+package logs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegisterTimeParser(t *testing.T) {
+	// dmesg timestamps are seconds since boot
+	boot := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := RegisterTimeParser("uptime", TimeParser{
+		Parse: func(value string) (time.Time, error) {
+			s, err := strconv.ParseFloat(value, 64)
+			return boot.Add(time.Duration(s * float64(time.Second))), err
+		},
+		Format: func(t time.Time) string {
+			return fmt.Sprintf("%.6f", t.Sub(boot).Seconds())
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to register parser: %s", err)
+	}
+	source := stringSource{name: "dmesg", content: "[    1.500000] usb 1-1: new device\n[    3.000000] eth0: link up\n"}
+	replayer, err := NewPipelineReplayer([]Source{source}, ReplayerOptions{
+		FilterRegex: ".*",
+		TimeRegex: `^\[\s*(\d+\.\d+)\]`,
+		TimeFormat: "uptime",
+		Speed: 100,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+
+	var lines []string
+	var original []time.Time
+	start := boot.Add(time.Hour)
+	err = replayer.StartEvents(context.Background(), start, func(_ context.Context, e LogEvent) {
+		lines = append(lines, e.Line)
+		original = append(original, e.OriginalTime)
+	})
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "[    3600.00") {
+		t.Fatalf("Expected the timestamp to be formatted by the parser, got %q", lines)
+	}
+	if d := original[1].Sub(original[0]); d != 1500*time.Millisecond {
+		t.Errorf("Expected the lines to be logged 1.5s apart, got %s", d)
+	}
+}
+
+func TestParseTime_Unix(t *testing.T) {
+	parsed, err := ParseTime("unix_ms", "1700000000123")
+	if err != nil {
+		t.Fatalf("Failed to parse time: %s", err)
+	}
+	if !parsed.Equal(time.UnixMilli(1700000000123)) {
+		t.Errorf("Unexpected time %s", parsed)
+	}
+	if _, err := ParseTime("unix", "yesterday"); err == nil {
+		t.Error("Expected an error for an invalid epoch timestamp")
+	}
+	if err := RegisterTimeParser("broken", TimeParser{}); err == nil {
+		t.Error("Expected an error for a parser without functions")
+	}
+}
@@ -0,0 +1,268 @@
+package mapping
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// function is a function that can be called in a mapping.
+type function struct {
+	minArgs, maxArgs int
+	// field is set if the first argument must be a field, which is passed as
+	// whether the field exists instead of its value.
+	field bool
+	// pattern is the index of the argument that can be a regex literal, -1
+	// if none.
+	pattern int
+	call func(args []any) (any, error)
+}
+
+// functions are the functions of the mapping language by name.
+var functions = map[string]function{
+	"upcase": {1, 1, false, -1, stringFunction(strings.ToUpper)},
+	"downcase": {1, 1, false, -1, stringFunction(strings.ToLower)},
+	"trim": {1, 1, false, -1, stringFunction(strings.TrimSpace)},
+	"contains": {2, 2, false, -1, stringPredicate(strings.Contains)},
+	"starts_with": {2, 2, false, -1, stringPredicate(strings.HasPrefix)},
+	"ends_with": {2, 2, false, -1, stringPredicate(strings.HasSuffix)},
+	"replace": {3, 3, false, 1, replace},
+	"match": {2, 2, false, 1, match},
+	"parse_regex": {2, 2, false, 1, parseRegex},
+	"split": {2, 2, false, -1, split},
+	"join": {2, 2, false, -1, join},
+	"slice": {2, 3, false, -1, slice},
+	"length": {1, 1, false, -1, length},
+	"exists": {1, 1, true, -1, func(args []any) (any, error) { return args[0], nil }},
+	"to_string": {1, 1, false, -1, func(args []any) (any, error) { return toString(args[0]), nil }},
+	"to_number": {1, 1, false, -1, toNumber},
+	"parse_json": {1, 1, false, -1, func(args []any) (any, error) {
+		s, err := stringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return parseJSON(s)
+	}},
+	"encode_json": {1, 1, false, -1, func(args []any) (any, error) { return string(appendJSON(nil, args[0])), nil }},
+	"sha256": {1, 1, false, -1, stringFunction(func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	})},
+}
+
+// stringArg returns the argument at index i, which must be a string.
+func stringArg(args []any, i int) (string, error) {
+	s, ok := args[i].(string)
+	if !ok {
+		return "", fmt.Errorf("argument %d must be a string, not %s", i+1, typeName(args[i]))
+	}
+	return s, nil
+}
+
+// intArg returns the argument at index i, which must be an integer.
+func intArg(args []any, i int) (int, error) {
+	f, ok := number(args[i])
+	if !ok || f != float64(int(f)) {
+		return 0, fmt.Errorf("argument %d must be an integer, not %s", i+1, toString(args[i]))
+	}
+	return int(f), nil
+}
+
+// stringFunction returns a function of a string argument.
+func stringFunction(f func(string) string) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		s, err := stringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		return f(s), nil
+	}
+}
+
+// stringPredicate returns a function of two string arguments returning a
+// boolean.
+func stringPredicate(f func(string, string) bool) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		s, err := stringArg(args, 0)
+		if err != nil {
+			return nil, err
+		}
+		t, err := stringArg(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		return f(s, t), nil
+	}
+}
+
+// replace implements replace(s, pattern, replacement), replacing all matches
+// of a string or regex. Replacements of regexes can refer to groups like $1.
+func replace(args []any) (any, error) {
+	s, err := stringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	replacement, err := stringArg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	if rx, ok := args[1].(*regexp.Regexp); ok {
+		return rx.ReplaceAllString(s, replacement), nil
+	}
+	old, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	return strings.ReplaceAll(s, old, replacement), nil
+}
+
+// match implements match(s, regex).
+func match(args []any) (any, error) {
+	s, err := stringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	rx, ok := args[1].(*regexp.Regexp)
+	if !ok {
+		return nil, errors.New("argument 2 must be a regex")
+	}
+	return rx.MatchString(s), nil
+}
+
+// parseRegex implements parse_regex(s, regex), returning an object of the
+// named groups of the first match, or null if the regex does not match.
+func parseRegex(args []any) (any, error) {
+	s, err := stringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	rx, ok := args[1].(*regexp.Regexp)
+	if !ok {
+		return nil, errors.New("argument 2 must be a regex")
+	}
+	m := rx.FindStringSubmatch(s)
+	if m == nil {
+		return nil, nil
+	}
+	o := &object{values: map[string]any{}}
+	for i, name := range rx.SubexpNames() {
+		if len(name) > 0 {
+			o.set(name, m[i])
+		}
+	}
+	return o, nil
+}
+
+// split implements split(s, separator).
+func split(args []any) (any, error) {
+	s, err := stringArg(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	sep, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.Split(s, sep)
+	a := make([]any, len(parts))
+	for i, p := range parts {
+		a[i] = p
+	}
+	return a, nil
+}
+
+// join implements join(array, separator).
+func join(args []any) (any, error) {
+	a, ok := args[0].([]any)
+	if !ok {
+		return nil, fmt.Errorf("argument 1 must be an array, not %s", typeName(args[0]))
+	}
+	sep, err := stringArg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]string, len(a))
+	for i, v := range a {
+		parts[i] = toString(v)
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// slice implements slice(s, start, end) on the characters of a string or the
+// elements of an array. Negative indexes count from the end, indexes beyond
+// the end are clamped.
+func slice(args []any) (any, error) {
+	var n int
+	runes, isString := []rune(nil), false
+	switch v := args[0].(type) {
+	case string:
+		runes, isString = []rune(v), true
+		n = len(runes)
+	case []any:
+		n = len(v)
+	default:
+		return nil, fmt.Errorf("argument 1 must be a string or an array, not %s", typeName(args[0]))
+	}
+	bound := func(i int) (int, error) {
+		b, err := intArg(args, i)
+		if b < 0 {
+			b += n
+		}
+		return max(0, min(b, n)), err
+	}
+	start, err := bound(1)
+	if err != nil {
+		return nil, err
+	}
+	end := n
+	if len(args) > 2 {
+		if end, err = bound(2); err != nil {
+			return nil, err
+		}
+	}
+	end = max(start, end)
+	if isString {
+		return string(runes[start:end]), nil
+	}
+	return args[0].([]any)[start:end:end], nil
+}
+
+// length implements length(v), the number of characters of a string or of
+// the elements of an array or object.
+func length(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case string:
+		return float64(utf8.RuneCountInString(v)), nil
+	case []any:
+		return float64(len(v)), nil
+	case *object:
+		return float64(len(v.keys)), nil
+	}
+	return nil, fmt.Errorf("argument 1 must be a string, array or object, not %s", typeName(args[0]))
+}
+
+// toNumber implements to_number(v), converting strings and booleans.
+func toNumber(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", v)
+		}
+		return f, nil
+	case bool:
+		if v {
+			return 1.0, nil
+		}
+		return 0.0, nil
+	}
+	if f, ok := number(args[0]); ok {
+		return f, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to a number", typeName(args[0]))
+}
@@ -0,0 +1,764 @@
+// Package mapping implements a small declarative language for transforming
+// log lines, inspired by the Vector Remap Language. A mapping is a list of
+// statements applied to the fields of each line, e.g.
+//
+//	.level = upcase(.level ?? "info")
+//	del(.password)
+//	drop if .path == "/health"
+//	if starts_with(.path, "/api") { .service = "api" } else { .service = "web" }
+//
+// Mappings are validated when compiled and evaluated without an interpreter
+// of a general purpose language, so they are cheaper than transform scripts
+// for simple manipulations of every line.
+package mapping
+
+import (
+	"bananabacon/internal/debug"
+	"bananabacon/internal/logs"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Line formats, see Compile.
+const (
+	// FormatAuto parses lines that are JSON objects as FormatJSON and all
+	// other lines as FormatRaw.
+	FormatAuto = "auto"
+	// FormatJSON parses lines as JSON objects, the fields of which are the
+	// fields of the mapping. The result is written as a JSON object with the
+	// order of the fields preserved.
+	FormatJSON = "json"
+	// FormatLogfmt parses lines of key=value pairs, like
+	// level=info msg="request done", and writes the result in the same format.
+	FormatLogfmt = "logfmt"
+	// FormatRaw puts the whole line into the field .message. The result is
+	// the field .message if it is the only field and a JSON object otherwise.
+	FormatRaw = "raw"
+)
+
+// variables are the names of the variables a mapping can read.
+var variables = []string{"line", "time", "original_time", "source"}
+
+// Program is a compiled mapping. It is a logs.Transformer and safe for
+// concurrent use.
+type Program struct {
+	statements []statement
+	format string
+}
+
+// Compile parses a mapping for lines of the given format, FormatAuto if
+// empty. Syntax errors, unknown functions and variables, wrong numbers of
+// arguments and invalid regexes are reported with their line and column.
+func Compile(source, format string) (*Program, error) {
+	switch format {
+	case "":
+		format = FormatAuto
+	case FormatAuto, FormatJSON, FormatLogfmt, FormatRaw:
+	default:
+		return nil, fmt.Errorf("invalid mapping format: %s, must be %s, %s, %s or %s", format, FormatAuto,
+			FormatJSON, FormatLogfmt, FormatRaw)
+	}
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mapping: %w", err)
+	}
+	p := &parser{src: source, tokens: tokens}
+	statements, err := p.block(eofToken, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid mapping: %w", err)
+	}
+	return &Program{statements: statements, format: format}, nil
+}
+
+// Transform applies the mapping to the line of the event. It returns false
+// if the line is dropped. If the line cannot be parsed in the format of the
+// mapping or the mapping fails, e.g. because a function gets an argument of
+// the wrong type, the event is emitted unchanged.
+func (p *Program) Transform(e logs.LogEvent) (logs.LogEvent, bool) {
+	line, keep, err := p.Map(e.Line, map[string]any{
+		"line": e.Line, "time": e.Time.Format(time.RFC3339Nano),
+		"original_time": e.OriginalTime.Format(time.RFC3339Nano), "source": e.Source,
+	})
+	if err != nil {
+		debug.Printf("Failed to map line %d of %s: %v", e.LineNumber, e.Source, err)
+		return e, true
+	}
+	e.Line = line
+	return e, keep
+}
+
+// Map applies the mapping to a line with the given values of the variables,
+// which are null if missing. It returns the mapped line and false if the line
+// is dropped.
+func (p *Program) Map(line string, vars map[string]any) (string, bool, error) {
+	format := p.format
+	if format == FormatAuto {
+		format = FormatRaw
+		if trimmed := strings.TrimSpace(line); strings.HasPrefix(trimmed, "{") {
+			format = FormatJSON
+		}
+	}
+	var root *object
+	switch format {
+	case FormatJSON:
+		v, err := parseJSON(line)
+		if err != nil {
+			return "", false, err
+		}
+		var ok bool
+		if root, ok = v.(*object); !ok {
+			return "", false, errors.New("the line is not a JSON object")
+		}
+	case FormatLogfmt:
+		var err error
+		if root, err = parseLogfmt(line); err != nil {
+			return "", false, err
+		}
+	default:
+		root = &object{}
+		root.set("message", line)
+	}
+	s := &state{root: root, vars: vars}
+	if err := s.run(p.statements); err != nil {
+		return "", false, err
+	}
+	if s.dropped {
+		return "", false, nil
+	}
+	switch format {
+	case FormatLogfmt:
+		return formatLogfmt(root), true, nil
+	case FormatRaw:
+		if len(root.keys) == 1 && root.keys[0] == "message" {
+			if message, ok := root.values["message"].(string); ok {
+				return message, true, nil
+			}
+		}
+	}
+	return string(appendJSON(nil, root)), true, nil
+}
+
+// object is a JSON object that keeps the order of its fields.
+type object struct {
+	keys []string
+	values map[string]any
+}
+
+// get returns the value of a field.
+func (o *object) get(key string) (any, bool) {
+	v, ok := o.values[key]
+	return v, ok
+}
+
+// set sets a field, appending it if it is new.
+func (o *object) set(key string, v any) {
+	if o.values == nil {
+		o.values = map[string]any{}
+	}
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = v
+}
+
+// remove removes a field.
+func (o *object) remove(key string) {
+	if _, ok := o.values[key]; !ok {
+		return
+	}
+	delete(o.values, key)
+	for i, k := range o.keys {
+		if k == key {
+			o.keys = append(o.keys[:i], o.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// state is the state of a mapping applied to a line.
+type state struct {
+	root *object
+	vars map[string]any
+	dropped bool
+}
+
+// run executes statements until the line is dropped.
+func (s *state) run(statements []statement) error {
+	for _, st := range statements {
+		if err := st.exec(s); err != nil {
+			return err
+		}
+		if s.dropped {
+			return nil
+		}
+	}
+	return nil
+}
+
+// lookup returns the value at a path, nil and false if it does not exist.
+// Numeric segments index arrays.
+func (s *state) lookup(path []string) (any, bool) {
+	var v any = s.root
+	for _, segment := range path {
+		switch c := v.(type) {
+		case *object:
+			var ok bool
+			if v, ok = c.get(segment); !ok {
+				return nil, false
+			}
+		case []any:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(c) {
+				return nil, false
+			}
+			v = c[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// parent returns the container holding the last segment of a path, creating
+// missing objects on the way if create is set. It returns nil if the
+// container does not exist.
+func (s *state) parent(path []string, create bool) (any, error) {
+	var v any = s.root
+	for i, segment := range path[:len(path)-1] {
+		switch c := v.(type) {
+		case *object:
+			next, ok := c.get(segment)
+			if !ok || next == nil {
+				if !create {
+					return nil, nil
+				}
+				next = &object{}
+				c.set(segment, next)
+			}
+			v = next
+		case []any:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(c) {
+				return nil, fmt.Errorf("no element %s in .%s", segment, strings.Join(path[:i], "."))
+			}
+			v = c[index]
+		default:
+			if !create {
+				return nil, nil
+			}
+			return nil, fmt.Errorf(".%s is a %s, not an object", strings.Join(path[:i+1], "."), typeName(c))
+		}
+	}
+	return v, nil
+}
+
+// statement is a statement of a mapping.
+type statement interface {
+	exec(s *state) error
+}
+
+// assignStatement sets a field, like .a.b = expr.
+type assignStatement struct {
+	path []string
+	value expression
+}
+
+func (a assignStatement) exec(s *state) error {
+	v, err := a.value.eval(s)
+	if err != nil {
+		return err
+	}
+	parent, err := s.parent(a.path, true)
+	if err != nil {
+		return err
+	}
+	key := a.path[len(a.path)-1]
+	switch c := parent.(type) {
+	case *object:
+		c.set(key, v)
+	case []any:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(c) {
+			return fmt.Errorf("cannot assign to .%s: no such element", strings.Join(a.path, "."))
+		}
+		c[i] = v
+	default:
+		return fmt.Errorf("cannot assign to .%s", strings.Join(a.path, "."))
+	}
+	return nil
+}
+
+// deleteStatement removes a field, like del(.a.b). Missing fields are
+// ignored.
+type deleteStatement struct {
+	path []string
+}
+
+func (d deleteStatement) exec(s *state) error {
+	parent, err := s.parent(d.path, false)
+	if err != nil {
+		return err
+	}
+	if o, ok := parent.(*object); ok {
+		o.remove(d.path[len(d.path)-1])
+	}
+	return nil
+}
+
+// dropStatement drops the line, if its condition is true or it has none.
+type dropStatement struct {
+	cond expression
+}
+
+func (d dropStatement) exec(s *state) error {
+	if d.cond == nil {
+		s.dropped = true
+		return nil
+	}
+	b, err := evalBool(s, d.cond, "the condition of drop")
+	s.dropped = b
+	return err
+}
+
+// ifStatement executes then if its condition is true and otherwise
+// otherwise.
+type ifStatement struct {
+	cond expression
+	then, otherwise []statement
+}
+
+func (i ifStatement) exec(s *state) error {
+	b, err := evalBool(s, i.cond, "the condition of if")
+	if err != nil {
+		return err
+	}
+	if b {
+		return s.run(i.then)
+	}
+	return s.run(i.otherwise)
+}
+
+// expression is an expression of a mapping. Its values are nil, bool,
+// float64, json.Number, string, []any and *object.
+type expression interface {
+	eval(s *state) (any, error)
+}
+
+// evalBool evaluates an expression that must be a boolean.
+func evalBool(s *state, e expression, what string) (bool, error) {
+	v, err := e.eval(s)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%s must be a boolean, not %s", what, typeName(v))
+	}
+	return b, nil
+}
+
+// literal is a constant.
+type literal struct {
+	value any
+}
+
+func (l literal) eval(*state) (any, error) {
+	return l.value, nil
+}
+
+// pathExpression reads a field, null if it does not exist. The path . reads
+// the whole line.
+type pathExpression struct {
+	path []string
+}
+
+func (p pathExpression) eval(s *state) (any, error) {
+	v, _ := s.lookup(p.path)
+	return v, nil
+}
+
+// variableExpression reads a variable.
+type variableExpression struct {
+	name string
+}
+
+func (v variableExpression) eval(s *state) (any, error) {
+	return s.vars[v.name], nil
+}
+
+// notExpression negates a boolean.
+type notExpression struct {
+	operand expression
+}
+
+func (n notExpression) eval(s *state) (any, error) {
+	b, err := evalBool(s, n.operand, "the operand of !")
+	return !b, err
+}
+
+// binaryExpression applies a binary operator.
+type binaryExpression struct {
+	op string
+	left, right expression
+}
+
+func (b binaryExpression) eval(s *state) (any, error) {
+	switch b.op {
+	case "??":
+		// Errors of the left operand are handled like null
+		if l, err := b.left.eval(s); err == nil && l != nil {
+			return l, nil
+		}
+		return b.right.eval(s)
+	case "&&", "||":
+		l, err := evalBool(s, b.left, "the operands of "+b.op)
+		if err != nil || l == (b.op == "||") {
+			return l, err
+		}
+		return evalBool(s, b.right, "the operands of "+b.op)
+	}
+	l, err := b.left.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	r, err := b.right.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	switch b.op {
+	case "==":
+		return equal(l, r), nil
+	case "!=":
+		return !equal(l, r), nil
+	case "+":
+		return add(l, r)
+	}
+	c, err := compare(l, r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compare with %s: %w", b.op, err)
+	}
+	switch b.op {
+	case "<":
+		return c < 0, nil
+	case ">":
+		return c > 0, nil
+	case "<=":
+		return c <= 0, nil
+	}
+	return c >= 0, nil
+}
+
+// callExpression calls a function.
+type callExpression struct {
+	name string
+	fn function
+	args []expression
+}
+
+func (c callExpression) eval(s *state) (any, error) {
+	args := make([]any, len(c.args))
+	for i, arg := range c.args {
+		if c.fn.field && i == 0 {
+			_, args[i] = s.lookup(arg.(pathExpression).path)
+			continue
+		}
+		var err error
+		if args[i], err = arg.eval(s); err != nil {
+			return nil, err
+		}
+	}
+	v, err := c.fn.call(args)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", c.name, err)
+	}
+	return v, nil
+}
+
+// number returns the value of a number, false if v is not a number.
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// equal returns whether two values are equal. Numbers are compared by value.
+func equal(a, b any) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	switch x := a.(type) {
+	case []any:
+		y, ok := b.([]any)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	case *object:
+		y, ok := b.(*object)
+		if !ok || len(x.keys) != len(y.keys) {
+			return false
+		}
+		for _, k := range x.keys {
+			if v, ok := y.get(k); !ok || !equal(x.values[k], v) {
+				return false
+			}
+		}
+		return true
+	case *regexp.Regexp:
+		return false
+	}
+	return a == b
+}
+
+// compare orders two numbers or two strings.
+func compare(a, b any) (int, error) {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	if x, ok := a.(string); ok {
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), nil
+		}
+	}
+	return 0, fmt.Errorf("%s and %s", typeName(a), typeName(b))
+}
+
+// add adds two numbers or concatenates two values if one of them is a
+// string.
+func add(a, b any) (any, error) {
+	if x, ok := number(a); ok {
+		if y, ok := number(b); ok {
+			return x + y, nil
+		}
+	}
+	_, aString := a.(string)
+	_, bString := b.(string)
+	if (aString || bString) && a != nil && b != nil {
+		return toString(a) + toString(b), nil
+	}
+	return nil, fmt.Errorf("cannot add %s and %s", typeName(a), typeName(b))
+}
+
+// typeName returns the name of the type of a value used in errors.
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64, json.Number:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case *object:
+		return "object"
+	case *regexp.Regexp:
+		return "regex"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// toString returns a string as is and other values as JSON.
+func toString(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return string(appendJSON(nil, v))
+}
+
+// parseJSON parses a JSON value, keeping the order of the fields of objects
+// and the text of numbers.
+func parseJSON(s string) (any, error) {
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	v, err := decodeJSON(d)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	if _, err := d.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("invalid JSON: unexpected data after the value")
+	}
+	return v, nil
+}
+
+// decodeJSON decodes the next value of d.
+func decodeJSON(d *json.Decoder) (any, error) {
+	t, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case json.Delim('{'):
+		o := &object{values: map[string]any{}}
+		for d.More() {
+			k, err := d.Token()
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeJSON(d)
+			if err != nil {
+				return nil, err
+			}
+			o.set(k.(string), v)
+		}
+		_, err := d.Token()
+		return o, err
+	case json.Delim('['):
+		a := []any{}
+		for d.More() {
+			v, err := decodeJSON(d)
+			if err != nil {
+				return nil, err
+			}
+			a = append(a, v)
+		}
+		_, err := d.Token()
+		return a, err
+	}
+	return t, nil
+}
+
+// appendJSON appends the JSON encoding of a value to b. HTML characters are
+// not escaped.
+func appendJSON(b []byte, v any) []byte {
+	switch x := v.(type) {
+	case nil:
+		return append(b, "null"...)
+	case bool:
+		return strconv.AppendBool(b, x)
+	case float64:
+		return strconv.AppendFloat(b, x, 'f', -1, 64)
+	case json.Number:
+		return append(b, x...)
+	case string:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		enc.Encode(x)
+		return append(b, bytes.TrimSuffix(buf.Bytes(), []byte("\n"))...)
+	case []any:
+		b = append(b, '[')
+		for i, e := range x {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSON(b, e)
+		}
+		return append(b, ']')
+	case *object:
+		b = append(b, '{')
+		for i, k := range x.keys {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSON(b, k)
+			b = append(b, ':')
+			b = appendJSON(b, x.values[k])
+		}
+		return append(b, '}')
+	case *regexp.Regexp:
+		return appendJSON(b, x.String())
+	}
+	return append(b, "null"...)
+}
+
+// parseLogfmt parses a line of key=value pairs. Values may be quoted, keys
+// without a value are true.
+func parseLogfmt(line string) (*object, error) {
+	o := &object{values: map[string]any{}}
+	i := 0
+	for i < len(line) {
+		if line[i] == ' ' || line[i] == '\t' {
+			i++
+			continue
+		}
+		start := i
+		for i < len(line) && line[i] != '=' && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		key := line[start:i]
+		if i >= len(line) || line[i] != '=' {
+			o.set(key, true)
+			continue
+		}
+		i++
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, fmt.Errorf("invalid logfmt: unterminated value of %s", key)
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid logfmt: invalid value of %s: %w", key, err)
+			}
+			o.set(key, value)
+			i = end + 1
+			continue
+		}
+		start = i
+		for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+			i++
+		}
+		o.set(key, line[start:i])
+	}
+	return o, nil
+}
+
+// formatLogfmt formats the fields of an object as key=value pairs. Values
+// with spaces, quotes or equal signs are quoted, objects and arrays are
+// written as JSON.
+func formatLogfmt(o *object) string {
+	var b []byte
+	for i, k := range o.keys {
+		if i > 0 {
+			b = append(b, ' ')
+		}
+		b = append(b, k...)
+		b = append(b, '=')
+		v := o.values[k]
+		if v == nil {
+			continue
+		}
+		s := toString(v)
+		if len(s) == 0 || strings.ContainsAny(s, " \t\"=\\") {
+			b = strconv.AppendQuote(b, s)
+		} else {
+			b = append(b, s...)
+		}
+	}
+	return string(b)
+}
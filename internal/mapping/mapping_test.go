@@ -0,0 +1,113 @@
+package mapping
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMapping_JSON(t *testing.T) {
+	p, err := Compile(`
+# Normalize the level and drop health checks
+.level = upcase(.level ?? "info")
+del(.password)
+drop if .path == "/health"
+if starts_with(.path, "/api") && .status >= 500 {
+	.alert = true
+} else if exists(.user.name) {
+	.user.id = sha256(.user.name)
+	del(.user.name)
+}
+.source = $source; ."request time" = to_number(.duration) + 1
+`, FormatAuto)
+	if err != nil {
+		t.Fatalf("Failed to compile mapping: %s", err)
+	}
+	vars := map[string]any{"source": "app.log"}
+	for _, test := range []struct {
+		line, expected string
+		keep bool
+	}{
+		{`{"path":"/api/x","status":503,"password":"secret","duration":"1.5"}`,
+			`{"path":"/api/x","status":503,"duration":"1.5","level":"INFO","alert":true,"source":"app.log","request time":2.5}`,
+			true},
+		{`{"level":"warn","path":"/web","user":{"name":"alice"},"duration":2}`,
+			`{"level":"WARN","path":"/web","user":{"id":"2bd806c97f0e00af1a1fc3328fa763a9269723c8db8fac4f93af71db186d6e90"},"duration":2,"source":"app.log","request time":3}`,
+			true},
+		{`{"path":"/health"}`, "", false},
+	} {
+		line, keep, err := p.Map(test.line, vars)
+		if err != nil {
+			t.Fatalf("Failed to map %s: %s", test.line, err)
+		}
+		if keep != test.keep || line != test.expected {
+			t.Errorf("Expected %s (%t) for %s, got %s (%t)", test.expected, test.keep, test.line, line, keep)
+		}
+	}
+}
+
+func TestMapping_Formats(t *testing.T) {
+	for _, test := range []struct {
+		mapping, format, line, expected string
+	}{
+		{`.message = replace(.message, /\d+\.\d+\.\d+\.\d+/, "x.x.x.x")`, FormatAuto,
+			"login from 10.0.0.1 <ok>", "login from x.x.x.x <ok>"},
+		{`.fields = parse_regex(.message, /user=(?P<user>\w+)/)`, FormatRaw, "login user=bob",
+			`{"message":"login user=bob","fields":{"user":"bob"}}`},
+		{`.msg = downcase(.msg) + "!"; .n = length(.msg); del(.debug)`, FormatLogfmt,
+			`level=info msg="Request Done" debug`, `level=info msg="request done!" n=13`},
+		{`.tags = join(slice(split(.tags, ","), -2), "|")`, FormatJSON, `{"tags":"a,b,c"}`, `{"tags":"b|c"}`},
+	} {
+		p, err := Compile(test.mapping, test.format)
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %s", test.mapping, err)
+		}
+		line, _, err := p.Map(test.line, nil)
+		if err != nil {
+			t.Fatalf("Failed to map %s: %s", test.line, err)
+		}
+		if line != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, line)
+		}
+	}
+}
+
+func TestMapping_RuntimeErrors(t *testing.T) {
+	for _, mapping := range []string{`.a = upcase(.n)`, `drop if .a`, `.n.x = 1`, `.x = .a + null`} {
+		p, err := Compile(mapping, FormatJSON)
+		if err != nil {
+			t.Fatalf("Failed to compile %s: %s", mapping, err)
+		}
+		if _, _, err := p.Map(`{"a":"b","n":1}`, nil); err == nil {
+			t.Errorf("Expected an error for %s", mapping)
+		}
+	}
+	p, _ := Compile(`.a = "b"`, FormatJSON)
+	if _, _, err := p.Map("not json", nil); err == nil {
+		t.Error("Expected an error for a line that is not JSON")
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	for _, test := range []struct {
+		mapping, expected string
+	}{
+		{".a = 1\n.b = frobnicate(.a)", "line 2, column 6: unknown function frobnicate"},
+		{`.a = upcase(.a, .b)`, "upcase takes 1 arguments, got 2"},
+		{`.a = match(.a, /(/)`, "invalid regex"},
+		{`.a = /x/`, "regexes are only allowed"},
+		{`.a = $nope`, "unknown variable $nope"},
+		{`.a = exists("x")`, "the argument of exists must be a field"},
+		{"if .a { .b = 1", `expected "}", found end of mapping`},
+		{`.a = 1 .b = 2`, "expected end of statement"},
+		{`.a = "unterminated`, "line 1, column 6: unterminated string"},
+		{`. = 1`, "cannot assign to ."},
+	} {
+		_, err := Compile(test.mapping, "")
+		if err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected error containing %q for %s, got %v", test.expected, test.mapping, err)
+		}
+	}
+	if _, err := Compile(`.a = 1`, "xml"); err == nil {
+		t.Error("Expected an error for an invalid format")
+	}
+}
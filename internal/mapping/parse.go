@@ -0,0 +1,514 @@
+package mapping
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// tokenKind is the kind of a token of a mapping.
+type tokenKind int
+
+const (
+	eofToken tokenKind = iota
+	separatorToken // line break or ";"
+	identToken
+	stringToken
+	numberToken
+	regexToken
+	pathToken
+	variableToken
+	operatorToken
+)
+
+// token is a token of a mapping. For paths, text holds the segments
+// separated by "\x00", for strings and regexes the unescaped value.
+type token struct {
+	kind tokenKind
+	text string
+	pos int
+}
+
+// operators are the operators and punctuation, longest first.
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "??", "=", "<", ">", "!", "+", "(", ")", "{", "}", ","}
+
+// lex splits a mapping into tokens.
+func lex(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		start := i
+		switch {
+		case c == '\n' || c == ';':
+			tokens = append(tokens, token{kind: separatorToken, pos: i})
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '"':
+			end, value, err := lexString(src, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: stringToken, text: value, pos: start})
+			i = end
+		case c == '/':
+			end := i + 1
+			for end < len(src) && src[end] != '/' && src[end] != '\n' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) || src[end] != '/' {
+				return nil, errorAt(src, start, "unterminated regex")
+			}
+			tokens = append(tokens, token{kind: regexToken, text: strings.ReplaceAll(src[i+1:end], `\/`, "/"),
+				pos: start})
+			i = end + 1
+		case c == '.':
+			end, segments, err := lexPath(src, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: pathToken, text: strings.Join(segments, "\x00"), pos: start})
+			i = end
+		case c == '$':
+			end := i + 1 + identLength(src[i+1:])
+			if end == i+1 {
+				return nil, errorAt(src, start, "missing variable name after $")
+			}
+			tokens = append(tokens, token{kind: variableToken, text: src[i+1 : end], pos: start})
+			i = end
+		case c >= '0' && c <= '9' || c == '-' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			end := i + 1
+			for end < len(src) && (src[end] >= '0' && src[end] <= '9' || src[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: numberToken, text: src[i:end], pos: start})
+			i = end
+		case identLength(src[i:]) > 0:
+			end := i + identLength(src[i:])
+			tokens = append(tokens, token{kind: identToken, text: src[i:end], pos: start})
+			i = end
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if len(op) == 0 {
+				r, _ := utf8.DecodeRuneInString(src[i:])
+				return nil, errorAt(src, start, fmt.Sprintf("unexpected character %q", r))
+			}
+			tokens = append(tokens, token{kind: operatorToken, text: op, pos: start})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: eofToken, pos: len(src)}), nil
+}
+
+// identLength returns the length of the identifier at the start of s.
+func identLength(s string) int {
+	n := 0
+	for n < len(s) {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if r != '_' && !unicode.IsLetter(r) && (n == 0 || !unicode.IsDigit(r)) {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+// lexString returns the end and the unescaped value of the string literal
+// starting at i.
+func lexString(src string, i int) (int, string, error) {
+	end := i + 1
+	for end < len(src) && src[end] != '"' && src[end] != '\n' {
+		if src[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(src) || src[end] != '"' {
+		return 0, "", errorAt(src, i, "unterminated string")
+	}
+	value, err := strconv.Unquote(src[i : end+1])
+	if err != nil {
+		return 0, "", errorAt(src, i, "invalid string: "+err.Error())
+	}
+	return end + 1, value, nil
+}
+
+// lexPath returns the end and the segments of the path starting at i, like
+// .user.name or ."user agent". The root path "." has no segments.
+func lexPath(src string, i int) (int, []string, error) {
+	var segments []string
+	for i < len(src) && src[i] == '.' {
+		switch {
+		case i+1 < len(src) && src[i+1] == '"':
+			end, value, err := lexString(src, i+1)
+			if err != nil {
+				return 0, nil, err
+			}
+			segments = append(segments, value)
+			i = end
+		case identLength(src[i+1:]) > 0 || i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			end := i + 1
+			for end < len(src) && (src[end] == '_' || src[end] == '-' || unicode.IsLetter(rune(src[end])) ||
+				unicode.IsDigit(rune(src[end])) || src[end] >= utf8.RuneSelf) {
+				end++
+			}
+			segments = append(segments, src[i+1:end])
+			i = end
+		case len(segments) == 0:
+			return i + 1, nil, nil
+		default:
+			return 0, nil, errorAt(src, i, "missing field name after .")
+		}
+	}
+	return i, segments, nil
+}
+
+// errorAt returns an error at the given position of the mapping.
+func errorAt(src string, pos int, msg string) error {
+	line := strings.Count(src[:pos], "\n") + 1
+	column := pos - strings.LastIndex(src[:pos], "\n")
+	return fmt.Errorf("line %d, column %d: %s", line, column, msg)
+}
+
+// parser parses the tokens of a mapping into statements.
+type parser struct {
+	src string
+	tokens []token
+	i int
+}
+
+// peek returns the next token.
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+// next returns the next token and advances.
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != eofToken {
+		p.i++
+	}
+	return t
+}
+
+// is returns whether the next token is the given operator or keyword.
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == operatorToken || t.kind == identToken) && t.text == text
+}
+
+// expect consumes the given operator or keyword.
+func (p *parser) expect(text string) error {
+	if !p.is(text) {
+		return p.errorf("expected %q", text)
+	}
+	p.next()
+	return nil
+}
+
+// errorf returns an error at the next token.
+func (p *parser) errorf(format string, args ...any) error {
+	t := p.peek()
+	msg := fmt.Sprintf(format, args...)
+	switch t.kind {
+	case eofToken:
+		msg += ", found end of mapping"
+	case separatorToken:
+		msg += ", found end of line"
+	default:
+		msg += fmt.Sprintf(", found %q", p.src[t.pos:min(t.pos+max(len(t.text), 1), len(p.src))])
+	}
+	return errorAt(p.src, t.pos, msg)
+}
+
+// skipSeparators skips line breaks and semicolons.
+func (p *parser) skipSeparators() {
+	for p.peek().kind == separatorToken {
+		p.next()
+	}
+}
+
+// block parses statements until the given closing token, which is not
+// consumed.
+func (p *parser) block(closing tokenKind, closingText string) ([]statement, error) {
+	var stmts []statement
+	for {
+		p.skipSeparators()
+		if t := p.peek(); t.kind == closing && t.text == closingText || t.kind == eofToken {
+			return stmts, nil
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+		if t := p.peek(); t.kind != separatorToken && t.kind != eofToken && !(t.kind == closing &&
+			t.text == closingText) {
+			return nil, p.errorf("expected end of statement")
+		}
+	}
+}
+
+// statement parses a single statement.
+func (p *parser) statement() (statement, error) {
+	t := p.peek()
+	switch {
+	case t.kind == identToken && t.text == "drop":
+		p.next()
+		if !p.is("if") {
+			return dropStatement{}, nil
+		}
+		p.next()
+		cond, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return dropStatement{cond: cond}, nil
+	case t.kind == identToken && t.text == "del":
+		p.next()
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		path := p.next()
+		if path.kind != pathToken || len(path.text) == 0 {
+			p.i--
+			return nil, p.errorf("expected a field")
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return deleteStatement{path: splitPath(path.text)}, nil
+	case t.kind == identToken && t.text == "if":
+		return p.ifStatement()
+	case t.kind == pathToken:
+		p.next()
+		if len(t.text) == 0 {
+			return nil, errorAt(p.src, t.pos, "cannot assign to .")
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		value, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return assignStatement{path: splitPath(t.text), value: value}, nil
+	}
+	return nil, p.errorf("expected a statement")
+}
+
+// ifStatement parses "if cond { ... } else if cond { ... } else { ... }".
+func (p *parser) ifStatement() (statement, error) {
+	p.next()
+	cond, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	then, err := p.block(operatorToken, "}")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+	s := ifStatement{cond: cond, then: then}
+	if !p.is("else") {
+		return s, nil
+	}
+	p.next()
+	if p.is("if") {
+		elseIf, err := p.ifStatement()
+		if err != nil {
+			return nil, err
+		}
+		s.otherwise = []statement{elseIf}
+		return s, nil
+	}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	if s.otherwise, err = p.block(operatorToken, "}"); err != nil {
+		return nil, err
+	}
+	return s, p.expect("}")
+}
+
+// binaryLevels are the binary operators by precedence, lowest first.
+var binaryLevels = [][]string{{"??"}, {"||"}, {"&&"}, {"==", "!=", "<", ">", "<=", ">="}, {"+"}}
+
+// expression parses an expression.
+func (p *parser) expression() (expression, error) {
+	return p.binary(0)
+}
+
+// binary parses the binary operators of the given precedence level and
+// above.
+func (p *parser) binary(level int) (expression, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != operatorToken || !containsString(binaryLevels[level], t.text) {
+			return left, nil
+		}
+		p.next()
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpression{op: t.text, left: left, right: right}
+	}
+}
+
+// unary parses negations and primary expressions.
+func (p *parser) unary() (expression, error) {
+	if p.is("!") {
+		p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpression{operand: operand}, nil
+	}
+	return p.primary()
+}
+
+// primary parses literals, fields, variables, function calls and
+// parenthesized expressions.
+func (p *parser) primary() (expression, error) {
+	t := p.peek()
+	switch t.kind {
+	case stringToken:
+		p.next()
+		return literal{value: t.text}, nil
+	case numberToken:
+		p.next()
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, errorAt(p.src, t.pos, "invalid number "+t.text)
+		}
+		return literal{value: f}, nil
+	case regexToken:
+		return nil, p.errorf("regexes are only allowed as pattern arguments of functions")
+	case pathToken:
+		p.next()
+		return pathExpression{path: splitPath(t.text)}, nil
+	case variableToken:
+		p.next()
+		if !containsString(variables, t.text) {
+			return nil, errorAt(p.src, t.pos, fmt.Sprintf("unknown variable $%s, must be one of $%s", t.text,
+				strings.Join(variables, ", $")))
+		}
+		return variableExpression{name: t.text}, nil
+	case identToken:
+		p.next()
+		switch t.text {
+		case "true", "false":
+			return literal{value: t.text == "true"}, nil
+		case "null":
+			return literal{value: nil}, nil
+		}
+		return p.call(t)
+	case operatorToken:
+		if t.text == "(" {
+			p.next()
+			e, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+	}
+	return nil, p.errorf("expected an expression")
+}
+
+// call parses the arguments of a call of the function named by t and checks
+// them against the signature of the function.
+func (p *parser) call(t token) (expression, error) {
+	f, ok := functions[t.text]
+	if !ok {
+		return nil, errorAt(p.src, t.pos, fmt.Sprintf("unknown function %s", t.text))
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var args []expression
+	for !p.is(")") {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		if t := p.peek(); t.kind == regexToken && len(args) == f.pattern {
+			p.next()
+			rx, err := regexp.Compile(t.text)
+			if err != nil {
+				return nil, errorAt(p.src, t.pos, "invalid regex: "+err.Error())
+			}
+			args = append(args, literal{value: rx})
+			continue
+		}
+		arg, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+	if len(args) < f.minArgs || len(args) > f.maxArgs {
+		n := strconv.Itoa(f.minArgs)
+		if f.maxArgs > f.minArgs {
+			n += " to " + strconv.Itoa(f.maxArgs)
+		}
+		return nil, errorAt(p.src, t.pos, fmt.Sprintf("%s takes %s arguments, got %d", t.text, n, len(args)))
+	}
+	if f.field {
+		if _, ok := args[0].(pathExpression); !ok {
+			return nil, errorAt(p.src, t.pos, fmt.Sprintf("the argument of %s must be a field", t.text))
+		}
+	}
+	return callExpression{name: t.text, fn: f, args: args}, nil
+}
+
+// splitPath splits the text of a path token into its segments.
+func splitPath(text string) []string {
+	if len(text) == 0 {
+		return nil
+	}
+	return strings.Split(text, "\x00")
+}
+
+// containsString returns whether list contains s.
+func containsString(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// ChurnMetricName is the default name of the metric family generated by
+	// NewChurnCollector.
+	ChurnMetricName = "bananabacon_churn"
+)
+
+// ChurnOptions configures the series generated by NewChurnCollector.
+type ChurnOptions struct {
+	// Metric is the name of the metric family, ChurnMetricName if empty.
+	Metric string
+	// Label is the name of the label whose value changes with every new
+	// series, "pod" if empty.
+	Label string
+	// Rate is the number of series created, and retired, per hour.
+	Rate float64
+	// Lifetime is how long each series exists. On average, Rate times
+	// Lifetime in hours series exist at the same time.
+	Lifetime time.Duration
+}
+
+// NewChurnCollector returns a Collector that creates and retires series of a
+// metric at a constant rate, like pods of a deployment that is rolled over and
+// over, to test head churn, WAL growth and series limits of time series
+// databases. Each series has a unique value of the churning label, e.g.
+// pod="pod-000042", and the value 1. The series are derived from the time
+// given by now, so churn starts in its steady state and stays consistent
+// across scrapes.
+func NewChurnCollector(options ChurnOptions, now func() time.Time) (Collector, error) {
+	if options.Rate <= 0 || options.Lifetime <= 0 {
+		return nil, fmt.Errorf("invalid churn: rate and lifetime must be positive")
+	}
+	if len(options.Metric) == 0 {
+		options.Metric = ChurnMetricName
+	}
+	if len(options.Label) == 0 {
+		options.Label = "pod"
+	}
+	if !isValidLabelName(options.Label) {
+		return nil, fmt.Errorf("invalid churn label: %s", options.Label)
+	}
+	interval := time.Duration(float64(time.Hour) / options.Rate)
+	if interval <= 0 {
+		return nil, fmt.Errorf("invalid churn rate: %v, too high", options.Rate)
+	}
+	start := now()
+	return func() []MetricValue {
+		// Series i is created at start-Lifetime+i*interval, so the series of
+		// one lifetime already exist at the start
+		elapsed := now().Sub(start)
+		first := int64(elapsed/interval) + 1
+		last := int64((elapsed + options.Lifetime) / interval)
+		values := make([]MetricValue, 0, max(last-first+1, 0))
+		for i := first; i <= last; i++ {
+			labels := map[string]string{options.Label: fmt.Sprintf("%s-%06d", options.Label, i)}
+			m := NewMetric(options.Metric, GaugeType, "", labels, "Generated series for churn tests")
+			values = append(values, NewMetricValue(m, 1))
+		}
+		return values
+	}, nil
+}
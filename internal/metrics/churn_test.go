@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+)
+
+func TestChurnCollector(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	collect, err := NewChurnCollector(ChurnOptions{Label: "instance", Rate: 60, Lifetime: 10 * time.Minute},
+		func() time.Time { return now })
+	if err != nil {
+		t.Fatalf("Failed to create churn collector: %s", err)
+	}
+	series := func() []string {
+		var values []string
+		for _, v := range collect() {
+			if v.Metric().Name() != ChurnMetricName {
+				t.Errorf("Unexpected metric name %s", v.Metric().Name())
+			}
+			values = append(values, v.Metric().Labels()["instance"])
+		}
+		return values
+	}
+
+	initial := series()
+	if len(initial) != 10 || initial[0] != "instance-000001" || initial[9] != "instance-000010" {
+		t.Errorf("Expected 10 series from instance-000001, got %v", initial)
+	}
+	now = now.Add(90 * time.Second)
+	later := series()
+	if len(later) != 10 || later[0] != "instance-000002" || later[9] != "instance-000011" {
+		t.Errorf("Expected one series to be replaced after 90s, got %v", later)
+	}
+	now = now.Add(time.Hour)
+	if later := series(); later[0] != "instance-000062" {
+		t.Errorf("Expected 60 series to be replaced after an hour, got %v", later)
+	}
+
+	if _, err := NewChurnCollector(ChurnOptions{Rate: 10}, time.Now); err == nil {
+		t.Error("Expected an error for a missing lifetime")
+	}
+}
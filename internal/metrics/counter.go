@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+)
+
+// Modes of keeping counters monotonic, see MetricsEngine.SetCounterMode.
+const (
+	// CounterAccumulate adds the increases of the results of the script to
+	// the exposed value and ignores their decreases, so the exposed counter
+	// grows wherever the script grows.
+	CounterAccumulate = "accumulate"
+	// CounterClamp exposes the highest result of the script so far, so the
+	// exposed counter stays flat until the script exceeds it again.
+	CounterClamp = "clamp"
+	// CounterOff exposes the results of the script as they are.
+	CounterOff = "off"
+)
+
+// counterValue is what a counter exposed, see Metric.monotonic.
+type counterValue struct {
+	raw float64 // last result of the script
+	exposed float64
+}
+
+// SetCounterMode sets how the results of the scripts of counters are turned
+// into exposed values, which Prometheus requires to never decrease except on
+// resets: CounterAccumulate, the default, CounterClamp or CounterOff. Scripts
+// simulate a reset by calling resetCounter(), the counter is then exposed
+// with the result of that evaluation. It must not be called while the
+// metrics are evaluated.
+func (me *MetricsEngine) SetCounterMode(mode string) error {
+	switch mode {
+	case CounterAccumulate, CounterClamp, CounterOff:
+	default:
+		return fmt.Errorf("invalid counter mode: %q, must be %q, %q or %q", mode, CounterAccumulate, CounterClamp,
+			CounterOff)
+	}
+	me.counterMode = mode
+	return nil
+}
+
+// monotonic returns the value exposed for the result v of the script of a
+// counter in the given mode and remembers it. The first result and the result
+// of an evaluation that called resetCounter() are exposed as they are.
+func (m *Metric) monotonic(v float64, mode string, reset bool) float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counter == nil || reset || math.IsNaN(m.counter.exposed) {
+		m.counter = &counterValue{raw: v, exposed: v}
+		return v
+	}
+	c := m.counter
+	if !math.IsNaN(v) {
+		switch mode {
+		case CounterClamp:
+			c.exposed = max(c.exposed, v)
+		default:
+			if v > c.raw {
+				c.exposed += v - c.raw
+			}
+		}
+		c.raw = v
+	}
+	return c.exposed
+}
+
+// exposedCounter returns the value last exposed by a counter and whether it
+// was evaluated.
+func (m *Metric) exposedCounter() (float64, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counter == nil {
+		return 0, false
+	}
+	return m.counter.exposed, true
+}
+
+// setExposedCounter sets the value last exposed by a counter whose script
+// last returned raw.
+func (m *Metric) setExposedCounter(raw, exposed float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counter = &counterValue{raw: raw, exposed: exposed}
+}
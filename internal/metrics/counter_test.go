@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMetricsEngine_CounterMode(t *testing.T) {
+	// Rises to 10, drops to 4, rises to 12, then resets to 1
+	script := `function c(t, prev, state) {
+		state.i = (state.i || 0) + 1
+		if (state.i == 4) { resetCounter(); return 1 }
+		return [10, 4, 12][state.i - 1]
+	}`
+	for _, test := range []struct {
+		mode     string
+		expected []float64
+	}{
+		{CounterAccumulate, []float64{10, 10, 18, 1}},
+		{CounterClamp, []float64{10, 10, 12, 1}},
+		{CounterOff, []float64{10, 4, 12, 1}},
+	} {
+		m := NewMetric("c", CounterType, script, nil, "")
+		engine := NewMetricsEngine([]*Metric{m})
+		if err := engine.SetCounterMode(test.mode); err != nil {
+			t.Fatalf("Failed to set counter mode: %s", err)
+		}
+		vm := engine.NewRuntime()
+		for i, expected := range test.expected {
+			mv, err := engine.Eval(m, vm)
+			if err != nil {
+				t.Fatalf("Failed to evaluate metric: %s", err)
+			}
+			if v, _ := toFloat(mv.Value()); v != expected {
+				t.Errorf("%s: expected %v in evaluation %d, got %v", test.mode, expected, i+1, mv.Value())
+			}
+		}
+		if last := m.LastValue(); last != int64(1) {
+			t.Errorf("%s: expected prev to be the result of the script, got %v", test.mode, last)
+		}
+	}
+
+	gauge := NewMetric("g", GaugeType, "10 - (prev || 0)", nil, "")
+	engine := NewMetricsEngine([]*Metric{gauge})
+	engine.Eval(gauge, engine.NewRuntime())
+	if mv, _ := engine.Eval(gauge, engine.NewRuntime()); mv.Value() != int64(0) {
+		t.Errorf("Expected gauges to decrease, got %v", mv.Value())
+	}
+	if err := engine.SetCounterMode("max"); err == nil {
+		t.Error("Expected an error for an invalid counter mode")
+	}
+}
+
+func TestMetricsEngine_CounterState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	newEngine := func() (*MetricsEngine, *Metric) {
+		m := NewMetric("c", CounterType, "prev == null ? 10 : 2", nil, "")
+		return NewMetricsEngine([]*Metric{m}), m
+	}
+	engine, m := newEngine()
+	engine.Eval(m, engine.NewRuntime())
+	engine.Eval(m, engine.NewRuntime())
+	if err := engine.SaveState(path); err != nil {
+		t.Fatalf("Failed to save state: %s", err)
+	}
+
+	restored, m := newEngine()
+	if err := restored.LoadState(path); err != nil {
+		t.Fatalf("Failed to load state: %s", err)
+	}
+	mv, err := restored.Eval(m, restored.NewRuntime())
+	if err != nil {
+		t.Fatalf("Failed to evaluate metric: %s", err)
+	}
+	if v, _ := toFloat(mv.Value()); v != 10 {
+		t.Errorf("Expected the counter to continue at 10, got %v", mv.Value())
+	}
+
+	replaced := NewMetric("c", CounterType, "5", nil, "")
+	restored.SetMetrics([]*Metric{replaced})
+	mv, _ = restored.Eval(replaced, restored.NewRuntime())
+	if v, _ := toFloat(mv.Value()); v != 13 {
+		t.Errorf("Expected the replaced counter to continue at 13, got %v", mv.Value())
+	}
+}
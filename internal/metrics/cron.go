@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule is a parsed cron expression with the five standard fields
+// minute, hour, day of month, month and day of week.
+type CronSchedule struct {
+	minute, hour, dom, month, dow []bool
+	domRestricted, dowRestricted bool
+}
+
+// cronField describes the range of values of a field of a cron expression.
+type cronField struct {
+	name string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// ParseCron parses a cron expression like "*/5 9-17 * * 1-5". Each field is
+// "*", a value, a range "a-b" or a comma-separated list of them, each
+// optionally followed by a step "/n". Day of week 0 and 7 are Sunday.
+func ParseCron(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+	sets := make([][]bool, len(fields))
+	for i, f := range fields {
+		set, err := parseCronField(f, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expr, err)
+		}
+		sets[i] = set
+	}
+	// Sunday can be given as 0 or 7
+	sets[4][0] = sets[4][0] || sets[4][7]
+	return &CronSchedule{
+		minute: sets[0],
+		hour: sets[1],
+		dom: sets[2],
+		month: sets[3],
+		dow: sets[4],
+		domRestricted: fields[2] != "*",
+		dowRestricted: fields[4] != "*",
+	}, nil
+}
+
+// parseCronField returns the set of values matched by a field.
+func parseCronField(field string, cf cronField) ([]bool, error) {
+	set := make([]bool, cf.max+1)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.Atoi(part[i+1:])
+			if err != nil || s <= 0 {
+				return nil, fmt.Errorf("invalid step in %s field: %s", cf.name, part)
+			}
+			step = s
+			part = part[:i]
+		}
+		lo, hi := cf.min, cf.max
+		if part != "*" {
+			var err error
+			bounds := strings.SplitN(part, "-", 2)
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid value in %s field: %s", cf.name, part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid value in %s field: %s", cf.name, part)
+				}
+			} else if step > 1 {
+				// "a/n" means from a to the maximum
+				hi = cf.max
+			}
+		}
+		if lo < cf.min || hi > cf.max || lo > hi {
+			return nil, fmt.Errorf("value out of range in %s field: %s", cf.name, part)
+		}
+		for v := lo; v <= hi; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// Matches returns true if the minute of t matches the schedule. Like in cron,
+// if both day of month and day of week are restricted, a day matches if
+// either of them matches.
+func (cs *CronSchedule) Matches(t time.Time) bool {
+	if !cs.minute[t.Minute()] || !cs.hour[t.Hour()] || !cs.month[int(t.Month())] {
+		return false
+	}
+	dom, dow := cs.dom[t.Day()], cs.dow[int(t.Weekday())]
+	if cs.domRestricted && cs.dowRestricted {
+		return dom || dow
+	}
+	return dom && dow
+}
@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bananabacon/internal/clock"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	// Monday, 2024-01-15 09:30
+	monday := time.Date(2024, 1, 15, 9, 30, 0, 0, time.UTC)
+	sunday := time.Date(2024, 1, 14, 9, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expr string
+		t time.Time
+		matches bool
+	}{
+		{"* * * * *", monday, true},
+		{"*/15 * * * *", monday, true},
+		{"*/20 * * * *", monday, false},
+		{"30 9-17 * * 1-5", monday, true},
+		{"30 9-17 * * 1-5", sunday, false},
+		{"30 9 * * 7", sunday, true},
+		{"30 9 1 * 1", monday, true}, // day of month or day of week
+		{"30 9 1 * *", monday, false},
+		{"0,30 9 * 1 *", monday, true},
+		{"10/10 * * * *", monday, true},
+	}
+	for _, test := range tests {
+		cs, err := ParseCron(test.expr)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", test.expr, err)
+		}
+		if cs.Matches(test.t) != test.matches {
+			t.Errorf("Expected %s to return %v for %s", test.expr, test.matches, test.t)
+		}
+	}
+
+	for _, invalid := range []string{"* * * *", "60 * * * *", "*/0 * * * *", "a * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestMetricsEngine_TimeHelpers(t *testing.T) {
+	m := NewMetric("test", GaugeType, `(businessHours() ? 1 : 0) + (cron("*/5 * * * *") ? 10 : 0) + 100 * weekday() + 1000 * hour()`, nil, "")
+	engine := NewMetricsEngine([]*Metric{m})
+	engine.SetClock(clock.WithNow(clock.Wall(), func() time.Time {
+		return time.Date(2024, 1, 15, 9, 30, 0, 0, time.UTC)
+	}))
+	val, err := engine.Eval(m, engine.NewRuntime())
+	if err != nil {
+		t.Fatalf("Failed to evaluate metric: %v", err)
+	}
+	if v, _ := toFloat(val.Value()); v != 9111 {
+		t.Errorf("Expected 9111, got %v", val.Value())
+	}
+
+	m = NewMetric("invalid", GaugeType, `cron("invalid")`, nil, "")
+	if _, err := engine.Eval(m, engine.NewRuntime()); err == nil {
+		t.Error("Expected error for invalid cron expression")
+	}
+}
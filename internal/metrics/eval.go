@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+const (
+	// EvalTimeout is the maximum time an expression evaluated by
+	// EvalExpression may run, so an endless loop does not block the server.
+	EvalTimeout = time.Second
+	// MaxEvalSize is the maximum size of an expression sent to "/api/eval".
+	MaxEvalSize = 64 << 10
+)
+
+// EvalResult is the response of the "/api/eval" endpoint.
+type EvalResult struct {
+	// Result is the value of the expression, if it was evaluated successfully.
+	Result any `json:"result"`
+	// Error describes why the expression could not be evaluated.
+	Error string `json:"error,omitempty"`
+}
+
+// EvalExpression evaluates an ad-hoc JavaScript expression in the current
+// context of the engine and returns its exported value. The expression sees
+// the same helpers and elapsed time t as the metrics, "metrics" holds the last
+// value of every metric by name and, if metric names one of the metrics, prev
+// is its last value and state a copy of its state, so changes to it are not
+// kept. An expression starting with "function" is called like a metric
+// script. Evaluation is aborted after EvalTimeout.
+func (me *MetricsEngine) EvalExpression(expr, metric string) (any, error) {
+	vm := me.NewRuntime()
+	values := map[string]any{}
+	var prev any
+	var state json.RawMessage
+	found := len(metric) == 0
+	for _, m := range me.List() {
+		values[m.Name()] = m.LastValue()
+		if m.Name() == metric {
+			prev, state, found = m.LastValue(), m.State(), true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown metric %q", metric)
+	}
+	vm.Set("metrics", values)
+	timer := time.AfterFunc(EvalTimeout, func() {
+		vm.Interrupt(fmt.Sprintf("evaluation timed out after %s", EvalTimeout))
+	})
+	defer timer.Stop()
+	t := me.elapsed().Milliseconds()
+	if !strings.HasPrefix(strings.TrimSpace(expr), "function") {
+		expr = fmt.Sprintf("(function(t, prev, state) { return %s\n})", expr)
+	} else {
+		expr = "(" + expr + ")"
+	}
+	fn, err := vm.RunString(expr)
+	if err != nil {
+		return nil, err
+	}
+	call, ok := goja.AssertFunction(fn)
+	if !ok {
+		return nil, fmt.Errorf("expression is not a function")
+	}
+	stateValue, err := parseState(vm, string(state))
+	if err != nil {
+		return nil, err
+	}
+	res, err := call(goja.Undefined(), vm.ToValue(t), vm.ToValue(prev), stateValue)
+	if err != nil {
+		return nil, err
+	}
+	return res.Export(), nil
+}
+
+// EnableEval serves EvalExpression at "/api/eval", so metric scripts can be
+// authored iteratively against the running engine. Expressions are sent as
+// body of a POST request, the "metric" query parameter selects the metric
+// whose last value is passed as prev. Requests must authenticate with token as
+// bearer token, it returns an error if the token is empty. It must be called
+// before Run.
+func (ms *MetricsServer) EnableEval(token string) error {
+	if len(token) == 0 {
+		return errors.New("missing token, evaluating expressions must be guarded by a token")
+	}
+	ms.mux.Handle("/api/eval", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")),
+			[]byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxEvalSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		var result EvalResult
+		status := http.StatusOK
+		if result.Result, err = ms.engine.EvalExpression(string(body), r.URL.Query().Get("metric")); err != nil {
+			result.Error = err.Error()
+			status = http.StatusUnprocessableEntity
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			// E.g. functions, fall back to their string representation
+			b, _ = json.Marshal(EvalResult{Result: fmt.Sprint(result.Result)})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			log.Printf("Failed to write eval result: %v", err)
+		}
+	}))
+	return nil
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsServer_Eval(t *testing.T) {
+	engine := NewMetricsEngine([]*Metric{
+		NewMetric("test_one", CounterType, "prev == null ? 1 : prev + 1", nil, ""),
+	})
+	if _, err := engine.Eval(engine.Metrics[0], engine.NewRuntime()); err != nil {
+		t.Fatalf("Failed to evaluate metric: %s", err)
+	}
+	server := NewMetricsServer(engine, 0)
+	if err := server.EnableEval(""); err == nil {
+		t.Error("Expected an error for an empty token")
+	}
+	if err := server.EnableEval("secret"); err != nil {
+		t.Fatalf("Failed to enable eval: %s", err)
+	}
+	ts := httptest.NewServer(server.mux)
+	defer ts.Close()
+
+	eval := func(expr, query, token string) (int, EvalResult) {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/api/eval"+query, strings.NewReader(expr))
+		if err != nil {
+			t.Fatalf("Failed to create request: %s", err)
+		}
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to send request: %s", err)
+		}
+		defer resp.Body.Close()
+		var res EvalResult
+		if resp.StatusCode != http.StatusUnauthorized {
+			if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+				t.Fatalf("Failed to decode response: %s", err)
+			}
+		}
+		return resp.StatusCode, res
+	}
+
+	if status, _ := eval("1", "", "wrong"); status != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 for a wrong token, got %d", status)
+	}
+	if status, _ := eval("1", "", ""); status != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a token, got %d", status)
+	}
+	if status, res := eval("prev * 10 + metrics.test_one", "?metric=test_one", "secret"); status != http.StatusOK || res.Result != float64(11) {
+		t.Errorf("Expected result 11, got %d %+v", status, res)
+	}
+	if status, res := eval("typeof t == 'number' && typeof businessHours == 'function'", "", "secret"); status != http.StatusOK || res.Result != true {
+		t.Errorf("Expected t and helpers to be defined, got %d %+v", status, res)
+	}
+	if status, res := eval("1", "?metric=missing", "secret"); status != http.StatusUnprocessableEntity || !strings.Contains(res.Error, "unknown metric") {
+		t.Errorf("Expected an unknown metric error, got %d %+v", status, res)
+	}
+	if status, res := eval("(function() { while (true) {} })()", "", "secret"); status != http.StatusUnprocessableEntity || !strings.Contains(res.Error, "timed out") {
+		t.Errorf("Expected a timeout error, got %d %+v", status, res)
+	}
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"bananabacon/internal/clock"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// Sample is the value of a single series at a point in time. Histograms and
+// summaries are split into their series like in the Prometheus exposition
+// format, i.e. "_bucket", "_sum" and "_count" series and a "quantile" label.
+type Sample struct {
+	Time time.Time `parquet:"time,timestamp(millisecond)"`
+	Name string `parquet:"metric,dict"`
+	// Labels are the labels of the series, formatted like `{job="api"}`.
+	Labels string `parquet:"labels,dict"`
+	Value float64 `parquet:"value"`
+}
+
+// SampleWriter writes samples to a file.
+type SampleWriter interface {
+	Write(samples []Sample) error
+	// Close flushes the samples, it does not close the underlying writer.
+	Close() error
+}
+
+// Simulate evaluates the metrics over the virtual time range from start to
+// end at the given step and passes the samples of each step to write. The
+// metrics see the elapsed virtual time as t and the time-of-day helpers use
+// the virtual clock, so a day of samples is computed in a fraction of a
+// second. It replaces the clock of the engine and should be used on an engine
+// that is not served at the same time.
+func (me *MetricsEngine) Simulate(start, end time.Time, step time.Duration, write func([]Sample) error) error {
+	if step <= 0 {
+		return fmt.Errorf("invalid step: %s, must be positive", step)
+	}
+	if end.Before(start) {
+		return fmt.Errorf("invalid range: end %s is before start %s", end, start)
+	}
+	now := start
+	me.SetClock(clock.WithNow(clock.Wall(), func() time.Time { return now }))
+	vm := me.NewRuntime()
+	for ; !now.After(end); now = now.Add(step) {
+		var samples []Sample
+		for _, m := range me.List() {
+			mv, err := me.evalAt(m, vm, now.Sub(start))
+			if err != nil {
+				return fmt.Errorf("failed to evaluate metric %s at %s: %w", m.Name(), now.Format(time.RFC3339), err)
+			}
+			samples = append(samples, mv.toSamples(now)...)
+		}
+		if err := write(samples); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// toSamples splits the value into samples at time t. Values that are not
+// numbers are skipped.
+func (mv MetricValue) toSamples(t time.Time) []Sample {
+	m := mv.Metric()
+	sample := func(suffix string, value any, extra ...string) []Sample {
+		v, ok := toFloat(value)
+		if !ok {
+			return nil
+		}
+		return []Sample{{Time: t, Name: m.Name() + suffix, Labels: formatLabels(m.Labels(), extra...), Value: v}}
+	}
+	values, ok := mv.Value().(map[string]any)
+	if !ok || (m.Type() != HistogramType && m.Type() != SummaryType) {
+		return sample("", mv.Value())
+	}
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var samples []Sample
+	for _, k := range keys {
+		switch {
+		case k == "sum" || k == "count":
+			samples = append(samples, sample("_"+k, values[k])...)
+		case m.Type() == HistogramType:
+			samples = append(samples, sample("_bucket", values[k], "le", k)...)
+		default:
+			samples = append(samples, sample("", values[k], "quantile", k)...)
+		}
+	}
+	return samples
+}
+
+// formatLabels formats the labels and additional label name-value pairs
+// sorted by name, like `{job="api",le="0.5"}`.
+func formatLabels(labels map[string]string, extra ...string) string {
+	pairs := make([]string, 0, len(labels)+len(extra)/2)
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+strconv.Quote(v))
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+"="+strconv.Quote(extra[i+1]))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// csvSampleWriter writes samples as CSV with a header row and RFC 3339
+// timestamps.
+type csvSampleWriter struct {
+	w *csv.Writer
+}
+
+// NewCSVSampleWriter returns a SampleWriter that writes CSV with the columns
+// time, metric, labels and value.
+func NewCSVSampleWriter(w io.Writer) (SampleWriter, error) {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"time", "metric", "labels", "value"}); err != nil {
+		return nil, err
+	}
+	return &csvSampleWriter{w: cw}, nil
+}
+
+func (sw *csvSampleWriter) Write(samples []Sample) error {
+	for _, s := range samples {
+		err := sw.w.Write([]string{s.Time.Format(time.RFC3339Nano), s.Name, s.Labels,
+			strconv.FormatFloat(s.Value, 'g', -1, 64)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sw *csvSampleWriter) Close() error {
+	sw.w.Flush()
+	return sw.w.Error()
+}
+
+// parquetSampleWriter writes samples as Parquet.
+type parquetSampleWriter struct {
+	w *parquet.GenericWriter[Sample]
+}
+
+// NewParquetSampleWriter returns a SampleWriter that writes a Parquet file
+// with the columns of Sample.
+func NewParquetSampleWriter(w io.Writer) SampleWriter {
+	return &parquetSampleWriter{w: parquet.NewGenericWriter[Sample](w)}
+}
+
+func (sw *parquetSampleWriter) Write(samples []Sample) error {
+	_, err := sw.w.Write(samples)
+	return err
+}
+
+func (sw *parquetSampleWriter) Close() error {
+	return sw.w.Close()
+}
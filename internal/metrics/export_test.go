@@ -0,0 +1,64 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func TestMetricsEngine_Simulate(t *testing.T) {
+	engine := NewMetricsEngine([]*Metric{
+		NewMetric("requests", CounterType, "(prev || 0) + (hour() >= 9 ? 10 : 1)", map[string]string{"job": "api"}, ""),
+		NewMetric("latency", SummaryType, "({'0.5': t / 1000, '0.9': 2 * t / 1000})", nil, ""),
+	})
+	start := time.Date(2024, 3, 1, 8, 30, 0, 0, time.UTC)
+
+	var csvOut bytes.Buffer
+	w, err := NewCSVSampleWriter(&csvOut)
+	if err != nil {
+		t.Fatalf("Failed to create CSV writer: %s", err)
+	}
+	if err := engine.Simulate(start, start.Add(time.Hour), 30*time.Minute, w.Write); err != nil {
+		t.Fatalf("Failed to simulate: %s", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close CSV writer: %s", err)
+	}
+	expected := `time,metric,labels,value
+2024-03-01T08:30:00Z,requests,"{job=""api""}",1
+2024-03-01T08:30:00Z,latency,"{quantile=""0.5""}",0
+2024-03-01T08:30:00Z,latency,"{quantile=""0.9""}",0
+2024-03-01T09:00:00Z,requests,"{job=""api""}",11
+2024-03-01T09:00:00Z,latency,"{quantile=""0.5""}",1800
+2024-03-01T09:00:00Z,latency,"{quantile=""0.9""}",3600
+2024-03-01T09:30:00Z,requests,"{job=""api""}",21
+2024-03-01T09:30:00Z,latency,"{quantile=""0.5""}",3600
+2024-03-01T09:30:00Z,latency,"{quantile=""0.9""}",7200
+`
+	if csvOut.String() != expected {
+		t.Errorf("Expected CSV:\n%s\nGot:\n%s", expected, csvOut.String())
+	}
+
+	var parquetOut bytes.Buffer
+	pw := NewParquetSampleWriter(&parquetOut)
+	if err := NewMetricsEngine(engine.Metrics).Simulate(start, start.Add(time.Hour), 30*time.Minute, pw.Write); err != nil {
+		t.Fatalf("Failed to simulate: %s", err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatalf("Failed to close Parquet writer: %s", err)
+	}
+	samples, err := parquet.Read[Sample](bytes.NewReader(parquetOut.Bytes()), int64(parquetOut.Len()))
+	if err != nil {
+		t.Fatalf("Failed to read Parquet: %s", err)
+	}
+	if len(samples) != 9 || !samples[3].Time.Equal(start.Add(30*time.Minute)) || samples[3].Labels != `{job="api"}` {
+		t.Errorf("Unexpected samples: %+v", samples)
+	}
+
+	if err := engine.Simulate(start, start, 0, w.Write); err == nil || !strings.Contains(err.Error(), "step") {
+		t.Errorf("Expected an error for a zero step, got %v", err)
+	}
+}
@@ -0,0 +1,383 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// LintProblem is a problem of an exposition found by Lint.
+type LintProblem struct {
+	// Line is the number of the line of the problem, starting at 1.
+	Line int
+	// Metric is the name of the metric family, if the problem concerns one.
+	Metric string
+	Text string
+}
+
+// String returns the problem in the format "line <n>: <metric>: <text>".
+func (p LintProblem) String() string {
+	if len(p.Metric) == 0 {
+		return fmt.Sprintf("line %d: %s", p.Line, p.Text)
+	}
+	return fmt.Sprintf("line %d: %s: %s", p.Line, p.Metric, p.Text)
+}
+
+var camelCaseRegex = regexp.MustCompile(`[a-z][A-Z]`)
+
+// lintFamily is the state of a metric family while linting.
+type lintFamily struct {
+	typ string
+	typed bool
+	help bool
+	line int // line of the first sample, 0 if none was seen yet
+	ended bool // set once a sample of another family followed
+	infBuckets map[string]bool // histogram series by labels, set once they have a +Inf bucket
+	firstLines map[string]int // line of the first sample of each histogram series
+}
+
+// linter checks an exposition line by line.
+type linter struct {
+	problems []LintProblem
+	families map[string]*lintFamily
+	series map[string]int
+	last string
+}
+
+// Lint checks an exposition in the Prometheus text format strictly, like
+// "promtool check metrics" and the parser of Prometheus do, and returns the
+// problems found, ordered by line. It reports syntax errors like invalid
+// escaping of label values, invalid names and values, duplicate series,
+// HELP and TYPE lines, samples of a family that are not grouped together,
+// counters without the "_total" suffix and other metrics with it, samples of
+// histograms and summaries with wrong suffixes or missing "le" and "quantile"
+// labels and histograms without a "+Inf" bucket. If requireHelp is set,
+// families without a HELP line are reported too.
+func Lint(exposition string, requireHelp bool) []LintProblem {
+	l := &linter{families: map[string]*lintFamily{}, series: map[string]int{}}
+	for i, line := range strings.Split(exposition, "\n") {
+		n := i + 1
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case len(strings.TrimSpace(line)) == 0:
+		case strings.HasPrefix(line, "#"):
+			l.comment(n, line)
+		default:
+			l.sample(n, line)
+		}
+	}
+	names := make([]string, 0, len(l.families))
+	for name := range l.families {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		f := l.families[name]
+		if requireHelp && !f.help {
+			l.report(f.line, name, "no help text")
+		}
+		for labels, ok := range f.infBuckets {
+			if !ok {
+				l.report(f.firstLines[labels], name, fmt.Sprintf("histogram series {%s} has no le=\"+Inf\" bucket", labels))
+			}
+		}
+	}
+	slices.SortStableFunc(l.problems, func(a, b LintProblem) int { return a.Line - b.Line })
+	return l.problems
+}
+
+// report adds a problem.
+func (l *linter) report(line int, metric, text string) {
+	l.problems = append(l.problems, LintProblem{Line: line, Metric: metric, Text: text})
+}
+
+// family returns the family of the given name, creating it if needed.
+func (l *linter) family(name string) *lintFamily {
+	f, ok := l.families[name]
+	if !ok {
+		f = &lintFamily{typ: "untyped"}
+		l.families[name] = f
+	}
+	return f
+}
+
+// comment checks a HELP or TYPE line. Other comments are ignored.
+func (l *linter) comment(n int, line string) {
+	fields := strings.SplitN(strings.TrimSpace(line[1:]), " ", 3)
+	if len(fields) < 2 || (fields[0] != "HELP" && fields[0] != "TYPE") {
+		return
+	}
+	name := fields[1]
+	if !l.checkName(n, name) {
+		return
+	}
+	f := l.family(name)
+	text := ""
+	if len(fields) > 2 {
+		text = fields[2]
+	}
+	if fields[0] == "HELP" {
+		if f.help {
+			l.report(n, name, "second HELP line for metric name")
+		}
+		f.help = true
+		if err := checkEscaping(text, false); err != nil {
+			l.report(n, name, fmt.Sprintf("invalid help text: %v", err))
+		}
+		return
+	}
+	switch text {
+	case "counter", "gauge", "histogram", "summary", "untyped":
+	default:
+		l.report(n, name, fmt.Sprintf("invalid metric type %q", text))
+		return
+	}
+	if f.typed {
+		l.report(n, name, "second TYPE line for metric name")
+		return
+	}
+	if f.line > 0 {
+		l.report(n, name, "TYPE line after samples of the metric")
+		return
+	}
+	f.typ, f.typed = text, true
+	if text == "counter" && !strings.HasSuffix(name, "_total") {
+		l.report(n, name, `counter metrics should have "_total" suffix`)
+	}
+	if text != "counter" && strings.HasSuffix(name, "_total") {
+		l.report(n, name, `non-counter metrics should not have "_total" suffix`)
+	}
+}
+
+// checkName reports an invalid metric name and one written in camelCase. It
+// returns false if the name is invalid.
+func (l *linter) checkName(n int, name string) bool {
+	if metricNameRegex.FindString(name) != name || len(name) == 0 {
+		l.report(n, "", fmt.Sprintf("invalid metric name %q", name))
+		return false
+	}
+	if camelCaseRegex.MatchString(name) {
+		l.report(n, name, "metric names should be written in 'snake_case' not 'camelCase'")
+	}
+	return true
+}
+
+// familyOf returns the name of the family of a sample, which is the name
+// without the suffix for the samples of histograms and summaries.
+func (l *linter) familyOf(name string) string {
+	for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+		base, ok := strings.CutSuffix(name, suffix)
+		if !ok {
+			continue
+		}
+		if f, ok := l.families[base]; ok && (f.typ == "histogram" || (f.typ == "summary" && suffix != "_bucket")) {
+			return base
+		}
+	}
+	return name
+}
+
+// sample checks a sample line.
+func (l *linter) sample(n int, line string) {
+	name := metricNameRegex.FindString(line)
+	if len(name) == 0 {
+		l.report(n, "", fmt.Sprintf("invalid metric name in %q", line))
+		return
+	}
+	rest := strings.TrimLeft(line[len(name):], " \t")
+	labels := map[string]string{}
+	if strings.HasPrefix(rest, "{") {
+		var err error
+		if rest, err = parseLintLabels(rest[1:], labels); err != nil {
+			l.report(n, name, err.Error())
+			return
+		}
+	} else if len(rest) == len(line[len(name):]) {
+		l.report(n, name, fmt.Sprintf("invalid character after metric name in %q", line))
+		return
+	}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		l.report(n, name, fmt.Sprintf("expected a value and an optional timestamp, got %q", rest))
+		return
+	}
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		l.report(n, name, fmt.Sprintf("invalid value %q", fields[0]))
+	}
+	if len(fields) == 2 {
+		if _, err := strconv.ParseInt(fields[1], 10, 64); err != nil {
+			l.report(n, name, fmt.Sprintf("invalid timestamp %q", fields[1]))
+		}
+	}
+
+	familyName := l.familyOf(name)
+	if _, ok := l.families[familyName]; !ok {
+		l.checkName(n, familyName)
+	}
+	f := l.family(familyName)
+	if l.last != familyName {
+		if f.ended {
+			l.report(n, familyName, "samples of the metric are not grouped together")
+		}
+		if last, ok := l.families[l.last]; ok {
+			last.ended = true
+		}
+		l.last = familyName
+	}
+	if f.line == 0 {
+		f.line = n
+	}
+	key := name + "{" + formatLintLabels(labels, "") + "}"
+	if first, ok := l.series[key]; ok {
+		l.report(n, familyName, fmt.Sprintf("duplicate series %s, first in line %d", key, first))
+	} else {
+		l.series[key] = n
+	}
+
+	suffix := strings.TrimPrefix(name, familyName)
+	switch f.typ {
+	case "counter":
+		if value < 0 {
+			l.report(n, familyName, fmt.Sprintf("negative counter value %v", value))
+		}
+	case "histogram":
+		l.checkHistogram(n, f, familyName, suffix, labels)
+	case "summary":
+		quantile, ok := labels["quantile"]
+		if len(suffix) > 0 {
+			if ok {
+				l.report(n, familyName, fmt.Sprintf("%s sample of a summary with a quantile label", suffix))
+			}
+		} else if !ok {
+			l.report(n, familyName, "summary sample without quantile label or _sum or _count suffix")
+		} else if q, err := strconv.ParseFloat(quantile, 64); err != nil || q < 0 || q > 1 {
+			l.report(n, familyName, fmt.Sprintf("invalid quantile %q, must be between 0 and 1", quantile))
+		}
+	}
+}
+
+// checkHistogram checks a sample of a histogram.
+func (l *linter) checkHistogram(n int, f *lintFamily, name, suffix string, labels map[string]string) {
+	le, ok := labels["le"]
+	switch suffix {
+	case "_bucket":
+		if !ok {
+			l.report(n, name, "histogram bucket without le label")
+			return
+		}
+		bound, err := strconv.ParseFloat(le, 64)
+		if err != nil {
+			l.report(n, name, fmt.Sprintf("invalid bucket bound le=%q", le))
+		}
+		if f.infBuckets == nil {
+			f.infBuckets, f.firstLines = map[string]bool{}, map[string]int{}
+		}
+		series := formatLintLabels(labels, "le")
+		if _, seen := f.infBuckets[series]; !seen {
+			f.firstLines[series] = n
+		}
+		f.infBuckets[series] = f.infBuckets[series] || math.IsInf(bound, 1)
+	case "_sum", "_count":
+		if ok {
+			l.report(n, name, fmt.Sprintf("%s sample of a histogram with an le label", suffix))
+		}
+	default:
+		l.report(n, name, "histogram sample without _bucket, _sum or _count suffix")
+	}
+}
+
+// parseLintLabels parses the labels of a sample after the opening brace into
+// labels and returns the rest of the line after the closing brace.
+func parseLintLabels(s string, labels map[string]string) (string, error) {
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if strings.HasPrefix(s, "}") {
+			return s[1:], nil
+		}
+		name := labelNameRegex.FindString(s)
+		if len(name) == 0 {
+			return "", fmt.Errorf("invalid label name at %q", s)
+		}
+		if strings.HasPrefix(name, "__") {
+			return "", fmt.Errorf("label name %q is reserved", name)
+		}
+		if _, ok := labels[name]; ok {
+			return "", fmt.Errorf("duplicate label name %q", name)
+		}
+		s = strings.TrimLeft(s[len(name):], " \t")
+		if !strings.HasPrefix(s, "=") {
+			return "", fmt.Errorf("expected \"=\" after label name %q", name)
+		}
+		s = strings.TrimLeft(s[1:], " \t")
+		if !strings.HasPrefix(s, `"`) {
+			return "", fmt.Errorf("expected a quoted value of label %q", name)
+		}
+		end := closingQuote(s[1:])
+		if end < 0 {
+			return "", fmt.Errorf("unterminated value of label %q", name)
+		}
+		value := s[1 : end+1]
+		if err := checkEscaping(value, true); err != nil {
+			return "", fmt.Errorf("invalid value of label %q: %w", name, err)
+		}
+		labels[name] = value
+		s = strings.TrimLeft(s[end+2:], " \t")
+		if strings.HasPrefix(s, ",") {
+			s = s[1:]
+		} else if !strings.HasPrefix(s, "}") {
+			return "", fmt.Errorf("unexpected %q after the value of label %q, unescaped quote in the value?", s, name)
+		}
+	}
+}
+
+// closingQuote returns the index of the first quote in s that is not escaped
+// by a backslash, or -1 if there is none.
+func closingQuote(s string) int {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// checkEscaping checks that a backslash in s only starts the escape
+// sequences "\\" and "\n", and `\"` if quoted is set.
+func checkEscaping(s string, quoted bool) error {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			continue
+		}
+		if i+1 == len(s) {
+			return fmt.Errorf("unescaped backslash at the end")
+		}
+		i++
+		if s[i] != '\\' && s[i] != 'n' && (!quoted || s[i] != '"') {
+			return fmt.Errorf("invalid escape sequence \\%c", s[i])
+		}
+	}
+	return nil
+}
+
+// formatLintLabels formats labels sorted by name, without the label skip.
+func formatLintLabels(labels map[string]string, skip string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		if name != skip {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf("%s=%q", name, labels[name])
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name        string
+		exposition  string
+		requireHelp bool
+		expected    []string
+	}{
+		{
+			name: "valid",
+			exposition: `# HELP requests_total Requests
+# TYPE requests_total counter
+requests_total {code="200",path="/a\\b\"c\nd"} 12 1700000000000
+requests_total {code="500"} 1
+# TYPE latency histogram
+latency_bucket {le="0.1"} 1
+latency_bucket {le="+Inf"} 3
+latency_sum {} 0.7
+latency_count {} 3
+# TYPE duration summary
+duration {quantile="0.5"} NaN
+duration_sum 1
+duration_count 2
+temperature {} -3.5
+`,
+		},
+		{
+			name: "invalid escaping",
+			exposition: `up {job="a\x"} 1
+up {job="a"b"} 1
+up {job="a} 1
+`,
+			expected: []string{
+				`line 1: up: invalid value of label "job": invalid escape sequence \x`,
+				`line 2: up: unexpected "b\"} 1" after the value of label "job", unescaped quote in the value?`,
+				`line 3: up: unterminated value of label "job"`,
+			},
+		},
+		{
+			name: "duplicates and grouping",
+			exposition: `# TYPE up gauge
+# TYPE up gauge
+up {b="1",a="2"} 1
+up {a="2",b="1"} 1
+other 1
+up {a="3"} 1
+up {a="3",a="4"} 1
+`,
+			expected: []string{
+				"line 2: up: second TYPE line for metric name",
+				`line 4: up: duplicate series up{a="2",b="1"}, first in line 3`,
+				"line 6: up: samples of the metric are not grouped together",
+				`line 7: up: duplicate label name "a"`,
+			},
+		},
+		{
+			name: "types and suffixes",
+			exposition: `# TYPE requests counter
+requests 1
+# TYPE errors_total gauge
+errors_total -1
+# TYPE latency histogram
+latency {} 1
+latency_bucket {le="1"} 1
+latency_bucket {} 1
+# TYPE duration summary
+duration {} 1
+duration {quantile="2"} 1
+# TYPE broken gaugee
+httpRequests 1
+value_total {} true
+`,
+			expected: []string{
+				`line 1: requests: counter metrics should have "_total" suffix`,
+				`line 3: errors_total: non-counter metrics should not have "_total" suffix`,
+				"line 6: latency: histogram sample without _bucket, _sum or _count suffix",
+				`line 7: latency: histogram series {} has no le="+Inf" bucket`,
+				"line 8: latency: histogram bucket without le label",
+				"line 10: duration: summary sample without quantile label or _sum or _count suffix",
+				`line 11: duration: invalid quantile "2", must be between 0 and 1`,
+				`line 12: broken: invalid metric type "gaugee"`,
+				"line 13: httpRequests: metric names should be written in 'snake_case' not 'camelCase'",
+				`line 14: value_total: invalid value "true"`,
+			},
+		},
+		{
+			name:        "missing help",
+			exposition:  "# HELP a A\na 1\nb 1\n",
+			requireHelp: true,
+			expected:    []string{"line 3: b: no help text"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var problems []string
+			for _, p := range Lint(tt.exposition, tt.requireHelp) {
+				problems = append(problems, p.String())
+			}
+			if strings.Join(problems, "\n") != strings.Join(tt.expected, "\n") {
+				t.Errorf("Expected problems:\n%s\nGot:\n%s", strings.Join(tt.expected, "\n"), strings.Join(problems, "\n"))
+			}
+		})
+	}
+}
+
+func TestLint_Exposition(t *testing.T) {
+	engine := NewMetricsEngine([]*Metric{
+		NewMetric("requests_total", CounterType, "t", map[string]string{"job": "api"}, "Requests"),
+		NewMetric("latency", HistogramType, `({"0.1": 1, "+Inf": 2, "sum": 0.3, "count": 2})`, nil, "Latency"),
+		NewMetric("duration", SummaryType, `({"0.5": 1, "0.9": 2, "sum": 3, "count": 2})`, map[string]string{"job": "api"}, "Duration"),
+	})
+	server := NewMetricsServer(engine, 0)
+	exposition := server.Render()
+	if problems := Lint(exposition, true); len(problems) > 0 {
+		t.Errorf("Expected no problems in\n%s\nGot: %v", exposition, problems)
+	}
+}
@@ -0,0 +1,342 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LogMetricEnvNamePrefix = "LOG_METRIC_"
+	// DefaultLogMetricWindow is the default window of the quantiles of log
+	// metrics, the default maximum age of summaries of the Prometheus client
+	// libraries.
+	DefaultLogMetricWindow = 10 * time.Minute
+)
+
+var (
+	// DefaultLogMetricQuantiles are the default quantiles of log metrics of
+	// type summary.
+	DefaultLogMetricQuantiles = []float64{0.5, 0.9, 0.99}
+	// DefaultLogMetricBuckets are the default upper bounds of the buckets of
+	// log metrics of type histogram, the default buckets of the Prometheus
+	// client libraries.
+	DefaultLogMetricBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+)
+
+// LogMetricOptions configures a LogMetric.
+type LogMetricOptions struct {
+	// Regex matches the lines with an observation. The value is the group
+	// named "value" or the first group.
+	Regex string
+	// Scale multiplies the values, e.g. 0.001 to observe durations logged in
+	// milliseconds in seconds. 0 means 1.
+	Scale float64
+	// Window is the duration in which observations count towards the
+	// quantiles of a summary. 0 means DefaultLogMetricWindow.
+	Window time.Duration
+	// Quantiles are the quantiles of a summary, DefaultLogMetricQuantiles if
+	// empty.
+	Quantiles []float64
+	// Buckets are the upper bounds of the buckets of a histogram, without
+	// +Inf, DefaultLogMetricBuckets if empty.
+	Buckets []float64
+}
+
+// observation is a value observed at a point in time.
+type observation struct {
+	at time.Time
+	value float64
+}
+
+// LogMetric is a summary or a histogram of values extracted from log lines,
+// e.g. the latencies in the lines of an access log. The quantiles of a
+// summary are computed over the observations of a sliding window, like the
+// summaries of the Prometheus client libraries, so they follow the shape of
+// the replayed log instead of converging to the quantiles of all lines. The
+// sum and count of the observations and the buckets of a histogram are
+// counters over all observations.
+type LogMetric struct {
+	metric *Metric
+	regex *regexp.Regexp
+	group int
+	scale float64
+	window time.Duration
+	quantiles []float64
+	buckets []float64
+	now func() time.Time
+
+	mu sync.Mutex
+	observations []observation // of the window, oldest first
+	counts []uint64 // observations per bucket, not cumulative, the last for +Inf
+	sum float64
+	count uint64
+}
+
+// NewLogMetric creates a LogMetric of the given metric, which must be a
+// summary or a histogram. The script of the metric is not used.
+func NewLogMetric(m *Metric, options LogMetricOptions) (*LogMetric, error) {
+	if m.Type() != SummaryType && m.Type() != HistogramType {
+		return nil, fmt.Errorf("invalid type of log metric %s, must be summary or histogram", m.Name())
+	}
+	rx, err := regexp.Compile(options.Regex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex of log metric %s: %w", m.Name(), err)
+	}
+	group := rx.SubexpIndex("value")
+	if group < 0 {
+		group = 1
+	}
+	if rx.NumSubexp() < group {
+		return nil, fmt.Errorf("invalid regex of log metric %s: no group matching the value", m.Name())
+	}
+	lm := &LogMetric{metric: m, regex: rx, group: group, scale: options.Scale, window: options.Window,
+		quantiles: options.Quantiles, buckets: options.Buckets, now: time.Now}
+	if lm.scale == 0 {
+		lm.scale = 1
+	}
+	if lm.window == 0 {
+		lm.window = DefaultLogMetricWindow
+	}
+	if lm.window < 0 {
+		return nil, fmt.Errorf("invalid window of log metric %s: %s, must be positive", m.Name(), lm.window)
+	}
+	if len(lm.quantiles) == 0 {
+		lm.quantiles = DefaultLogMetricQuantiles
+	}
+	for _, q := range lm.quantiles {
+		if q < 0 || q > 1 {
+			return nil, fmt.Errorf("invalid quantile of log metric %s: %v, must be between 0 and 1", m.Name(), q)
+		}
+	}
+	if len(lm.buckets) == 0 {
+		lm.buckets = DefaultLogMetricBuckets
+	}
+	if !slices.IsSorted(lm.buckets) {
+		return nil, fmt.Errorf("invalid buckets of log metric %s: must be in increasing order", m.Name())
+	}
+	lm.counts = make([]uint64, len(lm.buckets)+1)
+	return lm, nil
+}
+
+// Metric returns the metric of the log metric.
+func (lm *LogMetric) Metric() *Metric {
+	return lm.metric
+}
+
+// Observe extracts the value of the line and observes it. It returns false if
+// the line does not match the regex or the value is not a number.
+func (lm *LogMetric) Observe(line string) bool {
+	m := lm.regex.FindStringSubmatch(line)
+	if m == nil {
+		return false
+	}
+	v, err := strconv.ParseFloat(m[lm.group], 64)
+	if err != nil {
+		return false
+	}
+	v *= lm.scale
+	now := lm.now()
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.sum += v
+	lm.count++
+	if lm.metric.Type() == HistogramType {
+		i, _ := slices.BinarySearch(lm.buckets, v)
+		lm.counts[i]++
+		return true
+	}
+	lm.expire(now)
+	lm.observations = append(lm.observations, observation{at: now, value: v})
+	return true
+}
+
+// expire removes the observations that left the window at now.
+func (lm *LogMetric) expire(now time.Time) {
+	start := now.Add(-lm.window)
+	i := 0
+	for i < len(lm.observations) && !lm.observations[i].at.After(start) {
+		i++
+	}
+	if i > 0 {
+		lm.observations = slices.Delete(lm.observations, 0, i)
+	}
+}
+
+// Value returns the current value of the log metric, in the form the scripts
+// of summaries and histograms return: the quantiles of the window or the
+// cumulative bucket counts by their upper bound, and "sum" and "count". The
+// quantiles are NaN while the window is empty.
+func (lm *LogMetric) Value() MetricValue {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	value := map[string]any{"sum": lm.sum, "count": lm.count}
+	if lm.metric.Type() == HistogramType {
+		var cumulative uint64
+		for i, bound := range lm.buckets {
+			cumulative += lm.counts[i]
+			value[strconv.FormatFloat(bound, 'g', -1, 64)] = cumulative
+		}
+		value["+Inf"] = lm.count
+		return NewMetricValue(lm.metric, value)
+	}
+	lm.expire(lm.now())
+	values := make([]float64, len(lm.observations))
+	for i, o := range lm.observations {
+		values[i] = o.value
+	}
+	slices.Sort(values)
+	for _, q := range lm.quantiles {
+		value[strconv.FormatFloat(q, 'g', -1, 64)] = quantile(values, q)
+	}
+	return NewMetricValue(lm.metric, value)
+}
+
+// quantile returns the q-quantile of the sorted values, interpolated
+// linearly between the closest ranks, or NaN if there are no values.
+func quantile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+	rank := q * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := min(lower+1, len(sorted)-1)
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(rank-float64(lower))
+}
+
+// LogMetricsCollector returns a collector of the values of the log metrics.
+func LogMetricsCollector(lms []*LogMetric) Collector {
+	return func() []MetricValue {
+		values := make([]MetricValue, len(lms))
+		for i, lm := range lms {
+			values[i] = lm.Value()
+		}
+		return values
+	}
+}
+
+// LogMetricsFromEnv creates the log metrics configured by environment
+// variables, in the form of os.Environ, of the form
+// LOG_METRIC_<name>_<setting>. The settings are REGEX, which is required, TYPE
+// (summary or histogram, summary by default), SCALE, WINDOW, QUANTILES and
+// BUCKETS as comma-separated numbers, see LogMetricOptions, and DESCR and
+// LABEL like for the metrics configured by METRIC_ variables. The metrics are
+// sorted by name. It returns an error describing every invalid variable and
+// metric.
+func LogMetricsFromEnv(environ []string) ([]*LogMetric, error) {
+	configs := map[string]*logMetricConfig{}
+	var errs []error
+	for _, e := range slices.Sorted(slices.Values(environ)) {
+		key, value, _ := strings.Cut(e, "=")
+		rest, ok := strings.CutPrefix(key, LogMetricEnvNamePrefix)
+		if !ok {
+			continue
+		}
+		i := strings.LastIndex(rest, "_")
+		if i <= 0 {
+			errs = append(errs, fmt.Errorf("invalid log metric variable %s, must be like %s<name>_REGEX", key,
+				LogMetricEnvNamePrefix))
+			continue
+		}
+		name, setting := rest[:i], rest[i+1:]
+		c, ok := configs[name]
+		if !ok {
+			c = &logMetricConfig{builder: NewMetricBuilder(name).WithScript("0")}
+			c.builder.Type = SummaryType
+			configs[name] = c
+		}
+		if err := c.set(setting, value); err != nil {
+			errs = append(errs, fmt.Errorf("invalid variable %s: %w", key, err))
+		}
+	}
+	var lms []*LogMetric
+	for _, name := range slices.Sorted(maps.Keys(configs)) {
+		c := configs[name]
+		if len(c.options.Regex) == 0 {
+			errs = append(errs, fmt.Errorf("missing %s%s_REGEX", LogMetricEnvNamePrefix, name))
+			continue
+		}
+		m, _ := c.builder.Build()
+		if !isValidLabelName(name) {
+			errs = append(errs, fmt.Errorf("invalid log metric name %q", name))
+			continue
+		}
+		lm, err := NewLogMetric(m, c.options)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		lms = append(lms, lm)
+	}
+	return lms, errors.Join(errs...)
+}
+
+// logMetricConfig is the configuration of a log metric read by
+// LogMetricsFromEnv.
+type logMetricConfig struct {
+	builder *MetricBuilder
+	options LogMetricOptions
+}
+
+// set sets a setting of the configuration.
+func (c *logMetricConfig) set(setting, value string) error {
+	var err error
+	switch setting {
+	case "REGEX":
+		c.options.Regex = value
+	case "TYPE":
+		t, ok := stringToMetricType(value)
+		if !ok || (t != SummaryType && t != HistogramType) {
+			return fmt.Errorf("invalid type %s, must be summary or histogram", value)
+		}
+		c.builder.Type = t
+	case "SCALE":
+		if c.options.Scale, err = strconv.ParseFloat(value, 64); err != nil || c.options.Scale <= 0 {
+			return fmt.Errorf("invalid scale %s, must be a positive number", value)
+		}
+	case "WINDOW":
+		if c.options.Window, err = time.ParseDuration(value); err != nil || c.options.Window <= 0 {
+			return fmt.Errorf("invalid window %s, must be a positive duration", value)
+		}
+	case "QUANTILES":
+		c.options.Quantiles, err = parseFloats(value)
+	case "BUCKETS":
+		c.options.Buckets, err = parseFloats(value)
+	case "DESCR":
+		c.builder.WithDescription(value)
+	case "LABEL":
+		for _, label := range strings.Split(value, ",") {
+			k, v, ok := strings.Cut(label, "=")
+			if !ok {
+				return fmt.Errorf("invalid label %s, must be like name=value", label)
+			}
+			if _, err := c.builder.WithLabel(strings.TrimSpace(k), strings.TrimSpace(v)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown setting %s, must be REGEX, TYPE, SCALE, WINDOW, QUANTILES, BUCKETS, DESCR or LABEL",
+			setting)
+	}
+	return err
+}
+
+// parseFloats parses comma-separated numbers.
+func parseFloats(s string) ([]float64, error) {
+	var values []float64
+	for _, f := range strings.Split(s, ",") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", f)
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogMetric_Summary(t *testing.T) {
+	lms, err := LogMetricsFromEnv([]string{
+		`LOG_METRIC_request_duration_seconds_REGEX=took (?P<value>\d+)ms`,
+		"LOG_METRIC_request_duration_seconds_SCALE=0.001",
+		"LOG_METRIC_request_duration_seconds_WINDOW=70s",
+		"LOG_METRIC_request_duration_seconds_QUANTILES=0.5,1",
+		"LOG_METRIC_request_duration_seconds_LABEL=job=api",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create log metrics: %s", err)
+	}
+	if len(lms) != 1 {
+		t.Fatalf("Expected 1 log metric, got %d", len(lms))
+	}
+	lm := lms[0]
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	lm.now = func() time.Time { return now }
+
+	value := lm.Value().Value().(map[string]any)
+	if !math.IsNaN(value["0.5"].(float64)) || value["count"] != uint64(0) {
+		t.Errorf("Expected NaN quantiles without observations, got %v", value)
+	}
+	for _, line := range []string{"GET / took 100ms", "GET /slow took 900ms", "GET / took 200ms", "started"} {
+		lm.Observe(line)
+		now = now.Add(20 * time.Second)
+	}
+	// The first observation left the window
+	value = lm.Value().Value().(map[string]any)
+	if value["0.5"] != 0.55 || value["1"] != 0.9 {
+		t.Errorf("Expected the quantiles of the window, got %v", value)
+	}
+	if math.Abs(value["sum"].(float64)-1.2) > 1e-9 || value["count"] != uint64(3) {
+		t.Errorf("Expected the sum and count of all observations, got %v", value)
+	}
+
+	lines := lm.Value().Samples()
+	for _, expected := range []string{
+		`request_duration_seconds {job="api",quantile="0.5"} 0.55`,
+		`request_duration_seconds_count {job="api"} 3`,
+	} {
+		if !strings.Contains(lines, expected) {
+			t.Errorf("Expected %s in\n%s", expected, lines)
+		}
+	}
+}
+
+func TestLogMetric_Histogram(t *testing.T) {
+	lms, err := LogMetricsFromEnv([]string{
+		`LOG_METRIC_response_size_bytes_REGEX=size=(\d+)`,
+		"LOG_METRIC_response_size_bytes_TYPE=histogram",
+		"LOG_METRIC_response_size_bytes_BUCKETS=100,1000",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create log metrics: %s", err)
+	}
+	for _, line := range []string{"size=50", "size=100", "size=500", "size=5000"} {
+		lms[0].Observe(line)
+	}
+	value := lms[0].Value().Value().(map[string]any)
+	for k, expected := range map[string]any{"100": uint64(2), "1000": uint64(3), "+Inf": uint64(4), "count": uint64(4),
+		"sum": 5650.0} {
+		if value[k] != expected {
+			t.Errorf("Expected %v for %s, got %v", expected, k, value[k])
+		}
+	}
+}
+
+func TestLogMetricsFromEnv_Errors(t *testing.T) {
+	_, err := LogMetricsFromEnv([]string{
+		"LOG_METRIC_a_TYPE=gauge",
+		"LOG_METRIC_a_REGEX=(\\d+)",
+		"LOG_METRIC_b_WINDOW=1m",
+		"LOG_METRIC_c_REGEX=no group",
+		"LOG_METRIC_d_QUANTILES=0.5,2",
+		"LOG_METRIC_d_REGEX=(\\d+)",
+		"LOG_METRIC_e_COLOR=red",
+		"OTHER=1",
+	})
+	var joined interface{ Unwrap() []error }
+	if !errors.As(err, &joined) {
+		t.Fatalf("Expected joined errors, got %v", err)
+	}
+	expected := []string{
+		"invalid variable LOG_METRIC_a_TYPE: invalid type gauge, must be summary or histogram",
+		"invalid variable LOG_METRIC_e_COLOR: unknown setting COLOR, must be REGEX, TYPE, SCALE, WINDOW, QUANTILES, BUCKETS, DESCR or LABEL",
+		"missing LOG_METRIC_b_REGEX",
+		"invalid regex of log metric c: no group matching the value",
+		"invalid quantile of log metric d: 2, must be between 0 and 1",
+		"missing LOG_METRIC_e_REGEX",
+	}
+	if len(joined.Unwrap()) != len(expected) {
+		t.Fatalf("Expected %d errors, got %v", len(expected), err)
+	}
+	for i, err := range joined.Unwrap() {
+		if err.Error() != expected[i] {
+			t.Errorf("Expected error %q, got %q", expected[i], err)
+		}
+	}
+}
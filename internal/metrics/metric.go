@@ -1,8 +1,10 @@
 package metrics
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/dop251/goja"
@@ -17,7 +19,7 @@ const (
 )
 
 const (
-	MetricExpressionFuncTemplate = "function %s(t, prev) { return %s }"
+	MetricExpressionFuncTemplate = "function %s(t, prev, state) { return %s }"
 )
 
 type Metric struct {
@@ -27,6 +29,9 @@ type Metric struct {
 	labels map[string]string
 	description string
 	lastval goja.Value
+	state string // JSON of the state object of the script, empty before the first evaluation
+	counter *counterValue // of counters, nil before the first evaluation
+	mu sync.Mutex
 }
 
 // NewMetric constructs a new Metric instance with the specified name, type,
@@ -56,8 +61,10 @@ func (m *Metric) Type() int {
 
 // Script returns the script that defines the metric. The script is a Go
 // expression that is executed in a context where the "t" variable is the
-// elapsed time since the metric was created. The script should return a
-// value of the appropriate type for the metric type.
+// elapsed time since the metric was created, "prev" is the value of the last
+// evaluation and "state" an object whose properties are kept between
+// evaluations. The script should return a value of the appropriate type for
+// the metric type.
 func (m *Metric) Script() string {
 	return m.script
 }
@@ -75,6 +82,42 @@ func (m *Metric) Description() string {
 	return m.description
 }
 
+// LastValue returns the value emitted by the last evaluation of the metric,
+// or nil if the metric has not been evaluated yet.
+func (m *Metric) LastValue() any {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.lastval == nil {
+		return nil
+	}
+	return m.lastval.Export()
+}
+
+// setLastValue sets the value passed as prev to the next evaluation.
+func (m *Metric) setLastValue(v goja.Value) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastval = v
+}
+
+// State returns the JSON of the state object kept by the script of the metric
+// between evaluations, or nil if the metric has not been evaluated yet.
+func (m *Metric) State() json.RawMessage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.state) == 0 {
+		return nil
+	}
+	return json.RawMessage(m.state)
+}
+
+// setState sets the state object passed to the next evaluation.
+func (m *Metric) setState(state json.RawMessage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = string(state)
+}
+
 // String returns the name of the metric as a string.
 func (m *Metric) String() string {
 	return m.Name()
@@ -84,28 +127,77 @@ func (m *Metric) String() string {
 // The timestamp given to the metric is the time elapsed since instantiation or the
 // last call to Reset.
 func (m *Metric) Eval(vm *goja.Runtime, t time.Duration) (MetricValue, error) {
-	fnScript := m.Script()
-	if !strings.HasPrefix(m.Script(), "function") {
-		fnScript = fmt.Sprintf(MetricExpressionFuncTemplate, m.Name(), m.Script())
-	}
-	_, err := vm.RunString(fnScript)
+	fn, err := m.define(vm)
 	if err != nil {
 		return MetricValue{}, err
 	}
-	fn, ok := goja.AssertFunction(vm.Get(m.Name()))
-	if !ok {
-		return MetricValue{}, fmt.Errorf("metric %s is not a function", m.Name())
-	}
 
-	res, err := fn(goja.Undefined(), vm.ToValue(t.Milliseconds()), m.lastval)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, err := parseState(vm, m.state)
+	if err != nil {
+		return MetricValue{}, err
+	}
+	res, err := fn(goja.Undefined(), vm.ToValue(t.Milliseconds()), m.lastval, state)
 	if err != nil {
 		return MetricValue{}, err
 	}
+	if m.state, err = stringifyState(vm, state); err != nil {
+		return MetricValue{}, fmt.Errorf("invalid state of metric %s: %w", m.Name(), err)
+	}
 	m.lastval = res
 	// TODO: parse result based on metric type (as-is only works for gauge and counter)
 	return NewMetricValue(m, res.Export()), nil;
 }
 
+// parseState creates the state object of a script in vm from its JSON, an
+// empty object if the JSON is empty. The state is kept as JSON between
+// evaluations, so it can be passed to the runtimes of later evaluations and
+// persisted.
+func parseState(vm *goja.Runtime, state string) (goja.Value, error) {
+	if len(state) == 0 {
+		return vm.NewObject(), nil
+	}
+	parse, _ := goja.AssertFunction(vm.Get("JSON").ToObject(vm).Get("parse"))
+	return parse(goja.Undefined(), vm.ToValue(state))
+}
+
+// stringifyState returns the JSON of the state object of a script. Values
+// that JSON cannot represent, like functions, are dropped and NaN and
+// Infinity become null.
+func stringifyState(vm *goja.Runtime, state goja.Value) (string, error) {
+	stringify, _ := goja.AssertFunction(vm.Get("JSON").ToObject(vm).Get("stringify"))
+	v, err := stringify(goja.Undefined(), state)
+	if err != nil {
+		return "", err
+	}
+	return v.String(), nil
+}
+
+// Compile checks that the script of the metric compiles and defines the
+// function of the metric, without evaluating it.
+func (m *Metric) Compile() error {
+	_, err := m.define(goja.New())
+	return err
+}
+
+// define runs the script of the metric in vm, which defines the function of
+// the metric, and returns the function.
+func (m *Metric) define(vm *goja.Runtime) (goja.Callable, error) {
+	fnScript := m.Script()
+	if !strings.HasPrefix(m.Script(), "function") {
+		fnScript = fmt.Sprintf(MetricExpressionFuncTemplate, m.Name(), m.Script())
+	}
+	if _, err := vm.RunString(fnScript); err != nil {
+		return nil, err
+	}
+	fn, ok := goja.AssertFunction(vm.Get(m.Name()))
+	if !ok {
+		return nil, fmt.Errorf("metric %s is not a function", m.Name())
+	}
+	return fn, nil
+}
+
 type MetricValue struct {
 	metric *Metric
 	value any
@@ -1,37 +1,204 @@
 package metrics
 
 import (
+	"bananabacon/internal/clock"
+	"sync"
 	"time"
 
 	"github.com/dop251/goja"
 )
 
 type MetricsEngine struct {
+	// Metrics are the metrics of the engine. Use List while SetMetrics may
+	// be called concurrently.
 	Metrics []*Metric
-	startTime time.Time
+	metricsMu sync.RWMutex
+	clock clock.Clock
+	start time.Duration // elapsed time of the clock at which t is zero
+	crons map[string]*CronSchedule // parsed expressions of the cron helper
+	cronsMu sync.Mutex
+	modifiers []Modifier
+	counterMode string
 }
 
+// Modifier changes the value of a gauge, counter or untyped metric after it
+// has been evaluated, e.g. to simulate an incident. It is called on every
+// evaluation, also if it returns the value unchanged.
+type Modifier func(m *Metric, value float64) float64
+
 // NewMetricsEngine constructs a new MetricsEngine instance from the provided
 // metrics. The timestamp passed to each metric's Eval method will be the
 // elapsed time since the MetricsEngine was created.
 func NewMetricsEngine(metrics []*Metric) *MetricsEngine {
 	return &MetricsEngine{
 		Metrics: metrics,
-		startTime: time.Now(),
+		clock: clock.Wall(),
+		crons: map[string]*CronSchedule{},
+		counterMode: CounterAccumulate,
+	}
+}
+
+// SetClock sets the clock of the engine: the elapsed time passed to the
+// metrics as t advances like the elapsed time of the clock and the
+// time-of-day helpers of the scripts use its current time. With the clock of
+// the log replay, t advances at the replay speed and the diurnal patterns stay
+// aligned with the replayed log under time acceleration. t continues from its
+// current value. The default is the wall clock. It must not be called while
+// the metrics are evaluated.
+func (me *MetricsEngine) SetClock(c clock.Clock) {
+	elapsed := me.elapsed()
+	me.clock = c
+	me.start = c.Elapsed() - elapsed
+}
+
+// elapsed returns the time passed to the metrics as t.
+func (me *MetricsEngine) elapsed() time.Duration {
+	return me.clock.Elapsed() - me.start
+}
+
+// NewRuntime creates a Goja runtime for evaluating metrics with the following
+// helpers, which use the clock set by SetClock:
+//
+// - now(): the current time as a Date
+// - hour(): the hour of the day, 0-23
+// - weekday(): the day of the week, 0 (Sunday) to 6
+// - businessHours(start, end): true on Monday to Friday between the given
+//   hours, 9 to 17 if omitted
+// - cron(expr): true if the current minute matches the cron expression
+// - resetCounter(): simulates a reset of the counter being evaluated, see
+//   SetCounterMode
+func (me *MetricsEngine) NewRuntime() *goja.Runtime {
+	vm := goja.New()
+	vm.Set("now", func() goja.Value {
+		d, _ := vm.New(vm.Get("Date"), vm.ToValue(me.clock.Now().UnixMilli()))
+		return d
+	})
+	vm.Set("hour", func() int {
+		return me.clock.Now().Hour()
+	})
+	vm.Set("weekday", func() int {
+		return int(me.clock.Now().Weekday())
+	})
+	vm.Set("businessHours", func(call goja.FunctionCall) goja.Value {
+		start, end := int64(9), int64(17)
+		if len(call.Arguments) >= 2 {
+			start, end = call.Argument(0).ToInteger(), call.Argument(1).ToInteger()
+		}
+		t := me.clock.Now()
+		weekday := t.Weekday() != time.Saturday && t.Weekday() != time.Sunday
+		return vm.ToValue(weekday && int64(t.Hour()) >= start && int64(t.Hour()) < end)
+	})
+	vm.Set("cron", func(expr string) bool {
+		cs, err := me.cron(expr)
+		if err != nil {
+			panic(vm.NewGoError(err))
+		}
+		return cs.Matches(me.clock.Now())
+	})
+	vm.Set("resetCounter", func() {})
+	return vm
+}
+
+// cron returns the parsed cron expression, parsing it on first use.
+func (me *MetricsEngine) cron(expr string) (*CronSchedule, error) {
+	me.cronsMu.Lock()
+	defer me.cronsMu.Unlock()
+	if cs, ok := me.crons[expr]; ok {
+		return cs, nil
+	}
+	cs, err := ParseCron(expr)
+	if err != nil {
+		return nil, err
 	}
+	me.crons[expr] = cs
+	return cs, nil
 }
 
-// Reset sets the startTime of the MetricsEngine to the current time.
+// Reset sets the start of the MetricsEngine to the current time of its clock.
 // This effectively resets the time elapsed since the engine's creation
 // or the last reset, affecting timestamps passed to metric evaluations.
 func (me *MetricsEngine) Reset() {
-	me.startTime = time.Now()
+	me.start = me.clock.Elapsed()
 }
 
 // Eval evaluates the given metric using the given Goja runtime
 // and returns its result and any error that occurred.
 // The timestamp given to the metric is the time elapsed since instantiation or the
-// last call to Reset.
+// last call to Reset. Counters are kept monotonic as set by SetCounterMode.
+// Numeric values are then changed by the modifiers added with AddModifier.
 func (me *MetricsEngine) Eval(metric *Metric, vm *goja.Runtime) (MetricValue, error) {
-	return metric.Eval(vm, time.Since(me.startTime))
+	return me.evalAt(metric, vm, me.elapsed())
+}
+
+// evalAt evaluates the metric like Eval with t as the elapsed time.
+func (me *MetricsEngine) evalAt(metric *Metric, vm *goja.Runtime, t time.Duration) (MetricValue, error) {
+	counter := metric.Type() == CounterType && me.counterMode != CounterOff
+	reset := false
+	if counter {
+		vm.Set("resetCounter", func() { reset = true })
+	}
+	mv, err := metric.Eval(vm, t)
+	if err != nil || metric.Type() == HistogramType || metric.Type() == SummaryType {
+		return mv, err
+	}
+	v, ok := toFloat(mv.value)
+	if !ok {
+		return mv, nil
+	}
+	modified := v
+	if counter {
+		modified = metric.monotonic(v, me.counterMode, reset)
+	}
+	for _, modify := range me.modifiers {
+		modified = modify(metric, modified)
+	}
+	if modified != v {
+		mv.value = modified
+	}
+	return mv, nil
+}
+
+// List returns the current metrics of the engine.
+func (me *MetricsEngine) List() []*Metric {
+	me.metricsMu.RLock()
+	defer me.metricsMu.RUnlock()
+	return me.Metrics
+}
+
+// SetMetrics replaces the metrics of the engine, e.g. after the configuration
+// was reloaded. Metrics with the same name and labels as a replaced metric
+// continue from its last value, state and exposed counter value, so counters do
+// not start over. The elapsed time passed to the metrics is kept.
+func (me *MetricsEngine) SetMetrics(metrics []*Metric) {
+	last := map[string]*Metric{}
+	for _, m := range me.List() {
+		last[seriesKey(m)] = m
+	}
+	vm := goja.New()
+	for _, m := range metrics {
+		previous, ok := last[seriesKey(m)]
+		if !ok || previous == m {
+			continue
+		}
+		if v := previous.LastValue(); v != nil {
+			m.setLastValue(vm.ToValue(v))
+		}
+		if state := previous.State(); state != nil {
+			m.setState(state)
+		}
+		if exposed, ok := previous.exposedCounter(); ok && m.Type() == CounterType {
+			raw, _ := toFloat(previous.LastValue())
+			m.setExposedCounter(raw, exposed)
+		}
+	}
+	me.metricsMu.Lock()
+	me.Metrics = metrics
+	me.metricsMu.Unlock()
+}
+
+// AddModifier adds a modifier applied to the values of the metrics, after the
+// modifiers added before. It must not be called while the metrics are
+// evaluated.
+func (me *MetricsEngine) AddModifier(m Modifier) {
+	me.modifiers = append(me.modifiers, m)
 }
\ No newline at end of file
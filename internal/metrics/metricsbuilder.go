@@ -2,9 +2,12 @@ package metrics
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"maps"
 	"os"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -137,7 +140,12 @@ func NewMetricsEngineBuilderFromEnv() (MetricsEngineBuilder, error) {
 // an equals sign.
 func (mb MetricsEngineBuilder) AddFromEnv(varName, value string) (MetricsEngineBuilder, error) {
 	if strings.HasPrefix(varName, MetricEnvNamePrefix) {
-		name := varName[len(MetricEnvNamePrefix):strings.LastIndex(varName, "_")]
+		i := strings.LastIndex(varName, "_")
+		if suffix := varName[i:]; i <= len(MetricEnvNamePrefix) || (suffix != MetricExprEnvNameSuffix &&
+			suffix != MetricTypeEnvNameSuffix && suffix != MetricDescrEnvNameSuffix && suffix != MetricLabelEnvNameSuffix) {
+			return mb, errors.New("Invalid metric variable " + varName + ", must be like METRIC_<name>_EXPR, _TYPE, _DESCR or _LABEL")
+		}
+		name := varName[len(MetricEnvNamePrefix):i]
 		builder, ok := mb[name]
 		if !ok {
 			builder = NewMetricBuilder(name)
@@ -160,6 +168,9 @@ func (mb MetricsEngineBuilder) AddFromEnv(varName, value string) (MetricsEngineB
 			vars := strings.Split(value, ",")
 			for _, v := range vars {
 				parts := strings.SplitN(v, "=", 2)
+				if len(parts) < 2 {
+					return mb, errors.New("Invalid label " + v + " for metric " + name + ", must be like name=value")
+				}
 				_, err := builder.WithLabel(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
 				if err != nil {
 					return mb, err
@@ -170,6 +181,58 @@ func (mb MetricsEngineBuilder) AddFromEnv(varName, value string) (MetricsEngineB
 	return mb, nil
 }
 
+// ValidateEnv checks the metrics configured by the given environment
+// variables, in the form of os.Environ, and returns the builder of the valid
+// metrics and an error for every invalid variable and every metric with an
+// invalid name or a script that does not compile. Unlike
+// NewMetricsEngineBuilderFromEnv, it reports all problems instead of logging
+// and ignoring them.
+func ValidateEnv(environ []string) (MetricsEngineBuilder, []error) {
+	mb := newMetricsEngineBuilder()
+	var errs []error
+	invalid := map[string]bool{}
+	environ = slices.Sorted(slices.Values(environ))
+	for _, e := range environ {
+		pair := strings.SplitN(e, "=", 2)
+		if len(pair) < 2 {
+			continue
+		}
+		if _, err := mb.AddFromEnv(pair[0], pair[1]); err != nil {
+			errs = append(errs, err)
+			if i := strings.LastIndex(pair[0], "_"); i > len(MetricEnvNamePrefix) {
+				invalid[pair[0][len(MetricEnvNamePrefix):i]] = true
+			}
+		}
+	}
+	names := slices.Sorted(maps.Keys(mb))
+	for _, name := range names {
+		metric, ok := mb[name].Build()
+		if !ok || invalid[name] {
+			delete(mb, name)
+			continue
+		}
+		if err := ValidateMetric(metric); err != nil {
+			errs = append(errs, err)
+			delete(mb, name)
+		}
+	}
+	return mb, errs
+}
+
+// ValidateMetric returns an error if the name of the metric is invalid or its
+// script does not compile.
+func ValidateMetric(m *Metric) error {
+	// The names are also the names of JavaScript functions, so they cannot
+	// contain colons unlike other Prometheus metric names
+	if !isValidLabelName(m.Name()) {
+		return fmt.Errorf("invalid metric name %q", m.Name())
+	}
+	if err := m.Compile(); err != nil {
+		return fmt.Errorf("invalid script of metric %s: %w", m.Name(), err)
+	}
+	return nil
+}
+
 // Build constructs a MetricsEngine instance from the MetricBuilders in the
 // MetricsEngineBuilder. It iterates over each MetricBuilder, building a Metric
 // if it is complete, and adds it to the list of metrics. Returns a new
@@ -185,6 +248,12 @@ func (m MetricsEngineBuilder) Build() *MetricsEngine {
 	return NewMetricsEngine(metrics)
 }
 
+// ParseMetricType returns the metric type named like in the METRIC_<name>_TYPE
+// variables, e.g. "counter", and whether s is a valid type.
+func ParseMetricType(s string) (int, bool) {
+	return stringToMetricType(s)
+}
+
 // stringToMetricType takes a string value and returns a corresponding metric type.
 // It returns true as the second value if the string is a valid metric type, and
 // false otherwise. Valid metric type strings are "counter", "gauge", "histogram",
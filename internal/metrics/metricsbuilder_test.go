@@ -0,0 +1,34 @@
+package metrics
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestValidateEnv(t *testing.T) {
+	builder, errs := ValidateEnv([]string{
+		"METRIC_ok_EXPR=t * 2",
+		"METRIC_ok_TYPE=counter",
+		"METRIC_syntax_EXPR=t +",
+		"METRIC_type_TYPE=countr",
+		"METRIC_label_LABEL=app",
+		"METRIC_suffix_FOO=1",
+		"METRIC_noname=1",
+		"METRICS_PORT=8080",
+	})
+	if _, ok := builder["ok"]; !ok || len(builder) != 1 {
+		t.Errorf("Expected only the valid metric in the builder, got %v", slices.Collect(maps.Keys(builder)))
+	}
+	expected := []string{"Invalid label app", "Invalid metric variable METRIC_noname", "Invalid metric variable METRIC_suffix_FOO",
+		"Invalid metrics type for metric type", "invalid script of metric syntax"}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %v", len(expected), errs)
+	}
+	for i, err := range errs {
+		if !strings.Contains(err.Error(), expected[i]) {
+			t.Errorf("Expected error containing %q, got %v", expected[i], err)
+		}
+	}
+}
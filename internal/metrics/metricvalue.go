@@ -38,6 +38,18 @@ func NewMetricValue(metric *Metric, value any) MetricValue {
 // The metric description and type are only included if the metric has a
 // description and type, respectively.
 func (mv MetricValue) String() string {
+	helpLine := ""
+	if len(mv.Metric().Description()) > 0 {
+		helpLine = fmt.Sprintf("# HELP %s %s\n", mv.Metric().Name(), mv.Metric().Description())
+	}
+	typeLine := fmt.Sprintf("# TYPE %s %s\n", mv.Metric().Name(), MetricTypeToString(mv.Metric().Type()))
+	return fmt.Sprintf("%s%s%s", helpLine, typeLine, mv.Samples())
+}
+
+// Samples returns the sample lines of the MetricValue in the format expected by
+// Prometheus, i.e. String without the HELP and TYPE lines. It is used to write
+// multiple series of the same metric family under a single header.
+func (mv MetricValue) Samples() string {
 	var sb strings.Builder
 
 	cnt := 0
@@ -48,11 +60,6 @@ func (mv MetricValue) String() string {
 			sb.WriteString(",")
 		}
 	}
-	helpLine := ""
-	if len(mv.Metric().Description()) > 0 {
-		helpLine = fmt.Sprintf("# HELP %s %s\n", mv.Metric().Name(), mv.Metric().Description())
-	}
-	typeLine := fmt.Sprintf("# TYPE %s %s\n", mv.Metric().Name(), MetricTypeToString(mv.Metric().Type()))
 	var valueLines string
 	if mv.Metric().Type() == GaugeType || mv.Metric().Type() == CounterType || mv.Metric().Type() == UntypedType {
 		valueLines = fmt.Sprintf("%s {%s} %v", mv.Metric().Name(), sb.String(), mv.Value())
@@ -61,7 +68,7 @@ func (mv MetricValue) String() string {
 	} else if mv.Metric().Type() == SummaryType {
 		valueLines = createSummaryLines(mv, sb.String())
 	}
-	return fmt.Sprintf("%s%s%s", helpLine, typeLine, valueLines)
+	return valueLines
 }
 
 // MetricTypeToString takes a metric type as an integer (as returned by
@@ -85,7 +92,11 @@ func MetricTypeToString(t int) string {
 func createSummaryLines(mv MetricValue, labels string) string {
 	var msb strings.Builder
 	for k, v := range mv.Value().(map[string]any) {
-		labels := fmt.Sprintf("%s,quantile=\"%s\"", labels, k)
+		if k == "sum" || k == "count" {
+			msb.WriteString(fmt.Sprintf("%s_%s {%s} %v\n", mv.Metric().Name(), k, labels, v))
+			continue
+		}
+		labels := joinLabels(labels, fmt.Sprintf("quantile=\"%s\"", k))
 		msb.WriteString(fmt.Sprintf("%s {%s} %v\n", mv.Metric().Name(), labels, v))
 	}
 	return msb.String()
@@ -95,15 +106,24 @@ func createHistogramLines(mv MetricValue, labels string) string {
 	var msb strings.Builder
 	var suffix string
 	for k, v := range mv.Value().(map[string]any) {
+		bucketLabels := labels
 		if k == "sum" {
 			suffix = "_sum"
 		} else if k == "count" {
 			suffix = "_count"
 		} else {
 			suffix = "_bucket"
-			labels = fmt.Sprintf("%s,le=\"%s\"", labels, k)
+			bucketLabels = joinLabels(labels, fmt.Sprintf("le=\"%s\"", k))
 		}
-		msb.WriteString(fmt.Sprintf("%s%s {%s} %v\n", mv.Metric().Name(), suffix, labels, v))
+		msb.WriteString(fmt.Sprintf("%s%s {%s} %v\n", mv.Metric().Name(), suffix, bucketLabels, v))
 	}
 	return msb.String()
+}
+
+// joinLabels appends a label to the comma-separated labels.
+func joinLabels(labels, label string) string {
+	if len(labels) == 0 {
+		return label
+	}
+	return labels + "," + label
 }
\ No newline at end of file
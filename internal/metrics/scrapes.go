@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// ScrapeHistorySize is the number of scrape records kept by a ScrapeHistory.
+	ScrapeHistorySize = 100
+)
+
+// ScrapeRecord describes a single request to the metrics endpoint.
+type ScrapeRecord struct {
+	Timestamp  time.Time     `json:"timestamp"`
+	RemoteAddr string        `json:"remoteAddr"`
+	Duration   time.Duration `json:"duration"`
+	Bytes      int           `json:"bytes"`
+}
+
+// ScrapeHistory keeps the most recent scrape records in a ring buffer.
+// It is safe for concurrent use.
+type ScrapeHistory struct {
+	mu      sync.Mutex
+	records []ScrapeRecord
+	next    int
+	full    bool
+}
+
+// NewScrapeHistory creates a new ScrapeHistory that keeps at most size records.
+func NewScrapeHistory(size int) *ScrapeHistory {
+	return &ScrapeHistory{
+		records: make([]ScrapeRecord, size),
+	}
+}
+
+// Add records a scrape. If the history is full, the oldest record is dropped.
+func (sh *ScrapeHistory) Add(record ScrapeRecord) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if len(sh.records) == 0 {
+		return
+	}
+	sh.records[sh.next] = record
+	sh.next = (sh.next + 1) % len(sh.records)
+	if sh.next == 0 {
+		sh.full = true
+	}
+}
+
+// Records returns a copy of the recorded scrapes, oldest first.
+func (sh *ScrapeHistory) Records() []ScrapeRecord {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if !sh.full {
+		return append([]ScrapeRecord{}, sh.records[:sh.next]...)
+	}
+	return append(append([]ScrapeRecord{}, sh.records[sh.next:]...), sh.records[:sh.next]...)
+}
@@ -0,0 +1,166 @@
+package metrics
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Selector is a Prometheus series selector, e.g. `http_requests_total{job=~"api.*"}`.
+type Selector []labelMatcher
+
+// labelMatcher matches a single label. The metric name is matched as the
+// label "__name__".
+type labelMatcher struct {
+	name string
+	op string // one of =, !=, =~ and !~
+	value string
+	rx *regexp.Regexp // compiled value for =~ and !~
+}
+
+var metricNameRegex = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*`)
+var labelNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*`)
+
+// ParseSelector parses a series selector consisting of an optional metric name
+// and an optional list of label matchers in curly braces. At least one of them
+// must be given.
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	rest := strings.TrimSpace(s)
+	if name := metricNameRegex.FindString(rest); len(name) > 0 {
+		sel = append(sel, labelMatcher{name: "__name__", op: "=", value: name})
+		rest = strings.TrimSpace(rest[len(name):])
+	}
+	if strings.HasPrefix(rest, "{") {
+		rest = rest[1:]
+		for {
+			rest = strings.TrimSpace(rest)
+			if strings.HasPrefix(rest, "}") {
+				rest = rest[1:]
+				break
+			}
+			m, r, err := parseLabelMatcher(rest)
+			if err != nil {
+				return nil, fmt.Errorf("invalid selector %s: %w", s, err)
+			}
+			sel = append(sel, m)
+			rest = strings.TrimSpace(r)
+			rest = strings.TrimPrefix(rest, ",")
+		}
+	}
+	if len(strings.TrimSpace(rest)) > 0 {
+		return nil, fmt.Errorf("invalid selector %s: unexpected %q", s, rest)
+	}
+	if len(sel) == 0 {
+		return nil, fmt.Errorf("invalid selector %s: empty selector", s)
+	}
+	return sel, nil
+}
+
+// parseLabelMatcher parses a matcher like `job=~"api.*"` at the start of s and
+// returns the rest of s.
+func parseLabelMatcher(s string) (labelMatcher, string, error) {
+	name := labelNameRegex.FindString(s)
+	if len(name) == 0 {
+		return labelMatcher{}, "", fmt.Errorf("expected label name at %q", s)
+	}
+	s = strings.TrimSpace(s[len(name):])
+	var op string
+	for _, o := range []string{"=~", "!~", "!=", "="} {
+		if strings.HasPrefix(s, o) {
+			op = o
+			break
+		}
+	}
+	if len(op) == 0 {
+		return labelMatcher{}, "", fmt.Errorf("expected operator after label %s", name)
+	}
+	s = strings.TrimSpace(s[len(op):])
+	value, rest, err := parseQuoted(s)
+	if err != nil {
+		return labelMatcher{}, "", fmt.Errorf("invalid value of label %s: %w", name, err)
+	}
+	m := labelMatcher{name: name, op: op, value: value}
+	if op == "=~" || op == "!~" {
+		// Regex matchers are fully anchored, like in Prometheus
+		m.rx, err = regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return labelMatcher{}, "", fmt.Errorf("invalid regex for label %s: %w", name, err)
+		}
+	}
+	return m, rest, nil
+}
+
+// parseQuoted parses a double or single quoted string at the start of s and
+// returns the unquoted string and the rest of s.
+func parseQuoted(s string) (string, string, error) {
+	if len(s) == 0 || (s[0] != '"' && s[0] != '\'') {
+		return "", "", fmt.Errorf("expected quoted string at %q", s)
+	}
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case quote:
+			raw := s[:i+1]
+			if quote == '\'' {
+				raw = `"` + strings.ReplaceAll(raw[1:i], `"`, `\"`) + `"`
+			}
+			value, err := strconv.Unquote(raw)
+			if err != nil {
+				return "", "", err
+			}
+			return value, s[i+1:], nil
+		}
+	}
+	return "", "", fmt.Errorf("unterminated string %s", s)
+}
+
+// Matches returns true if a series with the given name and labels matches all
+// label matchers of the selector. Missing labels are treated as empty.
+func (sel Selector) Matches(name string, labels map[string]string) bool {
+	for _, m := range sel {
+		value := labels[m.name]
+		if m.name == "__name__" {
+			value = name
+		}
+		if !m.matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m labelMatcher) matches(value string) bool {
+	switch m.op {
+	case "=":
+		return value == m.value
+	case "!=":
+		return value != m.value
+	case "=~":
+		return m.rx.MatchString(value)
+	default:
+		return !m.rx.MatchString(value)
+	}
+}
+
+// matchesMetric returns true if any of the series exposed for the metric
+// matches the selector. For histograms and summaries, the _bucket, _sum and
+// _count series are considered next to the metric name.
+func (sel Selector) matchesMetric(m *Metric) bool {
+	names := []string{m.Name()}
+	switch m.Type() {
+	case HistogramType:
+		names = append(names, m.Name()+"_bucket", m.Name()+"_sum", m.Name()+"_count")
+	case SummaryType:
+		names = append(names, m.Name()+"_sum", m.Name()+"_count")
+	}
+	for _, name := range names {
+		if sel.Matches(name, m.Labels()) {
+			return true
+		}
+	}
+	return false
+}
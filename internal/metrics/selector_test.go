@@ -0,0 +1,36 @@
+package metrics
+
+import "testing"
+
+func TestParseSelector(t *testing.T) {
+	labels := map[string]string{"job": "api", "instance": "a:9090"}
+	tests := []struct {
+		selector string
+		matches bool
+	}{
+		{`http_requests_total`, true},
+		{`other_total`, false},
+		{`{__name__=~"http_.*"}`, true},
+		{`http_requests_total{job="api"}`, true},
+		{`http_requests_total{job!="api"}`, false},
+		{`{job=~"ap", instance!~"b.*"}`, false},
+		{`{job=~"ap.*", instance!~"b.*",}`, true},
+		{`{job='api'}`, true},
+		{`{missing=""}`, true},
+	}
+	for _, test := range tests {
+		sel, err := ParseSelector(test.selector)
+		if err != nil {
+			t.Fatalf("Failed to parse selector %s: %v", test.selector, err)
+		}
+		if sel.Matches("http_requests_total", labels) != test.matches {
+			t.Errorf("Expected selector %s to return %v", test.selector, test.matches)
+		}
+	}
+
+	for _, invalid := range []string{``, `{}`, `{job}`, `{job="api"`, `{job=~"("}`, `foo bar`} {
+		if _, err := ParseSelector(invalid); err == nil {
+			t.Errorf("Expected error for selector %q", invalid)
+		}
+	}
+}
@@ -1,66 +1,567 @@
 package metrics
 
 import (
+	"bananabacon/internal/debug"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dop251/goja"
+	"github.com/quic-go/quic-go/http3"
 )
 
+const (
+	// EvalTimeoutPartial serves the metrics evaluated within the evaluation
+	// timeout of a scrape and reports the missing ones in the gauge
+	// EvalTimeoutMetricName.
+	EvalTimeoutPartial = "partial"
+	// EvalTimeoutFail responds with 503 if the evaluation timeout of a scrape
+	// is exceeded.
+	EvalTimeoutFail = "fail"
+	// EvalTimeoutMetricName is the name of the gauge counting the metrics that
+	// were not evaluated within the evaluation timeout of a scrape.
+	EvalTimeoutMetricName = "bananabacon_scrape_timed_out_metrics"
+)
+
+// ServerOptions configures the timeouts and limits of the HTTP server, so slow
+// or stalled clients cannot exhaust its connections.
+type ServerOptions struct {
+	// ReadHeaderTimeout is the time allowed to read the headers of a request.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout is the time allowed to read a whole request. Zero means no
+	// limit.
+	ReadTimeout time.Duration
+	// WriteTimeout is the time allowed to write a response. Zero means no
+	// limit, which is required to stream lines over HTTP for longer.
+	WriteTimeout time.Duration
+	// IdleTimeout is the time a keep-alive connection waits for the next
+	// request.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes is the maximum size of the headers of a request.
+	MaxHeaderBytes int
+	// KeepAlive enables keep-alive connections. Without it, every connection
+	// is closed after its request.
+	KeepAlive bool
+	// ShutdownTimeout is how long open requests are drained when the server
+	// stops.
+	ShutdownTimeout time.Duration
+}
+
+// DefaultServerOptions are the options of a new MetricsServer.
+var DefaultServerOptions = ServerOptions{
+	ReadHeaderTimeout: 5 * time.Second,
+	ReadTimeout: 30 * time.Second,
+	IdleTimeout: 2 * time.Minute,
+	MaxHeaderBytes: 64 << 10,
+	KeepAlive: true,
+	ShutdownTimeout: 5 * time.Second,
+}
+
+// Collector returns additional metric values that are served on "/metrics"
+// next to the metrics of the MetricsEngine, e.g. internal statistics.
+type Collector func() []MetricValue
+
 type MetricsServer struct {
 	server *http.Server
+	h3 *http3.Server // serves HTTP/3 next to server, nil if disabled
+	mux *http.ServeMux
+	engine *MetricsEngine
+	scrapes *ScrapeHistory
+	ready atomic.Bool
+	collectors []Collector
+	collectorsMu sync.Mutex
+	padding int // minimum size of "/metrics" responses in bytes
+	scrapeDelay *Metric // evaluates to the delay of "/metrics" responses in ms
+	suppressed func() bool // omits the metrics of the engine while it returns true
+	overloaded func() bool // rejects requests other than scrapes while it returns true
+	shutdownTimeout time.Duration
+	evalTimeout time.Duration // budget for evaluating the metrics of a scrape, 0 for none
+	evalTimeoutAction string
+	timedOut *Metric // reports the metrics not evaluated within evalTimeout
 }
 
 func NewMetricsServer(engine *MetricsEngine, port int) *MetricsServer {
-	return &MetricsServer{
-		server: createMetricsServer(engine, port),
+	ms := &MetricsServer{
+		mux: http.NewServeMux(),
+		engine: engine,
+		scrapes: NewScrapeHistory(ScrapeHistorySize),
+	}
+	ms.server = createMetricsServer(ms, port)
+	ms.SetServerOptions(DefaultServerOptions)
+	return ms
+}
+
+// SetServerOptions sets the timeouts and limits of the HTTP server, see
+// DefaultServerOptions for the defaults. It must be called before Run.
+func (ms *MetricsServer) SetServerOptions(options ServerOptions) {
+	ms.server.ReadHeaderTimeout = options.ReadHeaderTimeout
+	ms.server.ReadTimeout = options.ReadTimeout
+	ms.server.WriteTimeout = options.WriteTimeout
+	ms.server.IdleTimeout = options.IdleTimeout
+	ms.server.MaxHeaderBytes = options.MaxHeaderBytes
+	ms.server.SetKeepAlivesEnabled(options.KeepAlive)
+	ms.shutdownTimeout = options.ShutdownTimeout
+}
+
+// SetTLS serves HTTPS with the PEM certificate and key in the given files
+// instead of plain HTTP. With h3, the endpoints are also served over HTTP/3 on
+// the UDP port of the same number, which the HTTPS responses announce in
+// their Alt-Svc header, so clients supporting QUIC switch to it. It must be
+// called before Run.
+func (ms *MetricsServer) SetTLS(certFile, keyFile string, h3 bool) error {
+	if len(certFile) == 0 || len(keyFile) == 0 {
+		return errors.New("missing certificate or key file")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	ms.server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	if h3 {
+		ms.h3 = &http3.Server{
+			Addr: ms.server.Addr,
+			Handler: ms,
+			TLSConfig: http3.ConfigureTLSConfig(ms.server.TLSConfig.Clone()),
+		}
+	}
+	return nil
+}
+
+// AddCollector registers a Collector whose values are appended to the output
+// of "/metrics".
+func (ms *MetricsServer) AddCollector(c Collector) {
+	ms.collectorsMu.Lock()
+	defer ms.collectorsMu.Unlock()
+	ms.collectors = append(ms.collectors, c)
+}
+
+// Handle registers an additional handler for the given pattern on the server.
+func (ms *MetricsServer) Handle(pattern string, handler http.Handler) {
+	ms.mux.Handle(pattern, handler)
+}
+
+// SetResponsePadding pads the responses of "/metrics" with comment lines to
+// at least the given number of bytes, e.g. to test body size limits of
+// Prometheus. Zero disables padding. It must be called before Run.
+func (ms *MetricsServer) SetResponsePadding(size int) {
+	ms.padding = size
+}
+
+// SetScrapeDelay delays the responses of "/metrics" by the value of the given
+// metric in milliseconds, which is evaluated on every scrape like the other
+// metrics. It simulates slow targets, e.g. to test scrape timeouts. It must be
+// called before Run.
+func (ms *MetricsServer) SetScrapeDelay(m *Metric) {
+	ms.scrapeDelay = m
+}
+
+// SetSuppression omits the metrics of the engine from the responses while the
+// given function returns true, so they go stale in Prometheus, e.g. to
+// simulate maintenance windows. Values of collectors are still served. It
+// must be called before Run.
+func (ms *MetricsServer) SetSuppression(suppressed func() bool) {
+	ms.suppressed = suppressed
+}
+
+// SetOverload rejects requests with 503 Service Unavailable while overloaded
+// returns true, except for the scrapes of "/metrics" and "/federate" and for
+// "/ready", so clients like log streams do not add goroutines. It must be
+// called before Run.
+func (ms *MetricsServer) SetOverload(overloaded func() bool) {
+	ms.overloaded = overloaded
+}
+
+// ServeHTTP serves the endpoints of the server.
+func (ms *MetricsServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if ms.h3 != nil && r.ProtoMajor < 3 {
+		ms.h3.SetQUICHeaders(w.Header())
+	}
+	if ms.overloaded != nil && ms.overloaded() {
+		switch r.URL.Path {
+		case "/metrics", "/federate", "/ready":
+		default:
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	ms.mux.ServeHTTP(w, r)
+}
+
+// SetEvalTimeout limits the time spent evaluating the metrics of a scrape of
+// "/metrics" or "/federate", so a slow script cannot make every scrape exceed
+// the scrape timeout of Prometheus. A script still running when the budget is
+// exhausted is interrupted and the remaining metrics are not evaluated. The
+// action is EvalTimeoutPartial or EvalTimeoutFail. Zero disables the limit. It
+// must be called before Run.
+func (ms *MetricsServer) SetEvalTimeout(timeout time.Duration, action string) error {
+	switch action {
+	case EvalTimeoutPartial, EvalTimeoutFail:
+	default:
+		return fmt.Errorf("invalid evaluation timeout action: %q, must be %q or %q", action, EvalTimeoutPartial,
+			EvalTimeoutFail)
 	}
+	if timeout < 0 {
+		return fmt.Errorf("invalid evaluation timeout: %s, must not be negative", timeout)
+	}
+	ms.evalTimeout = timeout
+	ms.evalTimeoutAction = action
+	ms.timedOut = NewMetric(EvalTimeoutMetricName, GaugeType, "", nil,
+		"Metrics not evaluated because the evaluation timeout of the scrape was exceeded")
+	return nil
 }
 
+// SetReady sets the state reported by the "/ready" endpoint.
+func (ms *MetricsServer) SetReady(ready bool) {
+	ms.ready.Store(ready)
+}
+
+// Scrapes returns the history of requests to the metrics endpoint.
+func (ms *MetricsServer) Scrapes() *ScrapeHistory {
+	return ms.scrapes
+}
+
+// Run serves the endpoints until the context is cancelled like Serve, but
+// ends the process if the server fails.
 func (ms *MetricsServer) Run(ctx context.Context) {
+	if err := ms.Serve(ctx); err != nil {
+		log.Fatalf("HTTP server error: %v", err)
+	}
+}
+
+// Serve serves the endpoints until the context is cancelled, then it stops the
+// server, draining the open requests, see Stop. It returns an error if the
+// server fails, e.g. because the port is in use.
+func (ms *MetricsServer) Serve(ctx context.Context) error {
 	go func() {
 		<-ctx.Done()
-		ms.Stop(ctx, 5*time.Second)
+		// Drain the requests although the context is done
+		ms.Stop(context.WithoutCancel(ctx), ms.shutdownTimeout)
 	}()
-	if err := ms.server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalf("HTTP server error: %v", err)
+	failed := make(chan error, 1)
+	if ms.h3 != nil {
+		ms.h3.IdleTimeout, ms.h3.MaxHeaderBytes = ms.server.IdleTimeout, ms.server.MaxHeaderBytes
+		go func() {
+			if err := ms.h3.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				failed <- fmt.Errorf("HTTP/3: %w", err)
+				ms.server.Close()
+			}
+		}()
 	}
+	var err error
+	if ms.server.TLSConfig != nil {
+		err = ms.server.ListenAndServeTLS("", "")
+	} else {
+		err = ms.server.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		if ms.h3 != nil {
+			ms.h3.Close()
+		}
+		return err
+	}
+	select {
+	case err := <-failed:
+		return err
+	default:
+		return nil
+	}
+}
+
+// Handler returns the handler of all endpoints of the server, to serve them
+// from another HTTP server instead of Run.
+func (ms *MetricsServer) Handler() http.Handler {
+	return ms
 }
 
+// Stop stops accepting connections and waits up to timeout for the open
+// requests to complete, then it closes the remaining connections.
 func (ms *MetricsServer) Stop(ctx context.Context, timeout time.Duration) {
 	sdctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
+	if ms.h3 != nil {
+		if err := ms.h3.Shutdown(sdctx); err != nil {
+			ms.h3.Close()
+		}
+	}
 	if err := ms.server.Shutdown(sdctx); err != nil {
-		log.Fatalf("HTTP shutdown error: %v", err)
+		log.Printf("Failed to drain HTTP connections within %s: %v", timeout, err)
+		ms.server.Close()
 	}
 }
 
 // createMetricsServer initializes and returns an HTTP server that will listen on the provided port
-// and serves metrics at the "/metrics" endpoint. It evaluates each metric in the provided
-// MetricsEngine and writes the results to the HTTP response. If an error occurs during
-// evaluation of a metric, it is skipped.
-func createMetricsServer(engine *MetricsEngine, port int) (*http.Server) {
+// and serves metrics at the "/metrics" endpoint. It evaluates each metric in the
+// MetricsEngine of the MetricsServer and writes the results to the HTTP response,
+// followed by the values of all registered collectors. If an error occurs during
+// evaluation of a metric, it is skipped. The "/federate" endpoint serves the
+// metrics matching the "match[]" selectors of the request.
+// Every request to "/metrics" is recorded in the ScrapeHistory, which is
+// served as JSON at the "/api/scrapes" endpoint. The "/ready" endpoint responds
+// with 200 if the server was set ready and 503 otherwise, so it can be used as a
+// readiness probe.
+func createMetricsServer(ms *MetricsServer, port int) (*http.Server) {
 	server := &http.Server{
         Addr: ":" + strconv.Itoa(port),
+		Handler: ms,
     }
-	http.Handle("/metrics", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		var sb strings.Builder
-		vm := goja.New()
-
-		for _, m := range engine.Metrics {
-			val, err := engine.Eval(m, vm)
-			if err == nil {
-				sb.WriteString(val.String())
-				sb.WriteString("\n")
+	ms.mux.Handle("/metrics", http.HandlerFunc(ms.serveMetrics))
+	ms.mux.Handle("/federate", http.HandlerFunc(ms.serveFederate))
+	ms.mux.Handle("/api/scrapes", http.HandlerFunc(ms.serveScrapes))
+	ms.mux.Handle("/ready", http.HandlerFunc(ms.serveReady))
+	return server
+}
+
+// serveMetrics evaluates all metrics and writes them in the Prometheus text format.
+// If the request has "collect[]" parameters, only the metrics with one of the
+// given names are evaluated and written.
+func (ms *MetricsServer) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	var include func(*Metric) bool
+	if names := r.URL.Query()["collect[]"]; len(names) > 0 {
+		include = func(m *Metric) bool {
+			return slices.Contains(names, m.Name())
+		}
+	}
+	if !ms.delayScrape(r.Context()) {
+		return
+	}
+	values, ok := ms.collectWithin(w, include)
+	if !ok {
+		return
+	}
+	var sb strings.Builder
+	writeValues(&sb, values)
+	padResponse(&sb, ms.padding)
+	n, _ := io.WriteString(w, sb.String())
+	ms.scrapes.Add(ScrapeRecord{
+		Timestamp: start,
+		RemoteAddr: r.RemoteAddr,
+		Duration: time.Since(start),
+		Bytes: n,
+	})
+}
+
+// Render evaluates all metrics like a scrape of /metrics without waiting for
+// the scrape delay and returns the response. Metrics that fail to evaluate
+// are missing from it.
+func (ms *MetricsServer) Render() string {
+	values, missing := ms.collect(nil)
+	if missing > 0 {
+		values = append(values, NewMetricValue(ms.timedOut, int64(missing)))
+	}
+	var sb strings.Builder
+	writeValues(&sb, values)
+	padResponse(&sb, ms.padding)
+	return sb.String()
+}
+
+// delayScrape waits for the scrape delay, if one is set. It returns false if
+// the request was cancelled while waiting.
+func (ms *MetricsServer) delayScrape(ctx context.Context) bool {
+	if ms.scrapeDelay == nil {
+		return true
+	}
+	val, err := ms.engine.Eval(ms.scrapeDelay, ms.engine.NewRuntime())
+	if err != nil {
+		debug.Printf("Failed to evaluate scrape delay: %v", err)
+		return true
+	}
+	delay, ok := toFloat(val.Value())
+	if !ok || delay <= 0 {
+		return true
+	}
+	timer := time.NewTimer(time.Duration(delay * float64(time.Millisecond)))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// toFloat converts a value exported from a script to a float64.
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+// paddingLine is repeated to pad responses. Comment lines are ignored by
+// Prometheus, so padding does not change the scraped samples.
+const paddingLine = "# padding ...............................................................\n"
+
+// padResponse appends comment lines to sb until it has at least size bytes.
+func padResponse(sb *strings.Builder, size int) {
+	for sb.Len() < size {
+		missing := size - sb.Len()
+		if missing >= len(paddingLine) {
+			sb.WriteString(paddingLine)
+			continue
+		}
+		// Fill the rest with a shorter comment, which needs at least "#\n"
+		sb.WriteString("#" + strings.Repeat(".", max(missing-2, 0)) + "\n")
+	}
+}
+
+// serveFederate writes the metrics matching any of the "match[]" selectors of
+// the request in the Prometheus text format, like the federation endpoint of
+// Prometheus. Only the matching metrics are evaluated.
+func (ms *MetricsServer) serveFederate(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	params := r.Form["match[]"]
+	if len(params) == 0 {
+		http.Error(w, "at least one match[] selector is required", http.StatusBadRequest)
+		return
+	}
+	selectors := make([]Selector, len(params))
+	for i, p := range params {
+		sel, err := ParseSelector(p)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		selectors[i] = sel
+	}
+	include := func(m *Metric) bool {
+		for _, sel := range selectors {
+			if sel.matchesMetric(m) {
+				return true
 			}
 		}
-		io.WriteString(w, sb.String())
-	}))
-	return server
-}
\ No newline at end of file
+		return false
+	}
+	values, ok := ms.collectWithin(w, include)
+	if !ok {
+		return
+	}
+	var sb strings.Builder
+	writeValues(&sb, values)
+	io.WriteString(w, sb.String())
+}
+
+// FormatValues returns the values in the Prometheus text format, see
+// writeValues.
+func FormatValues(values []MetricValue) string {
+	var sb strings.Builder
+	writeValues(&sb, values)
+	return sb.String()
+}
+
+// writeValues writes the values in the Prometheus text format. Consecutive
+// values of the same metric family share the HELP and TYPE lines of the first.
+func writeValues(sb *strings.Builder, values []MetricValue) {
+	for i, val := range values {
+		if i > 0 && values[i-1].Metric().Name() == val.Metric().Name() {
+			sb.WriteString(val.Samples())
+		} else {
+			sb.WriteString(val.String())
+		}
+		sb.WriteString("\n")
+	}
+}
+
+// collectWithin collects the values like collect and handles an exceeded
+// evaluation timeout: with EvalTimeoutFail it responds with 503 and returns
+// false, otherwise it appends the number of missing metrics to the values.
+func (ms *MetricsServer) collectWithin(w http.ResponseWriter, include func(*Metric) bool) ([]MetricValue, bool) {
+	values, missing := ms.collect(include)
+	if missing == 0 {
+		return values, true
+	}
+	log.Printf("Evaluation timeout of %s exceeded, %d metrics were not evaluated", ms.evalTimeout, missing)
+	if ms.evalTimeoutAction == EvalTimeoutFail {
+		http.Error(w, "evaluation timeout exceeded", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	return append(values, NewMetricValue(ms.timedOut, int64(missing))), true
+}
+
+// collect evaluates the metrics of the engine and returns their values,
+// followed by the values of all registered collectors. If include is not nil,
+// only the metrics it returns true for are evaluated and returned. If an error
+// occurs during evaluation of a metric, it is skipped. Metrics that could not
+// be evaluated within the evaluation timeout are skipped too, their number is
+// returned.
+func (ms *MetricsServer) collect(include func(*Metric) bool) ([]MetricValue, int) {
+	var values []MetricValue
+	vm := ms.engine.NewRuntime()
+	var deadline time.Time
+	if ms.evalTimeout > 0 {
+		deadline = time.Now().Add(ms.evalTimeout)
+		timer := time.AfterFunc(ms.evalTimeout, func() {
+			vm.Interrupt(fmt.Sprintf("evaluation timed out after %s", ms.evalTimeout))
+		})
+		defer timer.Stop()
+	}
+	missing := 0
+	suppressed := ms.suppressed != nil && ms.suppressed()
+	for _, m := range ms.engine.List() {
+		if suppressed || (include != nil && !include(m)) {
+			continue
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			missing++
+			continue
+		}
+		val, err := ms.engine.Eval(m, vm)
+		var interrupted *goja.InterruptedError
+		if errors.As(err, &interrupted) {
+			missing++
+			continue
+		}
+		if err != nil {
+			debug.Printf("Failed to evaluate metric %s: %v", m.Name(), err)
+			continue
+		}
+		values = append(values, val)
+	}
+	ms.collectorsMu.Lock()
+	collectors := ms.collectors
+	ms.collectorsMu.Unlock()
+	for _, c := range collectors {
+		for _, val := range c() {
+			if include != nil && !include(val.Metric()) {
+				continue
+			}
+			values = append(values, val)
+		}
+	}
+	return values, missing
+}
+
+// serveScrapes writes the scrape history as JSON.
+func (ms *MetricsServer) serveScrapes(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(ms.scrapes.Records()); err != nil {
+		log.Printf("Failed to encode scrape history: %v", err)
+	}
+}
+
+// serveReady responds with 200 if the server is ready and 503 otherwise.
+func (ms *MetricsServer) serveReady(w http.ResponseWriter, r *http.Request) {
+	if !ms.ready.Load() {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		return
+	}
+	io.WriteString(w, "ready")
+}
@@ -2,11 +2,21 @@ package metrics
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/quic-go/quic-go/http3"
 )
 
 func TestMetricsServer(t *testing.T) {
@@ -51,7 +61,249 @@ func TestMetricsServer(t *testing.T) {
 		t.Errorf("Expected metrics:\n%s\nGot:\n%s", expectedMetrics, body)
 	}
 
+	// The scrape must show up in the scrape history
+	records := server.Scrapes().Records()
+	if len(records) != 1 {
+		t.Fatalf("Expected 1 scrape record, got %d", len(records))
+	}
+	if records[0].Bytes != len(body) {
+		t.Errorf("Expected %d bytes in scrape record, got %d", len(body), records[0].Bytes)
+	}
+
 	// Stop the server gracefully
 	cancel()
 	time.Sleep(100 * time.Millisecond) // Allow some time for the server to shut down
-}
\ No newline at end of file
+}
+func TestMetricsServer_Federate(t *testing.T) {
+	engine := NewMetricsEngine([]*Metric{
+		NewMetric("test_one", CounterType, "99", map[string]string{"job": "api"}, ""),
+		NewMetric("test_two", GaugeType, "999", nil, ""),
+	})
+	server := NewMetricsServer(engine, 0)
+
+	rec := httptest.NewRecorder()
+	server.mux.ServeHTTP(rec, httptest.NewRequest("GET", `/federate?match[]={job="api"}`, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status code 200, got %d", rec.Code)
+	}
+	expected := "# TYPE test_one counter\ntest_one {job=\"api\"} 99\n"
+	if rec.Body.String() != expected {
+		t.Errorf("Expected metrics:\n%s\nGot:\n%s", expected, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	server.mux.ServeHTTP(rec, httptest.NewRequest("GET", "/federate", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code 400 without selector, got %d", rec.Code)
+	}
+}
+
+func TestMetricsServer_Collect(t *testing.T) {
+	engine := NewMetricsEngine([]*Metric{
+		NewMetric("test_one", CounterType, "99", nil, ""),
+		NewMetric("test_two", GaugeType, "999", nil, ""),
+		NewMetric("test_three", GaugeType, "9", nil, ""),
+	})
+	server := NewMetricsServer(engine, 0)
+
+	rec := httptest.NewRecorder()
+	server.mux.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics?collect[]=test_one&collect[]=test_three", nil))
+	expected := "# TYPE test_one counter\ntest_one {} 99\n# TYPE test_three gauge\ntest_three {} 9\n"
+	if rec.Body.String() != expected {
+		t.Errorf("Expected metrics:\n%s\nGot:\n%s", expected, rec.Body.String())
+	}
+}
+
+func TestMetricsServer_PaddingAndDelay(t *testing.T) {
+	engine := NewMetricsEngine([]*Metric{
+		NewMetric("test_one", CounterType, "99", nil, ""),
+	})
+	server := NewMetricsServer(engine, 0)
+	server.SetResponsePadding(1000)
+	server.SetScrapeDelay(NewMetric("scrape_delay", GaugeType, "50", nil, ""))
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	server.mux.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("Expected response to be delayed by 50ms, took %s", d)
+	}
+	body := rec.Body.String()
+	if len(body) < 1000 || len(body) > 1001 {
+		t.Errorf("Expected response to be padded to 1000 bytes, got %d", len(body))
+	}
+	if !strings.HasPrefix(body, "# TYPE test_one counter\ntest_one {} 99\n") {
+		t.Errorf("Expected metrics before padding, got:\n%s", body)
+	}
+}
+
+func TestMetricsServer_EvalTimeout(t *testing.T) {
+	engine := NewMetricsEngine([]*Metric{
+		NewMetric("test_one", CounterType, "99", nil, ""),
+		NewMetric("test_slow", GaugeType, "(function () { while (true) {} })()", nil, ""),
+		NewMetric("test_two", GaugeType, "9", nil, ""),
+	})
+	server := NewMetricsServer(engine, 0)
+	if err := server.SetEvalTimeout(50*time.Millisecond, "ignore"); err == nil {
+		t.Error("Expected error for invalid action")
+	}
+	if err := server.SetEvalTimeout(50*time.Millisecond, EvalTimeoutPartial); err != nil {
+		t.Fatalf("Failed to set evaluation timeout: %s", err)
+	}
+
+	rec := httptest.NewRecorder()
+	server.mux.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	expected := "# TYPE test_one counter\ntest_one {} 99\n" +
+		"# HELP " + EvalTimeoutMetricName + " Metrics not evaluated because the evaluation timeout of the scrape was exceeded\n" +
+		"# TYPE " + EvalTimeoutMetricName + " gauge\n" + EvalTimeoutMetricName + " {} 2\n"
+	if rec.Code != http.StatusOK || rec.Body.String() != expected {
+		t.Errorf("Expected partial metrics:\n%s\nGot %d:\n%s", expected, rec.Code, rec.Body.String())
+	}
+
+	if err := server.SetEvalTimeout(50*time.Millisecond, EvalTimeoutFail); err != nil {
+		t.Fatalf("Failed to set evaluation timeout: %s", err)
+	}
+	rec = httptest.NewRecorder()
+	server.mux.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code 503, got %d", rec.Code)
+	}
+}
+
+func TestMetricsServer_Stress(t *testing.T) {
+	server := NewMetricsServer(NewMetricsEngine(nil), 0)
+	server.AddCollector(NewStressCollector(3, 2, 20))
+
+	rec := httptest.NewRecorder()
+	server.mux.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	if n := strings.Count(body, "# TYPE "+StressMetricName+" gauge\n"); n != 1 {
+		t.Errorf("Expected a single TYPE line, got %d", n)
+	}
+	if n := strings.Count(body, StressMetricName+" {"); n != 3 {
+		t.Errorf("Expected 3 series, got %d", n)
+	}
+	if !strings.Contains(body, `label_1="value_2_1xxxxxxxxxxx"`) {
+		t.Errorf("Expected padded label values, got:\n%s", body)
+	}
+}
+
+func TestMetricsServer_Suppression(t *testing.T) {
+	engine := NewMetricsEngine([]*Metric{
+		NewMetric("test_one", CounterType, "99", nil, ""),
+	})
+	server := NewMetricsServer(engine, 0)
+	suppressed := true
+	server.SetSuppression(func() bool { return suppressed })
+
+	rec := httptest.NewRecorder()
+	server.mux.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Body.Len() != 0 {
+		t.Errorf("Expected no metrics while suppressed, got:\n%s", rec.Body.String())
+	}
+	suppressed = false
+	rec = httptest.NewRecorder()
+	server.mux.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), "test_one {} 99") {
+		t.Errorf("Expected metrics after suppression, got:\n%s", rec.Body.String())
+	}
+}
+
+func TestMetricsServer_Timeouts(t *testing.T) {
+	engine := NewMetricsEngine([]*Metric{
+		NewMetric("test_one", CounterType, "99", nil, ""),
+	})
+	port := 8083
+	server := NewMetricsServer(engine, port)
+	options := DefaultServerOptions
+	options.ReadHeaderTimeout = 100 * time.Millisecond
+	server.SetServerOptions(options)
+	server.SetScrapeDelay(NewMetric("scrape_delay", GaugeType, "300", nil, ""))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	// A client that never completes its headers is disconnected
+	conn, err := net.Dial("tcp", "localhost:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /metrics HTTP/1.1\r\nHost: localhost\r\n")
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	start := time.Now()
+	io.ReadAll(conn)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected stalled connection to be closed after the header timeout, took %s", d)
+	}
+
+	// A request in flight is completed when the server stops
+	result := make(chan error, 1)
+	go func() {
+		resp, err := http.Get("http://localhost:" + strconv.Itoa(port) + "/metrics")
+		if err == nil {
+			resp.Body.Close()
+		}
+		result <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	if err := <-result; err != nil {
+		t.Errorf("Expected request to be drained on shutdown, got %s", err)
+	}
+}
+
+func TestMetricsServer_HTTP3(t *testing.T) {
+	// Borrows the certificate of a TLS server, which is valid for 127.0.0.1
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	ts.Close()
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	key, err := x509.MarshalPKCS8PrivateKey(ts.TLS.Certificates[0].PrivateKey)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %s", err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}), 0o600)
+
+	engine := NewMetricsEngine([]*Metric{NewMetric("test_one", CounterType, "99", nil, "")})
+	port := 8084
+	server := NewMetricsServer(engine, port)
+	if err := server.SetTLS(certFile, keyFile, true); err != nil {
+		t.Fatalf("Failed to set TLS: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.Run(ctx)
+	time.Sleep(100 * time.Millisecond)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	url := "https://127.0.0.1:" + strconv.Itoa(port) + "/metrics"
+	https := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := https.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to scrape over HTTPS: %s", err)
+	}
+	resp.Body.Close()
+	if altSvc := resp.Header.Get("Alt-Svc"); !strings.Contains(altSvc, `h3=":8084"`) {
+		t.Errorf("Expected HTTP/3 to be announced, got Alt-Svc %q", altSvc)
+	}
+
+	h3 := &http.Client{Transport: &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err = h3.Get(url)
+	if err != nil {
+		t.Fatalf("Failed to scrape over HTTP/3: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.ProtoMajor != 3 || !strings.Contains(string(body), "test_one {} 99") {
+		t.Errorf("Expected the metrics over HTTP/3, got %s:\n%s", resp.Proto, body)
+	}
+
+	if err := NewMetricsServer(engine, port).SetTLS("", "", true); err == nil {
+		t.Error("Expected an error for HTTP/3 without a certificate")
+	}
+}
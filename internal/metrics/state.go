@@ -0,0 +1,147 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// EngineState is the state of a MetricsEngine that is persisted across
+// restarts, so counters continue where they left off.
+type EngineState struct {
+	// Elapsed is the time passed to the metrics, i.e. the time since the engine
+	// was started.
+	Elapsed time.Duration `json:"elapsed"`
+	// Values holds the last value of each metric, keyed by the metric name and
+	// its labels.
+	Values map[string]any `json:"values"`
+	// States holds the state object of the script of each metric that has
+	// one, keyed like Values.
+	States map[string]json.RawMessage `json:"states,omitempty"`
+	// Counters holds the value last exposed by each counter, which differs
+	// from its last value if the counter was kept monotonic, keyed like
+	// Values.
+	Counters map[string]float64 `json:"counters,omitempty"`
+}
+
+// seriesKey identifies a metric by its name and labels.
+func seriesKey(m *Metric) string {
+	labels := make([]string, 0, len(m.Labels()))
+	for k, v := range m.Labels() {
+		labels = append(labels, k+"="+v)
+	}
+	sort.Strings(labels)
+	return m.Name() + "{" + strings.Join(labels, ",") + "}"
+}
+
+// State returns the current state of the engine.
+func (me *MetricsEngine) State() EngineState {
+	state := EngineState{
+		Elapsed: me.elapsed(),
+		Values: map[string]any{},
+		States: map[string]json.RawMessage{},
+		Counters: map[string]float64{},
+	}
+	for _, m := range me.List() {
+		if v := m.LastValue(); v != nil {
+			state.Values[seriesKey(m)] = v
+		}
+		if s := m.State(); s != nil && string(s) != "{}" {
+			state.States[seriesKey(m)] = s
+		}
+		if v, ok := m.exposedCounter(); ok && !math.IsNaN(v) && !math.IsInf(v, 0) {
+			state.Counters[seriesKey(m)] = v
+		}
+	}
+	return state
+}
+
+// Restore continues from the given state: the elapsed time passed to the
+// metrics is restored and each metric's previous value, state and exposed
+// counter value are set to the stored ones. Values of metrics that no longer
+// exist are ignored.
+func (me *MetricsEngine) Restore(state EngineState) {
+	me.start = me.clock.Elapsed() - state.Elapsed
+	vm := goja.New()
+	for _, m := range me.List() {
+		if v, ok := state.Values[seriesKey(m)]; ok {
+			m.setLastValue(vm.ToValue(v))
+		}
+		if s, ok := state.States[seriesKey(m)]; ok {
+			m.setState(s)
+		}
+		if exposed, ok := state.Counters[seriesKey(m)]; ok && m.Type() == CounterType {
+			raw, _ := toFloat(m.LastValue())
+			m.setExposedCounter(raw, exposed)
+		}
+	}
+}
+
+// LoadState restores the state stored in the given file. A missing file is
+// not an error, the engine then starts from scratch.
+func (me *MetricsEngine) LoadState(path string) error {
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var state EngineState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return err
+	}
+	me.Restore(state)
+	return nil
+}
+
+// SaveState atomically writes the current state to the given file.
+func (me *MetricsEngine) SaveState(path string) error {
+	content, err := json.Marshal(me.State())
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// PersistState writes the state to the given file in the given interval until
+// the context is cancelled. The state is written once more on cancellation, so
+// a graceful shutdown does not lose the latest values.
+func (me *MetricsEngine) PersistState(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := me.SaveState(path); err != nil {
+				log.Printf("Failed to write metrics state: %v", err)
+			}
+			return
+		case <-ticker.C:
+			if err := me.SaveState(path); err != nil {
+				log.Printf("Failed to write metrics state: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMetricsEngine_State(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	newEngine := func() (*MetricsEngine, *Metric) {
+		m := NewMetric("test_counter", CounterType, "(prev || 0) + 1", map[string]string{"app": "a"}, "")
+		return NewMetricsEngine([]*Metric{m, NewMetric("test_new", GaugeType, "t", nil, "")}), m
+	}
+
+	engine, m := newEngine()
+	engine.start = -time.Hour
+	for i := 0; i < 3; i++ {
+		if _, err := engine.Eval(m, engine.NewRuntime()); err != nil {
+			t.Fatalf("Failed to evaluate metric: %v", err)
+		}
+	}
+	if err := engine.SaveState(path); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	restored, m := newEngine()
+	if err := restored.LoadState(path); err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	val, err := restored.Eval(m, restored.NewRuntime())
+	if err != nil {
+		t.Fatalf("Failed to evaluate metric: %v", err)
+	}
+	if v, _ := toFloat(val.Value()); v != 4 {
+		t.Errorf("Expected counter to continue at 4, got %v", val.Value())
+	}
+	if d := restored.elapsed(); d < time.Hour {
+		t.Errorf("Expected elapsed time to be restored, got %s", d)
+	}
+
+	if err := restored.LoadState(filepath.Join(t.TempDir(), "missing.json")); err != nil {
+		t.Errorf("Expected missing state file to be ignored, got %v", err)
+	}
+}
+
+func TestMetricsEngine_SetMetrics(t *testing.T) {
+	counter := NewMetric("test_counter", CounterType, "(prev || 0) + 1", map[string]string{"app": "a"}, "")
+	engine := NewMetricsEngine([]*Metric{counter})
+	for i := 0; i < 3; i++ {
+		if _, err := engine.Eval(counter, engine.NewRuntime()); err != nil {
+			t.Fatalf("Failed to evaluate metric: %v", err)
+		}
+	}
+
+	reloaded := NewMetric("test_counter", CounterType, "(prev || 0) + 10", map[string]string{"app": "a"}, "")
+	added := NewMetric("test_added", GaugeType, "1", nil, "")
+	engine.SetMetrics([]*Metric{reloaded, added})
+	if metrics := engine.List(); len(metrics) != 2 || metrics[0] != reloaded || metrics[1] != added {
+		t.Fatalf("Expected the new metrics, got %v", metrics)
+	}
+	val, err := engine.Eval(reloaded, engine.NewRuntime())
+	if err != nil {
+		t.Fatalf("Failed to evaluate metric: %v", err)
+	}
+	if v, _ := toFloat(val.Value()); v != 13 {
+		t.Errorf("Expected the reloaded counter to continue at 13, got %v", val.Value())
+	}
+}
+
+func TestMetric_ScriptState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	script := `function walk(t, prev, state) {
+		state.steps = (state.steps || []).concat([state.steps ? state.steps.length : 0]);
+		state.total = (state.total || 0) + 2;
+		return state.total;
+	}`
+	newEngine := func() (*MetricsEngine, *Metric) {
+		m := NewMetric("walk", GaugeType, script, nil, "")
+		return NewMetricsEngine([]*Metric{m}), m
+	}
+
+	engine, m := newEngine()
+	for i := 0; i < 3; i++ {
+		// Every evaluation gets a fresh runtime, like every scrape
+		if _, err := engine.Eval(m, engine.NewRuntime()); err != nil {
+			t.Fatalf("Failed to evaluate metric: %v", err)
+		}
+	}
+	if s := string(m.State()); s != `{"steps":[0,1,2],"total":6}` {
+		t.Errorf("Expected the state to accumulate, got %s", s)
+	}
+	if v, err := engine.EvalExpression("state.total += 100", "walk"); err != nil || v != int64(106) {
+		t.Errorf("Expected the expression to see the state, got %v, %v", v, err)
+	}
+	if s := string(m.State()); s != `{"steps":[0,1,2],"total":6}` {
+		t.Errorf("Expected evaluating an expression to keep the state, got %s", s)
+	}
+
+	if err := engine.SaveState(path); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+	restored, m := newEngine()
+	if err := restored.LoadState(path); err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	val, err := restored.Eval(m, restored.NewRuntime())
+	if err != nil {
+		t.Fatalf("Failed to evaluate metric: %v", err)
+	}
+	if v, _ := toFloat(val.Value()); v != 8 {
+		t.Errorf("Expected the state to be restored, got %v", val.Value())
+	}
+
+	reloaded := NewMetric("walk", GaugeType, "state.total", nil, "")
+	restored.SetMetrics([]*Metric{reloaded})
+	if val, err := restored.Eval(reloaded, restored.NewRuntime()); err != nil || val.Value() != int64(8) {
+		t.Errorf("Expected the state to be kept on reload, got %v, %v", val.Value(), err)
+	}
+
+	cyclic := NewMetric("cyclic", GaugeType, "(state.self = state, 1)", nil, "")
+	if _, err := engine.Eval(cyclic, engine.NewRuntime()); err == nil {
+		t.Error("Expected an error for a state that cannot be serialized")
+	}
+}
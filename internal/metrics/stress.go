@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// StressMetricName is the name of the metric family generated by
+	// NewStressCollector.
+	StressMetricName = "bananabacon_stress"
+)
+
+// NewStressCollector returns a Collector that generates the given number of
+// series of the metric StressMetricName, e.g. to test sample_limit,
+// label_limit and other ingestion guardrails of collectors. Each series has a
+// "series" label with its index and the given number of additional labels,
+// whose values are padded to valueLength characters.
+func NewStressCollector(series, labels, valueLength int) Collector {
+	metrics := make([]*Metric, series)
+	for i := range metrics {
+		l := make(map[string]string, labels+1)
+		l["series"] = strconv.Itoa(i)
+		for j := 0; j < labels; j++ {
+			value := fmt.Sprintf("value_%d_%d", i, j)
+			if len(value) < valueLength {
+				value += strings.Repeat("x", valueLength-len(value))
+			}
+			l[fmt.Sprintf("label_%d", j)] = value
+		}
+		metrics[i] = NewMetric(StressMetricName, GaugeType, "", l, "Generated series for stress tests")
+	}
+	return func() []MetricValue {
+		values := make([]MetricValue, len(metrics))
+		for i, m := range metrics {
+			values[i] = NewMetricValue(m, 1)
+		}
+		return values
+	}
+}
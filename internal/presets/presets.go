@@ -0,0 +1,99 @@
+package presets
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Preset holds the settings needed to replay a common log format.
+type Preset struct {
+	FilterRegex string
+	TimeRegex string
+	TimeFormat string
+}
+
+var presets = map[string]Preset{
+	// 127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 200 2326 "-" "curl/8.0"
+	"nginx": {
+		FilterRegex: ".*",
+		TimeRegex: `\[(\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]`,
+		TimeFormat: "02/Jan/2006:15:04:05 -0700",
+	},
+	// 2000/10/10 13:55:36 [error] 1234#0: *1 open() failed
+	"nginx_error": {
+		FilterRegex: ".*",
+		TimeRegex: `^(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})`,
+		TimeFormat: "2006/01/02 15:04:05",
+	},
+	// 127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "-" "Mozilla/4.08"
+	"apache_combined": {
+		FilterRegex: ".*",
+		TimeRegex: `\[(\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]`,
+		TimeFormat: "02/Jan/2006:15:04:05 -0700",
+	},
+	// Oct 10 13:55:36 myhost sshd[1234]: Accepted publickey for root
+	"syslog_rfc3164": {
+		FilterRegex: ".*",
+		TimeRegex: `^(?:<\d+>)?(\w{3} [ \d]\d \d{2}:\d{2}:\d{2})`,
+		TimeFormat: "Jan _2 15:04:05",
+	},
+	// <34>1 2000-10-10T13:55:36.123Z myhost sshd 1234 - - Accepted publickey for root
+	"syslog_rfc5424": {
+		FilterRegex: ".*",
+		TimeRegex: `^<\d+>1 (\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2}))`,
+		TimeFormat: "2006-01-02T15:04:05.999999999Z07:00",
+	},
+	// 2000-10-10 13:55:36,123 INFO  [main] com.example.App - Started
+	"java_log4j": {
+		FilterRegex: ".*",
+		TimeRegex: `^(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2},\d{3})`,
+		TimeFormat: "2006-01-02 15:04:05,000",
+	},
+	// I1010 13:55:36.123456    1234 main.go:42] Started
+	"klog": {
+		FilterRegex: ".*",
+		TimeRegex: `^[IWEF](\d{4} \d{2}:\d{2}:\d{2}\.\d{6})`,
+		TimeFormat: "0102 15:04:05.000000",
+	},
+	// type=USER_LOGIN msg=audit(1696945536.123:24287): pid=1234 uid=0 auid=1000 ses=3 msg='op=login acct="alice" exe="/usr/sbin/sshd" hostname=? addr=10.0.0.5 terminal=sshd res=success'
+	// Only the seconds are rewritten, the milliseconds and the serial number
+	// are kept.
+	"auditd": {
+		FilterRegex: ".*",
+		TimeRegex: `msg=audit\((\d+)\.\d+:\d+\)`,
+		TimeFormat: "unix",
+	},
+	// CEF:0|Vendor|Product|1.0|100|Login|5|rt=1696945536123 src=10.0.0.5 suser=alice
+	"cef": {
+		FilterRegex: ".*",
+		TimeRegex: `\brt=(\d{13})\b`,
+		TimeFormat: "unix_ms",
+	},
+	// LEEF:1.0|Vendor|Product|1.0|Login|devTime=Oct 10 2000 13:55:36<tab>src=10.0.0.5<tab>usrName=alice
+	"leef": {
+		FilterRegex: ".*",
+		TimeRegex: `\bdevTime=(\w{3} \d{2} \d{4} \d{2}:\d{2}:\d{2})`,
+		TimeFormat: "Jan 02 2006 15:04:05",
+	},
+}
+
+// Get returns the preset with the given name. If no preset with that name
+// exists, an error listing the available presets is returned.
+func Get(name string) (Preset, error) {
+	p, ok := presets[name]
+	if !ok {
+		return Preset{}, fmt.Errorf("unknown preset %q, available presets: %s", name, strings.Join(Names(), ", "))
+	}
+	return p, nil
+}
+
+// Names returns the names of all presets in alphabetical order.
+func Names() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,48 @@
+package presets
+
+import (
+	"bananabacon/internal/logs"
+	"regexp"
+	"testing"
+)
+
+func TestPresets(t *testing.T) {
+	examples := map[string]string{
+		"nginx": `127.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.1" 200 2326 "-" "curl/8.0"`,
+		"nginx_error": "2000/10/10 13:55:36 [error] 1234#0: *1 open() failed",
+		"apache_combined": `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326 "-" "Mozilla/4.08"`,
+		"syslog_rfc3164": "Oct  1 13:55:36 myhost sshd[1234]: Accepted publickey for root",
+		"syslog_rfc5424": "<34>1 2000-10-10T13:55:36.123Z myhost sshd 1234 - - Accepted publickey for root",
+		"java_log4j": "2000-10-10 13:55:36,123 INFO  [main] com.example.App - Started",
+		"klog": "I1010 13:55:36.123456    1234 main.go:42] Started",
+		"auditd": `type=USER_LOGIN msg=audit(1696945536.123:24287): pid=1234 uid=0 auid=1000 ses=3 msg='op=login acct="alice" exe="/usr/sbin/sshd" hostname=? addr=10.0.0.5 terminal=sshd res=success'`,
+		"cef": "CEF:0|Vendor|Product|1.0|100|Login|5|rt=1696945536123 src=10.0.0.5 suser=alice",
+		"leef": "LEEF:1.0|Vendor|Product|1.0|Login|devTime=Oct 10 2000 13:55:36\tsrc=10.0.0.5\tusrName=alice",
+	}
+	for _, name := range Names() {
+		p, err := Get(name)
+		if err != nil {
+			t.Fatalf("Failed to get preset %s: %v", name, err)
+		}
+		example, ok := examples[name]
+		if !ok {
+			t.Errorf("Missing example line for preset %s", name)
+			continue
+		}
+		if !regexp.MustCompile(p.FilterRegex).MatchString(example) {
+			t.Errorf("Expected filter of preset %s to match %q", name, example)
+		}
+		m := regexp.MustCompile(p.TimeRegex).FindStringSubmatch(example)
+		if m == nil {
+			t.Errorf("Expected time regex of preset %s to match %q", name, example)
+			continue
+		}
+		if _, err := logs.ParseTime(p.TimeFormat, m[1]); err != nil {
+			t.Errorf("Failed to parse timestamp of preset %s: %v", name, err)
+		}
+	}
+
+	if _, err := Get("does-not-exist"); err == nil {
+		t.Error("Expected error for unknown preset")
+	}
+}
@@ -0,0 +1,112 @@
+package profile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// GenerateOptions configures how a log is generated from a profile.
+type GenerateOptions struct {
+	// Layout is the time layout the timestamps at the start of the lines are
+	// formatted with.
+	Layout string
+	// Start is the timestamp of the first rate bucket, the start of the
+	// profile if zero.
+	Start time.Time
+	// Seed seeds the random choices, so the same seed generates the same log.
+	Seed uint64
+}
+
+// placeholderRegex matches the placeholders of the masks in templates.
+var placeholderRegex = regexp.MustCompile(`<(uuid|ip|email|str|hex|num)>`)
+
+// Generate writes a synthetic log with the shape of the profile to w: every
+// rate bucket gets as many lines as the profiled log had in it, at random
+// times within the bucket, and every line is drawn from the clusters by their
+// count, with the placeholders of the template filled with random values.
+// Lines of the clusters beyond Options.MaxClusters and untimed lines are not
+// reproduced.
+func Generate(p *Profile, w io.Writer, options GenerateOptions) error {
+	if len(p.Clusters) == 0 {
+		return errors.New("the profile has no clusters")
+	}
+	interval, err := time.ParseDuration(p.Interval)
+	if err != nil || interval <= 0 {
+		return fmt.Errorf("invalid interval of the profile: %s", p.Interval)
+	}
+	start := options.Start
+	if start.IsZero() {
+		start = p.Start
+	}
+	rnd := rand.New(rand.NewPCG(options.Seed, options.Seed))
+	total := 0
+	for _, c := range p.Clusters {
+		total += c.Count
+	}
+
+	bw := bufio.NewWriter(w)
+	var line []byte
+	for i, n := range p.Rate {
+		bucket := start.Add(time.Duration(i) * interval)
+		offsets := make([]time.Duration, n)
+		for j := range offsets {
+			offsets[j] = time.Duration(rnd.Int64N(int64(interval)))
+		}
+		slices.Sort(offsets)
+		for _, offset := range offsets {
+			line = bucket.Add(offset).AppendFormat(line[:0], options.Layout)
+			line = append(line, ' ')
+			line = fill(line, pick(p.Clusters, total, rnd).Template, rnd)
+			line = append(line, '\n')
+			if _, err := bw.Write(line); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// pick draws a cluster weighted by its count.
+func pick(clusters []Cluster, total int, rnd *rand.Rand) Cluster {
+	n := rnd.IntN(total)
+	for _, c := range clusters {
+		if n < c.Count {
+			return c
+		}
+		n -= c.Count
+	}
+	return clusters[len(clusters)-1]
+}
+
+// fill appends the template to b with its placeholders replaced by random
+// values of their kind.
+func fill(b []byte, template string, rnd *rand.Rand) []byte {
+	last := 0
+	for _, m := range placeholderRegex.FindAllStringSubmatchIndex(template, -1) {
+		b = append(b, template[last:m[0]]...)
+		last = m[1]
+		switch template[m[2]:m[3]] {
+		case "uuid":
+			b = fmt.Appendf(b, "%08x-%04x-4%03x-%04x-%012x", rnd.Uint32(), rnd.IntN(1<<16), rnd.IntN(1<<12),
+				0x8000|rnd.IntN(1<<14), rnd.Int64N(1<<48))
+		case "ip":
+			b = fmt.Appendf(b, "10.%d.%d.%d", rnd.IntN(256), rnd.IntN(256), 1+rnd.IntN(254))
+		case "email":
+			b = fmt.Appendf(b, "user%d@example.com", rnd.IntN(1000))
+		case "str":
+			b = fmt.Appendf(b, `"value%d"`, rnd.IntN(1000))
+		case "hex":
+			b = fmt.Appendf(b, "%08x", rnd.Uint32())
+		default:
+			b = strconv.AppendInt(b, int64(rnd.IntN(1000)), 10)
+		}
+	}
+	return append(b, template[last:]...)
+}
@@ -0,0 +1,216 @@
+package profile
+
+import (
+	"bananabacon/internal/logs"
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultInterval is the default width of the buckets of Profile.Rate.
+	DefaultInterval = time.Minute
+	// DefaultMaxClusters is the default number of clusters in a profile.
+	DefaultMaxClusters = 100
+)
+
+// Options configures how a log is profiled.
+type Options struct {
+	// TimeFormats are the formats of the timestamps in the log, tried in order.
+	TimeFormats []logs.TimestampFormat
+	// Locale is the language of month and day names in the timestamps, see
+	// logs.TimeLocales. Empty means English.
+	Locale string
+	// Interval is the width of the rate buckets, DefaultInterval if zero.
+	Interval time.Duration
+	// MaxClusters is the number of the most frequent clusters kept in the
+	// profile, DefaultMaxClusters if zero.
+	MaxClusters int
+}
+
+// Profile is the statistical shape of a log. It contains no line contents
+// except for the masked templates of the clusters, so it can be shared where
+// the log itself cannot.
+type Profile struct {
+	// Lines is the number of lines in the log.
+	Lines int `json:"lines"`
+	// Untimed is the number of lines without a timestamp, e.g. continuations
+	// of multi-line messages. They are not part of Rate.
+	Untimed int `json:"untimed"`
+	// Start and End are the first and last timestamp of the log.
+	Start time.Time `json:"start"`
+	End time.Time `json:"end"`
+	// Interval is the width of the buckets of Rate, as Go duration.
+	Interval string `json:"interval"`
+	// Rate is the number of lines per interval, from Start to End.
+	Rate []int `json:"rate"`
+	// Levels is the number of lines per log level. Lines without a
+	// recognized level are counted as "NONE".
+	Levels map[string]int `json:"levels"`
+	// Clusters are the most frequent message templates, by count.
+	Clusters []Cluster `json:"clusters"`
+	// Other is the number of lines in clusters beyond Options.MaxClusters.
+	Other int `json:"other"`
+}
+
+// Cluster is a group of lines that share a message template.
+type Cluster struct {
+	// Template is the line without its timestamp and with variable parts
+	// replaced by placeholders like "<num>".
+	Template string `json:"template"`
+	// Level is the log level of the lines.
+	Level string `json:"level"`
+	// Count is the number of lines.
+	Count int `json:"count"`
+	// Share is the fraction of all lines with a timestamp.
+	Share float64 `json:"share"`
+}
+
+// masks replace the variable parts of a line, in order.
+var masks = []struct {
+	rx *regexp.Regexp
+	placeholder string
+}{
+	{regexp.MustCompile(`[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}`), "<uuid>"},
+	{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<ip>"},
+	{regexp.MustCompile(`[\w.+-]+@[\w-]+(\.[\w-]+)+`), "<email>"},
+	{regexp.MustCompile(`"[^"]*"|'[^']*'`), "<str>"},
+	{regexp.MustCompile(`\b0x[0-9a-fA-F]+\b|\b[0-9a-fA-F]*(\d[a-fA-F]|[a-fA-F]\d)[0-9a-fA-F]*\b`), "<hex>"},
+	{regexp.MustCompile(`\d+(\.\d+)*`), "<num>"},
+}
+
+var levelRegex = regexp.MustCompile(`(?i)\b(trace|debug|info|notice|warn|warning|error|err|fatal|critical|crit)\b`)
+
+// levels normalizes the spellings of levelRegex.
+var levels = map[string]string{"WARNING": "WARN", "ERR": "ERROR", "CRIT": "CRITICAL"}
+
+type timeFormat struct {
+	matcher *logs.TimestampMatcher
+	layout string
+	locale string
+}
+
+// Extract reads the log and returns its profile. Lines are clustered by their
+// template, i.e. the line without the timestamp and with numbers, addresses,
+// identifiers and quoted strings masked.
+func Extract(r io.Reader, options Options) (*Profile, error) {
+	if err := logs.CheckTimeLocale(options.Locale); err != nil {
+		return nil, err
+	}
+	formats := make([]timeFormat, len(options.TimeFormats))
+	for i, f := range options.TimeFormats {
+		matcher, err := logs.NewTimestampMatcher(f)
+		if err != nil {
+			return nil, err
+		}
+		formats[i] = timeFormat{matcher: matcher, layout: f.Format, locale: options.Locale}
+	}
+	interval := options.Interval
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("invalid interval: %s, must be positive", interval)
+	}
+	maxClusters := options.MaxClusters
+	if maxClusters == 0 {
+		maxClusters = DefaultMaxClusters
+	}
+
+	p := &Profile{Interval: interval.String(), Levels: map[string]int{}, Rate: []int{}}
+	clusters := map[string]*Cluster{}
+	var times []time.Time
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		p.Lines++
+		t, rest, ok := parseLine(line, formats)
+		if !ok {
+			p.Untimed++
+			continue
+		}
+		times = append(times, t)
+		level := "NONE"
+		if m := levelRegex.FindString(rest); len(m) > 0 {
+			level = strings.ToUpper(m)
+			if l, ok := levels[level]; ok {
+				level = l
+			}
+		}
+		p.Levels[level]++
+		template := Template(rest)
+		c, ok := clusters[template]
+		if !ok {
+			c = &Cluster{Template: template, Level: level}
+			clusters[template] = c
+		}
+		c.Count++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(times) == 0 {
+		return p, nil
+	}
+
+	p.Start, p.End = times[0], times[0]
+	for _, t := range times {
+		if t.Before(p.Start) {
+			p.Start = t
+		}
+		if t.After(p.End) {
+			p.End = t
+		}
+	}
+	p.Rate = make([]int, p.End.Sub(p.Start)/interval+1)
+	for _, t := range times {
+		p.Rate[t.Sub(p.Start)/interval]++
+	}
+
+	for _, c := range clusters {
+		c.Share = float64(c.Count) / float64(len(times))
+		p.Clusters = append(p.Clusters, *c)
+	}
+	sort.Slice(p.Clusters, func(i, j int) bool {
+		if p.Clusters[i].Count != p.Clusters[j].Count {
+			return p.Clusters[i].Count > p.Clusters[j].Count
+		}
+		return p.Clusters[i].Template < p.Clusters[j].Template
+	})
+	if len(p.Clusters) > maxClusters {
+		for _, c := range p.Clusters[maxClusters:] {
+			p.Other += c.Count
+		}
+		p.Clusters = p.Clusters[:maxClusters]
+	}
+	return p, nil
+}
+
+// Template masks the variable parts of a line and collapses its whitespace.
+func Template(line string) string {
+	for _, m := range masks {
+		line = m.rx.ReplaceAllString(line, m.placeholder)
+	}
+	return strings.Join(strings.Fields(line), " ")
+}
+
+// parseLine returns the timestamp of the line and the line without it.
+func parseLine(line string, formats []timeFormat) (time.Time, string, bool) {
+	for _, f := range formats {
+		value, rest, _, ok := f.matcher.Cut(line)
+		if !ok {
+			continue
+		}
+		t, err := logs.ParseTimeIn(f.layout, f.locale, value)
+		if err != nil {
+			continue
+		}
+		return t, rest, true
+	}
+	return time.Time{}, "", false
+}
@@ -0,0 +1,103 @@
+package profile
+
+import (
+	"bananabacon/internal/logs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtract(t *testing.T) {
+	log := `2023-01-01 00:00:00.000 INFO GET /users/42 from 10.0.0.1 took 12ms
+2023-01-01 00:00:30.000 INFO GET /users/7 from 10.0.0.2 took 3ms
+	continuation
+2023-01-01 00:01:10.000 WARNING slow query "select * from users"
+2023-01-01 00:03:00.000 ERROR request 5f2b9c1e-1a2b-4c3d-8e9f-0123456789ab failed
+2023-01-01 00:03:01.000 INFO GET /users/1 from 10.0.0.3 took 1ms`
+
+	p, err := Extract(strings.NewReader(log), Options{
+		TimeFormats: []logs.TimestampFormat{{
+			Regex:  `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+			Format: "2006-01-02 15:04:05.000",
+		}},
+		MaxClusters: 2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to extract profile: %s", err)
+	}
+	if p.Lines != 6 || p.Untimed != 1 {
+		t.Errorf("Expected 6 lines and 1 untimed, got %d and %d", p.Lines, p.Untimed)
+	}
+	if p.End.Sub(p.Start) != 3*time.Minute+time.Second {
+		t.Errorf("Unexpected time range: %s - %s", p.Start, p.End)
+	}
+	if want := []int{2, 1, 0, 2}; len(p.Rate) != len(want) || p.Rate[0] != 2 || p.Rate[1] != 1 || p.Rate[2] != 0 || p.Rate[3] != 2 {
+		t.Errorf("Expected rate %v, got %v", want, p.Rate)
+	}
+	if p.Levels["INFO"] != 3 || p.Levels["WARN"] != 1 || p.Levels["ERROR"] != 1 {
+		t.Errorf("Unexpected levels: %v", p.Levels)
+	}
+	if len(p.Clusters) != 2 || p.Other != 1 {
+		t.Fatalf("Expected 2 clusters and 1 other line, got %+v and %d", p.Clusters, p.Other)
+	}
+	c := p.Clusters[0]
+	if c.Template != "INFO GET /users/<num> from <ip> took <num>ms" || c.Count != 3 || c.Level != "INFO" || c.Share != 0.6 {
+		t.Errorf("Unexpected first cluster: %+v", c)
+	}
+	if c := p.Clusters[1]; c.Template != "ERROR request <uuid> failed" {
+		t.Errorf("Unexpected second cluster: %+v", c)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tests := map[string]string{
+		`user  "alice" logged in from 192.168.1.1:8080`: "user <str> logged in from <ip>",
+		"mail to bob@example.com failed":                "mail to <email> failed",
+		"object 0xdeadbeef at a1b2c3":                   "object <hex> at <hex>",
+		"worker12 done in 1.5s":                         "worker<num> done in <num>s",
+	}
+	for line, want := range tests {
+		if got := Template(line); got != want {
+			t.Errorf("Template(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	p := &Profile{
+		Start:    time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		Interval: "1m0s",
+		Rate:     []int{3, 0, 5},
+		Clusters: []Cluster{
+			{Template: "INFO GET /users/<num> from <ip> took <num>ms", Count: 6},
+			{Template: "ERROR request <uuid> failed for <email> with <str> at <hex>", Count: 2},
+		},
+	}
+	var b strings.Builder
+	if err := Generate(p, &b, GenerateOptions{Layout: "2006-01-02 15:04:05.000", Seed: 1}); err != nil {
+		t.Fatalf("Failed to generate log: %s", err)
+	}
+	generated, err := Extract(strings.NewReader(b.String()), Options{
+		TimeFormats: []logs.TimestampFormat{{
+			Regex:  `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+			Format: "2006-01-02 15:04:05.000",
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to extract profile: %s", err)
+	}
+	if generated.Lines != 8 || !generated.Start.Before(p.Start.Add(time.Minute)) {
+		t.Errorf("Expected 8 lines starting in the first minute, got:\n%s", b.String())
+	}
+	for _, c := range generated.Clusters {
+		if c.Template != p.Clusters[0].Template && c.Template != p.Clusters[1].Template {
+			t.Errorf("Expected the lines to match the templates of the profile, got %q", c.Template)
+		}
+	}
+
+	var again strings.Builder
+	Generate(p, &again, GenerateOptions{Layout: "2006-01-02 15:04:05.000", Seed: 1})
+	if again.String() != b.String() {
+		t.Error("Expected the same seed to generate the same log")
+	}
+}
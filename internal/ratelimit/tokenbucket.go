@@ -0,0 +1,66 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// TokenBucket limits the throughput of a resource, e.g. bytes written, to a
+// fixed rate per second. Tokens are refilled continuously up to the burst size.
+// It is safe for concurrent use.
+type TokenBucket struct {
+	mu sync.Mutex
+	rate float64 // tokens per second
+	burst float64
+	tokens float64
+	last time.Time
+}
+
+// NewTokenBucket creates a new TokenBucket that allows rate tokens per second
+// and bursts of up to burst tokens. The bucket starts full.
+func NewTokenBucket(rate, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate: float64(rate),
+		burst: float64(burst),
+		tokens: float64(burst),
+		last: time.Now(),
+	}
+}
+
+// Wait blocks until n tokens are available and takes them from the bucket.
+// Requests larger than the burst size are allowed once the bucket is full and
+// put the bucket into debt, delaying subsequent requests accordingly.
+// It returns the context's error if the context is cancelled while waiting.
+func (tb *TokenBucket) Wait(ctx context.Context, n int) error {
+	for {
+		delay := tb.take(float64(n))
+		if delay <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// take takes n tokens if available and returns 0. Otherwise, it returns the
+// time until enough tokens are available.
+func (tb *TokenBucket) take(n float64) time.Duration {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+	now := time.Now()
+	tb.tokens = min(tb.burst, tb.tokens + now.Sub(tb.last).Seconds() * tb.rate)
+	tb.last = now
+
+	need := min(n, tb.burst)
+	if tb.tokens >= need {
+		tb.tokens -= n
+		return 0
+	}
+	return time.Duration((need - tb.tokens) / tb.rate * float64(time.Second))
+}
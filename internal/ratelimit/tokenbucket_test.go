@@ -0,0 +1,34 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket_Wait(t *testing.T) {
+	tb := NewTokenBucket(1000, 100)
+	ctx := context.Background()
+
+	start := time.Now()
+	// The first 100 tokens are available immediately, the next 200 take 200ms
+	for i := 0; i < 3; i++ {
+		if err := tb.Wait(ctx, 100); err != nil {
+			t.Fatalf("Wait failed: %v", err)
+		}
+	}
+	if d := time.Since(start); d < 180*time.Millisecond || d > 400*time.Millisecond {
+		t.Errorf("Expected waiting for about 200ms, waited %s", d)
+	}
+}
+
+func TestTokenBucket_Cancel(t *testing.T) {
+	tb := NewTokenBucket(1, 1)
+	tb.Wait(context.Background(), 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := tb.Wait(ctx, 1); err == nil {
+		t.Error("Expected error when context is cancelled")
+	}
+}
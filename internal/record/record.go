@@ -0,0 +1,184 @@
+package record
+
+import (
+	"bananabacon/internal/logs"
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Options configures how lines are recorded.
+type Options struct {
+	// TimeFormats are the formats of timestamps already present in the
+	// lines, tried in order.
+	TimeFormats []logs.TimestampFormat
+	// Locale is the language of month and day names in the timestamps, see
+	// logs.TimeLocales. Empty means English.
+	Locale string
+	// Layout is the format of the timestamps in the recording.
+	Layout string
+	// Normalize rewrites timestamps found with TimeFormats in Layout, so
+	// captures of differently formatted sources can be replayed with the same
+	// settings. If false, they are kept as they are.
+	Normalize bool
+}
+
+type timeFormat struct {
+	matcher *logs.TimestampMatcher
+	layout string
+	locale string
+}
+
+// Recorder turns captured lines into a replay-ready log: every line starts
+// with a timestamp in Layout.
+type Recorder struct {
+	formats []timeFormat
+	layout string
+	normalize bool
+	now func() time.Time
+}
+
+// NewRecorder creates a Recorder with the given options.
+func NewRecorder(options Options) (*Recorder, error) {
+	if err := logs.CheckTimeLocale(options.Locale); err != nil {
+		return nil, err
+	}
+	if len(options.Layout) == 0 {
+		return nil, errors.New("missing time layout")
+	}
+	formats := make([]timeFormat, 0, len(options.TimeFormats))
+	for _, f := range options.TimeFormats {
+		if len(f.Regex) == 0 {
+			continue
+		}
+		matcher, err := logs.NewTimestampMatcher(f)
+		if err != nil {
+			return nil, err
+		}
+		formats = append(formats, timeFormat{matcher: matcher, layout: f.Format, locale: options.Locale})
+	}
+	return &Recorder{formats: formats, layout: options.Layout, normalize: options.Normalize, now: time.Now}, nil
+}
+
+// Line returns the line as it is recorded. Lines with a timestamp are kept,
+// with the timestamp rewritten in Layout if Normalize is set. Indented lines,
+// e.g. stack traces, are kept as continuations of the previous line. Other
+// lines are prefixed with the current time.
+func (rc *Recorder) Line(line string) string {
+	for _, f := range rc.formats {
+		value, rest, at, ok := f.matcher.Cut(line)
+		if !ok {
+			continue
+		}
+		t, err := logs.ParseTimeIn(f.layout, f.locale, value)
+		if err != nil {
+			continue
+		}
+		if !rc.normalize {
+			return line
+		}
+		// The timestamp replaces its first group, the others are removed
+		return rest[:at] + t.Format(rc.layout) + rest[at:]
+	}
+	if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+		return line
+	}
+	return rc.now().Format(rc.layout) + " " + line
+}
+
+// Record reads lines from r until its end and writes them to w as returned by
+// Line. Every line is written out right away, so nothing is lost if the
+// process is killed. It returns the number of recorded lines.
+func (rc *Recorder) Record(r io.Reader, w io.Writer) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	bw := bufio.NewWriter(w)
+	n := 0
+	for scanner.Scan() {
+		if _, err := bw.WriteString(rc.Line(scanner.Text()) + "\n"); err != nil {
+			return n, err
+		}
+		if err := bw.Flush(); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, scanner.Err()
+}
+
+// Follow returns a reader of the lines appended to the input like Tail does
+// for files. Inputs starting with logs.DockerPrefix are the logs of a
+// container read through the Docker Engine API, inputs starting with
+// logs.DockerJSONPrefix are tailed log files in the json-file format of
+// Docker, whose entries are unwrapped, see logs.IsDockerInput.
+func Follow(ctx context.Context, input string, fromStart bool) (io.Reader, error) {
+	if container, ok := strings.CutPrefix(input, logs.DockerPrefix); ok {
+		return logs.FollowContainer(ctx, container, fromStart)
+	}
+	if name, ok := strings.CutPrefix(input, logs.DockerJSONPrefix); ok {
+		path, timestamps, err := logs.ParseDockerInput(name)
+		if err != nil {
+			return nil, err
+		}
+		r, err := Tail(ctx, path, fromStart)
+		if err != nil {
+			return nil, err
+		}
+		return logs.DockerJSONReader(r, timestamps), nil
+	}
+	return Tail(ctx, input, fromStart)
+}
+
+// Tail returns a reader of the data appended to the file at path, like
+// "tail -f". If fromStart is set, the existing content is read first. If the
+// file is truncated, e.g. by copytruncate rotation, reading continues at its
+// start. The reader reaches its end when the context is cancelled.
+func Tail(ctx context.Context, path string, fromStart bool) (io.Reader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fromStart {
+		if _, err := file.Seek(0, io.SeekEnd); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		defer file.Close()
+		buf := make([]byte, 32*1024)
+		ticker := time.NewTicker(logs.FollowPollInterval)
+		defer ticker.Stop()
+		for {
+			n, err := file.Read(buf)
+			if n > 0 {
+				if _, err := pw.Write(buf[:n]); err != nil {
+					return
+				}
+				continue
+			}
+			if err != nil && !errors.Is(err, io.EOF) {
+				pw.CloseWithError(err)
+				return
+			}
+			// Start over if the file was truncated
+			if pos, err := file.Seek(0, io.SeekCurrent); err == nil {
+				if info, err := file.Stat(); err == nil && info.Size() < pos {
+					file.Seek(0, io.SeekStart)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				pw.Close()
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return pr, nil
+}
@@ -0,0 +1,95 @@
+package record
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	rc, err := NewRecorder(Options{
+		TimeFormats: []logs.TimestampFormat{{
+			Regex:  `\[(\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\]`,
+			Format: "02/Jan/2006:15:04:05 -0700",
+		}},
+		Layout:    "2006-01-02 15:04:05.000",
+		Normalize: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create recorder: %s", err)
+	}
+	rc.now = func() time.Time { return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC) }
+
+	input := "started\n10.0.0.1 [01/Mar/2024:11:59:59 +0000] GET /\npanic: boom\n\tat main.go:12\n"
+	var out strings.Builder
+	n, err := rc.Record(strings.NewReader(input), &out)
+	if err != nil {
+		t.Fatalf("Failed to record: %s", err)
+	}
+	expected := "2024-03-01 12:00:00.000 started\n" +
+		"10.0.0.1 [2024-03-01 11:59:59.000] GET /\n" +
+		"2024-03-01 12:00:00.000 panic: boom\n" +
+		"\tat main.go:12\n"
+	if n != 4 || out.String() != expected {
+		t.Errorf("Expected 4 lines:\n%s\nGot %d:\n%s", expected, n, out.String())
+	}
+}
+
+func TestTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "live.log")
+	if err := os.WriteFile(path, []byte("old line\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := Tail(ctx, path, false)
+	if err != nil {
+		t.Fatalf("Failed to tail file: %s", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("Failed to open file: %s", err)
+	}
+	f.WriteString("new line\n")
+	f.Close()
+	time.AfterFunc(2*logs.FollowPollInterval, cancel)
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read: %s", err)
+	}
+	if string(b) != "new line\n" {
+		t.Errorf("Expected only the appended line, got %q", b)
+	}
+}
+
+func TestFollow_DockerJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "abc-json.log")
+	if err := os.WriteFile(path, []byte(`{"log":"old line\n","stream":"stdout","time":"2024-03-01T11:59:59Z"}`+"\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r, err := Follow(ctx, logs.DockerJSONPrefix+path+"?timestamps=true", false)
+	if err != nil {
+		t.Fatalf("Failed to follow file: %s", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("Failed to open file: %s", err)
+	}
+	f.WriteString(`{"log":"new line\n","stream":"stderr","time":"2024-03-01T12:00:00.5Z"}` + "\n")
+	f.Close()
+	time.AfterFunc(2*logs.FollowPollInterval, cancel)
+
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to read: %s", err)
+	}
+	if string(b) != "2024-03-01T12:00:00.5Z new line\n" {
+		t.Errorf("Expected only the appended line, got %q", b)
+	}
+}
@@ -0,0 +1,61 @@
+package samples
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	// Prefix marks an input file name as a reference to a bundled sample log,
+	// e.g. "builtin:webserver".
+	Prefix = "builtin:"
+)
+
+//go:embed data/*.log
+var data embed.FS
+
+// sample wraps the content of an embedded log so it can be used like an
+// opened file.
+type sample struct {
+	*bytes.Reader
+}
+
+// Close does nothing, embedded samples do not hold any resources.
+func (s sample) Close() error {
+	return nil
+}
+
+// IsBuiltin returns true if the given input file name refers to a bundled
+// sample log, i.e. it starts with Prefix.
+func IsBuiltin(name string) bool {
+	return strings.HasPrefix(name, Prefix)
+}
+
+// Open returns the bundled sample log with the given name. The name may be
+// given with or without Prefix. If no sample with that name exists, an error
+// listing the available samples is returned.
+func Open(name string) (io.ReadSeekCloser, error) {
+	name = strings.TrimPrefix(name, Prefix)
+	content, err := data.ReadFile(path.Join("data", name+".log"))
+	if err != nil {
+		return nil, fmt.Errorf("unknown builtin sample %q, available samples: %s", name, strings.Join(Names(), ", "))
+	}
+	return sample{bytes.NewReader(content)}, nil
+}
+
+// Names returns the sorted names of all bundled sample logs.
+func Names() []string {
+	entries, _ := fs.ReadDir(data, "data")
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".log"))
+	}
+	sort.Strings(names)
+	return names
+}
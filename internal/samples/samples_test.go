@@ -0,0 +1,27 @@
+package samples
+
+import (
+	"io"
+	"testing"
+)
+
+func TestOpen(t *testing.T) {
+	names := Names()
+	if len(names) == 0 {
+		t.Fatal("Expected bundled samples, got none")
+	}
+	for _, name := range names {
+		s, err := Open(Prefix + name)
+		if err != nil {
+			t.Fatalf("Failed to open sample %s: %v", name, err)
+		}
+		content, err := io.ReadAll(s)
+		if err != nil || len(content) == 0 {
+			t.Errorf("Expected content for sample %s, err: %v", name, err)
+		}
+	}
+
+	if _, err := Open("builtin:does-not-exist"); err == nil {
+		t.Error("Expected error for unknown sample")
+	}
+}
@@ -0,0 +1,201 @@
+package scenario
+
+import (
+	"bananabacon/internal/anomaly"
+	"bananabacon/internal/metrics"
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Replay is a replay controlled by a scenario.
+type Replay struct {
+	// Name is the instance of the replay, empty for a single replay.
+	Name string
+	Replayer anomaly.Replayer
+	// Injector injects the anomalies of the phases into the replay.
+	Injector *anomaly.Injector
+}
+
+// Orchestrator executes a scenario: it applies the settings of each phase to
+// the replays and the metrics engine in turn.
+type Orchestrator struct {
+	scenario *Scenario
+	replays []Replay
+	engine *metrics.MetricsEngine
+	base []*metrics.Metric // the configured metrics
+	phaseMetrics [][]*metrics.Metric // of each phase without its declared ones, nil for the configured ones
+	speeds []float64 // configured speed of each replay
+	current []float64 // current speed of each replay
+	paused []bool // whether each replay is paused by the scenario
+
+	mu sync.Mutex
+	phase int // index of the current phase, -1 before the start
+	overridden bool // the metrics of the current phase are not the configured ones
+}
+
+// New creates an orchestrator of the scenario for the replays and the
+// metrics of the engine. The settings the phases do not give are those the
+// replays and the engine have now. It returns an error if the scenario does
+// not pass Check.
+func New(s *Scenario, replays []Replay, engine *metrics.MetricsEngine) (*Orchestrator, error) {
+	o := &Orchestrator{scenario: s, replays: replays, engine: engine, base: engine.List(),
+		phaseMetrics: make([][]*metrics.Metric, len(s.Phases)), speeds: make([]float64, len(replays)),
+		current: make([]float64, len(replays)), paused: make([]bool, len(replays)), phase: -1}
+	for i, r := range replays {
+		o.speeds[i], o.paused[i] = r.Replayer.Speed()
+		o.current[i] = o.speeds[i]
+	}
+	names := make([]string, len(replays))
+	for i, r := range replays {
+		names[i] = r.Name
+	}
+	var metricNames []string
+	for _, m := range o.base {
+		metricNames = append(metricNames, m.Name())
+	}
+	if err := s.Check(names, metricNames); err != nil {
+		return nil, err
+	}
+	for i, p := range s.Phases {
+		if p.Metrics == nil && len(p.declared) == 0 {
+			continue
+		}
+		ms := []*metrics.Metric{}
+		for _, m := range o.base {
+			script, ok := p.Metrics[m.Name()]
+			switch {
+			case !ok:
+				ms = append(ms, m)
+			case len(script) > 0:
+				ms = append(ms, metrics.NewMetric(m.Name(), m.Type(), script, m.Labels(), m.Description()))
+			}
+		}
+		o.phaseMetrics[i] = ms
+	}
+	if s.Seed != 0 {
+		for i, r := range replays {
+			r.Injector.Seed(s.Seed + uint64(i))
+		}
+	}
+	return o, nil
+}
+
+// Run executes the phases in order, each for its duration, until the last
+// phase ended or the context is cancelled. A scenario that loops runs until
+// the context is cancelled.
+func (o *Orchestrator) Run(ctx context.Context) {
+	for {
+		for i, p := range o.scenario.Phases {
+			o.enter(i, time.Now())
+			if p.duration == 0 {
+				return
+			}
+			timer := time.NewTimer(p.duration)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+		if !o.scenario.Loop {
+			o.annotate("scenario_end", fmt.Sprintf("ended scenario %s", o.scenario.Name),
+				map[string]any{"scenario": o.scenario.Name})
+			return
+		}
+	}
+}
+
+// enter applies the settings of the i-th phase at now.
+func (o *Orchestrator) enter(i int, now time.Time) {
+	p := &o.scenario.Phases[i]
+	o.mu.Lock()
+	o.phase = i
+	if ms := o.phaseMetrics[i]; ms != nil {
+		ms = slices.Clone(ms)
+		for _, m := range p.declared {
+			// Declared metrics start from scratch in every run of the phase
+			ms = append(ms, metrics.NewMetric(m.Name(), m.Type(), m.Script(), m.Labels(), m.Description()))
+		}
+		o.engine.SetMetrics(ms)
+	} else if o.overridden {
+		o.engine.SetMetrics(o.base)
+	}
+	o.overridden = o.phaseMetrics[i] != nil
+	o.mu.Unlock()
+
+	fields := map[string]any{"scenario": o.scenario.Name, "phase": p.Name}
+	if p.duration > 0 {
+		fields["duration"] = p.duration.String()
+	}
+	o.annotate("scenario_phase", fmt.Sprintf("started phase %s of scenario %s", p.Name, o.scenario.Name), fields)
+	incidents := true
+	for j, r := range o.replays {
+		playing := p.Replays == nil || slices.Contains(p.Replays, r.Name)
+		speed := p.Speed
+		if speed == 0 {
+			speed = o.speeds[j]
+		}
+		if speed != o.current[j] {
+			o.current[j] = speed
+			// Bursts of the anomalies multiply the speed of the phase
+			r.Injector.SetSpeed(r.Replayer, speed)
+		}
+		if playing == o.paused[j] {
+			o.paused[j] = !playing
+			if playing {
+				r.Replayer.Resume()
+			} else {
+				r.Replayer.Pause()
+			}
+		}
+		if !playing {
+			continue
+		}
+		for _, a := range p.anomalies {
+			if a.Incident != nil && !incidents {
+				continue
+			}
+			occurrence := *a
+			if occurrence.Duration == 0 {
+				occurrence.Duration = p.duration
+			}
+			// Start only fails for anomalies without duration, which Parse rejects
+			r.Injector.Start(&occurrence, now)
+		}
+		incidents = false
+	}
+}
+
+// annotate writes an annotation to every replay.
+func (o *Orchestrator) annotate(kind, message string, fields map[string]any) {
+	for _, r := range o.replays {
+		r.Replayer.Annotate(kind, message, fields)
+	}
+}
+
+// Phase returns the name of the current phase, empty before the start.
+func (o *Orchestrator) Phase() string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.phase < 0 {
+		return ""
+	}
+	return o.scenario.Phases[o.phase].Name
+}
+
+// Collector returns a metrics collector exposing the number of the current
+// phase, starting at 1, so dashboards can show which phase of the scenario
+// the simulator is in.
+func (o *Orchestrator) Collector() metrics.Collector {
+	m := metrics.NewMetric("bananabacon_scenario_phase", metrics.GaugeType, "",
+		map[string]string{"scenario": o.scenario.Name}, "Number of the current phase of the scenario, starting at 1")
+	return func() []metrics.MetricValue {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		return []metrics.MetricValue{metrics.NewMetricValue(m, float64(o.phase+1))}
+	}
+}
@@ -0,0 +1,231 @@
+// Package scenario runs scripted simulations of incidents: a scenario is a
+// timeline of phases, e.g. ramp-up, error storm and recovery, each setting the
+// speed of the replays, which of them play, the expressions of the metrics and
+// the anomalies injected, for a fixed duration.
+package scenario
+
+import (
+	"bananabacon/internal/anomaly"
+	"bananabacon/internal/metrics"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scenario is a timeline of phases, read from a JSON file like
+//
+//	{"name": "checkout-outage", "seed": 42, "phases": [
+//	  {"name": "ramp-up", "duration": "10m", "speed": 2},
+//	  {"name": "error storm", "duration": "5m", "speed": 4,
+//	   "metrics": {"error_rate": "0.4 + 0.1 * Math.random()"},
+//	   "anomalies": ["errors 2", "cascading-timeouts(service=checkout) 2"]},
+//	  {"name": "rollback", "duration": "2m",
+//	   "declare": [{"name": "rollback_in_progress", "type": "gauge", "script": "1"}]},
+//	  {"name": "recovery", "duration": "10m", "replays": ["1"]}]}
+type Scenario struct {
+	// Name identifies the scenario in annotations and metrics.
+	Name string `json:"name"`
+	// Seed seeds the random choices of the anomalies, so runs with the same
+	// seed inject the same anomalies. 0 keeps them random.
+	Seed uint64 `json:"seed"`
+	// Loop starts the scenario over after its last phase. Otherwise the
+	// settings of the last phase stay in effect after it ended.
+	Loop bool `json:"loop"`
+	Phases []Phase `json:"phases"`
+}
+
+// Phase is a section of a scenario. Settings that are not given are the
+// configured ones, not those of the previous phase, so every phase is
+// described completely by its own settings.
+type Phase struct {
+	// Name identifies the phase in annotations, "phase <n>" if empty.
+	Name string `json:"name"`
+	// Duration is the Go duration of the phase in wall-clock time. Only the
+	// last phase of a scenario that does not loop can omit it, it then lasts
+	// until the end.
+	Duration string `json:"duration"`
+	// Speed is the speed of the replays during the phase, the configured
+	// speed if 0.
+	Speed float64 `json:"speed"`
+	// Replays are the instances of the replays that play during the phase,
+	// e.g. "1" for the replay of INPUT_FILE_1 or "" for a single replay. The
+	// others are paused. All replays play if omitted, none if empty.
+	Replays []string `json:"replays"`
+	// Metrics replace the scripts of the metrics with the given names during
+	// the phase. An empty script removes the metric during the phase.
+	Metrics map[string]string `json:"metrics"`
+	// Declare adds metrics that only exist during the phase, e.g. a gauge
+	// rollback_in_progress. They start from scratch whenever the phase is
+	// entered.
+	Declare []MetricSpec `json:"declare"`
+	// Anomalies are started at the start of the phase, given like the
+	// anomalies of ANOMALIES without a schedule, e.g. "errors 2",
+	// "burst 3 for 1m" or "memory-leak(service=api) 2". They last for the
+	// phase unless they have a duration. Bursts, errors and pauses are
+	// started on every replay playing in the phase, incidents, which also
+	// change the metrics, only on the first.
+	Anomalies []string `json:"anomalies"`
+
+	duration time.Duration
+	anomalies []*anomaly.Anomaly
+	declared []*metrics.Metric
+}
+
+// MetricSpec declares a metric like the METRIC_<name>_* variables.
+type MetricSpec struct {
+	Name string `json:"name"`
+	// Type is "counter", "gauge", "histogram" or "summary", "gauge" if
+	// empty.
+	Type string `json:"type"`
+	// Script is the expression or function of the metric, "t" if empty.
+	Script string `json:"script"`
+	Description string `json:"description"`
+	Labels map[string]string `json:"labels"`
+}
+
+// metric creates the declared metric.
+func (spec MetricSpec) metric() (*metrics.Metric, error) {
+	if len(spec.Name) == 0 {
+		return nil, errors.New("missing name of declared metric")
+	}
+	b := metrics.NewMetricBuilder(spec.Name).WithScript(spec.Script).WithDescription(spec.Description)
+	if len(spec.Type) > 0 {
+		typ, ok := metrics.ParseMetricType(spec.Type)
+		if !ok {
+			return nil, fmt.Errorf("invalid type %q of metric %s", spec.Type, spec.Name)
+		}
+		b.WithType(typ)
+	}
+	for k, v := range spec.Labels {
+		if _, err := b.WithLabel(k, v); err != nil {
+			return nil, fmt.Errorf("invalid label %q of metric %s", k, spec.Name)
+		}
+	}
+	m, _ := b.Build()
+	if err := metrics.ValidateMetric(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Load reads a scenario from a JSON file, see Parse. The name of the scenario
+// defaults to the name of the file without its extension.
+func Load(path string) (*Scenario, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	if len(s.Name) == 0 {
+		s.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return s, nil
+}
+
+// Parse parses and validates a scenario given as JSON. It returns an error
+// describing every invalid phase. The names of replays and metrics are checked
+// by Check.
+func Parse(data []byte) (*Scenario, error) {
+	var s Scenario
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&s); err != nil {
+		return nil, err
+	}
+	if len(s.Phases) == 0 {
+		return nil, errors.New("no phases")
+	}
+	var errs []error
+	for i := range s.Phases {
+		p := &s.Phases[i]
+		if len(p.Name) == 0 {
+			p.Name = fmt.Sprintf("phase %d", i+1)
+		}
+		if err := p.parse(i == len(s.Phases)-1 && !s.Loop); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// parse validates the settings of the phase. open is set if the phase may
+// omit its duration.
+func (p *Phase) parse(open bool) error {
+	var err error
+	if len(p.Duration) > 0 {
+		if p.duration, err = time.ParseDuration(p.Duration); err != nil || p.duration <= 0 {
+			return fmt.Errorf("invalid duration %s, must be a positive Go duration", p.Duration)
+		}
+	} else if !open {
+		return errors.New("missing duration, only the last phase of a scenario that does not loop can omit it")
+	}
+	if p.Speed < 0 {
+		return fmt.Errorf("invalid speed %v, must be positive", p.Speed)
+	}
+	for _, spec := range p.Anomalies {
+		a, err := anomaly.ParseOccurrence(spec)
+		if err != nil {
+			return err
+		}
+		if a.Duration == 0 && p.duration == 0 && a.Incident == nil {
+			return fmt.Errorf("missing duration of anomaly %q in a phase without duration", spec)
+		}
+		p.anomalies = append(p.anomalies, a)
+	}
+	names := map[string]bool{}
+	for _, spec := range p.Declare {
+		if names[spec.Name] {
+			return fmt.Errorf("metric %s declared twice", spec.Name)
+		}
+		names[spec.Name] = true
+		m, err := spec.metric()
+		if err != nil {
+			return err
+		}
+		p.declared = append(p.declared, m)
+	}
+	return nil
+}
+
+// Check returns an error if a phase refers to a replay or a metric that is not
+// among the given names, declares a metric that is among them or a script of
+// a metric does not compile.
+func (s *Scenario) Check(replays []string, metricNames []string) error {
+	var errs []error
+	for _, p := range s.Phases {
+		for _, name := range p.Replays {
+			if !slices.Contains(replays, name) {
+				errs = append(errs, fmt.Errorf("%s: unknown replay %q", p.Name, name))
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(p.Metrics)) {
+			if !slices.Contains(metricNames, name) {
+				errs = append(errs, fmt.Errorf("%s: unknown metric %q, declare it to add it during the phase", p.Name,
+					name))
+			} else if script := p.Metrics[name]; len(script) > 0 {
+				if err := metrics.NewMetric(name, metrics.GaugeType, script, nil, "").Compile(); err != nil {
+					errs = append(errs, fmt.Errorf("%s: invalid script of metric %s: %w", p.Name, name, err))
+				}
+			}
+		}
+		for _, spec := range p.Declare {
+			if slices.Contains(metricNames, spec.Name) {
+				errs = append(errs, fmt.Errorf("%s: declared metric %q already exists", p.Name, spec.Name))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
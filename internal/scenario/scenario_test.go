@@ -0,0 +1,207 @@
+package scenario
+
+import (
+	"bananabacon/internal/anomaly"
+	"bananabacon/internal/metrics"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeReplayer struct {
+	mu          sync.Mutex
+	speed       float64
+	paused      bool
+	annotations []string
+}
+
+func (r *fakeReplayer) Pause() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paused = true
+}
+
+func (r *fakeReplayer) Resume() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.paused = false
+}
+
+func (r *fakeReplayer) SetSpeed(speed float64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.speed = speed
+	return nil
+}
+
+func (r *fakeReplayer) Speed() (float64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.speed, r.paused
+}
+
+func (r *fakeReplayer) Annotate(kind, message string, fields map[string]any) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.annotations = append(r.annotations, kind+" "+message)
+}
+
+func TestParse(t *testing.T) {
+	s, err := Parse([]byte(`{"name": "outage", "phases": [
+		{"duration": "1m", "anomalies": ["errors 2", "memory-leak 2 for 30s"]},
+		{"name": "recovery"}]}`))
+	if err != nil {
+		t.Fatalf("Failed to parse scenario: %s", err)
+	}
+	if s.Phases[0].Name != "phase 1" || s.Phases[0].duration != time.Minute || len(s.Phases[0].anomalies) != 2 {
+		t.Errorf("Unexpected first phase %+v", s.Phases[0])
+	}
+	if s.Phases[1].duration != 0 {
+		t.Errorf("Expected the last phase to last until the end, got %s", s.Phases[1].duration)
+	}
+
+	for _, test := range []struct {
+		json     string
+		expected string
+	}{
+		{`{"phases": []}`, "no phases"},
+		{`{"phases": [{"duration": "1m", "sped": 2}]}`, `unknown field "sped"`},
+		{`{"loop": true, "phases": [{"name": "a"}]}`, "a: missing duration"},
+		{`{"phases": [{"name": "a"}, {"name": "b", "duration": "1m"}]}`, "a: missing duration"},
+		{`{"phases": [{"duration": "soon"}]}`, "phase 1: invalid duration soon"},
+		{`{"phases": [{"duration": "1m", "speed": -1}]}`, "phase 1: invalid speed -1"},
+		{`{"phases": [{"duration": "1m", "anomalies": ["spike 2"]}]}`, `phase 1: invalid anomaly "spike 2"`},
+		{`{"phases": [{"anomalies": ["errors 2"]}]}`, `phase 1: missing duration of anomaly "errors 2"`},
+		{`{"phases": [{"declare": [{"script": "1"}]}]}`, "phase 1: missing name of declared metric"},
+		{`{"phases": [{"declare": [{"name": "a", "type": "meter"}]}]}`, `phase 1: invalid type "meter" of metric a`},
+		{`{"phases": [{"declare": [{"name": "a", "script": "("}]}]}`, "phase 1: invalid script of metric a"},
+		{`{"phases": [{"declare": [{"name": "a"}, {"name": "a"}]}]}`, "phase 1: metric a declared twice"},
+	} {
+		if _, err := Parse([]byte(test.json)); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected an error containing %q for %s, got %v", test.expected, test.json, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkout-outage.json")
+	if err := os.WriteFile(path, []byte(`{"phases": [{"duration": "1m"}]}`), 0o644); err != nil {
+		t.Fatalf("Failed to write scenario: %s", err)
+	}
+	s, err := Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load scenario: %s", err)
+	}
+	if s.Name != "checkout-outage" {
+		t.Errorf("Expected the name of the file as name, got %q", s.Name)
+	}
+}
+
+func TestOrchestrator(t *testing.T) {
+	s, err := Parse([]byte(`{"name": "outage", "phases": [
+		{"name": "ramp-up", "duration": "1m", "speed": 2, "replays": ["1"]},
+		{"name": "error storm", "duration": "1m", "speed": 4,
+		 "metrics": {"error_rate": "0.5", "latency": ""}, "anomalies": ["burst 3"]},
+		{"name": "rollback", "duration": "1m", "declare": [{"name": "rollback_in_progress",
+		 "script": "(prev || 0) + 1", "labels": {"service": "checkout"}, "description": "Rollback running"}]},
+		{"name": "recovery", "duration": "1m"}]}`))
+	if err != nil {
+		t.Fatalf("Failed to parse scenario: %s", err)
+	}
+	engine := metrics.NewMetricsEngine([]*metrics.Metric{
+		metrics.NewMetric("error_rate", metrics.GaugeType, "0.01", nil, "Errors"),
+		metrics.NewMetric("latency", metrics.GaugeType, "0.2", nil, "Latency"),
+	})
+	r1, r2 := &fakeReplayer{speed: 1}, &fakeReplayer{speed: 1}
+	inj1, inj2 := anomaly.NewInjector(nil, nil, ""), anomaly.NewInjector(nil, nil, "")
+	o, err := New(s, []Replay{{Name: "1", Replayer: r1, Injector: inj1}, {Name: "2", Replayer: r2, Injector: inj2}},
+		engine)
+	if err != nil {
+		t.Fatalf("Failed to create orchestrator: %s", err)
+	}
+	collect := o.Collector()
+	if v := collect()[0].Value(); v != 0.0 {
+		t.Errorf("Expected phase 0 before the start, got %v", v)
+	}
+
+	o.enter(0, time.Now())
+	if o.Phase() != "ramp-up" || r1.speed != 2 || r2.speed != 2 || r1.paused || !r2.paused {
+		t.Errorf("Unexpected state in ramp-up: %+v %+v", r1, r2)
+	}
+	if v := collect()[0].Value(); v != 1.0 {
+		t.Errorf("Expected phase 1, got %v", v)
+	}
+
+	o.enter(1, time.Now())
+	if r1.speed != 4 || r2.paused {
+		t.Errorf("Unexpected state in the error storm: %+v %+v", r1, r2)
+	}
+	ms := engine.List()
+	if len(ms) != 1 || ms[0].Name() != "error_rate" || ms[0].Script() != "0.5" {
+		t.Errorf("Expected only error_rate with the script of the phase, got %v", ms)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go inj1.Run(ctx, r1)
+	go inj2.Run(ctx, r2)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s1, _ := r1.Speed()
+		s2, _ := r2.Speed()
+		if s1 == 12 && s2 == 12 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the burst to triple the speed of both replays, got %v and %v", s1, s2)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	o.enter(2, time.Now())
+	ms = engine.List()
+	if len(ms) != 3 || ms[0].Script() != "0.01" || ms[2].Name() != "rollback_in_progress" ||
+		ms[2].Type() != metrics.GaugeType || ms[2].Labels()["service"] != "checkout" {
+		t.Errorf("Expected the configured metrics and the declared one in the rollback, got %v", ms)
+	}
+	vm := engine.NewRuntime()
+	engine.Eval(ms[2], vm)
+	if v, _ := engine.Eval(ms[2], vm); v.Value() != int64(2) {
+		t.Errorf("Expected the declared metric to be evaluated, got %v", v.Value())
+	}
+
+	o.enter(3, time.Now())
+	if ms := engine.List(); len(ms) != 2 || ms[0].Script() != "0.01" {
+		t.Errorf("Expected the declared metric to be removed after the rollback, got %v", ms)
+	}
+	o.enter(2, time.Now())
+	if v, _ := engine.Eval(engine.List()[2], vm); v.Value() != int64(1) {
+		t.Errorf("Expected the declared metric to start from scratch, got %v", v.Value())
+	}
+	if s1, _ := r1.Speed(); s1 != 3 {
+		t.Errorf("Expected the configured speed times the burst, got %v", s1)
+	}
+	r2.mu.Lock()
+	if !strings.HasPrefix(r2.annotations[0], "scenario_phase started phase ramp-up of scenario outage") {
+		t.Errorf("Unexpected annotations %v", r2.annotations)
+	}
+	r2.mu.Unlock()
+
+	for _, invalid := range []string{
+		`{"phases": [{"duration": "1m", "replays": ["3"]}]}`,
+		`{"phases": [{"duration": "1m", "metrics": {"unknown": "1"}}]}`,
+		`{"phases": [{"duration": "1m", "metrics": {"latency": "("}}]}`,
+		`{"phases": [{"duration": "1m", "declare": [{"name": "latency"}]}]}`,
+	} {
+		s, err := Parse([]byte(invalid))
+		if err != nil {
+			t.Fatalf("Failed to parse scenario: %s", err)
+		}
+		if _, err := New(s, []Replay{{Name: "1", Replayer: r1, Injector: inj1}}, engine); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
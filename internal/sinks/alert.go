@@ -0,0 +1,266 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// alertTemplates are the built-in payload templates of an AlertSink.
+var alertTemplates = map[string]string{
+	// Alertmanager API v2, POST /api/v2/alerts
+	"alertmanager": `[{"labels":{"alertname":{{json .Name}}{{range $k, $v := .Labels}},{{json $k}}:{{json $v}}{{end}}},` +
+		`"annotations":{"summary":{{json .Line}},"source":{{json .Source}}},"startsAt":{{json .Time}}` +
+		`{{if not .EndsAt.IsZero}},"endsAt":{{json .EndsAt}}{{end}}}]`,
+	// PagerDuty Events API v2, POST https://events.pagerduty.com/v2/enqueue
+	"pagerduty": `{"routing_key":{{json (env "PAGERDUTY_ROUTING_KEY")}},"event_action":"trigger",` +
+		`"dedup_key":{{json .Fingerprint}},"payload":{"summary":{{json .Line}},` +
+		`"source":{{json (or .Source "bananabacon")}},"severity":{{json (or .Labels.severity "error")}},` +
+		`"timestamp":{{json .Time}},"custom_details":{{json .Labels}}}}`,
+	// Opsgenie Alert API, POST https://api.opsgenie.com/v2/alerts
+	"opsgenie": `{"message":{{json .Name}},"alias":{{json .Fingerprint}},"description":{{json .Line}},` +
+		`"details":{{json .Labels}},"source":"bananabacon","priority":{{json (or .Labels.priority "P3")}}}`,
+}
+
+// AlertOptions configures an AlertSink.
+type AlertOptions struct {
+	// Match selects the lines that fire an alert. Its named groups are added
+	// to the labels of the alert. Nil means every line fires one.
+	Match *regexp.Regexp
+	// Name is the name of the alert, e.g. the alertname label of Alertmanager.
+	Name string
+	// Labels are static labels of every alert.
+	Labels map[string]string
+	// Template is a text/template executed with an AlertEvent to create the
+	// JSON payload of the request, or the name of a built-in template:
+	// "alertmanager", "pagerduty" or "opsgenie".
+	Template string
+	// ResolveAfter is the time after the line an alert ends, 0 leaves it to
+	// the receiver.
+	ResolveAfter time.Duration
+	// RepeatInterval suppresses alerts with the same fingerprint until this
+	// replay time has passed since the last one, 0 sends every alert.
+	RepeatInterval time.Duration
+	// Retries is the number of times a failed request is retried. Requests
+	// are retried on network errors, 429 and 5xx responses.
+	Retries int
+	// Backoff is the delay before the first retry. It doubles with every
+	// retry.
+	Backoff time.Duration
+	HTTP HTTPOptions
+}
+
+// AlertEvent is the data the payload template of an AlertSink is executed
+// with. Besides the fields of the line, it has the name, labels and
+// fingerprint of the alert. The template can use the functions "json", which
+// encodes a value as JSON, e.g. {{json .Line}}, and "env", which returns the
+// value of an environment variable, e.g. for tokens that should not be part
+// of the output spec.
+type AlertEvent struct {
+	logs.LogEvent
+	Name string
+	// Labels are the static labels and the named groups of the match.
+	Labels map[string]string
+	// Fingerprint identifies alerts with the same name and labels, e.g. for
+	// the deduplication key of an incident.
+	Fingerprint string
+	// EndsAt is the time the alert resolves, zero if not set.
+	EndsAt time.Time
+}
+
+// AlertSink turns matching lines into alerts posted to an HTTP endpoint, e.g.
+// Alertmanager, PagerDuty or Opsgenie, so incident management tools receive
+// events consistent with the replayed logs. Every matching line is posted
+// right away in its own request with the replayed time of the line.
+// Other lines are dropped.
+type AlertSink struct {
+	url string
+	options AlertOptions
+	client *http.Client
+	payload *template.Template
+	lastSent map[string]time.Time // by fingerprint
+	buf bytes.Buffer
+}
+
+// NewAlertSink creates an AlertSink posting to the given URL.
+func NewAlertSink(endpoint string, options AlertOptions) (*AlertSink, error) {
+	if len(options.Name) == 0 {
+		return nil, errors.New("alert name must not be empty")
+	}
+	if options.ResolveAfter < 0 || options.RepeatInterval < 0 {
+		return nil, errors.New("resolve after and repeat interval must not be negative")
+	}
+	if options.Retries < 0 || options.Backoff < 0 {
+		return nil, errors.New("retries and backoff must not be negative")
+	}
+	text := options.Template
+	if builtin, ok := alertTemplates[text]; ok {
+		text = builtin
+	} else if !strings.Contains(text, "{{") {
+		return nil, fmt.Errorf("invalid alert template: %s, must be alertmanager, pagerduty, opsgenie or a template",
+			text)
+	}
+	payload, err := template.New("alert").Funcs(template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"env": os.Getenv,
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alert template: %w", err)
+	}
+	client, err := options.HTTP.Client()
+	if err != nil {
+		return nil, err
+	}
+	return &AlertSink{url: endpoint, options: options, client: client, payload: payload,
+		lastSent: map[string]time.Time{}}, nil
+}
+
+// event returns the alert of the line of e and false if it does not match.
+func (s *AlertSink) event(e logs.LogEvent) (AlertEvent, bool) {
+	labels := maps.Clone(s.options.Labels)
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	if s.options.Match != nil {
+		m := s.options.Match.FindStringSubmatch(e.Line)
+		if m == nil {
+			return AlertEvent{}, false
+		}
+		for i, name := range s.options.Match.SubexpNames() {
+			if len(name) > 0 && len(m[i]) > 0 {
+				labels[name] = m[i]
+			}
+		}
+	}
+	a := AlertEvent{LogEvent: e, Name: s.options.Name, Labels: labels, Fingerprint: fingerprint(s.options.Name, labels)}
+	if s.options.ResolveAfter > 0 {
+		a.EndsAt = e.Time.Add(s.options.ResolveAfter)
+	}
+	return a, true
+}
+
+// fingerprint hashes the name and the labels of an alert.
+func fingerprint(name string, labels map[string]string) string {
+	h := sha256.New()
+	h.Write([]byte(name))
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		fmt.Fprintf(h, "\x00%s\x00%s", k, labels[k])
+	}
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// Write posts an alert if the line of the event matches.
+func (s *AlertSink) Write(_ context.Context, e logs.LogEvent) error {
+	a, ok := s.event(e)
+	if !ok {
+		return nil
+	}
+	if s.options.RepeatInterval > 0 {
+		if last, ok := s.lastSent[a.Fingerprint]; ok && e.Time.Sub(last) < s.options.RepeatInterval {
+			return nil
+		}
+		s.lastSent[a.Fingerprint] = e.Time
+	}
+	s.buf.Reset()
+	if err := s.payload.Execute(&s.buf, a); err != nil {
+		return fmt.Errorf("failed to execute alert template: %w", err)
+	}
+	if !json.Valid(s.buf.Bytes()) {
+		return fmt.Errorf("alert template produced invalid JSON: %s", s.buf.String())
+	}
+	return postWithRetries(s.client, s.options.HTTP, s.url, s.buf.Bytes(), "application/json",
+		s.options.Retries, s.options.Backoff)
+}
+
+// Flush does nothing, alerts are posted right away.
+func (s *AlertSink) Flush() error {
+	return nil
+}
+
+// Close does nothing, alerts are posted right away.
+func (s *AlertSink) Close() error {
+	return nil
+}
+
+// openAlert creates an AlertSink from a spec like
+// "alert+http://alertmanager:9093/api/v2/alerts?match=ERROR&label=severity:critical".
+// The options are removed from the URL, other query parameters are kept.
+func openAlert(spec string) (logs.Sink, error) {
+	u, err := url.Parse(strings.TrimPrefix(spec, "alert+"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	q := u.Query()
+	httpOptions, err := parseHTTPOptions(q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	options := AlertOptions{
+		Name: queryOr(q, "name", "BananabaconLogMatch"),
+		Labels: map[string]string{},
+		Template: queryOr(q, "template", "alertmanager"),
+		Retries: 3,
+		Backoff: 500 * time.Millisecond,
+		HTTP: httpOptions,
+	}
+	if v := q.Get("match"); len(v) > 0 {
+		if options.Match, err = regexp.Compile(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	for _, l := range q["label"] {
+		name, value, ok := strings.Cut(l, ":")
+		if !ok || len(name) == 0 {
+			return nil, fmt.Errorf("invalid output %q: invalid label: %s, must be like name:value", spec, l)
+		}
+		options.Labels[name] = value
+	}
+	if v := q.Get("template_file"); len(v) > 0 {
+		b, err := os.ReadFile(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+		options.Template = string(b)
+	}
+	for key, d := range map[string]*time.Duration{"resolve_after": &options.ResolveAfter,
+		"repeat_interval": &options.RepeatInterval, "backoff": &options.Backoff} {
+		if v := q.Get(key); len(v) > 0 {
+			if *d, err = time.ParseDuration(v); err != nil {
+				return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+			}
+		}
+	}
+	if v := q.Get("retries"); len(v) > 0 {
+		if options.Retries, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	for _, key := range []string{"match", "name", "label", "template", "template_file", "resolve_after",
+		"repeat_interval", "retries", "backoff"} {
+		q.Del(key)
+	}
+	u.RawQuery = q.Encode()
+	s, err := NewAlertSink(u.String(), options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,85 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAlertSink_Alertmanager(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, string(b))
+	}))
+	defer server.Close()
+
+	sink, err := Open("alert+" + server.URL + "/api/v2/alerts?match=ERROR%20(?P<service>\\w%2B)" +
+		"&label=severity:critical&name=LogError&resolve_after=5m&repeat_interval=1m")
+	if err != nil {
+		t.Fatalf("Failed to open alert sink: %s", err)
+	}
+	defer sink.Close()
+	t0 := time.Date(2024, 1, 5, 13, 4, 5, 0, time.UTC)
+	for i, line := range []string{"INFO api started", "ERROR api timeout", "ERROR api timeout", "ERROR db down"} {
+		e := logs.LogEvent{Time: t0.Add(time.Duration(i) * time.Second), Line: line, Source: "app.log"}
+		if err := sink.Write(context.Background(), e); err != nil {
+			t.Fatalf("Failed to write: %s", err)
+		}
+	}
+
+	// The second timeout is suppressed by the repeat interval
+	expected := []string{
+		`[{"labels":{"alertname":"LogError","service":"api","severity":"critical"},` +
+			`"annotations":{"summary":"ERROR api timeout","source":"app.log"},` +
+			`"startsAt":"2024-01-05T13:04:06Z","endsAt":"2024-01-05T13:09:06Z"}]`,
+		`[{"labels":{"alertname":"LogError","service":"db","severity":"critical"},` +
+			`"annotations":{"summary":"ERROR db down","source":"app.log"},` +
+			`"startsAt":"2024-01-05T13:04:08Z","endsAt":"2024-01-05T13:09:08Z"}]`,
+	}
+	if len(bodies) != len(expected) {
+		t.Fatalf("Expected %d alerts, got %d: %v", len(expected), len(bodies), bodies)
+	}
+	for i := range expected {
+		if bodies[i] != expected[i] {
+			t.Errorf("Expected:\n%s\nGot:\n%s", expected[i], bodies[i])
+		}
+	}
+}
+
+func TestAlertSink_PagerDuty(t *testing.T) {
+	t.Setenv("PAGERDUTY_ROUTING_KEY", "abc")
+	var event struct {
+		RoutingKey string `json:"routing_key"`
+		DedupKey   string `json:"dedup_key"`
+		Payload    struct {
+			Severity string `json:"severity"`
+		} `json:"payload"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("Failed to decode event: %s", err)
+		}
+	}))
+	defer server.Close()
+
+	sink, err := NewAlertSink(server.URL, AlertOptions{Name: "LogError", Template: "pagerduty"})
+	if err != nil {
+		t.Fatalf("Failed to create alert sink: %s", err)
+	}
+	if err := sink.Write(context.Background(), logs.LogEvent{Line: "ERROR"}); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	if event.RoutingKey != "abc" || event.Payload.Severity != "error" || len(event.DedupKey) != 16 {
+		t.Errorf("Unexpected event %+v", event)
+	}
+
+	if _, err := NewAlertSink(server.URL, AlertOptions{Name: "LogError", Template: "pagerdty"}); err == nil {
+		t.Error("Expected error for unknown template")
+	}
+}
@@ -0,0 +1,112 @@
+package sinks
+
+import (
+	"sync"
+	"time"
+)
+
+// batcher collects the lines of the sinks that send them in batches. A batch
+// is pushed once it holds size lines, or when it is flushed and interval has
+// passed since the last push. Lines waiting for longer than interval, e.g.
+// during long gaps in the log, are pushed in the background.
+type batcher struct {
+	mu sync.Mutex
+	size int
+	interval time.Duration
+	push func() error // sends the buffered lines, called with mu held
+	pending int
+	lastPush time.Time
+	err error // error of a push in the background
+	stop chan struct{}
+	stopped sync.WaitGroup
+}
+
+// newBatcher creates a batcher calling push to send a batch.
+func newBatcher(size int, interval time.Duration, push func() error) *batcher {
+	b := &batcher{
+		size: size,
+		interval: interval,
+		push: push,
+		lastPush: time.Now(),
+		stop: make(chan struct{}),
+	}
+	b.stopped.Add(1)
+	go b.pushPeriodically()
+	return b
+}
+
+// pushPeriodically pushes lines that have been buffered for longer than the
+// interval.
+func (b *batcher) pushPeriodically() {
+	defer b.stopped.Done()
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			if err := b.pushIfDue(); err != nil && b.err == nil {
+				b.err = err
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// add calls buffer to add a line to the batch and pushes the batch if it is
+// full. It returns the error of a failed push in the background, if any.
+func (b *batcher) add(buffer func()) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	buffer()
+	b.pending++
+	if b.pending >= b.size {
+		return b.pushNow()
+	}
+	return nil
+}
+
+// flush pushes the batch if the interval has passed since the last push.
+func (b *batcher) flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	return b.pushIfDue()
+}
+
+// pushIfDue pushes the batch if the interval has passed. b.mu must be held.
+func (b *batcher) pushIfDue() error {
+	if time.Since(b.lastPush) < b.interval {
+		return nil
+	}
+	return b.pushNow()
+}
+
+// pushNow pushes the batch. b.mu must be held.
+func (b *batcher) pushNow() error {
+	b.lastPush = time.Now()
+	if b.pending == 0 {
+		return nil
+	}
+	b.pending = 0
+	return b.push()
+}
+
+// close stops pushing in the background and pushes the remaining lines.
+func (b *batcher) close() error {
+	close(b.stop)
+	b.stopped.Wait()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	return b.pushNow()
+}
@@ -0,0 +1,113 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClickHouseSink(t *testing.T) {
+	var query, body, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		user, pass, _ := r.BasicAuth()
+		auth = user + ":" + pass
+	}))
+	defer server.Close()
+
+	spec := strings.Replace(server.URL, "http://", "clickhouse+http://default:secret@", 1) + "?database=demo&table=app_logs"
+	sink, err := Open(spec)
+	if err != nil {
+		t.Fatalf("Failed to open ClickHouse sink: %s", err)
+	}
+	t0 := time.Date(2024, 1, 5, 23, 30, 0, 500000000, time.FixedZone("", -3600))
+	for _, line := range []string{
+		`{"level":"WARNING","msg":"slow query","took_ms":120,"db":"orders"}`,
+		`2024-01-05 23:30:00 ERROR payment failed order=42 reason="card declined"`,
+		`plain line`,
+	} {
+		if err := sink.Write(context.Background(), logs.LogEvent{Time: t0, Line: line}); err != nil {
+			t.Fatalf("Failed to write: %s", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+
+	if expected := "INSERT INTO demo.app_logs (timestamp, level, message, fields) FORMAT JSONEachRow"; query != expected {
+		t.Errorf("Expected query %q, got %q", expected, query)
+	}
+	expected := `{"fields":{"db":"orders","level":"WARNING","msg":"slow query","took_ms":"120"},"level":"warn","message":"slow query","timestamp":"2024-01-06 00:30:00.500000000"}` + "\n" +
+		`{"fields":{"order":"42","reason":"card declined"},"level":"error","message":"2024-01-05 23:30:00 ERROR payment failed order=42 reason=\"card declined\"","timestamp":"2024-01-06 00:30:00.500000000"}` + "\n" +
+		`{"fields":{},"level":"","message":"plain line","timestamp":"2024-01-06 00:30:00.500000000"}` + "\n"
+	if body != expected {
+		t.Errorf("Unexpected rows\n%s, expected\n%s", body, expected)
+	}
+	if auth != "default:secret" {
+		t.Errorf("Expected basic authentication, got %q", auth)
+	}
+}
+
+func TestClickHouseSink_Columns(t *testing.T) {
+	var query, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Get("query")
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+	}))
+	defer server.Close()
+
+	spec := strings.Replace(server.URL, "http://", "clickhouse+http://", 1) +
+		"?column=ts:original_time&column=raw:line&column=file:source&column=n:line_number"
+	sink, err := Open(spec)
+	if err != nil {
+		t.Fatalf("Failed to open ClickHouse sink: %s", err)
+	}
+	e := logs.LogEvent{OriginalTime: time.Date(2023, 3, 1, 8, 0, 0, 0, time.UTC), Line: "hello", Source: "app.log", LineNumber: 7}
+	sink.Write(context.Background(), e)
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+	if expected := "INSERT INTO logs (ts, raw, file, n) FORMAT JSONEachRow"; query != expected {
+		t.Errorf("Expected query %q, got %q", expected, query)
+	}
+	if expected := `{"file":"app.log","n":7,"raw":"hello","ts":"2023-03-01 08:00:00.000000000"}` + "\n"; body != expected {
+		t.Errorf("Expected row %q, got %q", expected, body)
+	}
+
+	for _, invalid := range []string{
+		"clickhouse+http://localhost:8123?table=app%20logs",
+		"clickhouse+http://localhost:8123?column=ts",
+		"clickhouse+http://localhost:8123?column=ts:timestamp",
+		"clickhouse+http://localhost:8123?column=my-col:line",
+	} {
+		if _, err := Open(invalid); err == nil {
+			t.Errorf("Expected an error for %s", invalid)
+		}
+	}
+}
+
+func TestClickHouseSink_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Code: 60. DB::Exception: Table default.logs does not exist. (UNKNOWN_TABLE)"))
+	}))
+	defer server.Close()
+
+	sink, err := NewClickHouseSink(server.URL, ClickHouseOptions{Table: "logs", BatchSize: 1, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to create ClickHouse sink: %s", err)
+	}
+	defer sink.Close()
+	err = sink.Write(context.Background(), logs.LogEvent{Line: "line"})
+	if err == nil || !strings.Contains(err.Error(), "UNKNOWN_TABLE") {
+		t.Errorf("Expected the error of ClickHouse to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+const (
+	// DefaultDatasetMaxRows is the default number of rows after which a new
+	// file is started in a partition.
+	DefaultDatasetMaxRows = 100000
+)
+
+// DatasetOptions configures a DatasetSink.
+type DatasetOptions struct {
+	// Format is "jsonl" or "parquet".
+	Format string
+	// Partition is "hour" or "day", the period of log time covered by each
+	// partition directory.
+	Partition string
+	// MaxRows starts a new file in the partition once a file has the given
+	// number of rows. Zero means DefaultDatasetMaxRows.
+	MaxRows int
+}
+
+// DatasetRecord is a row of a dataset written by a DatasetSink.
+type DatasetRecord struct {
+	Time time.Time `json:"time" parquet:"time,timestamp(millisecond)"`
+	OriginalTime time.Time `json:"original_time" parquet:"original_time,timestamp(millisecond)"`
+	Line string `json:"line" parquet:"line"`
+	Source string `json:"source" parquet:"source,dict"`
+	LineNumber int `json:"line_number" parquet:"line_number"`
+}
+
+// datasetFile is a file of a dataset.
+type datasetFile interface {
+	write(r DatasetRecord) error
+	flush() error
+	close() error
+}
+
+// DatasetSink writes the events as records to JSONL or Parquet files that are
+// partitioned by the hour or day of their replayed timestamp in UTC, using
+// Hive-style directories like "date=2024-03-01/hour=12", so data lake tools
+// can pick them up as a partitioned table. Parquet files are written to a
+// hidden file and renamed once complete, i.e. when the partition changes,
+// MaxRows is reached or the sink is closed.
+type DatasetSink struct {
+	dir string
+	options DatasetOptions
+	prefix string // distinguishes the files of this sink from earlier runs
+	partition string
+	file datasetFile
+	rows int
+	seq int
+}
+
+// NewDatasetSink creates a DatasetSink writing to the given directory.
+func NewDatasetSink(dir string, options DatasetOptions) (*DatasetSink, error) {
+	if options.Format != "jsonl" && options.Format != "parquet" {
+		return nil, fmt.Errorf("invalid dataset format: %s, must be jsonl or parquet", options.Format)
+	}
+	if options.Partition != "hour" && options.Partition != "day" {
+		return nil, fmt.Errorf("invalid dataset partition: %s, must be hour or day", options.Partition)
+	}
+	if options.MaxRows < 0 {
+		return nil, fmt.Errorf("invalid dataset max rows: %d, must not be negative", options.MaxRows)
+	}
+	if options.MaxRows == 0 {
+		options.MaxRows = DefaultDatasetMaxRows
+	}
+	return &DatasetSink{dir: dir, options: options, prefix: time.Now().UTC().Format("20060102T150405")}, nil
+}
+
+// Write appends the event to the file of its partition.
+func (ds *DatasetSink) Write(_ context.Context, e logs.LogEvent) error {
+	t := e.Time.UTC()
+	partition := "date=" + t.Format(time.DateOnly)
+	if ds.options.Partition == "hour" {
+		partition = filepath.Join(partition, "hour="+t.Format("15"))
+	}
+	if ds.file == nil || partition != ds.partition || ds.rows >= ds.options.MaxRows {
+		if err := ds.closeFile(); err != nil {
+			return err
+		}
+		if err := ds.openFile(partition); err != nil {
+			return err
+		}
+	}
+	ds.rows++
+	// Parquet files buffer the records of a row group
+	return ds.file.write(DatasetRecord{
+		Time: e.Time,
+		OriginalTime: e.OriginalTime,
+		Line: strings.Clone(e.Line),
+		Source: e.Source,
+		LineNumber: e.LineNumber,
+	})
+}
+
+// openFile starts a new file in the partition.
+func (ds *DatasetSink) openFile(partition string) error {
+	dir := filepath.Join(ds.dir, partition)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if partition != ds.partition {
+		ds.partition, ds.seq = partition, 0
+	}
+	ds.seq++
+	name := fmt.Sprintf("part-%s-%04d.%s", ds.prefix, ds.seq, ds.options.Format)
+	var err error
+	if ds.options.Format == "jsonl" {
+		ds.file, err = newJSONLFile(filepath.Join(dir, name))
+	} else {
+		ds.file, err = newParquetFile(filepath.Join(dir, name))
+	}
+	ds.rows = 0
+	return err
+}
+
+// closeFile completes the current file, if any.
+func (ds *DatasetSink) closeFile() error {
+	if ds.file == nil {
+		return nil
+	}
+	err := ds.file.close()
+	ds.file = nil
+	return err
+}
+
+// Flush writes buffered JSONL records to the file. Parquet records are only
+// written when the file is complete.
+func (ds *DatasetSink) Flush() error {
+	if ds.file == nil {
+		return nil
+	}
+	return ds.file.flush()
+}
+
+// Close completes the current file.
+func (ds *DatasetSink) Close() error {
+	return ds.closeFile()
+}
+
+type jsonlFile struct {
+	file *os.File
+	w *bufio.Writer
+	enc *json.Encoder
+}
+
+func newJSONLFile(path string) (*jsonlFile, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(file)
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return &jsonlFile{file: file, w: w, enc: enc}, nil
+}
+
+func (f *jsonlFile) write(r DatasetRecord) error {
+	return f.enc.Encode(r)
+}
+
+func (f *jsonlFile) flush() error {
+	return f.w.Flush()
+}
+
+func (f *jsonlFile) close() error {
+	if err := f.w.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+type parquetFile struct {
+	path string
+	file *os.File
+	w *parquet.GenericWriter[DatasetRecord]
+}
+
+// newParquetFile creates the hidden file a Parquet file at path is written to.
+func newParquetFile(path string) (*parquetFile, error) {
+	file, err := os.Create(hiddenPath(path))
+	if err != nil {
+		return nil, err
+	}
+	return &parquetFile{path: path, file: file, w: parquet.NewGenericWriter[DatasetRecord](file)}, nil
+}
+
+func hiddenPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".inprogress")
+}
+
+func (f *parquetFile) write(r DatasetRecord) error {
+	_, err := f.w.Write([]DatasetRecord{r})
+	return err
+}
+
+func (f *parquetFile) flush() error {
+	return nil
+}
+
+func (f *parquetFile) close() error {
+	if err := f.w.Close(); err != nil {
+		f.file.Close()
+		return err
+	}
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(hiddenPath(f.path), f.path)
+}
+
+// openDataset creates a DatasetSink from a spec like
+// "dataset:/data/logs?format=parquet&partition=day&max_rows=100000".
+func openDataset(spec string) (logs.Sink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	dir := u.Path
+	if len(u.Opaque) > 0 {
+		dir = u.Opaque
+	}
+	if len(dir) == 0 {
+		return nil, fmt.Errorf("invalid output %q: missing directory", spec)
+	}
+	q := u.Query()
+	options := DatasetOptions{
+		Format: queryOr(q, "format", "jsonl"),
+		Partition: queryOr(q, "partition", "hour"),
+	}
+	if v := q.Get("max_rows"); len(v) > 0 {
+		if options.MaxRows, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	ds, err := NewDatasetSink(dir, options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	return ds, nil
+}
@@ -0,0 +1,85 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+func writeDatasetEvents(t *testing.T, spec string) {
+	sink, err := Open(spec)
+	if err != nil {
+		t.Fatalf("Failed to open dataset sink: %s", err)
+	}
+	start := time.Date(2024, 3, 1, 11, 59, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		e := logs.LogEvent{
+			Time:         start.Add(time.Duration(i) * 30 * time.Second),
+			OriginalTime: start.Add(-time.Hour),
+			Line:         "line <" + string(rune('a'+i)) + ">",
+			Source:       "app.log",
+			LineNumber:   i + 1,
+		}
+		if err := sink.Write(context.Background(), e); err != nil {
+			t.Fatalf("Failed to write event: %s", err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %s", err)
+	}
+}
+
+func TestDatasetSink_JSONL(t *testing.T) {
+	dir := t.TempDir()
+	writeDatasetEvents(t, "dataset:"+dir+"?partition=hour")
+
+	files, _ := filepath.Glob(filepath.Join(dir, "date=2024-03-01", "hour=*", "*.jsonl"))
+	if len(files) != 2 || !strings.Contains(files[0], "hour=11") || !strings.Contains(files[1], "hour=12") {
+		t.Fatalf("Expected one file in each of two hour partitions, got %v", files)
+	}
+	b, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatalf("Failed to read file: %s", err)
+	}
+	expected := `{"time":"2024-03-01T11:59:00Z","original_time":"2024-03-01T10:59:00Z","line":"line <a>","source":"app.log","line_number":1}
+{"time":"2024-03-01T11:59:30Z","original_time":"2024-03-01T10:59:00Z","line":"line <b>","source":"app.log","line_number":2}
+`
+	if string(b) != expected {
+		t.Errorf("Expected:\n%s\nGot:\n%s", expected, b)
+	}
+}
+
+func TestDatasetSink_Parquet(t *testing.T) {
+	dir := t.TempDir()
+	writeDatasetEvents(t, "dataset:"+dir+"?format=parquet&partition=day&max_rows=2")
+
+	partition := filepath.Join(dir, "date=2024-03-01")
+	files, _ := filepath.Glob(filepath.Join(partition, "*.parquet"))
+	if len(files) != 3 {
+		t.Fatalf("Expected 3 files, got %v", files)
+	}
+	if hidden, _ := filepath.Glob(filepath.Join(partition, ".*")); len(hidden) != 0 {
+		t.Errorf("Expected no in-progress files, got %v", hidden)
+	}
+	var records []DatasetRecord
+	for _, f := range files {
+		rs, err := parquet.ReadFile[DatasetRecord](f)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %s", f, err)
+		}
+		records = append(records, rs...)
+	}
+	if len(records) != 5 || records[4].Line != "line <e>" || records[4].LineNumber != 5 || records[4].Source != "app.log" {
+		t.Errorf("Unexpected records: %+v", records)
+	}
+
+	if _, err := Open("dataset:" + dir + "?format=csv"); err == nil {
+		t.Errorf("Expected an error for an unsupported format")
+	}
+}
@@ -0,0 +1,59 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DockerJSONSink wraps every line in the json-file log format of Docker, e.g.
+// {"log":"line\n","stream":"stdout","time":"2024-01-01T00:00:00.123456789Z"},
+// and writes it to another sink. Collectors parsing container logs can be
+// tested by pointing them at a file written this way.
+type DockerJSONSink struct {
+	sink logs.Sink
+	stream string
+}
+
+// dockerJSONLine is a line in the json-file format.
+type dockerJSONLine struct {
+	Log string `json:"log"`
+	Stream string `json:"stream"`
+	Time string `json:"time"`
+}
+
+// NewDockerJSONSink creates a DockerJSONSink writing to sink. Stream is the
+// stream the lines are attributed to, "stdout" or "stderr".
+func NewDockerJSONSink(sink logs.Sink, stream string) (*DockerJSONSink, error) {
+	if stream != "stdout" && stream != "stderr" {
+		return nil, fmt.Errorf("invalid stream: %s, must be stdout or stderr", stream)
+	}
+	return &DockerJSONSink{sink: sink, stream: stream}, nil
+}
+
+// Write wraps the line of the event and writes it to the underlying sink. The
+// time of the entry is the time the line was mapped to.
+func (s *DockerJSONSink) Write(ctx context.Context, e logs.LogEvent) error {
+	b, err := json.Marshal(dockerJSONLine{
+		Log: e.Line + "\n",
+		Stream: s.stream,
+		Time: e.Time.UTC().Format(time.RFC3339Nano),
+	})
+	if err != nil {
+		return err
+	}
+	e.Line = string(b)
+	return s.sink.Write(ctx, e)
+}
+
+// Flush flushes the underlying sink.
+func (s *DockerJSONSink) Flush() error {
+	return s.sink.Flush()
+}
+
+// Close closes the underlying sink.
+func (s *DockerJSONSink) Close() error {
+	return s.sink.Close()
+}
@@ -0,0 +1,41 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDockerJSONSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "container-json.log")
+	sink, err := Open("file:" + path + "?format=docker&stream=stderr")
+	if err != nil {
+		t.Fatalf("Failed to open sink: %s", err)
+	}
+	e := logs.LogEvent{
+		Line: `level=error msg="<failed>"`,
+		Time: time.Date(2024, 1, 2, 3, 4, 5, 123456789, time.FixedZone("CET", 3600)),
+	}
+	if err := sink.Write(context.Background(), e); err != nil {
+		t.Fatalf("Failed to write line: %s", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close sink: %s", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read output: %s", err)
+	}
+	// Like Docker, HTML characters are escaped
+	expected := `{"log":"level=error msg=\"\u003cfailed\u003e\"\n","stream":"stderr","time":"2024-01-02T02:04:05.123456789Z"}` + "\n"
+	if string(content) != expected {
+		t.Errorf("Expected %s, got %s", expected, content)
+	}
+	if _, err := Open("stdout?format=xml"); err == nil {
+		t.Error("Expected error for unknown format")
+	}
+}
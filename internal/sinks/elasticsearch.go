@@ -0,0 +1,253 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ElasticsearchOptions configures an ElasticsearchSink.
+type ElasticsearchOptions struct {
+	// Index is the name of the index the lines are written to. Go time
+	// layouts in braces are replaced by the UTC time of the line, e.g.
+	// "logs-{2006.01.02}" writes to daily indices.
+	Index string
+	// TimestampField is the field holding the time of the line.
+	TimestampField string
+	// OpType is the bulk action, "index" or "create". Data streams require
+	// "create".
+	OpType string
+	// BatchSize is the number of lines after which they are sent.
+	BatchSize int
+	// FlushInterval is the maximum time lines are buffered.
+	FlushInterval time.Duration
+	// Username and Password are used for basic authentication if set.
+	Username string
+	Password string
+	HTTP HTTPOptions
+}
+
+// ElasticsearchSink indexes the lines in Elasticsearch or OpenSearch using
+// the bulk API. Each line becomes a document with the fields "message",
+// "source" and the timestamp field.
+type ElasticsearchSink struct {
+	url string
+	options ElasticsearchOptions
+	index []indexPart
+	client *http.Client
+	batch *batcher
+	body bytes.Buffer // buffered bulk request
+}
+
+// indexPart is a literal part or a time layout of an index name pattern.
+type indexPart struct {
+	text string
+	layout bool
+}
+
+// bulkResponse is the part of the bulk API response used to report errors.
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items []map[string]struct {
+		Status int `json:"status"`
+		Error struct {
+			Type string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// NewElasticsearchSink creates an ElasticsearchSink sending to the bulk API
+// at the given URL.
+func NewElasticsearchSink(bulkURL string, options ElasticsearchOptions) (*ElasticsearchSink, error) {
+	if options.OpType != "index" && options.OpType != "create" {
+		return nil, fmt.Errorf("invalid op type: %s, must be index or create", options.OpType)
+	}
+	if len(options.TimestampField) == 0 {
+		return nil, fmt.Errorf("timestamp field must not be empty")
+	}
+	if options.BatchSize <= 0 {
+		return nil, fmt.Errorf("invalid batch size: %d, must be positive", options.BatchSize)
+	}
+	if options.FlushInterval <= 0 {
+		return nil, fmt.Errorf("invalid flush interval: %s, must be positive", options.FlushInterval)
+	}
+	index, err := parseIndexPattern(options.Index)
+	if err != nil {
+		return nil, err
+	}
+	client, err := options.HTTP.Client()
+	if err != nil {
+		return nil, err
+	}
+	s := &ElasticsearchSink{url: bulkURL, options: options, index: index, client: client}
+	s.batch = newBatcher(options.BatchSize, options.FlushInterval, s.push)
+	return s, nil
+}
+
+// parseIndexPattern splits an index name pattern into literal parts and time
+// layouts in braces.
+func parseIndexPattern(pattern string) ([]indexPart, error) {
+	var parts []indexPart
+	rest := pattern
+	for len(rest) > 0 {
+		open := strings.IndexByte(rest, '{')
+		if open < 0 {
+			parts = append(parts, indexPart{text: rest})
+			break
+		}
+		end := strings.IndexByte(rest[open:], '}')
+		if end < 0 {
+			return nil, fmt.Errorf("invalid index pattern: %s, missing }", pattern)
+		}
+		if open > 0 {
+			parts = append(parts, indexPart{text: rest[:open]})
+		}
+		parts = append(parts, indexPart{text: rest[open+1 : open+end], layout: true})
+		rest = rest[open+end+1:]
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("index must not be empty")
+	}
+	return parts, nil
+}
+
+// indexName returns the name of the index for a line logged at t.
+func (s *ElasticsearchSink) indexName(t time.Time) string {
+	var b strings.Builder
+	for _, p := range s.index {
+		if p.layout {
+			b.WriteString(t.UTC().Format(p.text))
+		} else {
+			b.WriteString(p.text)
+		}
+	}
+	return b.String()
+}
+
+// Write buffers the line of the event as bulk action and sends the buffered
+// lines once the batch is full.
+func (s *ElasticsearchSink) Write(_ context.Context, e logs.LogEvent) error {
+	action, err := json.Marshal(map[string]map[string]string{s.options.OpType: {"_index": s.indexName(e.Time)}})
+	if err != nil {
+		return err
+	}
+	doc, err := json.Marshal(map[string]string{
+		s.options.TimestampField: e.Time.Format(time.RFC3339Nano),
+		"message": e.Line,
+		"source": e.Source,
+	})
+	if err != nil {
+		return err
+	}
+	return s.batch.add(func() {
+		s.body.Write(action)
+		s.body.WriteByte('\n')
+		s.body.Write(doc)
+		s.body.WriteByte('\n')
+	})
+}
+
+// Flush sends the buffered lines if the flush interval has passed since the
+// last request.
+func (s *ElasticsearchSink) Flush() error {
+	return s.batch.flush()
+}
+
+// push sends the buffered lines in a bulk request.
+func (s *ElasticsearchSink) push() error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(bytes.Clone(s.body.Bytes())))
+	s.body.Reset()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if len(s.options.Username) > 0 {
+		req.SetBasicAuth(s.options.Username, s.options.Password)
+	}
+	body, err := s.options.HTTP.send(s.client, req)
+	if err != nil {
+		return fmt.Errorf("failed to send bulk request: %w", err)
+	}
+	var resp bulkResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return fmt.Errorf("invalid bulk response: %w", err)
+	}
+	if !resp.Errors {
+		return nil
+	}
+	// Report the first failed document, the others usually fail the same way
+	failed := 0
+	var reason string
+	for _, item := range resp.Items {
+		for _, result := range item {
+			if result.Status/100 != 2 {
+				failed++
+				if len(reason) == 0 {
+					reason = result.Error.Type + ": " + result.Error.Reason
+				}
+			}
+		}
+	}
+	return fmt.Errorf("failed to index %d of %d lines, e.g. %s", failed, len(resp.Items), reason)
+}
+
+// Close sends the remaining lines.
+func (s *ElasticsearchSink) Close() error {
+	return s.batch.close()
+}
+
+// openElasticsearch creates an ElasticsearchSink from a spec like
+// "es+https://user:pass@es:9200?index=logs-{2006.01.02}&timestamp_field=@timestamp".
+// The bulk path is added if the URL has no path.
+func openElasticsearch(spec string) (logs.Sink, error) {
+	_, rest, _ := strings.Cut(spec, "+")
+	u, err := url.Parse(rest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	q := u.Query()
+	httpOptions, err := parseHTTPOptions(q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	options := ElasticsearchOptions{
+		Index: queryOr(q, "index", "bananabacon-{2006.01.02}"),
+		TimestampField: queryOr(q, "timestamp_field", "@timestamp"),
+		OpType: queryOr(q, "op_type", "index"),
+		BatchSize: 500,
+		FlushInterval: time.Second,
+		HTTP: httpOptions,
+	}
+	if u.User != nil {
+		options.Username = u.User.Username()
+		options.Password, _ = u.User.Password()
+		u.User = nil
+	}
+	if v := q.Get("batch_size"); len(v) > 0 {
+		if options.BatchSize, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	if v := q.Get("flush_interval"); len(v) > 0 {
+		if options.FlushInterval, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	if len(u.Path) == 0 || u.Path == "/" {
+		u.Path = "/_bulk"
+	}
+	u.RawQuery = ""
+	s, err := NewElasticsearchSink(u.String(), options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,84 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestElasticsearchSink(t *testing.T) {
+	var body, auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		user, pass, _ := r.BasicAuth()
+		auth = user + ":" + pass
+		w.Write([]byte(`{"errors":false,"items":[]}`))
+	}))
+	defer server.Close()
+
+	spec := strings.Replace(server.URL, "http://", "es+http://elastic:secret@", 1) + "?index=logs-{2006.01.02}&op_type=create"
+	sink, err := Open(spec)
+	if err != nil {
+		t.Fatalf("Failed to open Elasticsearch sink: %s", err)
+	}
+	e := logs.LogEvent{Time: time.Date(2024, 1, 5, 23, 30, 0, 0, time.FixedZone("", -3600)), Line: `GET "/"`, Source: "web.log"}
+	if err := sink.Write(context.Background(), e); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+
+	// The index uses the UTC date of the line
+	expected := `{"create":{"_index":"logs-2024.01.06"}}` + "\n" +
+		`{"@timestamp":"2024-01-05T23:30:00-01:00","message":"GET \"/\"","source":"web.log"}` + "\n"
+	if body != expected {
+		t.Errorf("Unexpected bulk request\n%s, expected\n%s", body, expected)
+	}
+	if auth != "elastic:secret" {
+		t.Errorf("Expected basic authentication, got %q", auth)
+	}
+}
+
+func TestElasticsearchSink_Errors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"errors":true,"items":[{"index":{"status":201}},` +
+			`{"index":{"status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}]}`))
+	}))
+	defer server.Close()
+
+	sink, err := NewElasticsearchSink(server.URL, ElasticsearchOptions{
+		Index: "logs", TimestampField: "@timestamp", OpType: "index", BatchSize: 2, FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create Elasticsearch sink: %s", err)
+	}
+	defer sink.Close()
+	sink.Write(context.Background(), logs.LogEvent{Line: "ok"})
+	err = sink.Write(context.Background(), logs.LogEvent{Line: "broken"})
+	if err == nil || !strings.Contains(err.Error(), "1 of 2") || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+		t.Errorf("Expected the failed document to be reported, got %v", err)
+	}
+}
+
+func TestParseIndexPattern(t *testing.T) {
+	if _, err := parseIndexPattern("logs-{2006"); err == nil {
+		t.Error("Expected an error for an unclosed layout")
+	}
+	parts, err := parseIndexPattern("{2006}-logs-{01}")
+	if err != nil {
+		t.Fatalf("Failed to parse pattern: %s", err)
+	}
+	if len(parts) != 3 || !parts[0].layout || parts[1].text != "-logs-" || parts[2].text != "01" {
+		t.Errorf("Unexpected parts %+v", parts)
+	}
+}
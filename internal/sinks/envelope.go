@@ -0,0 +1,136 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// EnvelopeOptions configures an EnvelopeSink.
+type EnvelopeOptions struct {
+	// Format is the format of the envelope, "json" or "logfmt".
+	Format string
+	// Fields are static fields added to every line, e.g. the service and
+	// environment, in order.
+	Fields []EnvelopeField
+	// TimeField is the name of the field holding the rewritten timestamp of
+	// the line in RFC 3339 format.
+	TimeField string
+	// MessageField is the name of the field holding the line.
+	MessageField string
+}
+
+// EnvelopeField is a static field of an EnvelopeSink.
+type EnvelopeField struct {
+	Name string
+	Value string
+}
+
+// EnvelopeSink wraps every line in a JSON object or a logfmt line with the
+// time of the line and static fields, e.g.
+// {"time":"2024-01-01T00:00:00Z","service":"api","message":"GET /"}, and
+// writes it to another sink. Replays of plain-text logs can so be consumed by
+// parsers expecting structured logs.
+type EnvelopeSink struct {
+	sink logs.Sink
+	options EnvelopeOptions
+}
+
+// NewEnvelopeSink creates an EnvelopeSink writing to sink.
+func NewEnvelopeSink(sink logs.Sink, options EnvelopeOptions) (*EnvelopeSink, error) {
+	if options.Format != "json" && options.Format != "logfmt" {
+		return nil, fmt.Errorf("invalid envelope format: %s, must be json or logfmt", options.Format)
+	}
+	if len(options.TimeField) == 0 || len(options.MessageField) == 0 {
+		return nil, fmt.Errorf("invalid envelope: time and message field must not be empty")
+	}
+	return &EnvelopeSink{sink: sink, options: options}, nil
+}
+
+// Write wraps the line of the event and writes it to the underlying sink.
+func (s *EnvelopeSink) Write(ctx context.Context, e logs.LogEvent) error {
+	t := e.Time.Format(time.RFC3339Nano)
+	var b []byte
+	if s.options.Format == "json" {
+		b = append(b, '{')
+		b = appendJSONField(b, s.options.TimeField, t)
+		for _, f := range s.options.Fields {
+			b = append(b, ',')
+			b = appendJSONField(b, f.Name, f.Value)
+		}
+		b = append(b, ',')
+		b = appendJSONField(b, s.options.MessageField, e.Line)
+		b = append(b, '}')
+	} else {
+		b = appendLogfmtField(b, s.options.TimeField, t)
+		for _, f := range s.options.Fields {
+			b = append(b, ' ')
+			b = appendLogfmtField(b, f.Name, f.Value)
+		}
+		b = append(b, ' ')
+		b = appendLogfmtField(b, s.options.MessageField, e.Line)
+	}
+	e.Line = string(b)
+	return s.sink.Write(ctx, e)
+}
+
+// Flush flushes the underlying sink.
+func (s *EnvelopeSink) Flush() error {
+	return s.sink.Flush()
+}
+
+// Close closes the underlying sink.
+func (s *EnvelopeSink) Close() error {
+	return s.sink.Close()
+}
+
+// appendJSONField appends "key":"value" to b.
+func appendJSONField(b []byte, key, value string) []byte {
+	// Marshaling a string cannot fail
+	k, _ := json.Marshal(key)
+	v, _ := json.Marshal(value)
+	b = append(b, k...)
+	b = append(b, ':')
+	return append(b, v...)
+}
+
+// appendLogfmtField appends key=value to b, quoting the value if it is empty
+// or contains spaces, quotes, equal signs or control characters.
+func appendLogfmtField(b []byte, key, value string) []byte {
+	b = append(b, key...)
+	b = append(b, '=')
+	if len(value) > 0 && strings.IndexFunc(value, func(r rune) bool {
+		return r == ' ' || r == '"' || r == '=' || unicode.IsControl(r) || unicode.IsSpace(r)
+	}) < 0 {
+		return append(b, value...)
+	}
+	return strconv.AppendQuote(b, value)
+}
+
+// parseEnvelopeOptions parses the options of an EnvelopeSink in the given
+// format from the query of an output spec:
+//
+// - "field": a static field like "service:api", can be repeated
+// - "time_field": the name of the time field, "time" if empty
+// - "message_field": the name of the message field, "message" if empty
+func parseEnvelopeOptions(format string, q url.Values) (EnvelopeOptions, error) {
+	o := EnvelopeOptions{
+		Format: format,
+		TimeField: queryOr(q, "time_field", "time"),
+		MessageField: queryOr(q, "message_field", "message"),
+	}
+	for _, f := range q["field"] {
+		name, value, ok := strings.Cut(f, ":")
+		if !ok || len(strings.TrimSpace(name)) == 0 {
+			return o, fmt.Errorf("invalid field: %s, must be like \"name:value\"", f)
+		}
+		o.Fields = append(o.Fields, EnvelopeField{Name: strings.TrimSpace(name), Value: strings.TrimSpace(value)})
+	}
+	return o, nil
+}
@@ -0,0 +1,48 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnvelopeSink(t *testing.T) {
+	e := logs.LogEvent{
+		Line: `GET /index.html "200"`,
+		Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	tests := []struct {
+		format   string
+		expected string
+	}{
+		{"json", `{"ts":"2024-01-02T03:04:05Z","service":"api","env":"dev","message":"GET /index.html \"200\""}`},
+		{"logfmt", `ts=2024-01-02T03:04:05Z service=api env=dev message="GET /index.html \"200\""`},
+	}
+	for _, test := range tests {
+		var out strings.Builder
+		options, err := parseEnvelopeOptions(test.format, map[string][]string{
+			"time_field": {"ts"},
+			"field":      {"service:api", "env: dev"},
+		})
+		if err != nil {
+			t.Fatalf("Failed to parse options: %s", err)
+		}
+		sink, err := NewEnvelopeSink(NewWriterSink(&out), options)
+		if err != nil {
+			t.Fatalf("Failed to create sink: %s", err)
+		}
+		if err := sink.Write(context.Background(), e); err != nil {
+			t.Fatalf("Failed to write line: %s", err)
+		}
+		sink.Close()
+		if out.String() != test.expected+"\n" {
+			t.Errorf("Expected %s, got %s", test.expected, out.String())
+		}
+	}
+
+	if _, err := Open("stdout?format=json&field=service"); err == nil {
+		t.Error("Expected error for field without value")
+	}
+}
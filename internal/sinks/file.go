@@ -0,0 +1,235 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// RotatedFileTimeFormat is the format of the timestamp appended to the name
+	// of rotated files.
+	RotatedFileTimeFormat = "20060102-150405.000"
+)
+
+// FileOptions configures the rotation of a FileSink.
+type FileOptions struct {
+	// MaxSize rotates the file once writing a line would exceed the given
+	// number of bytes. Zero means no limit.
+	MaxSize int64
+	// MaxAge rotates the file once it has been written to for the given
+	// duration. Zero means no limit.
+	MaxAge time.Duration
+	// MaxBackups is the number of rotated files that are kept, older ones are
+	// removed. Zero keeps all rotated files.
+	MaxBackups int
+	// Compress gzips rotated files.
+	Compress bool
+}
+
+// FileSink writes the lines of the events to a file and rotates it by size or
+// age. Rotated files are renamed to the file name followed by the time of the
+// rotation, like logrotate does, so collectors tailing the file pick up the
+// new file.
+type FileSink struct {
+	path string
+	options FileOptions
+	file *os.File
+	w *bufio.Writer
+	size int64
+	opened time.Time
+	compressing sync.WaitGroup
+	backupsMu sync.Mutex // serializes compressing and removing rotated files
+}
+
+// NewFileSink opens the file at the given path for appending, creating it and
+// its directory if necessary.
+func NewFileSink(path string, options FileOptions) (*FileSink, error) {
+	if options.MaxSize < 0 || options.MaxAge < 0 || options.MaxBackups < 0 {
+		return nil, fmt.Errorf("invalid rotation of %s: limits must not be negative", path)
+	}
+	fs := &FileSink{path: path, options: options}
+	if err := fs.open(); err != nil {
+		return nil, err
+	}
+	return fs, nil
+}
+
+// open opens the file and determines its current size.
+func (fs *FileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(fs.path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(fs.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	fs.file = file
+	fs.w = bufio.NewWriter(file)
+	fs.size = info.Size()
+	fs.opened = time.Now()
+	return nil
+}
+
+// Write appends the line of the event to the file, rotating it first if
+// necessary.
+func (fs *FileSink) Write(_ context.Context, e logs.LogEvent) error {
+	n := int64(len(e.Line) + 1)
+	if fs.size > 0 && ((fs.options.MaxSize > 0 && fs.size+n > fs.options.MaxSize) ||
+		(fs.options.MaxAge > 0 && time.Since(fs.opened) >= fs.options.MaxAge)) {
+		if err := fs.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := fs.w.WriteString(e.Line); err != nil {
+		return err
+	}
+	if err := fs.w.WriteByte('\n'); err != nil {
+		return err
+	}
+	fs.size += n
+	return nil
+}
+
+// rotate closes the file, renames it and opens a new one.
+func (fs *FileSink) rotate() error {
+	if err := fs.closeFile(); err != nil {
+		return err
+	}
+	rotated := fs.path + "." + time.Now().Format(RotatedFileTimeFormat)
+	if err := os.Rename(fs.path, rotated); err != nil {
+		return err
+	}
+	if fs.options.Compress {
+		// Compress in the background, so the replay is not delayed
+		fs.compressing.Add(1)
+		go func() {
+			defer fs.compressing.Done()
+			fs.backupsMu.Lock()
+			defer fs.backupsMu.Unlock()
+			if err := compressFile(rotated); err != nil {
+				log.Printf("Failed to compress %s: %v", rotated, err)
+			}
+			fs.removeBackups()
+		}()
+	} else {
+		fs.removeBackups()
+	}
+	return fs.open()
+}
+
+// removeBackups removes the oldest rotated files if there are more than
+// MaxBackups.
+func (fs *FileSink) removeBackups() {
+	if fs.options.MaxBackups == 0 {
+		return
+	}
+	backups, err := filepath.Glob(fs.path + ".[0-9]*")
+	if err != nil {
+		return
+	}
+	// A rotated file and its compressed version count once
+	for i := range backups {
+		backups[i] = strings.TrimSuffix(backups[i], ".gz")
+	}
+	// The timestamp suffix sorts chronologically
+	slices.Sort(backups)
+	backups = slices.Compact(backups)
+	for _, b := range backups[:max(len(backups)-fs.options.MaxBackups, 0)] {
+		os.Remove(b)
+		os.Remove(b + ".gz")
+	}
+}
+
+// compressFile gzips the given file and removes the original.
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// Flush writes the buffered lines to the file.
+func (fs *FileSink) Flush() error {
+	return fs.w.Flush()
+}
+
+// closeFile flushes and closes the current file.
+func (fs *FileSink) closeFile() error {
+	if err := fs.w.Flush(); err != nil {
+		fs.file.Close()
+		return err
+	}
+	return fs.file.Close()
+}
+
+// Close flushes and closes the file and waits for rotated files to be
+// compressed.
+func (fs *FileSink) Close() error {
+	err := fs.closeFile()
+	fs.compressing.Wait()
+	return err
+}
+
+// ParseSize parses a size in bytes with an optional unit, e.g. "10MB".
+func ParseSize(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		factor int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+	s = strings.ToUpper(strings.TrimSpace(s))
+	factor := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			factor = u.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", s)
+	}
+	return n * factor, nil
+}
@@ -0,0 +1,65 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileSink_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "logs", "app.log")
+	sink, err := Open("file:" + path + "?max_size=20B&max_backups=2&compress=true")
+	if err != nil {
+		t.Fatalf("Failed to open file sink: %s", err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := sink.Write(context.Background(), logs.LogEvent{Line: "0123456789abcdef"}); err != nil {
+			t.Fatalf("Failed to write line: %s", err)
+		}
+		// Rotated files are named by time with millisecond precision
+		time.Sleep(2 * time.Millisecond)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close file sink: %s", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != "0123456789abcdef\n" {
+		t.Errorf("Expected one line in current file, got %q, err: %v", content, err)
+	}
+	rotated, _ := filepath.Glob(path + ".*.gz")
+	if len(rotated) != 2 {
+		t.Fatalf("Expected 2 compressed backups, got %v", rotated)
+	}
+	f, err := os.Open(rotated[0])
+	if err != nil {
+		t.Fatalf("Failed to open backup: %s", err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Failed to read backup: %s", err)
+	}
+	content, _ = io.ReadAll(zr)
+	if strings.Count(string(content), "\n") != 1 {
+		t.Errorf("Expected one line per backup, got %q", content)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for s, expected := range map[string]int64{"100": 100, "20B": 20, "10KB": 10240, "2mb": 2 << 20, "1GB": 1 << 30} {
+		if n, err := ParseSize(s); err != nil || n != expected {
+			t.Errorf("Expected %s to be %d bytes, got %d, err: %v", s, expected, n, err)
+		}
+	}
+	if _, err := ParseSize("ten"); err == nil {
+		t.Error("Expected error for invalid size")
+	}
+}
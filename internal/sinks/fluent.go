@@ -0,0 +1,333 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// FluentOptions configures a FluentSink.
+type FluentOptions struct {
+	// Tag is the tag of all events.
+	Tag string
+	// BatchSize is the number of lines after which they are sent without
+	// waiting for a flush.
+	BatchSize int
+	// Ack requests an acknowledgement for every batch. A batch that is not
+	// acknowledged within AckTimeout is sent again.
+	Ack bool
+	AckTimeout time.Duration
+}
+
+// fluentAttempts is the number of times a batch is sent before giving up.
+const fluentAttempts = 3
+
+// FluentSink sends the lines to Fluentd or Fluent Bit using the forward
+// protocol. The lines are buffered and sent as a single message in forward
+// mode, with records containing the line as "message" and the input file as
+// "source".
+type FluentSink struct {
+	addr string
+	options FluentOptions
+	conn net.Conn
+	reader *bufio.Reader
+	entries []byte // encoded entries of the current batch
+	count int // number of entries in the current batch
+	msg []byte // encoded message, reused between batches
+}
+
+// NewFluentSink creates a FluentSink sending to the forward input at addr.
+// The connection is established on the first batch.
+func NewFluentSink(addr string, options FluentOptions) (*FluentSink, error) {
+	if len(options.Tag) == 0 {
+		return nil, errors.New("tag must not be empty")
+	}
+	if options.BatchSize <= 0 {
+		return nil, fmt.Errorf("invalid batch size: %d, must be positive", options.BatchSize)
+	}
+	if options.Ack && options.AckTimeout <= 0 {
+		return nil, fmt.Errorf("invalid ack timeout: %s, must be positive", options.AckTimeout)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+	return &FluentSink{addr: addr, options: options}, nil
+}
+
+// Write buffers the line of the event and sends the buffered lines once the
+// batch is full.
+func (s *FluentSink) Write(_ context.Context, e logs.LogEvent) error {
+	// [time, {"message": line, "source": source}]
+	b := appendMsgpackArrayHeader(s.entries, 2)
+	b = appendMsgpackEventTime(b, e.Time)
+	b = appendMsgpackMapHeader(b, 2)
+	b = appendMsgpackString(b, "message")
+	b = appendMsgpackString(b, e.Line)
+	b = appendMsgpackString(b, "source")
+	b = appendMsgpackString(b, e.Source)
+	s.entries = b
+	s.count++
+	if s.count >= s.options.BatchSize {
+		return s.send()
+	}
+	return nil
+}
+
+// send sends the buffered lines in forward mode and waits for the
+// acknowledgement if requested. Failed attempts are retried on a new
+// connection.
+func (s *FluentSink) send() error {
+	if s.count == 0 {
+		return nil
+	}
+	var chunk string
+	b := appendMsgpackArrayHeader(s.msg[:0], 3)
+	b = appendMsgpackString(b, s.options.Tag)
+	b = appendMsgpackArrayHeader(b, s.count)
+	b = append(b, s.entries...)
+	if s.options.Ack {
+		id := make([]byte, 16)
+		rand.Read(id)
+		chunk = base64.StdEncoding.EncodeToString(id)
+		b = appendMsgpackMapHeader(b, 2)
+		b = appendMsgpackString(b, "size")
+		b = appendMsgpackUint(b, uint64(s.count))
+		b = appendMsgpackString(b, "chunk")
+		b = appendMsgpackString(b, chunk)
+	} else {
+		b = appendMsgpackMapHeader(b, 1)
+		b = appendMsgpackString(b, "size")
+		b = appendMsgpackUint(b, uint64(s.count))
+	}
+	s.msg = b
+
+	var err error
+	for attempt := 0; attempt < fluentAttempts; attempt++ {
+		if err = s.sendOnce(chunk); err == nil {
+			s.entries = s.entries[:0]
+			s.count = 0
+			return nil
+		}
+		s.closeConn()
+	}
+	return fmt.Errorf("failed to send to %s: %w", s.addr, err)
+}
+
+// sendOnce writes the encoded message and waits for the acknowledgement of
+// the chunk, if not empty.
+func (s *FluentSink) sendOnce(chunk string) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout("tcp", s.addr, 10*time.Second)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+		s.reader = bufio.NewReader(conn)
+	}
+	if _, err := s.conn.Write(s.msg); err != nil {
+		return err
+	}
+	if len(chunk) == 0 {
+		return nil
+	}
+	s.conn.SetReadDeadline(time.Now().Add(s.options.AckTimeout))
+	defer s.conn.SetReadDeadline(time.Time{})
+	ack, err := readFluentAck(s.reader)
+	if err != nil {
+		return err
+	}
+	if ack != chunk {
+		return fmt.Errorf("unexpected ack %q for chunk %q", ack, chunk)
+	}
+	return nil
+}
+
+// closeConn closes the connection, so the next batch reconnects.
+func (s *FluentSink) closeConn() {
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// Flush sends the buffered lines.
+func (s *FluentSink) Flush() error {
+	return s.send()
+}
+
+// Close sends the remaining lines and closes the connection.
+func (s *FluentSink) Close() error {
+	err := s.send()
+	s.closeConn()
+	return err
+}
+
+// openFluent creates a FluentSink from a spec like
+// "fluent://fluentd:24224?tag=app.logs&ack=true&batch_size=100".
+func openFluent(spec string) (logs.Sink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	q := u.Query()
+	options := FluentOptions{
+		Tag: queryOr(q, "tag", "bananabacon"),
+		BatchSize: 100,
+		Ack: q.Get("ack") == "true",
+		AckTimeout: 10 * time.Second,
+	}
+	if v := q.Get("batch_size"); len(v) > 0 {
+		if options.BatchSize, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	if v := q.Get("ack_timeout"); len(v) > 0 {
+		if options.AckTimeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	s, err := NewFluentSink(u.Host, options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	return s, nil
+}
+
+// The following functions encode the subset of MessagePack used by the
+// forward protocol.
+
+func appendMsgpackArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x90|byte(n))
+	case n < 1<<16:
+		return binary.BigEndian.AppendUint16(append(b, 0xdc), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdd), uint32(n))
+}
+
+func appendMsgpackMapHeader(b []byte, n int) []byte {
+	switch {
+	case n < 16:
+		return append(b, 0x80|byte(n))
+	case n < 1<<16:
+		return binary.BigEndian.AppendUint16(append(b, 0xde), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, 0xdf), uint32(n))
+}
+
+func appendMsgpackString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n < 1<<8:
+		b = append(b, 0xd9, byte(n))
+	case n < 1<<16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+func appendMsgpackUint(b []byte, n uint64) []byte {
+	switch {
+	case n < 128:
+		return append(b, byte(n))
+	case n < 1<<32:
+		return binary.BigEndian.AppendUint32(append(b, 0xce), uint32(n))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xcf), n)
+}
+
+// appendMsgpackEventTime appends t as the EventTime extension of the forward
+// protocol, which has nanosecond precision.
+func appendMsgpackEventTime(b []byte, t time.Time) []byte {
+	b = append(b, 0xd7, 0x00)
+	b = binary.BigEndian.AppendUint32(b, uint32(t.Unix()))
+	return binary.BigEndian.AppendUint32(b, uint32(t.Nanosecond()))
+}
+
+// readFluentAck reads a response like {"ack": "<chunk>"} and returns the
+// chunk.
+func readFluentAck(r *bufio.Reader) (string, error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	var n int
+	switch {
+	case h&0xf0 == 0x80:
+		n = int(h & 0x0f)
+	case h == 0xde:
+		var v uint16
+		err = binary.Read(r, binary.BigEndian, &v)
+		n = int(v)
+	default:
+		return "", fmt.Errorf("unexpected response type 0x%x", h)
+	}
+	if err != nil {
+		return "", err
+	}
+	var ack string
+	for i := 0; i < n; i++ {
+		key, err := readMsgpackString(r)
+		if err != nil {
+			return "", err
+		}
+		value, err := readMsgpackString(r)
+		if err != nil {
+			return "", err
+		}
+		if key == "ack" {
+			ack = value
+		}
+	}
+	return ack, nil
+}
+
+// readMsgpackString reads a MessagePack string.
+func readMsgpackString(r *bufio.Reader) (string, error) {
+	h, err := r.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	var n int
+	switch {
+	case h&0xe0 == 0xa0:
+		n = int(h & 0x1f)
+	case h == 0xd9:
+		var v uint8
+		err = binary.Read(r, binary.BigEndian, &v)
+		n = int(v)
+	case h == 0xda:
+		var v uint16
+		err = binary.Read(r, binary.BigEndian, &v)
+		n = int(v)
+	case h == 0xdb:
+		var v uint32
+		err = binary.Read(r, binary.BigEndian, &v)
+		n = int(v)
+	default:
+		return "", fmt.Errorf("unexpected string type 0x%x", h)
+	}
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,88 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFluentSink(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer ln.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		received <- b
+	}()
+
+	sink, err := Open("fluent://" + ln.Addr().String() + "?tag=app")
+	if err != nil {
+		t.Fatalf("Failed to open fluent sink: %s", err)
+	}
+	e := logs.LogEvent{Time: time.Unix(0x01020304, 5), Line: "hi", Source: "a.log"}
+	if err := sink.Write(context.Background(), e); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+
+	// ["app", [[EventTime, {"message": "hi", "source": "a.log"}]], {"size": 1}]
+	expected := []byte{0x93, 0xa3, 'a', 'p', 'p', 0x91, 0x92,
+		0xd7, 0x00, 0x01, 0x02, 0x03, 0x04, 0x00, 0x00, 0x00, 0x05,
+		0x82, 0xa7, 'm', 'e', 's', 's', 'a', 'g', 'e', 0xa2, 'h', 'i',
+		0xa6, 's', 'o', 'u', 'r', 'c', 'e', 0xa5, 'a', '.', 'l', 'o', 'g',
+		0x81, 0xa4, 's', 'i', 'z', 'e', 0x01}
+	if b := <-received; !bytes.Equal(b, expected) {
+		t.Errorf("Unexpected message\n%x, expected\n%x", b, expected)
+	}
+}
+
+func TestFluentSink_Ack(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 4096)
+		n, _ := conn.Read(buf)
+		// The chunk is a 24 character string following the "chunk" key
+		i := bytes.Index(buf[:n], []byte("chunk")) + len("chunk")
+		chunk := buf[i : i+25]
+		resp := append([]byte{0x81, 0xa3, 'a', 'c', 'k'}, chunk...)
+		conn.Write(resp)
+		io.Copy(io.Discard, conn)
+	}()
+
+	sink, err := NewFluentSink(ln.Addr().String(), FluentOptions{Tag: "app", BatchSize: 2, Ack: true, AckTimeout: time.Second})
+	if err != nil {
+		t.Fatalf("Failed to create fluent sink: %s", err)
+	}
+	defer sink.Close()
+	for _, line := range []string{"first", "second"} {
+		if err := sink.Write(context.Background(), logs.LogEvent{Line: line}); err != nil {
+			t.Fatalf("Failed to write acknowledged batch: %s", err)
+		}
+	}
+	if sink.count != 0 {
+		t.Errorf("Expected the batch to be sent, %d lines are buffered", sink.count)
+	}
+}
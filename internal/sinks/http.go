@@ -0,0 +1,190 @@
+package sinks
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// TLSOptions configures the TLS connections of the sinks.
+type TLSOptions struct {
+	// CAFile is a PEM bundle of CAs trusted in addition to the system ones.
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and its key.
+	CertFile string
+	KeyFile string
+	InsecureSkipVerify bool
+}
+
+// parseTLSOptions reads the TLS options from the query parameters "ca_file",
+// "cert_file", "key_file" and "insecure_skip_verify" of a sink spec and
+// removes them from q.
+func parseTLSOptions(q url.Values) (TLSOptions, error) {
+	o := TLSOptions{
+		CAFile: q.Get("ca_file"),
+		CertFile: q.Get("cert_file"),
+		KeyFile: q.Get("key_file"),
+		InsecureSkipVerify: q.Get("insecure_skip_verify") == "true",
+	}
+	for _, key := range []string{"ca_file", "cert_file", "key_file", "insecure_skip_verify"} {
+		q.Del(key)
+	}
+	if (len(o.CertFile) == 0) != (len(o.KeyFile) == 0) {
+		return o, fmt.Errorf("cert_file and key_file must be given together")
+	}
+	return o, nil
+}
+
+// Config creates the TLS configuration of the options.
+func (o TLSOptions) Config() (*tls.Config, error) {
+	config := &tls.Config{InsecureSkipVerify: o.InsecureSkipVerify}
+	if len(o.CAFile) > 0 {
+		pem, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CAFile)
+		}
+		config.RootCAs = pool
+	}
+	if len(o.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// HTTPOptions configures the client of the HTTP based sinks. Corporate
+// networks often require a custom CA, a client certificate, a proxy or extra
+// headers, e.g. for authentication.
+type HTTPOptions struct {
+	TLSOptions
+	// Proxy is the URL of the proxy to use. If empty, the proxy is taken from
+	// the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables.
+	Proxy string
+	// Headers are added to every request.
+	Headers http.Header
+	// Timeout limits the time of a request, 0 means no limit.
+	Timeout time.Duration
+	// HTTP3 sends the requests over HTTP/3, i.e. QUIC, instead of TCP. It
+	// requires an https URL and does not support a proxy.
+	HTTP3 bool
+}
+
+// parseHTTPOptions reads the HTTP options from the query parameters of a sink
+// spec:
+//
+// - "ca_file", "cert_file" and "key_file": paths of PEM files
+// - "insecure_skip_verify=true": disables the verification of the server certificate
+// - "proxy": the URL of the proxy
+// - "header": an extra header like "Authorization: Bearer abc", can be repeated
+// - "timeout": the timeout of a request as a Go duration
+// - "http3=true": sends the requests over HTTP/3
+//
+// The parameters are removed from q, so the remaining ones can be handled by
+// the sink.
+func parseHTTPOptions(q url.Values) (HTTPOptions, error) {
+	tlsOptions, err := parseTLSOptions(q)
+	if err != nil {
+		return HTTPOptions{}, err
+	}
+	o := HTTPOptions{
+		TLSOptions: tlsOptions,
+		Proxy: q.Get("proxy"),
+		Headers: http.Header{},
+		HTTP3: q.Get("http3") == "true",
+	}
+	for _, h := range q["header"] {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok || len(strings.TrimSpace(name)) == 0 {
+			return o, fmt.Errorf("invalid header: %s, must be like \"Name: value\"", h)
+		}
+		o.Headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+	if v := q.Get("timeout"); len(v) > 0 {
+		if o.Timeout, err = time.ParseDuration(v); err != nil {
+			return o, fmt.Errorf("invalid timeout: %w", err)
+		}
+	}
+	for _, key := range []string{"proxy", "header", "timeout", "http3"} {
+		q.Del(key)
+	}
+	return o, nil
+}
+
+// Client creates an HTTP client with the TLS and proxy settings of the options.
+func (o HTTPOptions) Client() (*http.Client, error) {
+	tlsConfig, err := o.TLSOptions.Config()
+	if err != nil {
+		return nil, err
+	}
+	if o.HTTP3 {
+		if len(o.Proxy) > 0 {
+			return nil, errors.New("proxy is not supported with HTTP/3")
+		}
+		return &http.Client{Transport: &http3.Transport{TLSClientConfig: tlsConfig}, Timeout: o.Timeout}, nil
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if len(o.Proxy) > 0 {
+		proxy, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	return &http.Client{Transport: transport, Timeout: o.Timeout}, nil
+}
+
+// setHeaders adds the extra headers of the options to the request. They
+// replace headers of the same name set by the sink.
+func (o HTTPOptions) setHeaders(req *http.Request) {
+	for name, values := range o.Headers {
+		req.Header[name] = values
+	}
+}
+
+// send sends the request with the extra headers of the options and returns
+// the body of the response. Responses with a status other than 2xx are
+// returned as error, including the start of their body.
+func (o HTTPOptions) send(client *http.Client, req *http.Request) ([]byte, error) {
+	o.setHeaders(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &statusError{code: resp.StatusCode, status: resp.Status, msg: strings.TrimSpace(string(msg))}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// statusError is returned for responses with a status other than 2xx.
+type statusError struct {
+	code int
+	status string
+	msg string // start of the body
+}
+
+func (e *statusError) Error() string {
+	return e.status + ": " + e.msg
+}
@@ -0,0 +1,140 @@
+package sinks
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestHTTPOptions_CAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("Authorization")))
+	}))
+	defer server.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatalf("Failed to write CA file: %s", err)
+	}
+
+	q := url.Values{"ca_file": {caFile}, "header": {"Authorization: Bearer abc"}, "labels": {"job=app"}}
+	options, err := parseHTTPOptions(q)
+	if err != nil {
+		t.Fatalf("Failed to parse options: %s", err)
+	}
+	if len(q) != 1 || q.Get("labels") != "job=app" {
+		t.Errorf("Expected only the sink parameters to remain, got %v", q)
+	}
+	client, err := options.Client()
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+	options.setHeaders(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to send request: %s", err)
+	}
+	defer resp.Body.Close()
+	body := make([]byte, 64)
+	n, _ := resp.Body.Read(body)
+	if string(body[:n]) != "Bearer abc" {
+		t.Errorf("Expected the extra header to be sent, got %q", body[:n])
+	}
+
+	// Without the CA, the certificate of the server is not trusted
+	client, _ = HTTPOptions{}.Client()
+	if _, err := client.Get(server.URL); err == nil {
+		t.Error("Expected an error for an unknown CA")
+	}
+}
+
+func TestHTTPOptions_Proxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	options, err := parseHTTPOptions(url.Values{"proxy": {proxy.URL}})
+	if err != nil {
+		t.Fatalf("Failed to parse options: %s", err)
+	}
+	client, err := options.Client()
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	resp, err := client.Get("http://loki.internal:3100/ready")
+	if err != nil {
+		t.Fatalf("Failed to send request: %s", err)
+	}
+	resp.Body.Close()
+	if proxied != "http://loki.internal:3100/ready" {
+		t.Errorf("Expected the request to go through the proxy, got %q", proxied)
+	}
+}
+
+func TestParseHTTPOptions_Invalid(t *testing.T) {
+	for _, q := range []url.Values{
+		{"header": {"no colon"}},
+		{"cert_file": {"client.pem"}},
+		{"timeout": {"soon"}},
+	} {
+		if _, err := parseHTTPOptions(q); err == nil {
+			t.Errorf("Expected an error for %v", q)
+		}
+	}
+}
+
+func TestHTTPOptions_HTTP3(t *testing.T) {
+	// Borrows the certificate of a TLS server, which is valid for 127.0.0.1
+	ts := httptest.NewTLSServer(http.NotFoundHandler())
+	defer ts.Close()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caFile, ca, 0644); err != nil {
+		t.Fatalf("Failed to write CA file: %s", err)
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	server := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(&tls.Config{Certificates: ts.TLS.Certificates}),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+	}
+	go server.Serve(conn)
+	defer server.Close()
+
+	options, err := parseHTTPOptions(url.Values{"ca_file": {caFile}, "http3": {"true"}})
+	if err != nil {
+		t.Fatalf("Failed to parse options: %s", err)
+	}
+	client, err := options.Client()
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+	resp, err := client.Get("https://" + conn.LocalAddr().String() + "/")
+	if err != nil {
+		t.Fatalf("Failed to send request: %s", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "HTTP/3.0" {
+		t.Errorf("Expected the request to be sent over HTTP/3, got %q", body)
+	}
+
+	if _, err := (HTTPOptions{HTTP3: true, Proxy: "http://proxy:3128"}).Client(); err == nil {
+		t.Error("Expected an error for a proxy with HTTP/3")
+	}
+}
@@ -0,0 +1,196 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl"
+	"github.com/segmentio/kafka-go/sasl/plain"
+	"github.com/segmentio/kafka-go/sasl/scram"
+)
+
+// KafkaOptions configures a KafkaSink.
+type KafkaOptions struct {
+	// KeyTemplate is a text/template executed with the LogEvent to create the
+	// key of each message, e.g. "{{.Source}}". Messages with the same key end
+	// up in the same partition. Empty means messages have no key and are
+	// distributed round robin.
+	KeyTemplate string
+	// BatchSize is the number of lines after which they are produced without
+	// waiting for a flush.
+	BatchSize int
+	// TLS enables TLS with the given options.
+	TLS *TLSOptions
+	// SASLMechanism is "plain", "scram-sha-256" or "scram-sha-512". Empty
+	// means no authentication.
+	SASLMechanism string
+	SASLUsername string
+	SASLPassword string
+}
+
+// KafkaSink produces the lines as messages to a Kafka topic. The lines are
+// buffered until the sink is flushed or BatchSize lines are buffered, and are
+// then produced synchronously, so a failing cluster stops the replay.
+type KafkaSink struct {
+	writer *kafka.Writer
+	key *template.Template
+	batchSize int
+	messages []kafka.Message
+	keyBuf bytes.Buffer
+}
+
+// NewKafkaSink creates a KafkaSink producing to the topic on the given
+// brokers.
+func NewKafkaSink(brokers []string, topic string, options KafkaOptions) (*KafkaSink, error) {
+	if len(brokers) == 0 || len(topic) == 0 {
+		return nil, fmt.Errorf("brokers and topic must be given")
+	}
+	if options.BatchSize <= 0 {
+		return nil, fmt.Errorf("invalid batch size: %d, must be positive", options.BatchSize)
+	}
+	transport := &kafka.Transport{}
+	if options.TLS != nil {
+		config, err := options.TLS.Config()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLS = config
+	}
+	if len(options.SASLMechanism) > 0 {
+		mechanism, err := saslMechanism(options.SASLMechanism, options.SASLUsername, options.SASLPassword)
+		if err != nil {
+			return nil, err
+		}
+		transport.SASL = mechanism
+	}
+	s := &KafkaSink{
+		writer: &kafka.Writer{
+			Addr: kafka.TCP(brokers...),
+			Topic: topic,
+			Balancer: &kafka.Hash{},
+			BatchSize: options.BatchSize,
+			// The sink batches itself, do not wait for more messages
+			BatchTimeout: 10 * time.Millisecond,
+			RequiredAcks: kafka.RequireOne,
+			Transport: transport,
+		},
+		batchSize: options.BatchSize,
+	}
+	if len(options.KeyTemplate) > 0 {
+		key, err := template.New("key").Parse(options.KeyTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key template: %w", err)
+		}
+		s.key = key
+	}
+	return s, nil
+}
+
+// saslMechanism returns the SASL mechanism of the given name.
+func saslMechanism(name, username, password string) (sasl.Mechanism, error) {
+	switch name {
+	case "plain":
+		return plain.Mechanism{Username: username, Password: password}, nil
+	case "scram-sha-256":
+		return scram.Mechanism(scram.SHA256, username, password)
+	case "scram-sha-512":
+		return scram.Mechanism(scram.SHA512, username, password)
+	}
+	return nil, fmt.Errorf("invalid SASL mechanism: %s, must be plain, scram-sha-256 or scram-sha-512", name)
+}
+
+// message creates the Kafka message of the event.
+func (s *KafkaSink) message(e logs.LogEvent) (kafka.Message, error) {
+	m := kafka.Message{Value: []byte(e.Line), Time: e.Time}
+	if s.key != nil {
+		s.keyBuf.Reset()
+		if err := s.key.Execute(&s.keyBuf, e); err != nil {
+			return m, err
+		}
+		m.Key = bytes.Clone(s.keyBuf.Bytes())
+	}
+	return m, nil
+}
+
+// Write buffers the line of the event and produces the buffered lines once
+// the batch is full.
+func (s *KafkaSink) Write(ctx context.Context, e logs.LogEvent) error {
+	m, err := s.message(e)
+	if err != nil {
+		return err
+	}
+	s.messages = append(s.messages, m)
+	if len(s.messages) >= s.batchSize {
+		return s.produce(ctx)
+	}
+	return nil
+}
+
+// produce writes the buffered messages to the topic.
+func (s *KafkaSink) produce(ctx context.Context) error {
+	if len(s.messages) == 0 {
+		return nil
+	}
+	err := s.writer.WriteMessages(ctx, s.messages...)
+	s.messages = s.messages[:0]
+	if err != nil {
+		return fmt.Errorf("failed to produce to Kafka: %w", err)
+	}
+	return nil
+}
+
+// Flush produces the buffered lines.
+func (s *KafkaSink) Flush() error {
+	return s.produce(context.Background())
+}
+
+// Close produces the remaining lines and closes the connections.
+func (s *KafkaSink) Close() error {
+	err := s.Flush()
+	if cerr := s.writer.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// openKafka creates a KafkaSink from a spec like
+// "kafka://broker1:9092,broker2:9092/topic?key={{.Source}}&tls=true&sasl_mechanism=plain".
+func openKafka(spec string) (logs.Sink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	q := u.Query()
+	tlsOptions, err := parseTLSOptions(q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	options := KafkaOptions{
+		KeyTemplate: q.Get("key"),
+		BatchSize: 100,
+		SASLMechanism: q.Get("sasl_mechanism"),
+		SASLUsername: q.Get("sasl_username"),
+		SASLPassword: q.Get("sasl_password"),
+	}
+	if q.Get("tls") == "true" {
+		options.TLS = &tlsOptions
+	}
+	if v := q.Get("batch_size"); len(v) > 0 {
+		if options.BatchSize, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	s, err := NewKafkaSink(strings.Split(u.Host, ","), strings.TrimPrefix(u.Path, "/"), options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,37 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"testing"
+)
+
+func TestOpenKafka(t *testing.T) {
+	sink, err := Open("kafka://broker1:9092,broker2:9092/app-logs?key=%7B%7B.Source%7D%7D&batch_size=10&sasl_mechanism=plain&sasl_username=u&sasl_password=p")
+	if err != nil {
+		t.Fatalf("Failed to open Kafka sink: %s", err)
+	}
+	ks := sink.(*KafkaSink)
+	defer ks.writer.Close()
+	if ks.writer.Topic != "app-logs" || ks.writer.Addr.String() != "broker1:9092,broker2:9092" || ks.batchSize != 10 {
+		t.Errorf("Unexpected writer settings: topic %s, brokers %s, batch size %d", ks.writer.Topic, ks.writer.Addr, ks.batchSize)
+	}
+	m, err := ks.message(logs.LogEvent{Line: "GET /", Source: "web.log"})
+	if err != nil {
+		t.Fatalf("Failed to create message: %s", err)
+	}
+	if string(m.Key) != "web.log" || string(m.Value) != "GET /" {
+		t.Errorf("Unexpected message key %q, value %q", m.Key, m.Value)
+	}
+}
+
+func TestOpenKafka_Invalid(t *testing.T) {
+	for _, spec := range []string{
+		"kafka://broker:9092",
+		"kafka://broker:9092/topic?sasl_mechanism=gssapi",
+		"kafka://broker:9092/topic?key=%7B%7B.Source",
+	} {
+		if _, err := Open(spec); err == nil {
+			t.Errorf("Expected an error for %s", spec)
+		}
+	}
+}
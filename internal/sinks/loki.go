@@ -0,0 +1,185 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LokiPushPath is the path of the Loki push API.
+const LokiPushPath = "/loki/api/v1/push"
+
+// LokiOptions configures a LokiSink.
+type LokiOptions struct {
+	// Labels are the stream labels of all lines.
+	Labels map[string]string
+	// SourceLabel is the name of a label set to the input file of a line.
+	// Empty means the input file is not added as label.
+	SourceLabel string
+	// BatchSize is the number of lines after which they are pushed.
+	BatchSize int
+	// FlushInterval is the maximum time lines are buffered before they are
+	// pushed.
+	FlushInterval time.Duration
+	HTTP HTTPOptions
+}
+
+// LokiSink pushes the lines to the push API of Grafana Loki. Lines are
+// buffered and pushed when BatchSize lines are buffered or FlushInterval has
+// passed since the last push.
+type LokiSink struct {
+	url string
+	options LokiOptions
+	client *http.Client
+	batch *batcher
+	streams map[string]*lokiStream // buffered lines by input file
+}
+
+// lokiStream is a stream of the Loki push request.
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string `json:"values"`
+}
+
+// NewLokiSink creates a LokiSink pushing to the given URL, usually ending
+// with LokiPushPath.
+func NewLokiSink(pushURL string, options LokiOptions) (*LokiSink, error) {
+	if options.BatchSize <= 0 {
+		return nil, fmt.Errorf("invalid batch size: %d, must be positive", options.BatchSize)
+	}
+	if options.FlushInterval <= 0 {
+		return nil, fmt.Errorf("invalid flush interval: %s, must be positive", options.FlushInterval)
+	}
+	client, err := options.HTTP.Client()
+	if err != nil {
+		return nil, err
+	}
+	s := &LokiSink{
+		url: pushURL,
+		options: options,
+		client: client,
+		streams: map[string]*lokiStream{},
+	}
+	s.batch = newBatcher(options.BatchSize, options.FlushInterval, s.push)
+	return s, nil
+}
+
+// Write buffers the line of the event and pushes the buffered lines once the
+// batch is full.
+func (s *LokiSink) Write(_ context.Context, e logs.LogEvent) error {
+	return s.batch.add(func() {
+		stream, ok := s.streams[e.Source]
+		if !ok {
+			labels := s.options.Labels
+			if len(s.options.SourceLabel) > 0 {
+				labels = make(map[string]string, len(s.options.Labels)+1)
+				for k, v := range s.options.Labels {
+					labels[k] = v
+				}
+				labels[s.options.SourceLabel] = e.Source
+			}
+			stream = &lokiStream{Stream: labels}
+			s.streams[e.Source] = stream
+		}
+		stream.Values = append(stream.Values, [2]string{strconv.FormatInt(e.Time.UnixNano(), 10), strings.Clone(e.Line)})
+	})
+}
+
+// Flush pushes the buffered lines if the flush interval has passed since the
+// last push.
+func (s *LokiSink) Flush() error {
+	return s.batch.flush()
+}
+
+// push sends the buffered lines to Loki.
+func (s *LokiSink) push() error {
+	keys := make([]string, 0, len(s.streams))
+	for k := range s.streams {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	streams := make([]*lokiStream, len(keys))
+	for i, k := range keys {
+		streams[i] = s.streams[k]
+	}
+	body, err := json.Marshal(map[string]any{"streams": streams})
+	clear(s.streams)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if _, err := s.options.HTTP.send(s.client, req); err != nil {
+		return fmt.Errorf("failed to push to Loki: %w", err)
+	}
+	return nil
+}
+
+// Close pushes the remaining lines.
+func (s *LokiSink) Close() error {
+	return s.batch.close()
+}
+
+// openLoki creates a LokiSink from a spec like
+// "loki+http://loki:3100?labels=job=demo,env=dev&batch_size=1000&flush_interval=1s".
+// The push path is added if the URL has no path. The commas between the
+// labels do not separate outputs in OpenAll, see splitSpecs.
+func openLoki(spec string) (logs.Sink, error) {
+	u, err := url.Parse(strings.TrimPrefix(spec, "loki+"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	q := u.Query()
+	httpOptions, err := parseHTTPOptions(q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	options := LokiOptions{
+		Labels: map[string]string{"job": "bananabacon"},
+		SourceLabel: q.Get("source_label"),
+		BatchSize: 1000,
+		FlushInterval: time.Second,
+		HTTP: httpOptions,
+	}
+	if v := q.Get("labels"); len(v) > 0 {
+		options.Labels = map[string]string{}
+		for _, pair := range strings.Split(v, ",") {
+			name, value, ok := strings.Cut(pair, "=")
+			if !ok || len(name) == 0 {
+				return nil, fmt.Errorf("invalid output %q: invalid label %q", spec, pair)
+			}
+			options.Labels[strings.TrimSpace(name)] = strings.TrimSpace(value)
+		}
+	}
+	if v := q.Get("batch_size"); len(v) > 0 {
+		if options.BatchSize, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	if v := q.Get("flush_interval"); len(v) > 0 {
+		if options.FlushInterval, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	if len(u.Path) == 0 || u.Path == "/" {
+		u.Path = LokiPushPath
+	}
+	u.RawQuery = ""
+	s, err := NewLokiSink(u.String(), options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	return s, nil
+}
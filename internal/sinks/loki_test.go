@@ -0,0 +1,88 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLokiSink(t *testing.T) {
+	var mu sync.Mutex
+	var pushes []map[string][]lokiStream
+	var tenant string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != LokiPushPath {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		var body map[string][]lokiStream
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("Failed to decode push: %s", err)
+		}
+		mu.Lock()
+		pushes = append(pushes, body)
+		tenant = r.Header.Get("X-Scope-OrgID")
+		mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// Through OpenAll, whose commas between outputs must not split the labels
+	sink, err := OpenAll("loki+" + server.URL + "?labels=job=demo,env=dev&source_label=filename&batch_size=2&flush_interval=1h&header=X-Scope-OrgID:%20team")
+	if err != nil {
+		t.Fatalf("Failed to open Loki sink: %s", err)
+	}
+	t0 := time.Unix(1700000000, 0)
+	for i, source := range []string{"a.log", "b.log", "a.log"} {
+		e := logs.LogEvent{Time: t0.Add(time.Duration(i) * time.Second), Line: "line", Source: source}
+		if err := sink.Write(context.Background(), e); err != nil {
+			t.Fatalf("Failed to write: %s", err)
+		}
+	}
+	// The flush interval has not passed yet
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("Failed to flush: %s", err)
+	}
+	mu.Lock()
+	if len(pushes) != 1 {
+		t.Fatalf("Expected one push of a full batch, got %d", len(pushes))
+	}
+	mu.Unlock()
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+
+	if len(pushes) != 2 || tenant != "team" {
+		t.Fatalf("Expected the remaining line to be pushed with the tenant header, got %d pushes, tenant %q", len(pushes), tenant)
+	}
+	streams := pushes[0]["streams"]
+	if len(streams) != 2 {
+		t.Fatalf("Expected a stream per source, got %+v", streams)
+	}
+	if streams[0].Stream["filename"] != "a.log" || streams[0].Stream["job"] != "demo" || streams[0].Stream["env"] != "dev" {
+		t.Errorf("Unexpected labels %v", streams[0].Stream)
+	}
+	if streams[0].Values[0] != [2]string{"1700000000000000000", "line"} {
+		t.Errorf("Unexpected values %v", streams[0].Values)
+	}
+}
+
+func TestLokiSink_Error(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "entry too far behind", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	sink, err := NewLokiSink(server.URL, LokiOptions{BatchSize: 1, FlushInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to create Loki sink: %s", err)
+	}
+	defer sink.Close()
+	if err := sink.Write(context.Background(), logs.LogEvent{Line: "late"}); err == nil {
+		t.Error("Expected the rejected push to fail")
+	}
+}
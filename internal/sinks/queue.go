@@ -0,0 +1,271 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// QueueBlock makes the replay wait while the queue is full. The timing of
+	// the replay suffers, but no line is lost.
+	QueueBlock = "block"
+	// QueueDropOldest drops the line that waited the longest to make room for
+	// a new line, keeping the output close to real time.
+	QueueDropOldest = "drop_oldest"
+	// QueueDropNewest drops new lines while the queue is full.
+	QueueDropNewest = "drop_newest"
+)
+
+// QueueOptions configures a QueueSink.
+type QueueOptions struct {
+	// Size is the number of lines buffered ahead of the sink. Zero writes the
+	// lines synchronously, only retrying failed writes.
+	Size int
+	// Policy is what happens to lines while the queue is full, QueueBlock,
+	// QueueDropOldest or QueueDropNewest.
+	Policy string
+	// Retries is the number of times a failed write or flush of the sink is
+	// retried before the replay is stopped with the error. Sinks that failed
+	// mid-write may receive a line twice.
+	Retries int
+	// Backoff is the delay before the first retry. It doubles with every
+	// retry.
+	Backoff time.Duration
+}
+
+// QueueSink decouples the replay from a slow sink: the lines are buffered in a
+// bounded queue and written to the sink in the background, so the sink does
+// not delay the emission of the following lines. The sink is flushed whenever
+// the queue runs empty. If the sink fails, the failed write is retried and
+// eventually its error is returned by the next Write or Flush, which stops the
+// replay. Dropped lines are counted and logged.
+type QueueSink struct {
+	sink logs.Sink
+	options QueueOptions
+	queue chan logs.LogEvent // nil if the lines are written synchronously
+	done chan struct{}
+	dropped atomic.Int64
+	mu sync.Mutex
+	err error // first error of the sink after retries
+}
+
+// NewQueueSink creates a QueueSink writing to sink.
+func NewQueueSink(sink logs.Sink, options QueueOptions) (*QueueSink, error) {
+	if options.Size < 0 || options.Retries < 0 || options.Backoff < 0 {
+		return nil, errors.New("queue size, retries and backoff must not be negative")
+	}
+	switch options.Policy {
+	case "":
+		options.Policy = QueueBlock
+	case QueueBlock, QueueDropOldest, QueueDropNewest:
+	default:
+		return nil, fmt.Errorf("invalid queue policy: %s, must be %s, %s or %s", options.Policy, QueueBlock,
+			QueueDropOldest, QueueDropNewest)
+	}
+	s := &QueueSink{sink: sink, options: options, done: make(chan struct{})}
+	if options.Size == 0 {
+		close(s.done)
+		return s, nil
+	}
+	s.queue = make(chan logs.LogEvent, options.Size)
+	go s.drain()
+	return s, nil
+}
+
+// Write queues the event according to the policy. Without a queue, it writes
+// the event to the sink, retrying failed writes. It returns the error of the
+// sink if it failed.
+func (s *QueueSink) Write(ctx context.Context, e logs.LogEvent) error {
+	if s.queue == nil {
+		return s.retry(func() error { return s.sink.Write(ctx, e) })
+	}
+	if err := s.failed(); err != nil {
+		return err
+	}
+	// The queued event outlives the write
+	e = e.Clone()
+	switch s.options.Policy {
+	case QueueDropNewest:
+		select {
+		case s.queue <- e:
+		default:
+			s.drop()
+		}
+	case QueueDropOldest:
+		for {
+			select {
+			case s.queue <- e:
+				return nil
+			default:
+			}
+			select {
+			case <-s.queue:
+				s.drop()
+			default:
+			}
+		}
+	default:
+		select {
+		case s.queue <- e:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// drop counts a dropped line. The first drop is logged, so a slow sink does
+// not go unnoticed.
+func (s *QueueSink) drop() {
+	if s.dropped.Add(1) == 1 {
+		log.Printf("Output queue is full, dropping lines with policy %s", s.options.Policy)
+	}
+}
+
+// Dropped returns the number of lines dropped because the queue was full.
+func (s *QueueSink) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// Flush flushes the sink if the lines are written synchronously. Queued lines
+// are flushed once the queue runs empty, so Flush only returns the error of the
+// sink, if any.
+func (s *QueueSink) Flush() error {
+	if s.queue == nil {
+		return s.retry(s.sink.Flush)
+	}
+	return s.failed()
+}
+
+// Close writes the queued lines, closes the sink and returns the first error of
+// the sink.
+func (s *QueueSink) Close() error {
+	if s.queue != nil {
+		close(s.queue)
+	}
+	<-s.done
+	if dropped := s.dropped.Load(); dropped > 0 {
+		log.Printf("Dropped %d lines because the output queue was full", dropped)
+	}
+	return errors.Join(s.failed(), s.sink.Close())
+}
+
+// drain writes the queued lines to the sink until the queue is closed. After
+// the sink failed, the remaining lines are discarded.
+func (s *QueueSink) drain() {
+	defer close(s.done)
+	// Writes are not cancelled, Close waits for the queued lines instead
+	ctx := context.Background()
+	for e := range s.queue {
+		if s.failed() != nil {
+			continue
+		}
+		if err := s.retry(func() error { return s.sink.Write(ctx, e) }); err != nil {
+			s.fail(err)
+			continue
+		}
+		if len(s.queue) == 0 {
+			if err := s.retry(s.sink.Flush); err != nil {
+				s.fail(err)
+			}
+		}
+	}
+}
+
+// retry calls f until it succeeds or the retries are exhausted and returns its
+// last error.
+func (s *QueueSink) retry(f func() error) error {
+	backoff := s.options.Backoff
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= s.options.Retries {
+			return err
+		}
+		log.Printf("Failed to write to output, retrying in %s: %v", backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// fail records the first error of the sink.
+func (s *QueueSink) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// failed returns the first error of the sink, if any.
+func (s *QueueSink) failed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// queueKeys are the query parameters of an output spec that configure its
+// QueueSink.
+var queueKeys = []string{"queue_size", "queue_policy", "queue_retries", "queue_backoff"}
+
+// cutQueueOptions removes the queue options from the query of spec, so the
+// remaining parameters can be passed on, e.g. to the URL of a webhook. It
+// returns nil options if spec has none.
+func cutQueueOptions(spec string) (string, *QueueOptions, error) {
+	name, query, ok := strings.Cut(spec, "?")
+	if !ok {
+		return spec, nil, nil
+	}
+	var kept []string
+	q := url.Values{}
+	for _, param := range strings.Split(query, "&") {
+		key, value, _ := strings.Cut(param, "=")
+		if !strings.HasPrefix(key, "queue_") {
+			kept = append(kept, param)
+			continue
+		}
+		v, err := url.QueryUnescape(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+		q.Set(key, v)
+	}
+	if len(q) == 0 {
+		return spec, nil, nil
+	}
+	for key := range q {
+		if !slices.Contains(queueKeys, key) {
+			return "", nil, fmt.Errorf("invalid output %q: unknown option %s", spec, key)
+		}
+	}
+	options := QueueOptions{Policy: queryOr(q, "queue_policy", QueueBlock), Backoff: 100 * time.Millisecond}
+	var err error
+	if v := q.Get("queue_size"); len(v) > 0 {
+		if options.Size, err = strconv.Atoi(v); err != nil {
+			return "", nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	if v := q.Get("queue_retries"); len(v) > 0 {
+		if options.Retries, err = strconv.Atoi(v); err != nil {
+			return "", nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	if v := q.Get("queue_backoff"); len(v) > 0 {
+		if options.Backoff, err = time.ParseDuration(v); err != nil {
+			return "", nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	if len(kept) > 0 {
+		name += "?" + strings.Join(kept, "&")
+	}
+	return name, &options, nil
+}
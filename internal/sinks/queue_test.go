@@ -0,0 +1,146 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingSink records the lines written to it and blocks every write until
+// release is closed.
+type blockingSink struct {
+	mu      sync.Mutex
+	lines   []string
+	release chan struct{}
+}
+
+func (s *blockingSink) Write(_ context.Context, e logs.LogEvent) error {
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lines = append(s.lines, e.Line)
+	return nil
+}
+
+func (s *blockingSink) Flush() error {
+	return nil
+}
+
+func (s *blockingSink) Close() error {
+	return nil
+}
+
+func TestQueueSink_Policies(t *testing.T) {
+	for _, test := range []struct {
+		policy string
+		last   string
+	}{
+		{QueueDropNewest, "line 2"},
+		{QueueDropOldest, "line 9"},
+	} {
+		sink := &blockingSink{release: make(chan struct{})}
+		qs, err := NewQueueSink(sink, QueueOptions{Size: 2, Policy: test.policy})
+		if err != nil {
+			t.Fatalf("Failed to create queue sink: %s", err)
+		}
+		// The first line is taken by the blocked writer, two more fit into the
+		// queue, the others must not block
+		for i := 0; i < 10; i++ {
+			if err := qs.Write(context.Background(), logs.LogEvent{Line: "line " + strconv.Itoa(i)}); err != nil {
+				t.Fatalf("Failed to write: %s", err)
+			}
+			if i == 0 {
+				time.Sleep(10 * time.Millisecond)
+			}
+		}
+		close(sink.release)
+		if err := qs.Close(); err != nil {
+			t.Fatalf("Failed to close queue sink: %s", err)
+		}
+		if len(sink.lines) != 3 || sink.lines[0] != "line 0" || sink.lines[2] != test.last {
+			t.Errorf("Expected 3 lines ending with %q with policy %s, got %q", test.last, test.policy, sink.lines)
+		}
+		if qs.Dropped() != 7 {
+			t.Errorf("Expected 7 dropped lines with policy %s, got %d", test.policy, qs.Dropped())
+		}
+	}
+}
+
+func TestQueueSink_Block(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{})}
+	qs, err := NewQueueSink(sink, QueueOptions{Size: 1})
+	if err != nil {
+		t.Fatalf("Failed to create queue sink: %s", err)
+	}
+	qs.Write(context.Background(), logs.LogEvent{Line: "line 0"})
+	time.Sleep(10 * time.Millisecond)
+	qs.Write(context.Background(), logs.LogEvent{Line: "line 1"})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := qs.Write(ctx, logs.LogEvent{Line: "line 2"}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected write to block until the context is done, got %v", err)
+	}
+	close(sink.release)
+	qs.Close()
+	if len(sink.lines) != 2 || qs.Dropped() != 0 {
+		t.Errorf("Expected 2 lines and no drops, got %q and %d drops", sink.lines, qs.Dropped())
+	}
+}
+
+func TestQueueSink_Retries(t *testing.T) {
+	failures := 2
+	var lines []string
+	flaky := logs.SinkFunc(func(_ context.Context, e logs.LogEvent) error {
+		if failures > 0 {
+			failures--
+			return errors.New("unavailable")
+		}
+		lines = append(lines, e.Line)
+		return nil
+	})
+	qs, err := NewQueueSink(flaky, QueueOptions{Size: 10, Retries: 2, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create queue sink: %s", err)
+	}
+	qs.Write(context.Background(), logs.LogEvent{Line: "line 0"})
+	if err := qs.Close(); err != nil || len(lines) != 1 {
+		t.Errorf("Expected the write to succeed on the last retry, got %q and %v", lines, err)
+	}
+
+	failing := logs.SinkFunc(func(context.Context, logs.LogEvent) error {
+		return errors.New("unavailable")
+	})
+	qs, err = NewQueueSink(failing, QueueOptions{Retries: 1, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create queue sink: %s", err)
+	}
+	if err := qs.Write(context.Background(), logs.LogEvent{Line: "line 0"}); err == nil {
+		t.Error("Expected error once the retries are exhausted")
+	}
+}
+
+func TestCutQueueOptions(t *testing.T) {
+	spec, options, err := cutQueueOptions("https://example.com/logs?token=a%26b&queue_size=100&queue_policy=drop_oldest&retries=3")
+	if err != nil {
+		t.Fatalf("Failed to cut queue options: %s", err)
+	}
+	if spec != "https://example.com/logs?token=a%26b&retries=3" {
+		t.Errorf("Expected the other parameters to be kept, got %q", spec)
+	}
+	if options == nil || options.Size != 100 || options.Policy != QueueDropOldest {
+		t.Errorf("Expected queue of 100 lines dropping the oldest, got %+v", options)
+	}
+	if _, options, _ := cutQueueOptions("stdout?format=json"); options != nil {
+		t.Errorf("Expected no queue options, got %+v", options)
+	}
+	if _, _, err := cutQueueOptions("stdout?queue_length=1"); err == nil {
+		t.Error("Expected error for unknown queue option")
+	}
+	if _, err := Open("stdout?queue_size=10&queue_policy=drop_all"); err == nil {
+		t.Error("Expected error for invalid queue policy")
+	}
+}
@@ -0,0 +1,97 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMultiSink(t *testing.T) {
+	var first, second strings.Builder
+	failing := logs.SinkFunc(func(context.Context, logs.LogEvent) error {
+		return errors.New("failed")
+	})
+	ms := MultiSink{NewWriterSink(&first), failing, NewWriterSink(&second)}
+
+	if err := ms.Write(context.Background(), logs.LogEvent{Line: "line 1"}); err == nil {
+		t.Error("Expected error of failing sink")
+	}
+	if first.Len() != 0 {
+		t.Errorf("Expected output to be buffered until flushed, got %q", first.String())
+	}
+	if err := ms.Close(); err != nil {
+		t.Fatalf("Failed to close sinks: %s", err)
+	}
+	if first.String() != "line 1\n" || second.String() != "line 1\n" {
+		t.Errorf("Expected line in both outputs, got %q and %q", first.String(), second.String())
+	}
+}
+
+func TestOrderedSink(t *testing.T) {
+	var order []string
+	recording := func(name string) logs.Sink {
+		return logs.SinkFunc(func(_ context.Context, e logs.LogEvent) error {
+			order = append(order, name+" "+e.Line)
+			return nil
+		})
+	}
+	var buffered strings.Builder
+	ordered, err := NewOrderedSink(MultiSink{recording("a"), NewWriterSink(&buffered), recording("b")})
+	if err != nil {
+		t.Fatalf("Failed to create ordered sink: %s", err)
+	}
+	for _, line := range []string{"1", "2"} {
+		if err := ordered.Write(context.Background(), logs.LogEvent{Line: line}); err != nil {
+			t.Fatalf("Failed to write line: %s", err)
+		}
+		if !strings.HasSuffix(buffered.String(), line+"\n") {
+			t.Errorf("Expected line %s to be flushed before the next one, got %q", line, buffered.String())
+		}
+	}
+	if strings.Join(order, ",") != "a 1,b 1,a 2,b 2" {
+		t.Errorf("Expected the lines in order, got %v", order)
+	}
+
+	queued, err := Open("stdout?queue_size=10")
+	if err != nil {
+		t.Fatalf("Failed to open sink: %s", err)
+	}
+	defer queued.Close()
+	if _, err := NewOrderedSink(MultiSink{queued, recording("a")}); err == nil {
+		t.Error("Expected an error for a queued output")
+	}
+}
+
+func TestOpenAll(t *testing.T) {
+	s, err := OpenAll("stdout, stderr")
+	if err != nil {
+		t.Fatalf("Failed to open sinks: %s", err)
+	}
+	if ms, ok := s.(MultiSink); !ok || len(ms) != 2 {
+		t.Errorf("Expected 2 sinks, got %v", s)
+	}
+	if _, err := OpenAll("stdout,unknown"); err == nil {
+		t.Error("Expected error for unknown output")
+	}
+}
+
+func TestOpenAll_Commas(t *testing.T) {
+	s, err := OpenAll("stdout, kafka://b1:9092,b2:9092/t?batch_size=10,stderr")
+	if err != nil {
+		t.Fatalf("Failed to open sinks: %s", err)
+	}
+	ms, ok := s.(MultiSink)
+	if !ok || len(ms) != 3 {
+		t.Fatalf("Expected 3 sinks, got %v", s)
+	}
+	ks, ok := ms[1].(*KafkaSink)
+	if !ok {
+		t.Fatalf("Expected a Kafka sink, got %T", ms[1])
+	}
+	defer ks.writer.Close()
+	if ks.writer.Addr.String() != "b1:9092,b2:9092" || ks.writer.Topic != "t" {
+		t.Errorf("Expected both brokers, got brokers %s, topic %s", ks.writer.Addr, ks.writer.Topic)
+	}
+}
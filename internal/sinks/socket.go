@@ -0,0 +1,168 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"time"
+)
+
+// socketBufferSize is the number of buffered bytes after which a SocketSink
+// sends the lines without waiting for a flush.
+const socketBufferSize = 64 << 10
+
+// SocketOptions configures the reconnects of a SocketSink.
+type SocketOptions struct {
+	// Backoff is the initial delay between connection attempts. It doubles
+	// after every failed attempt up to MaxBackoff.
+	Backoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// SocketSink streams the lines to a TCP or UDP listener, e.g. the tcp input of
+// Logstash, Vector or Fluent Bit. The connection is established on the first
+// write, so the listener does not need to be up when the replay starts. If the
+// connection fails, the sink reconnects with exponential backoff and sends the
+// unsent lines again, which can duplicate lines the listener already
+// received. Over UDP, every line is sent in its own datagram and write errors
+// are ignored.
+type SocketSink struct {
+	network string
+	addr string
+	options SocketOptions
+	conn net.Conn
+	buf []byte // TCP lines not yet sent
+	ctx context.Context // context of the last write, used when flushing
+}
+
+// NewSocketSink creates a SocketSink sending to addr over network, which is
+// "tcp" or "udp".
+func NewSocketSink(network, addr string, options SocketOptions) (*SocketSink, error) {
+	if network != "tcp" && network != "udp" {
+		return nil, fmt.Errorf("invalid network: %s, must be tcp or udp", network)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, err
+	}
+	if options.Backoff <= 0 {
+		options.Backoff = time.Second
+	}
+	options.MaxBackoff = max(options.MaxBackoff, options.Backoff)
+	return &SocketSink{network: network, addr: addr, options: options, ctx: context.Background()}, nil
+}
+
+// connect dials the listener until it succeeds or ctx is canceled.
+func (s *SocketSink) connect(ctx context.Context) error {
+	var dialer net.Dialer
+	backoff := s.options.Backoff
+	for {
+		conn, err := dialer.DialContext(ctx, s.network, s.addr)
+		if err == nil {
+			s.conn = conn
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("Failed to connect to %s, retrying in %s: %v", s.addr, backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, s.options.MaxBackoff)
+	}
+}
+
+// Write sends the line of the event. Over TCP, the line is buffered until the
+// sink is flushed or the buffer is full.
+func (s *SocketSink) Write(ctx context.Context, e logs.LogEvent) error {
+	s.ctx = ctx
+	if s.network == "udp" {
+		if s.conn == nil {
+			if err := s.connect(ctx); err != nil {
+				return err
+			}
+		}
+		s.conn.Write([]byte(e.Line))
+		return nil
+	}
+	s.buf = append(s.buf, e.Line...)
+	s.buf = append(s.buf, '\n')
+	if len(s.buf) >= socketBufferSize {
+		return s.send(ctx)
+	}
+	return nil
+}
+
+// send writes the buffered lines, reconnecting until they have been written
+// or ctx is canceled.
+func (s *SocketSink) send(ctx context.Context) error {
+	for len(s.buf) > 0 {
+		if s.conn == nil {
+			if err := s.connect(ctx); err != nil {
+				return err
+			}
+		}
+		if _, err := s.conn.Write(s.buf); err != nil {
+			log.Printf("Lost connection to %s, reconnecting: %v", s.addr, err)
+			s.conn.Close()
+			s.conn = nil
+			continue
+		}
+		s.buf = s.buf[:0]
+	}
+	return nil
+}
+
+// Flush sends the buffered lines.
+func (s *SocketSink) Flush() error {
+	return s.send(s.ctx)
+}
+
+// Close sends the buffered lines over the current connection, without
+// reconnecting, and closes it.
+func (s *SocketSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	var err error
+	if len(s.buf) > 0 {
+		_, err = s.conn.Write(s.buf)
+		s.buf = s.buf[:0]
+	}
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+	s.conn = nil
+	return err
+}
+
+// openSocket creates a SocketSink from a spec like
+// "tcp://logstash:5000?backoff=1s&max_backoff=30s".
+func openSocket(spec string) (logs.Sink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	options := SocketOptions{MaxBackoff: 30 * time.Second}
+	q := u.Query()
+	if v := q.Get("backoff"); len(v) > 0 {
+		if options.Backoff, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	if v := q.Get("max_backoff"); len(v) > 0 {
+		if options.MaxBackoff, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	s, err := NewSocketSink(u.Scheme, u.Host, options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,111 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSocketSink_Reconnect(t *testing.T) {
+	// Reserve a port without listening on it yet
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	sink, err := Open("tcp://" + addr + "?backoff=10ms&max_backoff=50ms")
+	if err != nil {
+		t.Fatalf("Failed to open socket sink: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sink.Write(ctx, logs.LogEvent{Line: "first"}); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	flushed := make(chan error, 1)
+	go func() {
+		flushed <- sink.Flush()
+	}()
+
+	// The sink keeps retrying until the listener is up
+	time.Sleep(50 * time.Millisecond)
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("Port was taken in the meantime: %s", err)
+	}
+	defer ln.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("Failed to accept: %s", err)
+	}
+	defer conn.Close()
+	if err := <-flushed; err != nil {
+		t.Fatalf("Failed to flush: %s", err)
+	}
+	if err := sink.Write(ctx, logs.LogEvent{Line: "second"}); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+
+	scanner := bufio.NewScanner(conn)
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if len(lines) != 2 || lines[0] != "first" || lines[1] != "second" {
+		t.Errorf("Unexpected lines %q", lines)
+	}
+}
+
+func TestSocketSink_Canceled(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	sink, err := NewSocketSink("tcp", addr, SocketOptions{Backoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Failed to create socket sink: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	sink.Write(ctx, logs.LogEvent{Line: "lost"})
+	if err := sink.Flush(); err != context.DeadlineExceeded {
+		t.Errorf("Expected the flush to stop with the context, got %v", err)
+	}
+}
+
+func TestSocketSink_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer conn.Close()
+
+	sink, err := Open("udp://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to open socket sink: %s", err)
+	}
+	defer sink.Close()
+	if err := sink.Write(context.Background(), logs.LogEvent{Line: "datagram"}); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to receive datagram: %s", err)
+	}
+	if string(buf[:n]) != "datagram" {
+		t.Errorf("Unexpected datagram %q", buf[:n])
+	}
+}
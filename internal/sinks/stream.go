@@ -0,0 +1,254 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// StreamPath is the path the StreamSink is served at by the command.
+	StreamPath = "/logs/stream"
+	// StreamKeepAlive is the interval in which idle Server-Sent Events
+	// streams receive a comment, so proxies do not close them.
+	StreamKeepAlive = 15 * time.Second
+	// websocketGUID is appended to the key of a WebSocket handshake, see
+	// RFC 6455, section 1.3.
+	websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+)
+
+// StreamSink streams the lines to connected HTTP clients, either as
+// Server-Sent Events or over a WebSocket, e.g. to tail a replay in a browser.
+// Clients only receive the lines written while they are connected. Lines are
+// dropped for clients that do not keep up, so slow clients never delay the
+// replay.
+type StreamSink struct {
+	mu sync.Mutex
+	clients map[chan string]struct{}
+	buffer int // number of lines buffered per client
+	dropped atomic.Int64
+	done chan struct{}
+	closeOnce sync.Once
+}
+
+// NewStreamSink creates a StreamSink that buffers up to the given number of
+// lines per client.
+func NewStreamSink(buffer int) *StreamSink {
+	return &StreamSink{
+		clients: map[chan string]struct{}{},
+		buffer: buffer,
+		done: make(chan struct{}),
+	}
+}
+
+// Write sends the line of the event to all connected clients.
+func (s *StreamSink) Write(_ context.Context, e logs.LogEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.clients) == 0 {
+		return nil
+	}
+	// The clients send the line in the background
+	line := strings.Clone(e.Line)
+	for c := range s.clients {
+		select {
+		case c <- line:
+		default:
+			s.dropped.Add(1)
+		}
+	}
+	return nil
+}
+
+// Flush does nothing, lines are sent to the clients as they are written.
+func (s *StreamSink) Flush() error {
+	return nil
+}
+
+// Close disconnects all clients.
+func (s *StreamSink) Close() error {
+	s.closeOnce.Do(func() { close(s.done) })
+	return nil
+}
+
+// Dropped returns the number of lines that were dropped because a client
+// did not keep up.
+func (s *StreamSink) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// subscribe registers a new client.
+func (s *StreamSink) subscribe() chan string {
+	c := make(chan string, s.buffer)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[c] = struct{}{}
+	return c
+}
+
+// unsubscribe removes a client.
+func (s *StreamSink) unsubscribe(c chan string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.clients, c)
+}
+
+// ServeHTTP streams the lines to the client over a WebSocket if the request
+// asks for an upgrade, and as Server-Sent Events otherwise.
+func (s *StreamSink) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		s.serveWebSocket(w, r)
+		return
+	}
+	s.serveEvents(w, r)
+}
+
+// serveEvents streams the lines as Server-Sent Events, one event per line.
+func (s *StreamSink) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	c := s.subscribe()
+	defer s.unsubscribe(c)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(StreamKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		case line := <-c:
+			_, err = io.WriteString(w, "data: "+line+"\n\n")
+		case <-keepAlive.C:
+			_, err = io.WriteString(w, ": keep-alive\n\n")
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// serveWebSocket performs the WebSocket handshake and sends every line as a
+// text message until the client closes the connection.
+func (s *StreamSink) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if len(key) == 0 || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		http.Error(w, "invalid WebSocket handshake", http.StatusBadRequest)
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if rw.Flush() != nil {
+		return
+	}
+
+	c := s.subscribe()
+	defer s.unsubscribe(c)
+	// Messages of the client are discarded, a close message or a failed read
+	// ends the stream
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		discardWebSocketFrames(rw.Reader)
+	}()
+	for {
+		select {
+		case <-closed:
+			writeWebSocketFrame(rw.Writer, 0x8, nil)
+			rw.Flush()
+			return
+		case <-s.done:
+			writeWebSocketFrame(rw.Writer, 0x8, nil)
+			rw.Flush()
+			return
+		case line := <-c:
+			writeWebSocketFrame(rw.Writer, 0x1, []byte(line))
+			if rw.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// writeWebSocketFrame writes an unmasked, unfragmented frame with the given
+// opcode, e.g. 0x1 for a text message, see RFC 6455, section 5.2.
+func writeWebSocketFrame(w *bufio.Writer, opcode byte, payload []byte) {
+	w.WriteByte(0x80 | opcode)
+	switch n := len(payload); {
+	case n < 126:
+		w.WriteByte(byte(n))
+	case n <= 0xffff:
+		w.WriteByte(126)
+		binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		w.WriteByte(127)
+		binary.Write(w, binary.BigEndian, uint64(n))
+	}
+	w.Write(payload)
+}
+
+// discardWebSocketFrames reads the frames of a client until it sends a close
+// frame or reading fails.
+func discardWebSocketFrames(r *bufio.Reader) {
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return
+		}
+		opcode := header[0] & 0x0f
+		n := uint64(header[1] & 0x7f)
+		switch n {
+		case 126:
+			var ext uint16
+			if binary.Read(r, binary.BigEndian, &ext) != nil {
+				return
+			}
+			n = uint64(ext)
+		case 127:
+			if binary.Read(r, binary.BigEndian, &n) != nil {
+				return
+			}
+		}
+		// Frames of clients are masked with a 4 byte key
+		if header[1]&0x80 != 0 {
+			n += 4
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(n)); err != nil || opcode == 0x8 {
+			return
+		}
+	}
+}
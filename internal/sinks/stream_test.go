@@ -0,0 +1,91 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForClients waits until the sink has the given number of clients.
+func waitForClients(t *testing.T, s *StreamSink, n int) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		s.mu.Lock()
+		connected := len(s.clients)
+		s.mu.Unlock()
+		if connected == n {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Expected %d connected clients", n)
+}
+
+func TestStreamSink_Events(t *testing.T) {
+	sink := NewStreamSink(10)
+	server := httptest.NewServer(sink)
+	defer server.Close()
+	defer sink.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected event stream, got %s", ct)
+	}
+	waitForClients(t, sink, 1)
+	sink.Write(context.Background(), logs.LogEvent{Line: "GET /index.html"})
+
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	if err != nil || line != "data: GET /index.html\n" {
+		t.Errorf("Expected line as event, got %q, err: %v", line, err)
+	}
+}
+
+func TestStreamSink_WebSocket(t *testing.T) {
+	sink := NewStreamSink(10)
+	server := httptest.NewServer(sink)
+	defer server.Close()
+	defer sink.Close()
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(server.URL, "http://"))
+	if err != nil {
+		t.Fatalf("Failed to connect: %s", err)
+	}
+	defer conn.Close()
+	// Example handshake of RFC 6455, section 1.3
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatalf("Failed to read handshake: %s", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols ||
+		resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Expected handshake to succeed, got %s %v", resp.Status, resp.Header)
+	}
+	waitForClients(t, sink, 1)
+	sink.Write(context.Background(), logs.LogEvent{Line: "hello"})
+
+	frame := make([]byte, 7)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		t.Fatalf("Failed to read frame: %s", err)
+	}
+	if frame[0] != 0x81 || frame[1] != 5 || string(frame[2:]) != "hello" {
+		t.Errorf("Expected text frame with the line, got %v", frame)
+	}
+
+	// A masked close frame of the client ends the stream
+	conn.Write([]byte{0x88, 0x80, 1, 2, 3, 4})
+	waitForClients(t, sink, 0)
+}
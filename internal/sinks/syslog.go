@@ -0,0 +1,153 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+var syslogSeverities = map[string]int{
+	"emerg": 0, "alert": 1, "crit": 2, "err": 3, "warning": 4, "notice": 5, "info": 6, "debug": 7,
+}
+
+// SyslogOptions configures the messages of a SyslogSink.
+type SyslogOptions struct {
+	// Network is "udp" or "tcp".
+	Network string
+	// Format is "rfc3164" or "rfc5424".
+	Format string
+	Facility string
+	Severity string
+	Hostname string
+	AppName string
+}
+
+// SyslogSink forwards each line as a syslog message over UDP or TCP. Over
+// TCP, RFC 5424 messages are framed by octet counting and RFC 3164 messages
+// by a trailing line break.
+type SyslogSink struct {
+	options SyslogOptions
+	priority int
+	conn net.Conn
+	w *bufio.Writer // buffers TCP output, nil for UDP
+}
+
+// NewSyslogSink connects to the syslog server at the given address.
+func NewSyslogSink(addr string, options SyslogOptions) (*SyslogSink, error) {
+	if options.Network != "udp" && options.Network != "tcp" {
+		return nil, fmt.Errorf("invalid syslog transport: %s, must be udp or tcp", options.Network)
+	}
+	if options.Format != "rfc3164" && options.Format != "rfc5424" {
+		return nil, fmt.Errorf("invalid syslog format: %s, must be rfc3164 or rfc5424", options.Format)
+	}
+	facility, ok := syslogFacilities[options.Facility]
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog facility: %s", options.Facility)
+	}
+	severity, ok := syslogSeverities[options.Severity]
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog severity: %s", options.Severity)
+	}
+	conn, err := net.Dial(options.Network, addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &SyslogSink{options: options, priority: facility*8 + severity, conn: conn}
+	if options.Network == "tcp" {
+		s.w = bufio.NewWriter(conn)
+	}
+	return s, nil
+}
+
+// message formats the event as a syslog message without the trailing line
+// break of the line.
+func (s *SyslogSink) message(e logs.LogEvent) string {
+	line := strings.TrimRight(e.Line, "\r\n")
+	if s.options.Format == "rfc3164" {
+		return fmt.Sprintf("<%d>%s %s %s: %s", s.priority, e.Time.Format("Jan _2 15:04:05"),
+			s.options.Hostname, s.options.AppName, line)
+	}
+	return fmt.Sprintf("<%d>1 %s %s %s - - - %s", s.priority, e.Time.Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.options.Hostname, s.options.AppName, line)
+}
+
+// Write sends the line of the event. Over UDP, each message is sent
+// immediately in its own datagram.
+func (s *SyslogSink) Write(_ context.Context, e logs.LogEvent) error {
+	msg := s.message(e)
+	if s.w == nil {
+		_, err := s.conn.Write([]byte(msg))
+		return err
+	}
+	var err error
+	if s.options.Format == "rfc5424" {
+		_, err = s.w.WriteString(strconv.Itoa(len(msg)) + " " + msg)
+	} else {
+		_, err = s.w.WriteString(msg + "\n")
+	}
+	return err
+}
+
+// Flush sends the buffered messages over TCP.
+func (s *SyslogSink) Flush() error {
+	if s.w == nil {
+		return nil
+	}
+	return s.w.Flush()
+}
+
+// Close flushes and closes the connection.
+func (s *SyslogSink) Close() error {
+	err := s.Flush()
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// openSyslog creates a SyslogSink from a spec like
+// "syslog://host:514?transport=tcp&format=rfc5424&facility=local0".
+func openSyslog(spec string) (logs.Sink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	if len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid output %q: missing host", spec)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	q := u.Query()
+	options := SyslogOptions{
+		Network: queryOr(q, "transport", "udp"),
+		Format: queryOr(q, "format", "rfc5424"),
+		Facility: queryOr(q, "facility", "user"),
+		Severity: queryOr(q, "severity", "info"),
+		Hostname: queryOr(q, "hostname", hostname),
+		AppName: queryOr(q, "app_name", "bananabacon"),
+	}
+	return NewSyslogSink(u.Host, options)
+}
+
+// queryOr returns the value of the query parameter, or fallback if it is not
+// set.
+func queryOr(q url.Values, key, fallback string) string {
+	if v := q.Get(key); len(v) > 0 {
+		return v
+	}
+	return fallback
+}
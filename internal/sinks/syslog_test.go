@@ -0,0 +1,71 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSyslogSink_UDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer conn.Close()
+
+	sink, err := Open("syslog://" + conn.LocalAddr().String() + "?format=rfc3164&facility=local0&severity=err&hostname=web&app_name=nginx")
+	if err != nil {
+		t.Fatalf("Failed to open syslog sink: %s", err)
+	}
+	defer sink.Close()
+	e := logs.LogEvent{Time: time.Date(2024, 1, 5, 13, 4, 5, 0, time.UTC), Line: "GET /\n"}
+	if err := sink.Write(context.Background(), e); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to receive message: %s", err)
+	}
+	if msg := string(buf[:n]); msg != "<131>Jan  5 13:04:05 web nginx: GET /" {
+		t.Errorf("Unexpected message %q", msg)
+	}
+}
+
+func TestSyslogSink_TCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %s", err)
+	}
+	defer ln.Close()
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		received <- line
+	}()
+
+	sink, err := Open("syslog://" + ln.Addr().String() + "?transport=tcp&hostname=web&app_name=app")
+	if err != nil {
+		t.Fatalf("Failed to open syslog sink: %s", err)
+	}
+	e := logs.LogEvent{Time: time.Date(2024, 1, 5, 13, 4, 5, 0, time.UTC), Line: "started\n"}
+	if err := sink.Write(context.Background(), e); err != nil {
+		t.Fatalf("Failed to write: %s", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Failed to close: %s", err)
+	}
+	if msg := <-received; msg != "55 <14>1 2024-01-05T13:04:05.000000Z web app - - - started" {
+		t.Errorf("Unexpected message %q", msg)
+	}
+}
@@ -0,0 +1,196 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookOptions configures a WebhookSink.
+type WebhookOptions struct {
+	// Format is the format of the request body: "lines" sends the lines
+	// separated by line breaks, "json" sends a JSON array of objects with the
+	// fields "time", "line" and "source".
+	Format string
+	// BatchSize is the number of lines after which they are sent.
+	BatchSize int
+	// FlushInterval is the maximum time lines are buffered.
+	FlushInterval time.Duration
+	// Retries is the number of times a failed request is retried. Requests
+	// are retried on network errors, 429 and 5xx responses.
+	Retries int
+	// Backoff is the delay before the first retry. It doubles with every
+	// retry.
+	Backoff time.Duration
+	HTTP HTTPOptions
+}
+
+// WebhookSink posts batches of lines to an HTTP endpoint, e.g. a custom
+// ingest API.
+type WebhookSink struct {
+	url string
+	options WebhookOptions
+	client *http.Client
+	batch *batcher
+	lines []webhookLine
+}
+
+// webhookLine is a line in the JSON format of a WebhookSink.
+type webhookLine struct {
+	Time time.Time `json:"time"`
+	Line string `json:"line"`
+	Source string `json:"source"`
+}
+
+// NewWebhookSink creates a WebhookSink posting to the given URL.
+func NewWebhookSink(endpoint string, options WebhookOptions) (*WebhookSink, error) {
+	if options.Format != "lines" && options.Format != "json" {
+		return nil, fmt.Errorf("invalid format: %s, must be lines or json", options.Format)
+	}
+	if options.BatchSize <= 0 {
+		return nil, fmt.Errorf("invalid batch size: %d, must be positive", options.BatchSize)
+	}
+	if options.FlushInterval <= 0 {
+		return nil, fmt.Errorf("invalid flush interval: %s, must be positive", options.FlushInterval)
+	}
+	if options.Retries < 0 || options.Backoff < 0 {
+		return nil, errors.New("retries and backoff must not be negative")
+	}
+	client, err := options.HTTP.Client()
+	if err != nil {
+		return nil, err
+	}
+	s := &WebhookSink{url: endpoint, options: options, client: client}
+	s.batch = newBatcher(options.BatchSize, options.FlushInterval, s.push)
+	return s, nil
+}
+
+// Write buffers the line of the event and sends the buffered lines once the
+// batch is full.
+func (s *WebhookSink) Write(_ context.Context, e logs.LogEvent) error {
+	return s.batch.add(func() {
+		s.lines = append(s.lines, webhookLine{Time: e.Time, Line: strings.Clone(e.Line), Source: e.Source})
+	})
+}
+
+// Flush sends the buffered lines if the flush interval has passed since the
+// last request.
+func (s *WebhookSink) Flush() error {
+	return s.batch.flush()
+}
+
+// body encodes the buffered lines in the configured format.
+func (s *WebhookSink) body() ([]byte, string, error) {
+	if s.options.Format == "json" {
+		b, err := json.Marshal(s.lines)
+		return b, "application/json", err
+	}
+	var b bytes.Buffer
+	for _, l := range s.lines {
+		b.WriteString(l.Line)
+		b.WriteByte('\n')
+	}
+	return b.Bytes(), "text/plain; charset=utf-8", nil
+}
+
+// push sends the buffered lines, retrying with exponential backoff.
+func (s *WebhookSink) push() error {
+	body, contentType, err := s.body()
+	s.lines = s.lines[:0]
+	if err != nil {
+		return err
+	}
+	return postWithRetries(s.client, s.options.HTTP, s.url, body, contentType, s.options.Retries,
+		s.options.Backoff)
+}
+
+// postWithRetries posts the body to the endpoint, retrying on network errors, 429
+// and 5xx responses with exponential backoff.
+func postWithRetries(client *http.Client, options HTTPOptions, endpoint string, body []byte, contentType string,
+	retries int, backoff time.Duration) error {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType)
+		_, err = options.send(client, req)
+		if err == nil {
+			return nil
+		}
+		var se *statusError
+		retryable := !errors.As(err, &se) || se.code == http.StatusTooManyRequests || se.code >= 500
+		if !retryable || attempt >= retries {
+			return fmt.Errorf("failed to post to %s: %w", endpoint, err)
+		}
+		log.Printf("Failed to post to %s, retrying in %s: %v", endpoint, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// Close sends the remaining lines.
+func (s *WebhookSink) Close() error {
+	return s.batch.close()
+}
+
+// openWebhook creates a WebhookSink from a spec like
+// "https://ingest.example.com/logs?format=json&batch_size=100&retries=3".
+// The options are removed from the URL, other query parameters are kept.
+func openWebhook(spec string) (logs.Sink, error) {
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	q := u.Query()
+	httpOptions, err := parseHTTPOptions(q)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	options := WebhookOptions{
+		Format: queryOr(q, "format", "lines"),
+		BatchSize: 100,
+		FlushInterval: time.Second,
+		Retries: 3,
+		Backoff: 500 * time.Millisecond,
+		HTTP: httpOptions,
+	}
+	if v := q.Get("batch_size"); len(v) > 0 {
+		if options.BatchSize, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	if v := q.Get("flush_interval"); len(v) > 0 {
+		if options.FlushInterval, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	if v := q.Get("retries"); len(v) > 0 {
+		if options.Retries, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	if v := q.Get("backoff"); len(v) > 0 {
+		if options.Backoff, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+		}
+	}
+	for _, key := range []string{"format", "batch_size", "flush_interval", "retries", "backoff"} {
+		q.Del(key)
+	}
+	u.RawQuery = q.Encode()
+	s, err := NewWebhookSink(u.String(), options)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output %q: %w", spec, err)
+	}
+	return s, nil
+}
@@ -0,0 +1,73 @@
+package sinks
+
+import (
+	"bananabacon/internal/logs"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookSink_Retry(t *testing.T) {
+	attempts := 0
+	var body, contentType, token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		body, contentType, token = string(b), r.Header.Get("Content-Type"), r.URL.Query().Get("token")
+	}))
+	defer server.Close()
+
+	sink, err := Open(server.URL + "/ingest?token=abc&format=json&batch_size=2&backoff=1ms")
+	if err != nil {
+		t.Fatalf("Failed to open webhook sink: %s", err)
+	}
+	defer sink.Close()
+	t0 := time.Date(2024, 1, 5, 13, 4, 5, 0, time.UTC)
+	for _, line := range []string{"first", "second"} {
+		if err := sink.Write(context.Background(), logs.LogEvent{Time: t0, Line: line, Source: "a.log"}); err != nil {
+			t.Fatalf("Failed to write: %s", err)
+		}
+	}
+
+	if attempts != 2 {
+		t.Errorf("Expected the failed request to be retried once, got %d attempts", attempts)
+	}
+	expected := `[{"time":"2024-01-05T13:04:05Z","line":"first","source":"a.log"},` +
+		`{"time":"2024-01-05T13:04:05Z","line":"second","source":"a.log"}]`
+	if body != expected || contentType != "application/json" {
+		t.Errorf("Unexpected request %s: %s", contentType, body)
+	}
+	if token != "abc" {
+		t.Errorf("Expected the query parameters of the endpoint to be kept, got token %q", token)
+	}
+}
+
+func TestWebhookSink_ClientError(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	sink, err := NewWebhookSink(server.URL, WebhookOptions{
+		Format: "lines", BatchSize: 1, FlushInterval: time.Hour, Retries: 3, Backoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create webhook sink: %s", err)
+	}
+	defer sink.Close()
+	if err := sink.Write(context.Background(), logs.LogEvent{Line: "denied"}); err == nil {
+		t.Error("Expected the rejected request to fail")
+	}
+	if attempts != 1 {
+		t.Errorf("Expected client errors not to be retried, got %d attempts", attempts)
+	}
+}
@@ -0,0 +1,97 @@
+package suppress
+
+import (
+	"bananabacon/internal/logs"
+	"bananabacon/internal/metrics"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Window is a recurring period in which output is suppressed.
+type Window struct {
+	// Start is the schedule of the start of the window.
+	Start *metrics.CronSchedule
+	// Duration is how long the window lasts after each start.
+	Duration time.Duration
+}
+
+// Windows is a set of suppression windows. It can be used as a logs.Filter to
+// drop the lines emitted during a window.
+type Windows struct {
+	windows []Window
+	mu sync.Mutex
+	checked time.Time // second of the last check
+	active bool // result of the last check
+	suppressing bool // whether Keep dropped the last event
+	onChange func(active bool, t time.Time)
+}
+
+// Parse parses a semicolon-separated list of windows, each given as a cron
+// expression for its start followed by "for" and a Go duration, e.g.
+// "0 2 * * * for 30m; 0 12 * * 1-5 for 5m".
+func Parse(spec string) (*Windows, error) {
+	var windows []Window
+	for _, w := range strings.Split(spec, ";") {
+		expr, d, ok := strings.Cut(w, " for ")
+		if !ok {
+			return nil, fmt.Errorf("invalid suppression window %q: expected \"<cron> for <duration>\"", w)
+		}
+		start, err := metrics.ParseCron(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid suppression window %q: %w", w, err)
+		}
+		duration, err := time.ParseDuration(strings.TrimSpace(d))
+		if err != nil || duration <= 0 {
+			return nil, fmt.Errorf("invalid suppression window %q: invalid duration %s", w, d)
+		}
+		windows = append(windows, Window{Start: start, Duration: duration})
+	}
+	return &Windows{windows: windows}, nil
+}
+
+// Active returns true if t is within one of the windows. The result is cached
+// for the second of t, as windows start at full minutes.
+func (ws *Windows) Active(t time.Time) bool {
+	second := t.Truncate(time.Second)
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if second.Equal(ws.checked) {
+		return ws.active
+	}
+	ws.checked = second
+	ws.active = false
+	for _, w := range ws.windows {
+		// Look for a start within the duration before t
+		for m := t.Truncate(time.Minute); t.Sub(m) < w.Duration; m = m.Add(-time.Minute) {
+			if w.Start.Matches(m) {
+				ws.active = true
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// OnChange registers a function that is called by Keep whenever a window
+// starts or ends, with the time of the first event after the change.
+func (ws *Windows) OnChange(f func(active bool, t time.Time)) {
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	ws.onChange = f
+}
+
+// Keep returns false for events emitted during a window.
+func (ws *Windows) Keep(e logs.LogEvent) bool {
+	active := ws.Active(e.Time)
+	ws.mu.Lock()
+	changed := active != ws.suppressing
+	ws.suppressing = active
+	onChange := ws.onChange
+	ws.mu.Unlock()
+	if changed && onChange != nil {
+		onChange(active, e.Time)
+	}
+	return !active
+}
@@ -0,0 +1,45 @@
+package suppress
+
+import (
+	"bananabacon/internal/logs"
+	"testing"
+	"time"
+)
+
+func TestWindows(t *testing.T) {
+	ws, err := Parse("0 2 * * * for 30m; 15 12 * * 1-5 for 90s")
+	if err != nil {
+		t.Fatalf("Failed to parse windows: %s", err)
+	}
+	// Monday, 2024-01-15
+	day := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		offset time.Duration
+		active bool
+	}{
+		{time.Hour + 59*time.Minute, false},
+		{2 * time.Hour, true},
+		{2*time.Hour + 29*time.Minute + 59*time.Second, true},
+		{2*time.Hour + 30*time.Minute, false},
+		{12*time.Hour + 16*time.Minute, true},
+		{12*time.Hour + 16*time.Minute + 30*time.Second, false},
+	}
+	var changes []bool
+	ws.OnChange(func(active bool, _ time.Time) {
+		changes = append(changes, active)
+	})
+	for _, test := range tests {
+		if ws.Keep(logs.LogEvent{Time: day.Add(test.offset)}) == test.active {
+			t.Errorf("Expected window active=%v at %s", test.active, test.offset)
+		}
+	}
+	if len(changes) != 4 || !changes[0] || changes[1] || !changes[2] || changes[3] {
+		t.Errorf("Expected the windows to start and end twice, got changes %v", changes)
+	}
+
+	for _, invalid := range []string{"0 2 * * *", "0 2 * * for 1h", "0 2 * * * for soon"} {
+		if _, err := Parse(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
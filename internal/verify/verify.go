@@ -0,0 +1,156 @@
+package verify
+
+import (
+	"bananabacon/internal/logs"
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Options configures how the source log and the recorded output are compared.
+type Options struct {
+	// TimeFormats are the formats of the timestamps in the source log and the
+	// output, tried in order.
+	TimeFormats []logs.TimestampFormat
+	// Locale is the language of month and day names in the timestamps, see
+	// logs.TimeLocales. Empty means English.
+	Locale string
+	// Speed is the replay speed the output was recorded with. Zero means the
+	// original speed.
+	Speed float64
+}
+
+// Report is the result of comparing a source log with the recorded output of
+// its replay. Only lines with a timestamp are compared.
+type Report struct {
+	// Expected is the number of lines in the source log.
+	Expected int
+	// Observed is the number of lines in the output.
+	Observed int
+	// Matched is the number of output lines that correspond to a source line.
+	Matched int
+	// Lost is the number of source lines missing in the output.
+	Lost int
+	// Extra is the number of output lines without a corresponding source line.
+	Extra int
+	// MaxDrift is the largest deviation of a line from its expected position
+	// on the timeline, relative to the first matched line.
+	MaxDrift time.Duration
+	// MeanDrift is the mean absolute deviation of the matched lines.
+	MeanDrift time.Duration
+	// FinalDrift is the deviation of the last matched line, i.e. how far the
+	// replay was ahead (positive) or behind (negative) at its end.
+	FinalDrift time.Duration
+}
+
+// String returns a human readable summary of the report.
+func (r Report) String() string {
+	return fmt.Sprintf("expected %d lines, observed %d, matched %d, lost %d, extra %d\n"+
+		"drift: max %s, mean %s, final %s",
+		r.Expected, r.Observed, r.Matched, r.Lost, r.Extra, r.MaxDrift, r.MeanDrift, r.FinalDrift)
+}
+
+// entry is a line with a timestamp.
+type entry struct {
+	time time.Time
+	key string // the line without its timestamp, used to match lines
+}
+
+type timeFormat struct {
+	matcher *logs.TimestampMatcher
+	layout string
+	locale string
+}
+
+// Compare reads the source log and the recorded output and reports loss and
+// timing drift of the output. Lines are matched by their content without the
+// timestamp, in order. The timing of a matched output line is compared with
+// the timing of its source line, scaled by the replay speed.
+func Compare(source, output io.Reader, options Options) (Report, error) {
+	if err := logs.CheckTimeLocale(options.Locale); err != nil {
+		return Report{}, err
+	}
+	formats := make([]timeFormat, len(options.TimeFormats))
+	for i, f := range options.TimeFormats {
+		matcher, err := logs.NewTimestampMatcher(f)
+		if err != nil {
+			return Report{}, err
+		}
+		formats[i] = timeFormat{matcher: matcher, layout: f.Format, locale: options.Locale}
+	}
+	speed := options.Speed
+	if speed == 0 {
+		speed = 1
+	}
+	expected, err := readEntries(source, formats)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to read source: %w", err)
+	}
+	observed, err := readEntries(output, formats)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to read output: %w", err)
+	}
+
+	report := Report{Expected: len(expected), Observed: len(observed)}
+	// Indices of the unmatched source lines by key, in order
+	pending := map[string][]int{}
+	for i, e := range expected {
+		pending[e.key] = append(pending[e.key], i)
+	}
+	var first struct {
+		expected, observed time.Time
+	}
+	var totalDrift time.Duration
+	last := -1 // index of the last matched source line
+	for _, o := range observed {
+		candidates := pending[o.key]
+		// Skip source lines before the last match, they were lost
+		for len(candidates) > 0 && candidates[0] < last {
+			candidates = candidates[1:]
+		}
+		if len(candidates) == 0 {
+			report.Extra++
+			continue
+		}
+		i := candidates[0]
+		pending[o.key] = candidates[1:]
+		last = i
+		report.Matched++
+		if report.Matched == 1 {
+			first.expected, first.observed = expected[i].time, o.time
+		}
+		want := time.Duration(float64(expected[i].time.Sub(first.expected)) / speed)
+		drift := o.time.Sub(first.observed) - want
+		report.FinalDrift = drift
+		totalDrift += drift.Abs()
+		report.MaxDrift = max(report.MaxDrift, drift.Abs())
+	}
+	report.Lost = report.Expected - report.Matched
+	if report.Matched > 0 {
+		report.MeanDrift = totalDrift / time.Duration(report.Matched)
+	}
+	return report, nil
+}
+
+// readEntries reads the lines with a timestamp.
+func readEntries(r io.Reader, formats []timeFormat) ([]entry, error) {
+	var entries []entry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, f := range formats {
+			value, key, _, ok := f.matcher.Cut(line)
+			if !ok {
+				continue
+			}
+			t, err := logs.ParseTimeIn(f.layout, f.locale, value)
+			if err != nil {
+				continue
+			}
+			entries = append(entries, entry{time: t, key: key})
+			break
+		}
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,39 @@
+package verify
+
+import (
+	"bananabacon/internal/logs"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompare(t *testing.T) {
+	source := `2023-01-01 00:00:00.000 line a
+2023-01-01 00:00:01.000 line b
+	continuation
+2023-01-01 00:00:02.000 line c
+2023-01-01 00:00:04.000 line d`
+	// Replayed at double speed, line c lost, line d 100ms late
+	output := `2024-06-01 12:00:00.000 line a
+2024-06-01 12:00:00.500 line b
+	continuation
+2024-06-01 12:00:02.100 line d
+2024-06-01 12:00:02.200 unrelated`
+
+	report, err := Compare(strings.NewReader(source), strings.NewReader(output), Options{
+		TimeFormats: []logs.TimestampFormat{{
+			Regex: `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3})`,
+			Format: "2006-01-02 15:04:05.000",
+		}},
+		Speed: 2,
+	})
+	if err != nil {
+		t.Fatalf("Failed to compare: %s", err)
+	}
+	if report.Expected != 4 || report.Observed != 4 || report.Matched != 3 || report.Lost != 1 || report.Extra != 1 {
+		t.Errorf("Unexpected counts: %+v", report)
+	}
+	if report.MaxDrift != 100*time.Millisecond || report.FinalDrift != 100*time.Millisecond {
+		t.Errorf("Expected 100ms drift, got %+v", report)
+	}
+}
@@ -0,0 +1,159 @@
+// Package fakemetrics embeds the metrics engine of bananabacon in other Go
+// programs. An Engine evaluates metrics defined by JavaScript expressions of
+// the elapsed time t, the previous value prev and an object state kept
+// between evaluations, and serves them in the Prometheus text format. Errors
+// are returned instead of ending the process like the bananabacon command
+// does.
+package fakemetrics
+
+import (
+	"bananabacon/internal/metrics"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+type (
+	// Metric is a metric with the script computing its values.
+	Metric = metrics.Metric
+	// Value is the result of evaluating a metric.
+	Value = metrics.MetricValue
+	// Sample is a value of a series at a point in time, see Engine.Simulate.
+	Sample = metrics.Sample
+)
+
+// The types of metrics.
+const (
+	Untyped = metrics.UntypedType
+	Counter = metrics.CounterType
+	Gauge = metrics.GaugeType
+	Histogram = metrics.HistogramType
+	Summary = metrics.SummaryType
+)
+
+// The modes of keeping counters monotonic, see Engine.SetCounterMode.
+const (
+	CounterAccumulate = metrics.CounterAccumulate
+	CounterClamp = metrics.CounterClamp
+	CounterOff = metrics.CounterOff
+)
+
+// NewMetric creates a metric of the given type whose values are computed by
+// script, an expression like "Math.sin(t / 1000) + 1" or a function named
+// like the metric. It returns an error if the name or a label name is
+// invalid or the script does not compile.
+func NewMetric(name string, typ int, script string, labels map[string]string, description string) (*Metric, error) {
+	b := metrics.NewMetricBuilder(name).WithScript(script).WithDescription(description)
+	if _, err := b.WithType(typ); err != nil {
+		return nil, err
+	}
+	for k, v := range labels {
+		if _, err := b.WithLabel(k, v); err != nil {
+			return nil, fmt.Errorf("invalid label %q of metric %s: %w", k, name, err)
+		}
+	}
+	m, _ := b.Build()
+	if err := metrics.ValidateMetric(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Engine evaluates metrics.
+type Engine struct {
+	engine *metrics.MetricsEngine
+}
+
+// NewEngine creates an engine of the given metrics. The elapsed time t
+// passed to their scripts starts now.
+func NewEngine(ms ...*Metric) *Engine {
+	return &Engine{engine: metrics.NewMetricsEngine(ms)}
+}
+
+// FromEnv creates an engine of the metrics configured by METRIC_ variables
+// like the bananabacon command, e.g. from os.Environ(). It returns an error
+// describing every invalid variable and metric.
+func FromEnv(environ []string) (*Engine, error) {
+	builder, errs := metrics.ValidateEnv(environ)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return &Engine{engine: builder.Build()}, nil
+}
+
+// Metrics returns the metrics of the engine.
+func (e *Engine) Metrics() []*Metric {
+	return e.engine.List()
+}
+
+// SetMetrics replaces the metrics of the engine. Metrics with the same name
+// and labels as a replaced metric continue from its last value.
+func (e *Engine) SetMetrics(ms []*Metric) {
+	e.engine.SetMetrics(ms)
+}
+
+// SetCounterMode sets how counters are kept monotonic when their scripts
+// return decreasing values: CounterAccumulate, the default, adds only the
+// increases, CounterClamp exposes the highest value so far and CounterOff
+// exposes the values as they are. Scripts simulate a reset by calling
+// resetCounter().
+func (e *Engine) SetCounterMode(mode string) error {
+	return e.engine.SetCounterMode(mode)
+}
+
+// Eval evaluates all metrics at the current elapsed time. It returns the
+// values of the metrics that could be evaluated and an error describing
+// the others.
+func (e *Engine) Eval() ([]Value, error) {
+	vm := e.engine.NewRuntime()
+	var values []Value
+	var errs []error
+	for _, m := range e.engine.List() {
+		v, err := e.engine.Eval(m, vm)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to evaluate metric %s: %w", m.Name(), err))
+			continue
+		}
+		values = append(values, v)
+	}
+	return values, errors.Join(errs...)
+}
+
+// WriteText evaluates all metrics and writes them to w in the Prometheus
+// text format, like they are served on /metrics.
+func (e *Engine) WriteText(w io.Writer) error {
+	values, err := e.Eval()
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, metrics.FormatValues(values))
+	return err
+}
+
+// Simulate evaluates the metrics from start to end in steps of the given
+// duration without waiting, with t being the time since start, and passes
+// the samples of every step to write. The engine should not be served at the
+// same time, see metrics.MetricsEngine.Simulate.
+func (e *Engine) Simulate(start, end time.Time, step time.Duration, write func([]Sample) error) error {
+	return e.engine.Simulate(start, end, step, write)
+}
+
+// Handler returns the HTTP handler of the endpoints of the bananabacon
+// command: /metrics, /federate, /api/scrapes and /ready, which reports ready.
+func (e *Engine) Handler() http.Handler {
+	server := metrics.NewMetricsServer(e.engine, 0)
+	server.SetReady(true)
+	return server.Handler()
+}
+
+// Serve serves the endpoints of Handler on the given port until the context
+// is cancelled. It returns an error if the server fails, e.g. because the
+// port is in use.
+func (e *Engine) Serve(ctx context.Context, port int) error {
+	server := metrics.NewMetricsServer(e.engine, port)
+	server.SetReady(true)
+	return server.Serve(ctx)
+}
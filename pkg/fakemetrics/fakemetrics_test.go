@@ -0,0 +1,43 @@
+package fakemetrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEngine(t *testing.T) {
+	m, err := NewMetric("requests_total", Counter, "(prev || 0) + 2", map[string]string{"job": "api"}, "Requests")
+	if err != nil {
+		t.Fatalf("Failed to create metric: %s", err)
+	}
+	engine := NewEngine(m)
+	var sb strings.Builder
+	for i := 0; i < 2; i++ {
+		sb.Reset()
+		if err := engine.WriteText(&sb); err != nil {
+			t.Fatalf("Failed to write metrics: %s", err)
+		}
+	}
+	expected := "# HELP requests_total Requests\n# TYPE requests_total counter\nrequests_total {job=\"api\"} 4\n"
+	if sb.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, sb.String())
+	}
+
+	for _, test := range []struct {
+		name, script string
+		labels       map[string]string
+	}{
+		{"bad-name", "t", nil},
+		{"syntax", "t +", nil},
+		{"label", "t", map[string]string{"bad-label": "x"}},
+	} {
+		if _, err := NewMetric(test.name, Gauge, test.script, test.labels, ""); err == nil {
+			t.Errorf("Expected an error for metric %s", test.name)
+		}
+	}
+
+	if _, err := FromEnv([]string{"METRIC_a_EXPR=t", "METRIC_a_TYPE=countr", "METRIC_b_EXPR=t +"}); err == nil ||
+		!strings.Contains(err.Error(), "metric a") || !strings.Contains(err.Error(), "metric b") {
+		t.Errorf("Expected errors for both metrics, got %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+// Package replayer embeds the log replay of bananabacon in other Go programs.
+// A Replayer reads log files, or any other Source, and emits their lines with
+// the original timing, with the timestamps rewritten to the time of the
+// replay. Errors are returned instead of ending the process like the
+// bananabacon command does.
+//
+// The types are aliases of the implementation, so filters, transformers and
+// sinks written against this package work with all of its features.
+package replayer
+
+import (
+	"bananabacon/internal/logs"
+	"bananabacon/internal/sinks"
+	"context"
+	"time"
+)
+
+type (
+	// Options configures a Replayer, see DefaultOptions.
+	Options = logs.ReplayerOptions
+	// TimestampFormat is an additional format of timestamps in the lines.
+	TimestampFormat = logs.TimestampFormat
+	// Event is a replayed line with its original and rewritten time. Sinks
+	// keeping events beyond Write keep a Clone of them.
+	Event = logs.LogEvent
+	// Source is an input of a Replayer, e.g. a file.
+	Source = logs.Source
+	// FileSource is a Source that reads a file, which can be gzip-compressed.
+	FileSource = logs.FileSource
+	// Filter drops events before they are emitted.
+	Filter = logs.Filter
+	// FilterFunc is a Filter implemented by a function.
+	FilterFunc = logs.FilterFunc
+	// Transformer changes or drops events before they are emitted.
+	Transformer = logs.Transformer
+	// TransformerFunc is a Transformer implemented by a function.
+	TransformerFunc = logs.TransformerFunc
+	// Sink receives the emitted events.
+	Sink = logs.Sink
+	// SinkFunc is a Sink implemented by a function.
+	SinkFunc = logs.SinkFunc
+	// Stats are the counters of a Replayer.
+	Stats = logs.ReplayerStats
+)
+
+// DefaultOptions returns the options the bananabacon command uses without
+// configuration, except that the replay does not loop: all lines are
+// replayed at the original speed and timestamps like
+// "2006-01-02 15:04:05.000" are rewritten.
+func DefaultOptions() Options {
+	return Options{
+		FilterRegex: ".*",
+		TimeRegex: `(\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}\.\d{3}).*`,
+		TimeFormat: "2006-01-02 15:04:05.000",
+		TimeParseCheck: logs.TimeParseWarn,
+		MaxTimeParseFailures: 0.1,
+		Speed: 1,
+		BatchWindow: logs.DefaultBatchWindow,
+		MaxTimingError: logs.DefaultMaxTimingError,
+		MaxBatchLines: logs.DefaultMaxBatchLines,
+		CheckpointInterval: 10 * time.Second,
+	}
+}
+
+// Replayer replays the lines of its sources on a single timeline.
+type Replayer struct {
+	lr *logs.LogReplayer
+}
+
+// New creates a Replayer of the given files, whose lines are merged by their
+// timestamps. It returns an error if the options are invalid.
+func New(files []string, options Options) (*Replayer, error) {
+	lr, err := logs.NewMultiLogReplayer(files, options)
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{lr: lr}, nil
+}
+
+// NewFromSources creates a Replayer of the given sources, like New does for
+// files.
+func NewFromSources(sources []Source, options Options) (*Replayer, error) {
+	lr, err := logs.NewPipelineReplayer(sources, options)
+	if err != nil {
+		return nil, err
+	}
+	return &Replayer{lr: lr}, nil
+}
+
+// Run replays the lines into the sink, with the first line emitted at start,
+// until all lines were replayed or the context is cancelled. It returns the
+// first error of the sink or of reading the sources.
+func (r *Replayer) Run(ctx context.Context, start time.Time, sink Sink) error {
+	return r.lr.StartSink(ctx, start, sink)
+}
+
+// Ready returns a channel that is closed once the sources have been opened.
+func (r *Replayer) Ready() <-chan struct{} {
+	return r.lr.Ready()
+}
+
+// Done returns a channel that is closed once Run returned.
+func (r *Replayer) Done() <-chan struct{} {
+	return r.lr.Done()
+}
+
+// Stats returns the current counters of the replay.
+func (r *Replayer) Stats() Stats {
+	return r.lr.Stats()
+}
+
+// Pause stops emitting lines until Resume is called. The time of the replay
+// stands still in the meantime.
+func (r *Replayer) Pause() {
+	r.lr.Pause()
+}
+
+// Resume continues a paused replay.
+func (r *Replayer) Resume() {
+	r.lr.Resume()
+}
+
+// SetSpeed changes the factor by which the replay is faster than the
+// original log.
+func (r *Replayer) SetSpeed(speed float64) error {
+	return r.lr.SetSpeed(speed)
+}
+
+// Skip jumps ahead by the given duration of log time.
+func (r *Replayer) Skip(d time.Duration) error {
+	return r.lr.Skip(d)
+}
+
+// Reconfigure replaces the filter regex and the transformers while the
+// replay runs.
+func (r *Replayer) Reconfigure(filterRegex string, transformers []Transformer) error {
+	return r.lr.Reconfigure(filterRegex, transformers)
+}
+
+// OpenSink opens the outputs of a comma-separated list of output specs like
+// the OUTPUT variable of the bananabacon command, e.g.
+// "stdout,file:/tmp/out.log" or "loki+http://loki:3100". Close the sink
+// after Run to flush the buffered lines.
+func OpenSink(specs string) (Sink, error) {
+	return sinks.OpenAll(specs)
+}
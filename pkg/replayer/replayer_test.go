@@ -0,0 +1,45 @@
+package replayer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	content := "2023-01-01 00:00:00.000 a\n2023-01-01 00:00:00.010 debug b\n2023-01-01 00:00:00.020 c\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write log: %s", err)
+	}
+	options := DefaultOptions()
+	options.Filters = []Filter{FilterFunc(func(e Event) bool { return !strings.Contains(e.Line, "debug") })}
+	r, err := New([]string{path}, options)
+	if err != nil {
+		t.Fatalf("Failed to create replayer: %s", err)
+	}
+	var lines []string
+	start := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	err = r.Run(context.Background(), start, SinkFunc(func(_ context.Context, e Event) error {
+		lines = append(lines, e.Line)
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("Replay failed: %s", err)
+	}
+	expected := []string{"2024-06-01 12:00:00.000 a", "2024-06-01 12:00:00.020 c"}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %q, got %q", expected, lines)
+	}
+	if stats := r.Stats(); stats.LinesEmitted != 2 {
+		t.Errorf("Expected 2 emitted lines, got %d", stats.LinesEmitted)
+	}
+
+	options.FilterRegex = "("
+	if _, err := New([]string{path}, options); err == nil {
+		t.Error("Expected an error for an invalid filter regex")
+	}
+}